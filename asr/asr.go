@@ -4,28 +4,48 @@ package asr
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"os/exec"
+	"strings"
+	"time"
 
 	"github.com/voidingwarranties/offsite-apfs-backup/diskutil"
 )
 
+// ErrRestoreFailed is wrapped by errors of restores that asr failed, as
+// opposed to restores that were interrupted. Test for it with errors.Is.
+var ErrRestoreFailed = errors.New("asr restore failed")
+
 // ASR restores a target volume to a source volume's APFS snapshot.
 type ASR interface {
 	Restore(source, target diskutil.VolumeInfo, to, from diskutil.Snapshot) error
 	DestructiveRestore(source, target diskutil.VolumeInfo, to diskutil.Snapshot) error
 }
 
+// ContextASR is an ASR with variants of its methods that stop early if ctx is
+// done: asr is interrupted and the restore returns an error. See GracePeriod.
+// The ASRs returned by New and NewDryRun implement ContextASR. See
+// WithContext.
+type ContextASR interface {
+	ASR
+	RestoreContext(ctx context.Context, source, target diskutil.VolumeInfo, to, from diskutil.Snapshot) error
+	DestructiveRestoreContext(ctx context.Context, source, target diskutil.VolumeInfo, to diskutil.Snapshot) error
+}
+
 type asr struct {
 	config
 }
 
 // config contains fields shared between asr and dryRunASR.
 type config struct {
-	execCommand func(string, ...string) *exec.Cmd
+	execCommand func(context.Context, string, ...string) *exec.Cmd
 	stdout      io.Writer
+	onProgress  ProgressFunc
+	gracePeriod time.Duration
 }
 
 // Option configures the behavior of ASR.
@@ -38,7 +58,15 @@ func Stdout(w io.Writer) Option {
 	}
 }
 
-func withExecCmd(f func(string, ...string) *exec.Cmd) Option {
+// GracePeriod returns an Option that sets how long asr has to exit after it's
+// interrupted, e.g. to clean up, before it's killed. Defaults to 30 seconds.
+func GracePeriod(d time.Duration) Option {
+	return func(conf *config) {
+		conf.gracePeriod = d
+	}
+}
+
+func withExecCmd(f func(context.Context, string, ...string) *exec.Cmd) Option {
 	return func(conf *config) {
 		conf.execCommand = f
 	}
@@ -47,8 +75,9 @@ func withExecCmd(f func(string, ...string) *exec.Cmd) Option {
 // New returns a new ASR.
 func New(opts ...Option) ASR {
 	conf := config{
-		execCommand: exec.Command,
+		execCommand: exec.CommandContext,
 		stdout:      os.Stdout,
+		gracePeriod: 30 * time.Second,
 	}
 	for _, opt := range opts {
 		opt(&conf)
@@ -62,37 +91,121 @@ func New(opts ...Option) ASR {
 // target volume's `from` snapshot. Both to and from must exist in source. From
 // must also exist in target.
 func (a asr) Restore(source, target diskutil.VolumeInfo, to, from diskutil.Snapshot) error {
-	cmd := a.execCommand(
-		"asr", "restore",
+	return a.RestoreContext(context.Background(), source, target, to, from)
+}
+
+// RestoreContext is like Restore, but interrupts asr if ctx is done first.
+func (a asr) RestoreContext(ctx context.Context, source, target diskutil.VolumeInfo, to, from diskutil.Snapshot) error {
+	return a.run(ctx, target,
+		"restore",
 		"--source", source.Device,
 		"--target", target.Device,
 		"--toSnapshot", to.UUID,
 		"--fromSnapshot", from.UUID,
-		"--erase", "--noprompt")
-	cmd.Stdout = a.stdout
-	stderr := new(bytes.Buffer)
-	cmd.Stderr = stderr
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("`%s` failed (%w) with stderr: %s", cmd, err, stderr.String())
-	}
-	return nil
+		"--erase", "--noprompt", "--puppetstrings")
 }
 
 // DestructiveRestore restores the target volume to the source volume's `to`
 // snapshot. `to` must exist in source. target's previous data and snapshots
 // will be lost. Use with caution!
 func (a asr) DestructiveRestore(source, target diskutil.VolumeInfo, to diskutil.Snapshot) error {
-	cmd := a.execCommand(
-		"asr", "restore",
+	return a.DestructiveRestoreContext(context.Background(), source, target, to)
+}
+
+// DestructiveRestoreContext is like DestructiveRestore, but interrupts asr if
+// ctx is done first.
+func (a asr) DestructiveRestoreContext(ctx context.Context, source, target diskutil.VolumeInfo, to diskutil.Snapshot) error {
+	return a.run(ctx, target,
+		"restore",
 		"--source", source.Device,
 		"--target", target.Device,
 		"--toSnapshot", to.UUID,
-		"--erase", "--noprompt")
-	cmd.Stdout = a.stdout
+		"--erase", "--noprompt", "--puppetstrings")
+}
+
+// run runs asr with args, a restore to target, reporting its progress. If ctx
+// is done first, asr is interrupted as if by Ctrl-C, so that it can clean up,
+// and killed if it hasn't exited within the grace period.
+func (a asr) run(ctx context.Context, target diskutil.VolumeInfo, args ...string) error {
+	killCtx, kill := context.WithCancel(context.Background())
+	defer kill()
+	cmd := a.execCommand(killCtx, "asr", args...)
+	stdout := a.newProgressWriter(target)
+	cmd.Stdout = stdout
 	stderr := new(bytes.Buffer)
 	cmd.Stderr = stderr
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("`%s` failed (%w) with stderr: %s", cmd, err, stderr.String())
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("`%s` failed %w", cmd, stderrError{cmdErr: err})
+	}
+	exited := make(chan struct{})
+	go func() {
+		select {
+		case <-exited:
+			return
+		case <-ctx.Done():
+		}
+		cmd.Process.Signal(os.Interrupt)
+		select {
+		case <-exited:
+		case <-time.After(a.gracePeriod):
+			kill()
+		}
+	}()
+	err := cmd.Wait()
+	close(exited)
+	stdout.Flush()
+	if err != nil && ctx.Err() != nil {
+		return fmt.Errorf("`%s` interrupted (%w) with stderr: %s", cmd, ctx.Err(), stderr.String())
+	}
+	if err != nil {
+		return fmt.Errorf("`%s` failed %w", cmd, stderrError{stderr: stderr.String(), cmdErr: err})
 	}
 	return nil
 }
+
+type stderrError struct {
+	stderr string
+	cmdErr error
+}
+
+func (err stderrError) Error() string {
+	if err.stderr == "" {
+		return fmt.Sprintf("(%s)", err.cmdErr)
+	}
+	return fmt.Sprintf("(%s) with stderr: %s", err.cmdErr, err.stderr)
+}
+
+func (err stderrError) Unwrap() error {
+	return err.cmdErr
+}
+
+func (err stderrError) Is(target error) bool {
+	return target == ErrRestoreFailed
+}
+
+// transientMessages are parts of asr error messages, in lower case, of
+// failures that may succeed if retried. asr fails before restoring anything
+// if it can't unmount target, e.g. while Spotlight is indexing it.
+var transientMessages = []string{
+	"resource busy",
+	"couldn't unmount",
+	"could not unmount",
+	"unable to unmount",
+}
+
+// IsTransient returns true if err, returned by an ASR, is a failure that may
+// succeed if retried, based on asr's error message. Interrupted restores are
+// never transient.
+func IsTransient(err error) bool {
+	var stdErr stderrError
+	if !errors.As(err, &stdErr) {
+		return false
+	}
+	message := strings.ToLower(stdErr.stderr)
+	for _, m := range transientMessages {
+		if strings.Contains(message, m) {
+			return true
+		}
+	}
+	return false
+}
@@ -1,9 +1,13 @@
 package asr
 
 import (
+	"context"
+	"errors"
 	"io"
 	"os"
+	"os/exec"
 	"testing"
+	"time"
 
 	"github.com/voidingwarranties/offsite-apfs-backup/diskutil"
 	"github.com/voidingwarranties/offsite-apfs-backup/testutils/fakecmd"
@@ -23,7 +27,7 @@ func TestRestore_WritesOutputToStdout(t *testing.T) {
 
 	a := New(
 		Stdout(pw),
-		withExecCmd(fakecmd.FakeCommand(t,
+		withExecCmd(fakecmd.FakeCommandContext(t,
 			fakecmd.Stdout("asr", "want stdout"),
 		)),
 	)
@@ -79,7 +83,7 @@ func TestRestore_CmdArgs(t *testing.T) {
 		fakecmd.WantArg("asr", to.UUID),
 		fakecmd.WantArg("asr", from.UUID),
 	}
-	a := New(withExecCmd(fakecmd.FakeCommand(t, opts...)))
+	a := New(withExecCmd(fakecmd.FakeCommandContext(t, opts...)))
 	err := a.Restore(source, target, to, from)
 	if err := fakecmd.AsHelperProcessErr(err); err != nil {
 		t.Fatal(err)
@@ -88,3 +92,93 @@ func TestRestore_CmdArgs(t *testing.T) {
 		t.Fatalf("Restore returned unexpected error: %v, want: nil", err)
 	}
 }
+
+func TestRestore_Interrupted(t *testing.T) {
+	a := New(
+		Stdout(io.Discard),
+		withExecCmd(fakecmd.FakeCommandContext(t,
+			fakecmd.Block("asr"),
+		)),
+	).(ContextASR)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	dummyVolume := diskutil.VolumeInfo{}
+	dummySnap := diskutil.Snapshot{}
+	start := time.Now()
+	err := a.RestoreContext(ctx, dummyVolume, dummyVolume, dummySnap, dummySnap)
+	if !errors.Is(err, context.Canceled) || errors.Is(err, ErrRestoreFailed) {
+		t.Errorf("RestoreContext returned unexpected error: %v, want: context.Canceled", err)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("RestoreContext returned after %v, want: soon after it was interrupted", elapsed)
+	}
+}
+
+// Test that asr is killed if it doesn't exit within the grace period after
+// it's interrupted.
+func TestRestore_KilledAfterGracePeriod(t *testing.T) {
+	a := New(
+		Stdout(io.Discard),
+		GracePeriod(10*time.Millisecond),
+		withExecCmd(func(ctx context.Context, name string, args ...string) *exec.Cmd {
+			// Ignores the interrupt.
+			return exec.CommandContext(ctx, "sh", "-c", `trap "" INT; exec sleep 3600`)
+		}),
+	).(ContextASR)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	dummyVolume := diskutil.VolumeInfo{}
+	dummySnap := diskutil.Snapshot{}
+	start := time.Now()
+	err := a.DestructiveRestoreContext(ctx, dummyVolume, dummyVolume, dummySnap)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("DestructiveRestoreContext returned unexpected error: %v, want: context.Canceled", err)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("DestructiveRestoreContext returned after %v, want: soon after the grace period", elapsed)
+	}
+}
+
+func TestIsTransient(t *testing.T) {
+	tests := []struct {
+		name   string
+		stderr string
+		want   bool
+	}{
+		{
+			name:   "target busy",
+			stderr: "asr: Couldn't unmount target volume: Resource busy",
+			want:   true,
+		},
+		{
+			name:   "other failure",
+			stderr: "asr: Invalid snapshot",
+			want:   false,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			a := New(
+				Stdout(io.Discard),
+				withExecCmd(fakecmd.FakeCommandContext(t,
+					fakecmd.Stderr("asr", test.stderr),
+					fakecmd.ExitFail("asr"),
+				)),
+			)
+			dummyVolume := diskutil.VolumeInfo{}
+			dummySnap := diskutil.Snapshot{}
+			err := a.Restore(dummyVolume, dummyVolume, dummySnap, dummySnap)
+			if err := fakecmd.AsHelperProcessErr(err); err != nil {
+				t.Fatal(err)
+			}
+			if !errors.Is(err, ErrRestoreFailed) {
+				t.Errorf("Restore returned unexpected error: %v, want: ErrRestoreFailed", err)
+			}
+			if got := IsTransient(err); got != test.want {
+				t.Errorf("IsTransient(%v) = %t, want: %t", err, got, test.want)
+			}
+		})
+	}
+}
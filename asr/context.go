@@ -0,0 +1,39 @@
+package asr
+
+import (
+	"context"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/diskutil"
+)
+
+// WithContext returns r as a ContextASR. If r doesn't implement ContextASR,
+// the Context variants of the returned ASR's methods return ctx's error if
+// ctx is already done, and otherwise call r's methods, which run to
+// completion. WithContext returns nil if r is nil.
+func WithContext(r ASR) ContextASR {
+	if r == nil {
+		return nil
+	}
+	if cr, ok := r.(ContextASR); ok {
+		return cr
+	}
+	return contextASR{r}
+}
+
+type contextASR struct {
+	ASR
+}
+
+func (r contextASR) RestoreContext(ctx context.Context, source, target diskutil.VolumeInfo, to, from diskutil.Snapshot) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return r.Restore(source, target, to, from)
+}
+
+func (r contextASR) DestructiveRestoreContext(ctx context.Context, source, target diskutil.VolumeInfo, to diskutil.Snapshot) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return r.DestructiveRestore(source, target, to)
+}
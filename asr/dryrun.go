@@ -1,6 +1,7 @@
 package asr
 
 import (
+	"context"
 	"fmt"
 	"os"
 
@@ -28,7 +29,15 @@ func (dry dryRun) Restore(source, target diskutil.VolumeInfo, to, from diskutil.
 	return nil
 }
 
+func (dry dryRun) RestoreContext(ctx context.Context, source, target diskutil.VolumeInfo, to, from diskutil.Snapshot) error {
+	return dry.Restore(source, target, to, from)
+}
+
 func (dry dryRun) DestructiveRestore(source, target diskutil.VolumeInfo, to diskutil.Snapshot) error {
 	fmt.Fprintln(dry.stdout, "Restore completed successfully.")
 	return nil
 }
+
+func (dry dryRun) DestructiveRestoreContext(ctx context.Context, source, target diskutil.VolumeInfo, to diskutil.Snapshot) error {
+	return dry.DestructiveRestore(source, target, to)
+}
@@ -0,0 +1,170 @@
+package asr
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/diskutil"
+)
+
+// Progress is the progress of a restore, parsed from asr's --puppetstrings
+// output.
+type Progress struct {
+	// Phase is asr's current phase, e.g. "setup", "restore", or "verify".
+	Phase string
+	// Percent is the percentage of Phase completed, from 0 to 100.
+	Percent float64
+	// TotalBytes is the number of bytes to restore, if reported by asr.
+	TotalBytes int64
+	// Bytes is the estimated number of bytes restored so far. Only set
+	// during the "restore" phase, if TotalBytes is known.
+	Bytes int64
+}
+
+// ProgressFunc is called with each update to the percent completed of a
+// phase of a restore to target. Each phase with progress starts at 0 and ends
+// at 100 percent, though some phases have no progress.
+type ProgressFunc func(target diskutil.VolumeInfo, p Progress)
+
+// OnProgress returns an Option that calls f with the progress of each
+// restore, instead of writing the progress to stdout. f is called from the
+// goroutine calling Restore or DestructiveRestore.
+func OnProgress(f ProgressFunc) Option {
+	return func(conf *config) {
+		conf.onProgress = f
+	}
+}
+
+// progressWriter is the stdout of asr. It parses asr's --puppetstrings output
+// into Progress, and writes all other output to stdout.
+type progressWriter struct {
+	target     diskutil.VolumeInfo
+	stdout     io.Writer
+	onProgress ProgressFunc
+
+	buf      []byte
+	progress Progress
+	// reported is the last multiple of 10 percent of the current phase
+	// written to stdout, if onProgress is not set. -10 if none were.
+	reported int
+}
+
+func (a asr) newProgressWriter(target diskutil.VolumeInfo) *progressWriter {
+	return &progressWriter{
+		target:     target,
+		stdout:     a.stdout,
+		onProgress: a.onProgress,
+		reported:   -10,
+	}
+}
+
+func (w *progressWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		line := w.buf[:i+1]
+		if err := w.writeLine(line); err != nil {
+			return len(p), err
+		}
+		w.buf = w.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+// Flush writes any incomplete last line.
+func (w *progressWriter) Flush() error {
+	if len(w.buf) == 0 {
+		return nil
+	}
+	err := w.writeLine(w.buf)
+	w.buf = nil
+	return err
+}
+
+func (w *progressWriter) writeLine(line []byte) error {
+	ok, percentChanged := w.parse(strings.TrimRight(string(line), "\r\n"))
+	if !ok {
+		_, err := w.stdout.Write(line)
+		return err
+	}
+	if !percentChanged {
+		return nil
+	}
+	if w.onProgress != nil {
+		w.onProgress(w.target, w.progress)
+		return nil
+	}
+	// Only write every 10% to stdout, to keep logs readable.
+	if percent := int(w.progress.Percent) / 10 * 10; percent > w.reported {
+		w.reported = percent
+		_, err := fmt.Fprintf(w.stdout, "%s: %d%%\n", w.progress.Phase, percent)
+		return err
+	}
+	return nil
+}
+
+// parse updates w.progress with a line of asr's --puppetstrings output. ok is
+// false if line isn't one, and percentChanged is true if line reports the
+// percent completed. Lines are tab separated:
+//
+//	XSTA <phase> [<size> bytes]   the start of a phase, with the total size
+//	                              of the restore at the "start" phase
+//	PSTT                          the start of progress of the phase
+//	PINF <percent>                the percent of the phase completed
+//	PSTP                          the end of progress of the phase
+func (w *progressWriter) parse(line string) (ok, percentChanged bool) {
+	fields := strings.Split(line, "\t")
+	for i := range fields {
+		fields[i] = strings.TrimSpace(fields[i])
+	}
+	switch fields[0] {
+	case "XSTA":
+		if len(fields) < 2 {
+			return false, false
+		}
+		w.progress.Phase = fields[1]
+		w.progress.Percent = 0
+		w.progress.Bytes = 0
+		w.reported = -10
+		if len(fields) >= 4 && fields[3] == "bytes" {
+			if size, err := strconv.ParseInt(fields[2], 10, 64); err == nil {
+				w.progress.TotalBytes = size
+			}
+		}
+	case "PSTT":
+		w.setPercent(0)
+	case "PINF":
+		if len(fields) < 2 {
+			return false, false
+		}
+		percent, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			return false, false
+		}
+		w.setPercent(percent)
+	case "PSTP":
+		w.setPercent(100)
+	default:
+		return false, false
+	}
+	return true, fields[0] != "XSTA"
+}
+
+func (w *progressWriter) setPercent(percent float64) {
+	if percent < 0 {
+		percent = 0
+	}
+	if percent > 100 {
+		percent = 100
+	}
+	w.progress.Percent = percent
+	if w.progress.Phase == "restore" && w.progress.TotalBytes > 0 {
+		w.progress.Bytes = int64(float64(w.progress.TotalBytes) * percent / 100)
+	}
+}
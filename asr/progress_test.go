@@ -0,0 +1,98 @@
+package asr
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/diskutil"
+	"github.com/voidingwarranties/offsite-apfs-backup/testutils/fakecmd"
+)
+
+const puppetStrings = "XSTA\tstart\t1000\tbytes\n" +
+	"XSTA\tsetup\n" +
+	"Validating target...done\n" +
+	"XSTA\trestore\n" +
+	"PSTT\n" +
+	"PINF\t5\n" +
+	"PINF\t50\n" +
+	"PINF\t55.5\n" +
+	"PSTP\n" +
+	"XSTA\tverify\n" +
+	"PSTT\n" +
+	"PINF\t100\n" +
+	"PSTP\n" +
+	"Restore completed successfully."
+
+func TestRestore_Progress(t *testing.T) {
+	target := diskutil.VolumeInfo{UUID: "target-uuid"}
+	var got []Progress
+	stdout := new(bytes.Buffer)
+	a := New(
+		Stdout(stdout),
+		OnProgress(func(tgt diskutil.VolumeInfo, p Progress) {
+			if tgt != target {
+				t.Errorf("OnProgress called with target %v, want: %v", tgt, target)
+			}
+			got = append(got, p)
+		}),
+		withExecCmd(fakecmd.FakeCommandContext(t,
+			fakecmd.Stdout("asr", puppetStrings),
+			fakecmd.WantArg("asr", "--puppetstrings"),
+		)),
+	)
+	err := a.Restore(diskutil.VolumeInfo{}, target, diskutil.Snapshot{}, diskutil.Snapshot{})
+	if err := fakecmd.AsHelperProcessErr(err); err != nil {
+		t.Fatal(err)
+	}
+	if err != nil {
+		t.Fatalf("Restore returned unexpected error: %v, want: nil", err)
+	}
+
+	want := []Progress{
+		{Phase: "restore", TotalBytes: 1000},
+		{Phase: "restore", Percent: 5, TotalBytes: 1000, Bytes: 50},
+		{Phase: "restore", Percent: 50, TotalBytes: 1000, Bytes: 500},
+		{Phase: "restore", Percent: 55.5, TotalBytes: 1000, Bytes: 555},
+		{Phase: "restore", Percent: 100, TotalBytes: 1000, Bytes: 1000},
+		{Phase: "verify", TotalBytes: 1000},
+		{Phase: "verify", Percent: 100, TotalBytes: 1000},
+		{Phase: "verify", Percent: 100, TotalBytes: 1000},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Restore reported unexpected progress. -want +got:\n%s", diff)
+	}
+	wantStdout := "Validating target...done\nRestore completed successfully."
+	if diff := cmp.Diff(wantStdout, stdout.String()); diff != "" {
+		t.Errorf("Restore wrote unexpected stdout. -want +got:\n%s", diff)
+	}
+}
+
+func TestDestructiveRestore_ProgressToStdout(t *testing.T) {
+	stdout := new(bytes.Buffer)
+	a := New(
+		Stdout(stdout),
+		withExecCmd(fakecmd.FakeCommandContext(t,
+			fakecmd.Stdout("asr", puppetStrings),
+			fakecmd.WantArg("asr", "--puppetstrings"),
+		)),
+	)
+	err := a.DestructiveRestore(diskutil.VolumeInfo{}, diskutil.VolumeInfo{}, diskutil.Snapshot{})
+	if err := fakecmd.AsHelperProcessErr(err); err != nil {
+		t.Fatal(err)
+	}
+	if err != nil {
+		t.Fatalf("DestructiveRestore returned unexpected error: %v, want: nil", err)
+	}
+	want := `Validating target...done
+restore: 0%
+restore: 50%
+restore: 100%
+verify: 0%
+verify: 100%
+Restore completed successfully.`
+	if diff := cmp.Diff(want, stdout.String()); diff != "" {
+		t.Errorf("DestructiveRestore wrote unexpected stdout. -want +got:\n%s", diff)
+	}
+}
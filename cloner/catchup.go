@@ -0,0 +1,115 @@
+package cloner
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/diskutil"
+)
+
+// Hop is a single incremental restore of a catch-up clone (see CatchUp).
+type Hop struct {
+	From diskutil.Snapshot
+	To   diskutil.Snapshot
+}
+
+func (h Hop) String() string {
+	return fmt.Sprintf("%s -> %s", h.From, h.To)
+}
+
+// HopError is returned by Clone when a catch-up clone fails partway through.
+// Hops[:Completed] were restored successfully, and target is left at the
+// last of those hops' To snapshot.
+type HopError struct {
+	Hops      []Hop
+	Completed int
+	Err       error
+}
+
+func (err *HopError) Error() string {
+	if err.Completed == 0 {
+		return fmt.Sprintf("error restoring hop 1/%d (%s): %v", len(err.Hops), err.Hops[0], err.Err)
+	}
+	return fmt.Sprintf("error restoring hop %d/%d (%s): %v; target stopped at %s",
+		err.Completed+1, len(err.Hops), err.Hops[err.Completed], err.Err, err.Hops[err.Completed-1].To)
+}
+
+func (err *HopError) Unwrap() error {
+	return err.Err
+}
+
+// LastCompleted returns the snapshot target was left at, and false if no hops
+// were completed.
+func (err *HopError) LastCompleted() (diskutil.Snapshot, bool) {
+	if err.Completed == 0 {
+		return diskutil.Snapshot{}, false
+	}
+	return err.Hops[err.Completed-1].To, true
+}
+
+// catchUpHops returns the hops required to restore target from common to
+// sourceSnaps[toIndex], passing through every source snapshot in between.
+// sourceSnaps must be ordered most recent first, and common must be older than
+// sourceSnaps[toIndex].
+func catchUpHops(sourceSnaps []diskutil.Snapshot, common diskutil.Snapshot, toIndex int) []Hop {
+	commonIndex := toIndex
+	for i := toIndex; i < len(sourceSnaps); i++ {
+		if sourceSnaps[i].UUID == common.UUID {
+			commonIndex = i
+			break
+		}
+	}
+	var hops []Hop
+	from := common
+	for i := commonIndex - 1; i >= toIndex; i-- {
+		hops = append(hops, Hop{From: from, To: sourceSnaps[i]})
+		from = sourceSnaps[i]
+	}
+	return hops
+}
+
+// restoreHops restores each hop in order, stopping at the first failure. The
+// outcome of each attempted hop is appended to result.Hops.
+func (c Cloner) restoreHops(ctx context.Context, source, target diskutil.VolumeInfo, hops []Hop, result *CloneResult) error {
+	for i, hop := range hops {
+		fmt.Fprintf(c.stdout, "Restoring hop %d/%d:\n\t%s\n", i+1, len(hops), hop)
+		start := time.Now()
+		from := hop.From
+		entry := JournalEntry{
+			Step:   PhaseRestore,
+			Source: source,
+			Target: target,
+			From:   &from,
+			To:     hop.To,
+		}
+		event := Event{
+			Source: &source,
+			Target: &target,
+			From:   &from,
+			To:     &entry.To,
+			Hop:    i + 1,
+			Hops:   len(hops),
+		}
+		err := c.restoreEvents(event, func() error {
+			return c.journaled(entry, func() error {
+				return c.asr.RestoreContext(ctx, source, target, hop.To, hop.From)
+			})
+		})
+		result.Hops = append(result.Hops, HopResult{
+			Hop:      hop,
+			Duration: time.Since(start),
+			Err:      err,
+		})
+		if err != nil {
+			fmt.Fprintf(c.stdout, "Hop %d/%d failed.\n", i+1, len(hops))
+			return &HopError{
+				Hops:      hops,
+				Completed: i,
+				Err:       err,
+			}
+		}
+		fmt.Fprintf(c.stdout, "Hop %d/%d completed.\n", i+1, len(hops))
+	}
+	return nil
+}
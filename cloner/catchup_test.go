@@ -0,0 +1,176 @@
+package cloner
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/diskutil"
+)
+
+var (
+	catchUpSnap1 = diskutil.Snapshot{Name: "snap-1", UUID: "snap-1-uuid"}
+	catchUpSnap2 = diskutil.Snapshot{Name: "snap-2", UUID: "snap-2-uuid"}
+	catchUpSnap3 = diskutil.Snapshot{Name: "snap-3", UUID: "snap-3-uuid"}
+	catchUpSnap4 = diskutil.Snapshot{Name: "snap-4", UUID: "snap-4-uuid"}
+
+	catchUpSource = diskutil.VolumeInfo{
+		Name:           "source-name",
+		UUID:           "source-uuid",
+		MountPoint:     "/source/mount/point",
+		Writable:       true,
+		FileSystemType: "apfs",
+	}
+	catchUpTarget = diskutil.VolumeInfo{
+		Name:           "target-name",
+		UUID:           "target-uuid",
+		MountPoint:     "/target/mount/point",
+		Writable:       true,
+		FileSystemType: "apfs",
+	}
+)
+
+func TestCatchUpHops(t *testing.T) {
+	sourceSnaps := []diskutil.Snapshot{catchUpSnap4, catchUpSnap3, catchUpSnap2, catchUpSnap1}
+	tests := []struct {
+		name    string
+		common  diskutil.Snapshot
+		toIndex int
+		want    []Hop
+	}{
+		{
+			name:    "latest",
+			common:  catchUpSnap1,
+			toIndex: 0,
+			want: []Hop{
+				{From: catchUpSnap1, To: catchUpSnap2},
+				{From: catchUpSnap2, To: catchUpSnap3},
+				{From: catchUpSnap3, To: catchUpSnap4},
+			},
+		},
+		{
+			name:    "selected snapshot",
+			common:  catchUpSnap1,
+			toIndex: 1,
+			want: []Hop{
+				{From: catchUpSnap1, To: catchUpSnap2},
+				{From: catchUpSnap2, To: catchUpSnap3},
+			},
+		},
+		{
+			name:    "single hop",
+			common:  catchUpSnap3,
+			toIndex: 0,
+			want: []Hop{
+				{From: catchUpSnap3, To: catchUpSnap4},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := catchUpHops(sourceSnaps, test.common, test.toIndex)
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("catchUpHops returned unexpected hops. -want +got:\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestClone_CatchUp(t *testing.T) {
+	tests := []struct {
+		name            string
+		opts            []Option
+		wantTargetSnaps []diskutil.Snapshot
+	}{
+		{
+			name:            "default options",
+			opts:            []Option{CatchUp(true)},
+			wantTargetSnaps: []diskutil.Snapshot{catchUpSnap4, catchUpSnap3, catchUpSnap2, catchUpSnap1},
+		},
+		{
+			name:            "prune target",
+			opts:            []Option{CatchUp(true), Prune(true)},
+			wantTargetSnaps: []diskutil.Snapshot{catchUpSnap4, catchUpSnap3, catchUpSnap2},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			devices := newFakeDevices(t,
+				withFakeVolume(catchUpSource, catchUpSnap4, catchUpSnap3, catchUpSnap2, catchUpSnap1),
+				withFakeVolume(catchUpTarget, catchUpSnap1),
+			)
+			du := &fakeDiskUtil{devices}
+			r := &fakeASR{devices}
+			c := New(du, r, test.opts...)
+			if _, err := c.Clone(catchUpSource.MountPoint, catchUpTarget.MountPoint); err != nil {
+				t.Fatalf("Clone(...) returned unexpected error: %q, want: nil", err)
+			}
+
+			gotTargetSnaps, err := devices.Snapshots(catchUpTarget.UUID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cmpOpts := []cmp.Option{
+				cmpopts.SortSlices(func(lhs, rhs diskutil.Snapshot) bool {
+					return lhs.UUID < rhs.UUID
+				}),
+			}
+			if diff := cmp.Diff(test.wantTargetSnaps, gotTargetSnaps, cmpOpts...); diff != "" {
+				t.Errorf("Clone(...) resulted in unexpected snapshots in target. -want +got:\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestClone_CatchUpStopsAtLastSuccessfulHop(t *testing.T) {
+	devices := newFakeDevices(t,
+		withFakeVolume(catchUpSource, catchUpSnap4, catchUpSnap3, catchUpSnap2, catchUpSnap1),
+		withFakeVolume(catchUpTarget, catchUpSnap1),
+	)
+	du := &fakeDiskUtil{devices}
+	r := &failingFakeASR{
+		fakeASR: fakeASR{devices},
+		succeed: 1,
+	}
+	c := New(du, r, CatchUp(true), Prune(true))
+	_, err := c.Clone(catchUpSource.MountPoint, catchUpTarget.MountPoint)
+	var hopErr *HopError
+	if !errors.As(err, &hopErr) {
+		t.Fatalf("Clone(...) returned unexpected error: %v, want: *HopError", err)
+	}
+	if hopErr.Completed != 1 {
+		t.Errorf("HopError.Completed = %d, want: 1", hopErr.Completed)
+	}
+	if got, _ := hopErr.LastCompleted(); got != catchUpSnap2 {
+		t.Errorf("HopError.LastCompleted() = %s, want: %s", got, catchUpSnap2)
+	}
+
+	t.Run("target stopped at last successful hop", func(t *testing.T) {
+		gotTargetSnaps, err := devices.Snapshots(catchUpTarget.UUID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The common snapshot is not pruned, as the clone did not
+		// complete.
+		wantTargetSnaps := []diskutil.Snapshot{catchUpSnap2, catchUpSnap1}
+		cmpOpts := []cmp.Option{
+			cmpopts.SortSlices(func(lhs, rhs diskutil.Snapshot) bool {
+				return lhs.UUID < rhs.UUID
+			}),
+		}
+		if diff := cmp.Diff(wantTargetSnaps, gotTargetSnaps, cmpOpts...); diff != "" {
+			t.Errorf("Clone(...) resulted in unexpected snapshots in target. -want +got:\n%s", diff)
+		}
+	})
+	t.Run("target renamed to original name", func(t *testing.T) {
+		gotTargetInfo, err := devices.Volume(catchUpTarget.UUID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if gotTargetInfo.Name != catchUpTarget.Name {
+			t.Errorf("target name = %q, want: %q", gotTargetInfo.Name, catchUpTarget.Name)
+		}
+	})
+}
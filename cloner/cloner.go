@@ -2,13 +2,14 @@
 package cloner
 
 import (
-	"errors"
-	"fmt"
+	"context"
 	"io"
 	"os"
+	"time"
 
 	"github.com/voidingwarranties/offsite-apfs-backup/asr"
 	"github.com/voidingwarranties/offsite-apfs-backup/diskutil"
+	"github.com/voidingwarranties/offsite-apfs-backup/tmutil"
 )
 
 // Option configures Cloner.
@@ -33,6 +34,45 @@ func InitializeTargets(initTargets bool) Option {
 	}
 }
 
+// CatchUp returns an Option that, if catchUp is true, changes the behavior of
+// an incremental Clone to restore every source snapshot between the common
+// snapshot and the selected snapshot, one at a time and oldest first, rather
+// than restoring the selected snapshot in a single step. Target is left with
+// the same snapshot history as source. Has no effect if InitializeTargets is
+// true.
+func CatchUp(catchUp bool) Option {
+	return func(c *Cloner) {
+		c.catchUp = catchUp
+	}
+}
+
+// Retention returns an Option that, after a successful clone, deletes target
+// snapshots that are not kept by any of the given policies. The snapshot
+// target was restored to, and the snapshot needed as the base of the next
+// incremental clone, are never deleted. By default, no retention policies are
+// applied.
+func Retention(policies ...RetentionPolicy) Option {
+	return func(c *Cloner) {
+		c.retention = policies
+	}
+}
+
+// withNow replaces time.Now when applying retention policies. It's used in
+// tests.
+func withNow(now func() time.Time) Option {
+	return func(c *Cloner) {
+		c.now = now
+	}
+}
+
+// ToSnapshot returns an Option that selects which of source's snapshots is
+// cloned to targets. By default, the latest snapshot is cloned.
+func ToSnapshot(sel SnapshotSelector) Option {
+	return func(c *Cloner) {
+		c.selectSnapshot = sel
+	}
+}
+
 // Stdout returns an Option that sets the stdout to the given io.Writer.
 func Stdout(w io.Writer) Option {
 	return func(c *Cloner) {
@@ -40,32 +80,67 @@ func Stdout(w io.Writer) Option {
 	}
 }
 
-// New returns a new Cloner with the given options.
+// New returns a new Cloner with the given options. The Context variants of
+// Cloner's methods, and the CommandTimeout and RestoreTimeout options, only
+// stop commands of a du that implements diskutil.ContextDiskUtil and an r
+// that implements asr.ContextASR, as those returned by diskutil.New and
+// asr.New do. Other commands run to completion.
 func New(du diskutil.DiskUtil, r asr.ASR, opts ...Option) Cloner {
 	c := Cloner{
-		diskutil: du,
-		asr:      r,
+		diskutil: diskutil.WithContext(du),
+		asr:      asr.WithContext(r),
 
 		stdout: os.Stdout,
 
-		prune:       false,
-		initTargets: false,
+		prune:          false,
+		initTargets:    false,
+		catchUp:        false,
+		selectSnapshot: LatestSnapshot(),
+
+		snapshotTimeout:      time.Minute,
+		snapshotPollInterval: time.Second,
+
+		journal: nopJournal{},
+
+		now: time.Now,
 	}
 	for _, opt := range opts {
 		opt(&c)
 	}
+	c.applyTimeouts()
+	c.applyRetries()
 	return c
 }
 
 // Cloner clones APFS volumes using APFS snapshot diffs.
 type Cloner struct {
-	diskutil diskutil.DiskUtil
-	asr      asr.ASR
+	diskutil diskutil.ContextDiskUtil
+	asr      asr.ContextASR
 
 	stdout io.Writer
 
-	prune       bool
-	initTargets bool
+	prune            bool
+	initTargets      bool
+	catchUp          bool
+	discardDiverged  bool
+	fallbackRestores int
+	selectSnapshot   SnapshotSelector
+	retention        []RetentionPolicy
+
+	tmutil               tmutil.ContextTMUtil
+	snapshotTimeout      time.Duration
+	snapshotPollInterval time.Duration
+
+	journal Journal
+	verify  bool
+	hooks   map[HookPoint][]Hook
+	events  EventSink
+
+	commandTimeout time.Duration
+	restoreTimeout time.Duration
+	retry          RetryPolicy
+
+	now func() time.Time
 }
 
 // Cloneable returns nil if source is cloneable to all targets, where cloneable
@@ -75,189 +150,49 @@ type Cloner struct {
 //     i.e. all must be non-case-sensitive, or all must be case-sensitive.
 //   - All targets are writable.
 //   - All targets must have a snapshot in common with source.
-//   - The snapshot in common must not be the latest snapshot in source.
+//   - The snapshot in common must be older than the selected source snapshot
+//     (see ToSnapshot), and targets must not have snapshots newer than it,
+//     unless they are discarded (see DiscardDiverged).
+//
+// See CompareHistories, and Diagnose to explain why targets aren't cloneable.
 func (c Cloner) Cloneable(source string, targets ...string) error {
-	sourceInfo, err := c.diskutil.Info(source)
-	if err != nil {
-		return fmt.Errorf("invalid source volume: %v", err)
-	}
-	if sourceInfo.FileSystemType != "apfs" {
-		return errors.New("invalid source volume: does not contain an APFS file system")
-	}
-	sourceSnaps, err := c.diskutil.ListSnapshots(sourceInfo)
-	if err != nil {
-		return fmt.Errorf("error listing snapshots of source: %v", err)
-	}
-	if len(sourceSnaps) == 0 {
-		return errors.New("invalid source: no snapshots to clone")
-	}
-
-	if len(targets) == 0 {
-		return errors.New("no targets")
-	}
-	// Map of target UUIDs to the target argument.
-	targetUUIDs := make(map[string]string)
-	for _, t := range targets {
-		targetInfo, err := c.diskutil.Info(t)
-		if err != nil {
-			return fmt.Errorf("invalid target volume: %v", err)
-		}
-		if sourceInfo.UUID == targetInfo.UUID {
-			return errors.New("source and target must be different volumes")
-		}
-		if duplicate := targetUUIDs[targetInfo.UUID]; duplicate != "" {
-			return fmt.Errorf("invalid target: %q is the same as %q", t, duplicate)
-		}
-		targetUUIDs[targetInfo.UUID] = t
-		if targetInfo.FileSystemType != "apfs" {
-			return errors.New("invalid target volume: does not contain an APFS file system")
-		}
-		// `asr restore` will restore the target volume to the same file system
-		// as source. To be safe, error here to prevent changing the file
-		// system without the user knowing.
-		if sourceInfo.FileSystem != targetInfo.FileSystem {
-			return fmt.Errorf("invalid source + target combination: source is formatted as %s, but target is formatted as %s", sourceInfo.FileSystem, targetInfo.FileSystem)
-		}
-		if !targetInfo.Writable {
-			return errors.New("invalid target volume: volume not writable")
-		}
-
-		targetSnaps, err := c.diskutil.ListSnapshots(targetInfo)
-		if err != nil {
-			return fmt.Errorf("error listing snapshots of target: %v", err)
-		}
-		if err := c.cloneable(sourceSnaps, targetSnaps); err != nil {
-			return err
-		}
-	}
-	return nil
+	return c.CloneableContext(context.Background(), source, targets...)
 }
 
-func (c Cloner) cloneable(sourceSnaps, targetSnaps []diskutil.Snapshot) error {
-	if !c.initTargets {
-		_, err := latestCommonSnapshot(sourceSnaps, targetSnaps)
-		return err
-	}
-	if len(targetSnaps) > 0 {
-		return errors.New("invalid target: target has snapshots - erase the disk before using initialize")
-	}
-	return nil
+// CloneableContext is like Cloneable, but stops if ctx is done first.
+func (c Cloner) CloneableContext(ctx context.Context, source string, targets ...string) error {
+	_, err := c.PlanContext(ctx, source, targets...)
+	return err
 }
 
-// Clone the latest snapshot in source to target, from the most recent common
-// snapshot present in both source and target.
-func (c Cloner) Clone(source, target string) error {
-	sourceInfo, err := c.diskutil.Info(source)
-	if err != nil {
-		return fmt.Errorf("error getting volume info of source %q: %v", source, err)
-	}
-	targetInfo, err := c.diskutil.Info(target)
-	if err != nil {
-		return fmt.Errorf("error getting volume info of target %q: %v", target, err)
-	}
-
-	if c.initTargets {
-		if err := c.destructiveClone(sourceInfo, targetInfo); err != nil {
-			return err
-		}
-	} else {
-		if err := c.clone(sourceInfo, targetInfo); err != nil {
-			return err
-		}
-	}
-	// ASR renames the volume to source's name after a restore. Change it
-	// back.
-	if err := c.diskutil.Rename(targetInfo, targetInfo.Name); err != nil {
-		return fmt.Errorf("error renaming volume to original name: %v", err)
-	}
-	return nil
+// Clone the selected snapshot in source (by default, the latest) to target,
+// from the most recent common snapshot present in both source and target.
+// Clone is equivalent to calling Plan and then Execute with a single target.
+//
+// Clone returns a CloneResult describing what was done to target. Non-fatal
+// issues, such as failing to rename target back to its original name, are
+// reported as CloneResult.Warnings rather than as an error.
+func (c Cloner) Clone(source, target string) (CloneResult, error) {
+	return c.CloneContext(context.Background(), source, target)
 }
 
-func (c Cloner) clone(source, target diskutil.VolumeInfo) error {
-	sourceSnaps, err := c.diskutil.ListSnapshots(source)
+// CloneContext is like Clone, but stops if ctx is done first. See
+// ExecuteTargetContext.
+func (c Cloner) CloneContext(ctx context.Context, source, target string) (CloneResult, error) {
+	plan, err := c.PlanContext(ctx, source, target)
 	if err != nil {
-		return fmt.Errorf("error listing snapshots of source: %v", err)
-	}
-	if len(sourceSnaps) == 0 {
-		return errors.New("source does not contain any snapshots")
-	}
-	// TODO: document that this relies on the snapshots being in the right order.
-	latestSourceSnap := sourceSnaps[0]
-	fmt.Fprintf(c.stdout, "Latest snapshot in source:\n\t%s\n", latestSourceSnap)
-
-	targetSnaps, err := c.diskutil.ListSnapshots(target)
-	if err != nil {
-		return fmt.Errorf("error listing snapshots of target: %v", err)
-	}
-	commonSnap, err := latestCommonSnapshot(sourceSnaps, targetSnaps)
-	if err != nil {
-		return fmt.Errorf("error finding latest snapshot in common between source and target: %v", err)
-	}
-	fmt.Fprintf(c.stdout, "Snapshot in common:\n\t%s\n", commonSnap)
-
-	fmt.Fprintln(c.stdout, "Restoring to latest snapshot in source from common snapshot...")
-	if err := c.asr.Restore(source, target, latestSourceSnap, commonSnap); err != nil {
-		return fmt.Errorf("error restoring: %v", err)
-	}
-
-	if c.prune {
-		if err := c.diskutil.DeleteSnapshot(target, commonSnap); err != nil {
-			return fmt.Errorf("error deleting snapshot %q from target", commonSnap)
-		}
-		fmt.Fprintln(c.stdout, "Pruned common snapshot from target.")
-	}
-	return nil
-}
-
-func (c Cloner) destructiveClone(source, target diskutil.VolumeInfo) error {
-	sourceSnaps, err := c.diskutil.ListSnapshots(source)
-	if err != nil {
-		return fmt.Errorf("error listing snapshots of source: %v", err)
-	}
-	if len(sourceSnaps) == 0 {
-		return errors.New("source does not contain any snapshots")
-	}
-	// TODO: document that this relies on the snapshots being in the right order.
-	latestSourceSnap := sourceSnaps[0]
-	fmt.Fprintf(c.stdout, "Latest snapshot in source:\n\t%s\n", latestSourceSnap)
-
-	targetSnaps, err := c.diskutil.ListSnapshots(target)
-	if err != nil {
-		return fmt.Errorf("error listing snapshots of target: %v", err)
-	}
-	if len(targetSnaps) > 0 {
-		return errors.New("aborting because target contains snapshots that would be erased")
-	}
-	fmt.Fprintln(c.stdout, "Restoring to latest snapshot in source...")
-	if err := c.asr.DestructiveRestore(source, target, latestSourceSnap); err != nil {
-		return fmt.Errorf("error restoring: %v", err)
-	}
-	return nil
-}
-
-// TODO: document that this relies on the snapshots being in the right order.
-func latestCommonSnapshot(source, target []diskutil.Snapshot) (diskutil.Snapshot, error) {
-	commonSourceI, commonTargetI, exists := latestCommonSnapshotIndices(source, target)
-	if !exists {
-		return diskutil.Snapshot{}, errors.New("source and target have no snapshots in common")
-	}
-	if commonSourceI == 0 && commonTargetI == 0 {
-		return diskutil.Snapshot{}, errors.New("both source and target have the same latest snapshot")
-	}
-	// TODO: is this logic correct? Shouldn't it be `commonSourceI < commonTargetI`?
-	if commonSourceI == 0 {
-		return diskutil.Snapshot{}, errors.New("target has a snapshot ahead of source")
+		return CloneResult{}, err
 	}
-	return source[commonSourceI], nil
+	return c.ExecuteTargetContext(ctx, plan, plan.Targets[0])
 }
 
-func latestCommonSnapshotIndices(source, target []diskutil.Snapshot) (sourceIndex, targetIndex int, exists bool) {
-	for targetIndex, ts := range target {
-		for sourceIndex, ss := range source {
-			if ss.UUID == ts.UUID {
-				return sourceIndex, targetIndex, true
-			}
-		}
+// latestCommonSnapshot returns the most recent snapshot present in both source
+// and target, validating that target can be restored from it to the snapshot
+// to clone, source[toIndex]. See CompareHistories.
+func latestCommonSnapshot(source, target []diskutil.Snapshot, toIndex int) (diskutil.Snapshot, error) {
+	h := CompareHistories(source, toIndex, target)
+	if err := h.Err(); err != nil {
+		return diskutil.Snapshot{}, err
 	}
-	return 0, 0, false
+	return *h.Common, nil
 }
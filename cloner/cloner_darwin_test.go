@@ -166,7 +166,7 @@ func TestClone_DryRun(t *testing.T) {
 	du := diskutil.NewDryRun(diskutil.New())
 	r := asr.NewDryRun()
 	c := cloner.New(du, r)
-	if err := c.Clone(sourceInfo.Device, targetInfo.Device); err != nil {
+	if _, err := c.Clone(sourceInfo.Device, targetInfo.Device); err != nil {
 		t.Fatalf("Clone returned unexpected error: %q, want: nil", err)
 	}
 
@@ -184,7 +184,7 @@ func TestClone_DryRun(t *testing.T) {
 		if err != nil {
 			t.Fatal(err)
 		}
-		if diff := cmp.Diff(wantTargetSnaps, gotSnaps); diff != "" {
+		if diff := cmp.Diff(wantTargetSnaps, gotSnaps, diskimage.IgnoreSnapshotState); diff != "" {
 			t.Errorf("Clone resulted in unexpected target snapshots. -want +got:\n%s", diff)
 		}
 	})
@@ -227,7 +227,7 @@ func TestClone_Incremental(t *testing.T) {
 			}
 
 			c := cloner.New(diskutil.New(), asr.New(), test.opts...)
-			if err := c.Clone(source, target); err != nil {
+			if _, err := c.Clone(source, target); err != nil {
 				t.Fatalf("Clone returned unexpected error: %v, want: nil", err)
 			}
 
@@ -249,7 +249,7 @@ func TestClone_Incremental(t *testing.T) {
 				if err != nil {
 					t.Fatal(err)
 				}
-				if diff := cmp.Diff(test.wantTargetSnaps, gotTargetSnaps); diff != "" {
+				if diff := cmp.Diff(test.wantTargetSnaps, gotTargetSnaps, diskimage.IgnoreSnapshotState); diff != "" {
 					t.Errorf("Clone resulted in unexpected target snapshots. -want +got:\n%s", diff)
 				}
 			})
@@ -268,7 +268,7 @@ func TestClone_InitializeTargets(t *testing.T) {
 	}
 
 	c := cloner.New(diskutil.New(), asr.New(), cloner.InitializeTargets(true))
-	if err := c.Clone(source, target); err != nil {
+	if _, err := c.Clone(source, target); err != nil {
 		t.Fatalf("Clone returned unexpected error: %v, want: nil", err)
 	}
 
@@ -296,7 +296,7 @@ func TestClone_InitializeTargets(t *testing.T) {
 		wantTargetSnaps := []diskutil.Snapshot{
 			diskimage.SourceImg.Snapshots(t)[0],
 		}
-		if diff := cmp.Diff(wantTargetSnaps, gotTargetSnaps); diff != "" {
+		if diff := cmp.Diff(wantTargetSnaps, gotTargetSnaps, diskimage.IgnoreSnapshotState); diff != "" {
 			t.Errorf("Clone resulted in unexpected target snapshots. -want +got:\n%s", diff)
 		}
 	})
@@ -342,7 +342,7 @@ func TestClone_Errors(t *testing.T) {
 			// nil so that test panics of any asr methods are called.
 			var r asr.ASR = nil
 			c := cloner.New(du, r, test.opts...)
-			if err := c.Clone(source, target); err == nil {
+			if _, err := c.Clone(source, target); err == nil {
 				t.Fatal("Clone returned unexpected error: nil, want: non-nil")
 			}
 		})
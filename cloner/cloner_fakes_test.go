@@ -3,6 +3,7 @@ package cloner
 import (
 	"errors"
 	"fmt"
+	"io"
 	"testing"
 
 	"github.com/voidingwarranties/offsite-apfs-backup/diskutil"
@@ -44,7 +45,7 @@ func (d *fakeDevices) Volume(id string) (diskutil.VolumeInfo, error) {
 			return info, nil
 		}
 	}
-	return diskutil.VolumeInfo{}, errors.New("volume does not exist")
+	return diskutil.VolumeInfo{}, fmt.Errorf("%w: %s", diskutil.ErrVolumeNotFound, id)
 }
 
 func (d *fakeDevices) AddVolume(volume diskutil.VolumeInfo, snapshots ...diskutil.Snapshot) error {
@@ -95,7 +96,8 @@ func (d *fakeDevices) AddSnapshot(volumeUUID string, snapshot diskutil.Snapshot)
 			return errors.New("snapshot already exists")
 		}
 	}
-	d.snapshots[volumeUUID] = append(d.snapshots[volumeUUID], snapshot)
+	// Prepend, as snapshots are ordered most recent first.
+	d.snapshots[volumeUUID] = append([]diskutil.Snapshot{snapshot}, d.snapshots[volumeUUID]...)
 	return nil
 }
 
@@ -161,6 +163,24 @@ func (du *readonlyFakeDiskUtil) ListSnapshots(volume diskutil.VolumeInfo) ([]dis
 	return du.du.ListSnapshots(volume)
 }
 
+// renameFailingFakeDiskUtil fails all calls to Rename.
+type renameFailingFakeDiskUtil struct {
+	fakeDiskUtil
+}
+
+func (du *renameFailingFakeDiskUtil) Rename(volume diskutil.VolumeInfo, name string) error {
+	return errors.New("fake rename failure")
+}
+
+// deleteFailingFakeDiskUtil fails all calls to DeleteSnapshot.
+type deleteFailingFakeDiskUtil struct {
+	fakeDiskUtil
+}
+
+func (du *deleteFailingFakeDiskUtil) DeleteSnapshot(volume diskutil.VolumeInfo, snap diskutil.Snapshot) error {
+	return errors.New("fake delete failure")
+}
+
 type fakeASR struct {
 	devices *fakeDevices
 }
@@ -224,3 +244,103 @@ func (asr *fakeASR) DestructiveRestore(source, target diskutil.VolumeInfo, to di
 	target.Name = source.Name
 	return asr.devices.AddVolume(target, to)
 }
+
+// failingFakeASR fails all restores after the first `succeed` restores, with
+// err if set.
+type failingFakeASR struct {
+	fakeASR
+	succeed int
+	err     error
+}
+
+func (asr *failingFakeASR) Restore(source, target diskutil.VolumeInfo, to, from diskutil.Snapshot) error {
+	if asr.succeed <= 0 {
+		if asr.err != nil {
+			return asr.err
+		}
+		return errors.New("fake restore failure")
+	}
+	asr.succeed--
+	return asr.fakeASR.Restore(source, target, to, from)
+}
+
+// fakeTMUtil adds a Time Machine snapshot to each of the volumes with the
+// given UUIDs.
+type fakeTMUtil struct {
+	devices     *fakeDevices
+	volumeUUIDs []string
+	date        string
+}
+
+func (tm *fakeTMUtil) LocalSnapshot() (string, error) {
+	snap := diskutil.Snapshot{
+		Name: fmt.Sprintf("com.apple.TimeMachine.%s.local", tm.date),
+		UUID: fmt.Sprintf("tm-%s-uuid", tm.date),
+	}
+	for _, uuid := range tm.volumeUUIDs {
+		if err := tm.devices.AddSnapshot(uuid, snap); err != nil {
+			return "", err
+		}
+	}
+	return tm.date, nil
+}
+
+// fakeJournal keeps entries in memory, and records the steps begun.
+type fakeJournal struct {
+	begun   []Phase
+	entries map[string]JournalEntry
+}
+
+func newFakeJournal() *fakeJournal {
+	return &fakeJournal{
+		entries: make(map[string]JournalEntry),
+	}
+}
+
+func (j *fakeJournal) Begin(entry JournalEntry) error {
+	j.begun = append(j.begun, entry.Step)
+	j.entries[entry.Target.UUID] = entry
+	return nil
+}
+
+func (j *fakeJournal) Finish(target diskutil.VolumeInfo) error {
+	delete(j.entries, target.UUID)
+	return nil
+}
+
+func (j *fakeJournal) Pending() ([]JournalEntry, error) {
+	var entries []JournalEntry
+	for _, e := range j.entries {
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// noopRestoreFakeASR reports success without restoring.
+type noopRestoreFakeASR struct {
+	fakeASR
+}
+
+func (asr *noopRestoreFakeASR) Restore(source, target diskutil.VolumeInfo, to, from diskutil.Snapshot) error {
+	return nil
+}
+
+// noopDeleteFakeDiskUtil reports success without deleting snapshots.
+type noopDeleteFakeDiskUtil struct {
+	fakeDiskUtil
+}
+
+func (du *noopDeleteFakeDiskUtil) DeleteSnapshot(volume diskutil.VolumeInfo, snap diskutil.Snapshot) error {
+	return nil
+}
+
+// fakeHook records the events it's run with, and fails if err is non-nil.
+type fakeHook struct {
+	events []HookEvent
+	err    error
+}
+
+func (h *fakeHook) Run(event HookEvent, stdout io.Writer) error {
+	h.events = append(h.events, event)
+	return h.err
+}
@@ -1,6 +1,7 @@
 package cloner
 
 import (
+	"errors"
 	"testing"
 	"time"
 
@@ -125,6 +126,16 @@ func TestCloneable(t *testing.T) {
 			source:  source.Device,
 			targets: []string{uninitializedTarget.Device},
 		},
+		{
+			name: "selected snapshot is newer than common snapshot",
+			fakeDevices: newFakeDevices(t,
+				withFakeVolume(source, latestSnap, commonSnap2, commonSnap1),
+				withFakeVolume(target1, commonSnap1),
+			),
+			opts:    []Option{ToSnapshot(SnapshotByUUID(commonSnap2.UUID))},
+			source:  source.MountPoint,
+			targets: []string{target1.MountPoint},
+		},
 	}
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
@@ -218,6 +229,8 @@ func TestCloneable_Errors(t *testing.T) {
 		opts        []Option
 		source      string
 		targets     []string
+		// wantErr, if set, must be wrapped by the returned error.
+		wantErr error
 	}{
 		{
 			name: "source not a device",
@@ -226,6 +239,7 @@ func TestCloneable_Errors(t *testing.T) {
 			),
 			source:  "not-a-volume-uuid",
 			targets: []string{target.UUID},
+			wantErr: diskutil.ErrVolumeNotFound,
 		},
 		{
 			name: "one of the targets is not a device",
@@ -235,6 +249,7 @@ func TestCloneable_Errors(t *testing.T) {
 			),
 			source:  source.UUID,
 			targets: []string{target.UUID, "not-a-volume-uuid"},
+			wantErr: diskutil.ErrVolumeNotFound,
 		},
 		{
 			name: "same target repeated multiple times",
@@ -261,6 +276,7 @@ func TestCloneable_Errors(t *testing.T) {
 			),
 			source:  source.UUID,
 			targets: []string{target.UUID},
+			wantErr: ErrNoCommonSnapshot,
 		},
 		{
 			name: "source has no snapshots",
@@ -279,6 +295,7 @@ func TestCloneable_Errors(t *testing.T) {
 			),
 			source:  source.UUID,
 			targets: []string{target.UUID},
+			wantErr: ErrNoCommonSnapshot,
 		},
 		{
 			name: "source and target have same latest snapshot",
@@ -288,6 +305,7 @@ func TestCloneable_Errors(t *testing.T) {
 			),
 			source:  source.UUID,
 			targets: []string{target.UUID},
+			wantErr: ErrUpToDate,
 		},
 		{
 			name: "target snapshot is ahead of latest source snapshot",
@@ -297,6 +315,17 @@ func TestCloneable_Errors(t *testing.T) {
 			),
 			source:  source.UUID,
 			targets: []string{target.UUID},
+			wantErr: ErrTargetAhead,
+		},
+		{
+			name: "target has a snapshot newer than common snapshot that source doesn't have",
+			fakeDevices: newFakeDevices(t,
+				withFakeVolume(source, latestSnap, commonSnap),
+				withFakeVolume(target, uncommonSnap, commonSnap),
+			),
+			source:  source.UUID,
+			targets: []string{target.UUID},
+			wantErr: ErrDiverged,
 		},
 		{
 			name: "source is not an APFS volume",
@@ -324,6 +353,7 @@ func TestCloneable_Errors(t *testing.T) {
 			),
 			source:  caseSensitiveAPFS.UUID,
 			targets: []string{target.UUID},
+			wantErr: ErrFileSystemMismatch,
 		},
 		{
 			name: "target not writable",
@@ -333,6 +363,7 @@ func TestCloneable_Errors(t *testing.T) {
 			),
 			source:  source.UUID,
 			targets: []string{readonly.UUID},
+			wantErr: ErrNotWritable,
 		},
 		{
 			name: "initialize - target has snapshots",
@@ -344,6 +375,38 @@ func TestCloneable_Errors(t *testing.T) {
 			source:  source.Device,
 			targets: []string{uninitializedTarget.Device},
 		},
+		{
+			name: "selected snapshot does not exist",
+			fakeDevices: newFakeDevices(t,
+				withFakeVolume(source, latestSnap, commonSnap),
+				withFakeVolume(target, commonSnap),
+			),
+			opts:    []Option{ToSnapshot(SnapshotByUUID(uncommonSnap.UUID))},
+			source:  source.UUID,
+			targets: []string{target.UUID},
+		},
+		{
+			name: "selected snapshot is the common snapshot",
+			fakeDevices: newFakeDevices(t,
+				withFakeVolume(source, latestSnap, commonSnap),
+				withFakeVolume(target, commonSnap),
+			),
+			opts:    []Option{ToSnapshot(SnapshotByUUID(commonSnap.UUID))},
+			source:  source.UUID,
+			targets: []string{target.UUID},
+			wantErr: ErrUpToDate,
+		},
+		{
+			name: "selected snapshot is older than common snapshot",
+			fakeDevices: newFakeDevices(t,
+				withFakeVolume(source, latestSnap, commonSnap),
+				withFakeVolume(target, latestSnap),
+			),
+			opts:    []Option{ToSnapshot(SnapshotByUUID(commonSnap.UUID))},
+			source:  source.UUID,
+			targets: []string{target.UUID},
+			wantErr: ErrTargetAhead,
+		},
 	}
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
@@ -355,9 +418,13 @@ func TestCloneable_Errors(t *testing.T) {
 			var r asr.ASR = nil
 
 			c := New(du, r, test.opts...)
-			if err := c.Cloneable(test.source, test.targets...); err == nil {
+			err := c.Cloneable(test.source, test.targets...)
+			if err == nil {
 				t.Error("Cloneable returnd error: nil, want: non-nil")
 			}
+			if test.wantErr != nil && !errors.Is(err, test.wantErr) {
+				t.Errorf("Cloneable returned unexpected error: %v, want: %v", err, test.wantErr)
+			}
 		})
 	}
 }
@@ -389,18 +456,22 @@ func TestClone(t *testing.T) {
 			fakeDevices: newFakeDevices(t,
 				withFakeVolume(
 					diskutil.VolumeInfo{
-						Name:       "foo-name",
-						UUID:       "123-foo-uuid",
-						MountPoint: "/foo/mount/point",
+						Name:           "foo-name",
+						UUID:           "123-foo-uuid",
+						MountPoint:     "/foo/mount/point",
+						Writable:       true,
+						FileSystemType: "apfs",
 					},
 					snap2,
 					snap1,
 				),
 				withFakeVolume(
 					diskutil.VolumeInfo{
-						Name:       "bar-name",
-						UUID:       "123-bar-uuid",
-						MountPoint: "/bar/mount/point",
+						Name:           "bar-name",
+						UUID:           "123-bar-uuid",
+						MountPoint:     "/bar/mount/point",
+						Writable:       true,
+						FileSystemType: "apfs",
 					},
 					snap1,
 				),
@@ -421,18 +492,22 @@ func TestClone(t *testing.T) {
 			fakeDevices: newFakeDevices(t,
 				withFakeVolume(
 					diskutil.VolumeInfo{
-						Name:       "foo-name",
-						UUID:       "123-foo-uuid",
-						MountPoint: "/foo/mount/point",
+						Name:           "foo-name",
+						UUID:           "123-foo-uuid",
+						MountPoint:     "/foo/mount/point",
+						Writable:       true,
+						FileSystemType: "apfs",
 					},
 					snap2,
 					snap1,
 				),
 				withFakeVolume(
 					diskutil.VolumeInfo{
-						Name:       "bar-name",
-						UUID:       "123-bar-uuid",
-						MountPoint: "/bar/mount/point",
+						Name:           "bar-name",
+						UUID:           "123-bar-uuid",
+						MountPoint:     "/bar/mount/point",
+						Writable:       true,
+						FileSystemType: "apfs",
 					},
 					snap1,
 				),
@@ -453,18 +528,22 @@ func TestClone(t *testing.T) {
 			fakeDevices: newFakeDevices(t,
 				withFakeVolume(
 					diskutil.VolumeInfo{
-						Name:       "foo-name",
-						UUID:       "123-foo-uuid",
-						MountPoint: "/foo/mount/point",
+						Name:           "foo-name",
+						UUID:           "123-foo-uuid",
+						MountPoint:     "/foo/mount/point",
+						Writable:       true,
+						FileSystemType: "apfs",
 					},
 					snap2,
 					snap1,
 				),
 				withFakeVolume(
 					diskutil.VolumeInfo{
-						Name:       "bar-name",
-						UUID:       "123-bar-uuid",
-						MountPoint: "/bar/mount/point",
+						Name:           "bar-name",
+						UUID:           "123-bar-uuid",
+						MountPoint:     "/bar/mount/point",
+						Writable:       true,
+						FileSystemType: "apfs",
 					},
 				),
 			),
@@ -485,7 +564,7 @@ func TestClone(t *testing.T) {
 			du := &fakeDiskUtil{test.fakeDevices}
 			r := &fakeASR{test.fakeDevices}
 			c := New(du, r, test.opts...)
-			if err := c.Clone(test.source, test.target); err != nil {
+			if _, err := c.Clone(test.source, test.target); err != nil {
 				t.Fatalf("Clone(...) returned unexpected error: %q, want: nil", err)
 			}
 
@@ -547,18 +626,22 @@ func TestClone_DryRun(t *testing.T) {
 			fakeDevices: newFakeDevices(t,
 				withFakeVolume(
 					diskutil.VolumeInfo{
-						Name:       "foo-name",
-						UUID:       "123-foo-uuid",
-						MountPoint: "/foo/mount/point",
+						Name:           "foo-name",
+						UUID:           "123-foo-uuid",
+						MountPoint:     "/foo/mount/point",
+						Writable:       true,
+						FileSystemType: "apfs",
 					},
 					snap2,
 					snap1,
 				),
 				withFakeVolume(
 					diskutil.VolumeInfo{
-						Name:       "bar-name",
-						UUID:       "123-bar-uuid",
-						MountPoint: "/bar/mount/point",
+						Name:           "bar-name",
+						UUID:           "123-bar-uuid",
+						MountPoint:     "/bar/mount/point",
+						Writable:       true,
+						FileSystemType: "apfs",
 					},
 					snap1,
 				),
@@ -579,18 +662,22 @@ func TestClone_DryRun(t *testing.T) {
 			fakeDevices: newFakeDevices(t,
 				withFakeVolume(
 					diskutil.VolumeInfo{
-						Name:       "foo-name",
-						UUID:       "123-foo-uuid",
-						MountPoint: "/foo/mount/point",
+						Name:           "foo-name",
+						UUID:           "123-foo-uuid",
+						MountPoint:     "/foo/mount/point",
+						Writable:       true,
+						FileSystemType: "apfs",
 					},
 					snap2,
 					snap1,
 				),
 				withFakeVolume(
 					diskutil.VolumeInfo{
-						Name:       "bar-name",
-						UUID:       "123-bar-uuid",
-						MountPoint: "/bar/mount/point",
+						Name:           "bar-name",
+						UUID:           "123-bar-uuid",
+						MountPoint:     "/bar/mount/point",
+						Writable:       true,
+						FileSystemType: "apfs",
 					},
 				),
 			),
@@ -614,7 +701,7 @@ func TestClone_DryRun(t *testing.T) {
 			})
 			r := asr.NewDryRun()
 			c := New(du, r, test.opts...)
-			if err := c.Clone(test.source, test.target); err != nil {
+			if _, err := c.Clone(test.source, test.target); err != nil {
 				t.Fatalf("Clone(...) returned unexpected error: %q, want: nil", err)
 			}
 
@@ -674,9 +761,11 @@ func TestClone_Errors(t *testing.T) {
 			fakeDevices: newFakeDevices(t,
 				withFakeVolume(
 					diskutil.VolumeInfo{
-						Name:       "foo-name",
-						UUID:       "123-foo-uuid",
-						MountPoint: "/foo/mount/point",
+						Name:           "foo-name",
+						UUID:           "123-foo-uuid",
+						MountPoint:     "/foo/mount/point",
+						Writable:       true,
+						FileSystemType: "apfs",
 					},
 					snap2,
 					snap1,
@@ -690,9 +779,11 @@ func TestClone_Errors(t *testing.T) {
 			fakeDevices: newFakeDevices(t,
 				withFakeVolume(
 					diskutil.VolumeInfo{
-						Name:       "foo-name",
-						UUID:       "123-foo-uuid",
-						MountPoint: "/foo/mount/point",
+						Name:           "foo-name",
+						UUID:           "123-foo-uuid",
+						MountPoint:     "/foo/mount/point",
+						Writable:       true,
+						FileSystemType: "apfs",
 					},
 					snap2,
 					snap1,
@@ -706,16 +797,20 @@ func TestClone_Errors(t *testing.T) {
 			fakeDevices: newFakeDevices(t,
 				withFakeVolume(
 					diskutil.VolumeInfo{
-						Name:       "foo-name",
-						UUID:       "123-foo-uuid",
-						MountPoint: "/foo/mount/point",
+						Name:           "foo-name",
+						UUID:           "123-foo-uuid",
+						MountPoint:     "/foo/mount/point",
+						Writable:       true,
+						FileSystemType: "apfs",
 					},
 				),
 				withFakeVolume(
 					diskutil.VolumeInfo{
-						Name:       "bar-name",
-						UUID:       "123-bar-uuid",
-						MountPoint: "/bar/mount/point",
+						Name:           "bar-name",
+						UUID:           "123-bar-uuid",
+						MountPoint:     "/bar/mount/point",
+						Writable:       true,
+						FileSystemType: "apfs",
 					},
 				),
 			),
@@ -727,18 +822,22 @@ func TestClone_Errors(t *testing.T) {
 			fakeDevices: newFakeDevices(t,
 				withFakeVolume(
 					diskutil.VolumeInfo{
-						Name:       "foo-name",
-						UUID:       "123-foo-uuid",
-						MountPoint: "/foo/mount/point",
+						Name:           "foo-name",
+						UUID:           "123-foo-uuid",
+						MountPoint:     "/foo/mount/point",
+						Writable:       true,
+						FileSystemType: "apfs",
 					},
 					snap2,
 					snap1,
 				),
 				withFakeVolume(
 					diskutil.VolumeInfo{
-						Name:       "bar-name",
-						UUID:       "123-bar-uuid",
-						MountPoint: "/bar/mount/point",
+						Name:           "bar-name",
+						UUID:           "123-bar-uuid",
+						MountPoint:     "/bar/mount/point",
+						Writable:       true,
+						FileSystemType: "apfs",
 					},
 					snap2,
 					snap1,
@@ -752,17 +851,21 @@ func TestClone_Errors(t *testing.T) {
 			fakeDevices: newFakeDevices(t,
 				withFakeVolume(
 					diskutil.VolumeInfo{
-						Name:       "foo-name",
-						UUID:       "123-foo-uuid",
-						MountPoint: "/foo/mount/point",
+						Name:           "foo-name",
+						UUID:           "123-foo-uuid",
+						MountPoint:     "/foo/mount/point",
+						Writable:       true,
+						FileSystemType: "apfs",
 					},
 					snap1,
 				),
 				withFakeVolume(
 					diskutil.VolumeInfo{
-						Name:       "bar-name",
-						UUID:       "123-bar-uuid",
-						MountPoint: "/bar/mount/point",
+						Name:           "bar-name",
+						UUID:           "123-bar-uuid",
+						MountPoint:     "/bar/mount/point",
+						Writable:       true,
+						FileSystemType: "apfs",
 					},
 					snap2,
 				),
@@ -775,16 +878,20 @@ func TestClone_Errors(t *testing.T) {
 			fakeDevices: newFakeDevices(t,
 				withFakeVolume(
 					diskutil.VolumeInfo{
-						Name:       "foo-name",
-						UUID:       "123-foo-uuid",
-						MountPoint: "/foo/mount/point",
+						Name:           "foo-name",
+						UUID:           "123-foo-uuid",
+						MountPoint:     "/foo/mount/point",
+						Writable:       true,
+						FileSystemType: "apfs",
 					},
 				),
 				withFakeVolume(
 					diskutil.VolumeInfo{
-						Name:       "bar-name",
-						UUID:       "123-bar-uuid",
-						MountPoint: "/bar/mount/point",
+						Name:           "bar-name",
+						UUID:           "123-bar-uuid",
+						MountPoint:     "/bar/mount/point",
+						Writable:       true,
+						FileSystemType: "apfs",
 					},
 					snap1,
 				),
@@ -797,16 +904,20 @@ func TestClone_Errors(t *testing.T) {
 			fakeDevices: newFakeDevices(t,
 				withFakeVolume(
 					diskutil.VolumeInfo{
-						Name:       "foo-name",
-						UUID:       "123-foo-uuid",
-						MountPoint: "/foo/mount/point",
+						Name:           "foo-name",
+						UUID:           "123-foo-uuid",
+						MountPoint:     "/foo/mount/point",
+						Writable:       true,
+						FileSystemType: "apfs",
 					},
 				),
 				withFakeVolume(
 					diskutil.VolumeInfo{
-						Name:       "bar-name",
-						UUID:       "123-bar-uuid",
-						MountPoint: "/bar/mount/point",
+						Name:           "bar-name",
+						UUID:           "123-bar-uuid",
+						MountPoint:     "/bar/mount/point",
+						Writable:       true,
+						FileSystemType: "apfs",
 					},
 				),
 			),
@@ -819,18 +930,22 @@ func TestClone_Errors(t *testing.T) {
 			fakeDevices: newFakeDevices(t,
 				withFakeVolume(
 					diskutil.VolumeInfo{
-						Name:       "foo-name",
-						UUID:       "123-foo-uuid",
-						MountPoint: "/foo/mount/point",
+						Name:           "foo-name",
+						UUID:           "123-foo-uuid",
+						MountPoint:     "/foo/mount/point",
+						Writable:       true,
+						FileSystemType: "apfs",
 					},
 					snap2,
 					snap1,
 				),
 				withFakeVolume(
 					diskutil.VolumeInfo{
-						Name:       "bar-name",
-						UUID:       "123-bar-uuid",
-						MountPoint: "/bar/mount/point",
+						Name:           "bar-name",
+						UUID:           "123-bar-uuid",
+						MountPoint:     "/bar/mount/point",
+						Writable:       true,
+						FileSystemType: "apfs",
 					},
 					snap1,
 				),
@@ -850,9 +965,93 @@ func TestClone_Errors(t *testing.T) {
 			var r asr.ASR = nil
 
 			c := New(du, r, test.opts...)
-			if err := c.Clone(test.source, test.target); err == nil {
+			if _, err := c.Clone(test.source, test.target); err == nil {
 				t.Fatal("Clone(...) returned unexpected error: nil, want: non-nil")
 			}
 		})
 	}
 }
+
+func TestClone_ToSnapshot(t *testing.T) {
+	snap1 := diskutil.Snapshot{
+		Name:    "common-snap",
+		UUID:    "123-common-uuid",
+		Created: time.Time{},
+	}
+	snap2 := diskutil.Snapshot{
+		Name:    "selected-snap",
+		UUID:    "123-selected-uuid",
+		Created: snap1.Created.Add(time.Hour),
+	}
+	snap3 := diskutil.Snapshot{
+		Name:    "latest-snap",
+		UUID:    "123-latest-uuid",
+		Created: snap2.Created.Add(time.Hour),
+	}
+	source := diskutil.VolumeInfo{
+		Name:           "foo-name",
+		UUID:           "123-foo-uuid",
+		MountPoint:     "/foo/mount/point",
+		Writable:       true,
+		FileSystemType: "apfs",
+	}
+	target := diskutil.VolumeInfo{
+		Name:           "bar-name",
+		UUID:           "123-bar-uuid",
+		MountPoint:     "/bar/mount/point",
+		Writable:       true,
+		FileSystemType: "apfs",
+	}
+
+	tests := []struct {
+		name            string
+		fakeDevices     *fakeDevices
+		opts            []Option
+		wantTargetSnaps []diskutil.Snapshot
+	}{
+		{
+			name: "incremental clone",
+			fakeDevices: newFakeDevices(t,
+				withFakeVolume(source, snap3, snap2, snap1),
+				withFakeVolume(target, snap1),
+			),
+			opts:            []Option{ToSnapshot(SnapshotByName(snap2.Name))},
+			wantTargetSnaps: []diskutil.Snapshot{snap2, snap1},
+		},
+		{
+			name: "initialize clone",
+			fakeDevices: newFakeDevices(t,
+				withFakeVolume(source, snap3, snap2, snap1),
+				withFakeVolume(target),
+			),
+			opts: []Option{
+				InitializeTargets(true),
+				ToSnapshot(SnapshotByUUID(snap2.UUID)),
+			},
+			wantTargetSnaps: []diskutil.Snapshot{snap2},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			du := &fakeDiskUtil{test.fakeDevices}
+			r := &fakeASR{test.fakeDevices}
+			c := New(du, r, test.opts...)
+			if _, err := c.Clone(source.MountPoint, target.MountPoint); err != nil {
+				t.Fatalf("Clone(...) returned unexpected error: %q, want: nil", err)
+			}
+
+			gotTargetSnaps, err := test.fakeDevices.Snapshots(target.UUID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cmpOpts := []cmp.Option{
+				cmpopts.SortSlices(func(lhs, rhs diskutil.Snapshot) bool {
+					return lhs.UUID < rhs.UUID
+				}),
+			}
+			if diff := cmp.Diff(test.wantTargetSnaps, gotTargetSnaps, cmpOpts...); diff != "" {
+				t.Errorf("Clone(...) resulted in unexpected snapshots in target. -want +got:\n%s", diff)
+			}
+		})
+	}
+}
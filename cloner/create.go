@@ -0,0 +1,85 @@
+package cloner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/diskutil"
+	"github.com/voidingwarranties/offsite-apfs-backup/tmutil"
+)
+
+// SnapshotCreator returns an Option that sets the TMUtil used by
+// CreateSnapshot to create source snapshots.
+func SnapshotCreator(tm tmutil.TMUtil) Option {
+	return func(c *Cloner) {
+		c.tmutil = tmutil.WithContext(tm)
+	}
+}
+
+// SnapshotTimeout returns an Option that sets how long CreateSnapshot waits
+// for a newly created snapshot to be listed by diskutil. Defaults to 1 minute.
+func SnapshotTimeout(d time.Duration) Option {
+	return func(c *Cloner) {
+		c.snapshotTimeout = d
+	}
+}
+
+// withSnapshotPollInterval sets how often CreateSnapshot lists snapshots
+// while waiting for a newly created snapshot. It's used in tests.
+func withSnapshotPollInterval(d time.Duration) Option {
+	return func(c *Cloner) {
+		c.snapshotPollInterval = d
+	}
+}
+
+// CreateSnapshot creates a new snapshot of source, waits for it to be listed
+// by diskutil, and returns it. Requires the SnapshotCreator option.
+//
+// To clone the new snapshot, pass ToSnapshot(SnapshotByUUID(snap.UUID)) to
+// the Cloner used to clone.
+func (c Cloner) CreateSnapshot(source string) (diskutil.Snapshot, error) {
+	return c.CreateSnapshotContext(context.Background(), source)
+}
+
+// CreateSnapshotContext is like CreateSnapshot, but stops if ctx is done
+// first.
+func (c Cloner) CreateSnapshotContext(ctx context.Context, source string) (diskutil.Snapshot, error) {
+	if c.tmutil == nil {
+		return diskutil.Snapshot{}, errors.New("no snapshot creator configured")
+	}
+	sourceInfo, err := c.diskutil.InfoContext(ctx, source)
+	if err != nil {
+		return diskutil.Snapshot{}, fmt.Errorf("error getting volume info of source %q: %v", source, err)
+	}
+
+	fmt.Fprintln(c.stdout, "Creating snapshot of source...")
+	date, err := c.tmutil.LocalSnapshotContext(ctx)
+	if err != nil {
+		return diskutil.Snapshot{}, fmt.Errorf("error creating snapshot: %v", err)
+	}
+
+	deadline := time.Now().Add(c.snapshotTimeout)
+	for {
+		snaps, err := c.diskutil.ListSnapshotsContext(ctx, sourceInfo)
+		if err != nil {
+			return diskutil.Snapshot{}, fmt.Errorf("error listing snapshots of source: %v", err)
+		}
+		for _, s := range snaps {
+			if strings.Contains(s.Name, date) {
+				fmt.Fprintf(c.stdout, "Created snapshot:\n\t%s\n", s)
+				return s, nil
+			}
+		}
+		if time.Now().After(deadline) {
+			return diskutil.Snapshot{}, fmt.Errorf("timed out after %s waiting for snapshot with date %s to appear on source (is source excluded from Time Machine backups?)", c.snapshotTimeout, date)
+		}
+		select {
+		case <-ctx.Done():
+			return diskutil.Snapshot{}, ctx.Err()
+		case <-time.After(c.snapshotPollInterval):
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package cloner
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/diskutil"
+)
+
+func TestCreateSnapshot(t *testing.T) {
+	source := diskutil.VolumeInfo{
+		Name:           "source-name",
+		UUID:           "source-uuid",
+		MountPoint:     "/source/mount/point",
+		Writable:       true,
+		FileSystemType: "apfs",
+	}
+	target := diskutil.VolumeInfo{
+		Name:           "target-name",
+		UUID:           "target-uuid",
+		MountPoint:     "/target/mount/point",
+		Writable:       true,
+		FileSystemType: "apfs",
+	}
+	commonSnap := diskutil.Snapshot{Name: "common-snap", UUID: "common-snap-uuid"}
+	devices := newFakeDevices(t,
+		withFakeVolume(source, commonSnap),
+		withFakeVolume(target, commonSnap),
+	)
+	du := &fakeDiskUtil{devices}
+	r := &fakeASR{devices}
+	tm := &fakeTMUtil{
+		devices:     devices,
+		volumeUUIDs: []string{source.UUID},
+		date:        "2021-03-01-203509",
+	}
+
+	c := New(du, r, SnapshotCreator(tm), withSnapshotPollInterval(time.Millisecond))
+	snap, err := c.CreateSnapshot(source.MountPoint)
+	if err != nil {
+		t.Fatalf("CreateSnapshot returned unexpected error: %v, want: nil", err)
+	}
+	if snap.Name != "com.apple.TimeMachine.2021-03-01-203509.local" {
+		t.Errorf("CreateSnapshot returned unexpected snapshot: %s", snap)
+	}
+
+	c = New(du, r, ToSnapshot(SnapshotByUUID(snap.UUID)))
+	if _, err := c.Clone(source.MountPoint, target.MountPoint); err != nil {
+		t.Fatalf("Clone(...) returned unexpected error: %v, want: nil", err)
+	}
+	gotTargetSnaps, err := devices.Snapshots(target.UUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmpOpts := []cmp.Option{
+		cmpopts.SortSlices(func(lhs, rhs diskutil.Snapshot) bool {
+			return lhs.UUID < rhs.UUID
+		}),
+	}
+	if diff := cmp.Diff([]diskutil.Snapshot{snap, commonSnap}, gotTargetSnaps, cmpOpts...); diff != "" {
+		t.Errorf("Clone(...) resulted in unexpected snapshots in target. -want +got:\n%s", diff)
+	}
+}
+
+func TestCreateSnapshot_Errors(t *testing.T) {
+	source := diskutil.VolumeInfo{
+		Name:           "source-name",
+		UUID:           "source-uuid",
+		MountPoint:     "/source/mount/point",
+		Writable:       true,
+		FileSystemType: "apfs",
+	}
+	other := diskutil.VolumeInfo{
+		Name:           "other-name",
+		UUID:           "other-uuid",
+		MountPoint:     "/other/mount/point",
+		Writable:       true,
+		FileSystemType: "apfs",
+	}
+
+	tests := []struct {
+		name   string
+		tm     func(*fakeDevices) *fakeTMUtil
+		source string
+	}{
+		{
+			name:   "no snapshot creator",
+			tm:     func(*fakeDevices) *fakeTMUtil { return nil },
+			source: source.MountPoint,
+		},
+		{
+			name: "source not found",
+			tm: func(d *fakeDevices) *fakeTMUtil {
+				return &fakeTMUtil{devices: d, volumeUUIDs: []string{source.UUID}, date: "2021-03-01-203509"}
+			},
+			source: "/not/a/volume",
+		},
+		{
+			name: "snapshot never appears on source",
+			tm: func(d *fakeDevices) *fakeTMUtil {
+				return &fakeTMUtil{devices: d, volumeUUIDs: []string{other.UUID}, date: "2021-03-01-203509"}
+			},
+			source: source.MountPoint,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			devices := newFakeDevices(t,
+				withFakeVolume(source),
+				withFakeVolume(other),
+			)
+			opts := []Option{
+				SnapshotTimeout(10 * time.Millisecond),
+				withSnapshotPollInterval(time.Millisecond),
+			}
+			if tm := test.tm(devices); tm != nil {
+				opts = append(opts, SnapshotCreator(tm))
+			}
+			c := New(&fakeDiskUtil{devices}, nil, opts...)
+			if _, err := c.CreateSnapshot(test.source); err == nil {
+				t.Error("CreateSnapshot returned error: nil, want: non-nil")
+			}
+		})
+	}
+}
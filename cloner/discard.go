@@ -0,0 +1,82 @@
+package cloner
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/diskutil"
+)
+
+// DiscardDiverged returns an Option that, if discard is true, allows cloning
+// to a target whose history has diverged from source's (see
+// RelationDiverged), e.g. because the target was used as a working disk. The
+// snapshots target has that source doesn't, newer than their latest snapshot
+// in common, are deleted from target before it's restored from that
+// snapshot. See TargetPlan.Discard.
+//
+// Targets with no snapshots in common with source are still not cloneable, as
+// deleting their snapshots would leave no snapshot to restore from. Neither
+// are targets that are ahead of source (see RelationAhead): source snapshots
+// newer than the selected one are backups, not diverged history, and a target
+// whose only extra snapshots follow the selected snapshot would have nothing
+// to restore once they're deleted. Such a target becomes diverged, and so
+// cloneable, once source has a newer snapshot.
+func DiscardDiverged(discard bool) Option {
+	return func(c *Cloner) {
+		c.discardDiverged = discard
+	}
+}
+
+// discardable returns the snapshots to delete from target so that it can be
+// restored from h.Common, if c discards diverged snapshots. See
+// DiscardDiverged for why targets that are ahead of source are refused.
+func (c Cloner) discardable(h History, sourceSnaps []diskutil.Snapshot) ([]diskutil.Snapshot, error) {
+	if !c.discardDiverged {
+		return nil, nil
+	}
+	switch h.Relation {
+	case RelationDiverged:
+		return h.TargetOnly, nil
+	case RelationAhead:
+		for _, s := range h.TargetOnly {
+			if containsSnapshot(sourceSnaps, s) {
+				return nil, fmt.Errorf("%w; refusing to discard snapshots that source has - select a newer snapshot instead", h.Err())
+			}
+		}
+		return nil, fmt.Errorf("%w; not discarding them, as target already has the selected snapshot, so there would be nothing to restore - clone again once source has a newer snapshot", h.Err())
+	case RelationDisjoint:
+		if len(h.TargetOnly) > 0 {
+			return nil, fmt.Errorf("%w: refusing to discard target's snapshots, as that would leave no snapshot to restore from - initialize the target instead", ErrNoCommonSnapshot)
+		}
+	}
+	return nil, nil
+}
+
+// discardPlanned deletes the snapshots tp plans to discard from target.
+func (c Cloner) discardPlanned(ctx context.Context, source diskutil.VolumeInfo, tp TargetPlan, result *CloneResult) error {
+	start := time.Now()
+	defer result.timePhase(PhaseDiscard, start)
+	fmt.Fprintln(c.stdout, "Discarding snapshots that only target has:")
+	for _, s := range tp.Discard {
+		fmt.Fprintf(c.stdout, "\t%s\n", s)
+		s := s
+		entry := JournalEntry{
+			Step:     PhaseDiscard,
+			Source:   source,
+			Target:   tp.Target,
+			From:     tp.From,
+			To:       tp.To,
+			Snapshot: &s,
+		}
+		err := c.journaled(entry, func() error {
+			return c.diskutil.DeleteSnapshotContext(ctx, tp.Target, s)
+		})
+		c.emit(endEvent(Event{Source: &source, Target: &tp.Target, Snapshot: &s}, EventDiscard, time.Time{}, err))
+		if err != nil {
+			return fmt.Errorf("error discarding snapshot %q from target: %v", s, err)
+		}
+		result.Discarded = append(result.Discarded, s)
+	}
+	return nil
+}
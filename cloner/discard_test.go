@@ -0,0 +1,108 @@
+package cloner
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/diskutil"
+)
+
+var divergedSnap = diskutil.Snapshot{Name: "diverged-snap", UUID: "diverged-snap-uuid"}
+
+func TestClone_DiscardDiverged(t *testing.T) {
+	devices := newFakeDevices(t,
+		withFakeVolume(catchUpSource, catchUpSnap3, catchUpSnap2, catchUpSnap1),
+		withFakeVolume(catchUpTarget, divergedSnap, catchUpSnap2, catchUpSnap1),
+	)
+	du := &fakeDiskUtil{devices}
+	r := &fakeASR{devices}
+	c := New(du, r, DiscardDiverged(true), Verify(true))
+
+	plan, err := c.Plan(catchUpSource.MountPoint, catchUpTarget.MountPoint)
+	if err != nil {
+		t.Fatalf("Plan(...) returned unexpected error: %v, want: nil", err)
+	}
+	tp := plan.Targets[0]
+	if diff := cmp.Diff([]diskutil.Snapshot{divergedSnap}, tp.Discard); diff != "" {
+		t.Errorf("Plan(...) planned to discard unexpected snapshots. -want +got:\n%s", diff)
+	}
+	if tp.From == nil || *tp.From != catchUpSnap2 {
+		t.Errorf("Plan(...) planned to restore from %v, want: %s", tp.From, catchUpSnap2)
+	}
+
+	result, err := c.ExecuteTarget(plan, tp)
+	if err != nil {
+		t.Fatalf("ExecuteTarget(...) returned unexpected error: %v, want: nil", err)
+	}
+	if diff := cmp.Diff([]diskutil.Snapshot{divergedSnap}, result.Discarded); diff != "" {
+		t.Errorf("ExecuteTarget(...) discarded unexpected snapshots. -want +got:\n%s", diff)
+	}
+	gotTargetSnaps, err := devices.Snapshots(catchUpTarget.UUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantTargetSnaps := []diskutil.Snapshot{catchUpSnap3, catchUpSnap2, catchUpSnap1}
+	cmpOpts := []cmp.Option{
+		cmpopts.SortSlices(func(lhs, rhs diskutil.Snapshot) bool {
+			return lhs.UUID < rhs.UUID
+		}),
+	}
+	if diff := cmp.Diff(wantTargetSnaps, gotTargetSnaps, cmpOpts...); diff != "" {
+		t.Errorf("ExecuteTarget(...) resulted in unexpected snapshots in target. -want +got:\n%s", diff)
+	}
+}
+
+func TestPlan_DiscardDivergedErrors(t *testing.T) {
+	tests := []struct {
+		name        string
+		opts        []Option
+		targetSnaps []diskutil.Snapshot
+		wantErr     error
+		// wantErrContains is a substring of the error that explains why
+		// target's snapshots aren't discarded.
+		wantErrContains string
+	}{
+		{
+			name:            "no snapshots in common",
+			targetSnaps:     []diskutil.Snapshot{divergedSnap},
+			wantErr:         ErrNoCommonSnapshot,
+			wantErrContains: "no snapshot to restore from",
+		},
+		{
+			name:            "target has snapshots after the selected snapshot",
+			targetSnaps:     []diskutil.Snapshot{divergedSnap, catchUpSnap3, catchUpSnap2},
+			wantErr:         ErrTargetAhead,
+			wantErrContains: "nothing to restore",
+		},
+		{
+			name:            "target has source snapshots newer than the selected snapshot",
+			opts:            []Option{ToSnapshot(SnapshotByName(catchUpSnap2.Name))},
+			targetSnaps:     []diskutil.Snapshot{catchUpSnap3, catchUpSnap1},
+			wantErr:         ErrTargetAhead,
+			wantErrContains: "snapshots that source has",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			devices := newFakeDevices(t,
+				withFakeVolume(catchUpSource, catchUpSnap3, catchUpSnap2, catchUpSnap1),
+				withFakeVolume(catchUpTarget, test.targetSnaps...),
+			)
+			// readonly so that test panics if any modifying methods are called.
+			du := &readonlyFakeDiskUtil{
+				du: &fakeDiskUtil{devices},
+			}
+			opts := append([]Option{DiscardDiverged(true)}, test.opts...)
+			c := New(du, nil, opts...)
+			_, err := c.Plan(catchUpSource.MountPoint, catchUpTarget.MountPoint)
+			if !errors.Is(err, test.wantErr) || !strings.Contains(fmt.Sprint(err), test.wantErrContains) {
+				t.Errorf("Plan(...) returned unexpected error: %v, want: %v containing %q", err, test.wantErr, test.wantErrContains)
+			}
+		})
+	}
+}
@@ -0,0 +1,26 @@
+package cloner
+
+import "errors"
+
+// Errors returned by Cloneable, Plan, and Clone when source can't be cloned to
+// a target. Returned errors wrap these, so test for them with errors.Is. See
+// also diskutil.ErrVolumeNotFound and asr.ErrRestoreFailed.
+var (
+	// ErrNoCommonSnapshot means source and target have no snapshot in
+	// common to restore from. Target must be initialized to be cloned to.
+	ErrNoCommonSnapshot = errors.New("source and target have no snapshots in common")
+	// ErrTargetAhead means target has a snapshot newer than the source
+	// snapshot selected to be cloned.
+	ErrTargetAhead = errors.New("target is ahead of source")
+	// ErrDiverged means target has snapshots that source doesn't have, newer
+	// than their latest snapshot in common.
+	ErrDiverged = errors.New("target has diverged from source")
+	// ErrUpToDate means target already has the source snapshot selected to
+	// be cloned.
+	ErrUpToDate = errors.New("target is up to date")
+	// ErrFileSystemMismatch means source and target are formatted with
+	// different file systems, e.g. only one is case-sensitive.
+	ErrFileSystemMismatch = errors.New("file system mismatch")
+	// ErrNotWritable means target is mounted read-only.
+	ErrNotWritable = errors.New("volume not writable")
+)
@@ -0,0 +1,100 @@
+package cloner
+
+import (
+	"time"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/diskutil"
+)
+
+// EventType is a step of a clone to a single target, reported by an Event.
+type EventType string
+
+// Event types, in the order they are emitted.
+const (
+	// EventValidate is emitted once source and target are checked against
+	// the plan.
+	EventValidate EventType = "validate"
+	// EventSelectSnapshot is emitted with the snapshot target is restored
+	// to, and the snapshot in common it is restored from.
+	EventSelectSnapshot EventType = "select_snapshot"
+	// EventDiscard is emitted for each snapshot deleted from target before
+	// the restore. See DiscardDiverged.
+	EventDiscard EventType = "discard"
+	// EventRestoreStart and EventRestoreEnd are emitted before and after
+	// each restore. A catch-up clone (see CatchUp) emits both for each hop.
+	EventRestoreStart EventType = "restore_start"
+	EventRestoreEnd   EventType = "restore_end"
+	// EventPrune is emitted for each snapshot deleted from target.
+	EventPrune EventType = "prune"
+	// EventRename is emitted once target is renamed back to its original
+	// name.
+	EventRename EventType = "rename"
+	// EventVerify is emitted once target is verified. See Verify.
+	EventVerify EventType = "verify"
+)
+
+// Event describes a step of a clone to a single target. Fields that don't
+// apply to Type are empty.
+type Event struct {
+	Type EventType `json:"type"`
+	Time time.Time `json:"time"`
+
+	Source *diskutil.VolumeInfo `json:"source,omitempty"`
+	Target *diskutil.VolumeInfo `json:"target,omitempty"`
+	From   *diskutil.Snapshot   `json:"from,omitempty"`
+	To     *diskutil.Snapshot   `json:"to,omitempty"`
+	// Snapshot is the snapshot deleted by EventDiscard or EventPrune.
+	Snapshot *diskutil.Snapshot `json:"snapshot,omitempty"`
+	// Hop and Hops number the restores of a catch-up clone, starting at 1.
+	Hop  int `json:"hop,omitempty"`
+	Hops int `json:"hops,omitempty"`
+
+	// Outcome is OutcomeSuccess or OutcomeFailure. Set for every event
+	// that ends a step, i.e. all but EventSelectSnapshot and
+	// EventRestoreStart.
+	Outcome string `json:"outcome,omitempty"`
+	// Error describes the failure if Outcome is OutcomeFailure.
+	Error string `json:"error,omitempty"`
+	// DurationSeconds is the duration of the step. Set for EventValidate,
+	// EventRestoreEnd, and EventVerify.
+	DurationSeconds float64 `json:"duration_seconds,omitempty"`
+}
+
+// EventSink receives an Event for each step of a clone, e.g. to report
+// progress in a machine-readable format. Event may be called concurrently by
+// Cloners sharing a sink.
+type EventSink interface {
+	Event(e Event)
+}
+
+// Events returns an Option that emits an Event to sink for each step of
+// Execute and ExecuteTarget. By default, events are discarded.
+func Events(sink EventSink) Option {
+	return func(c *Cloner) {
+		c.events = sink
+	}
+}
+
+// emit sends e, at the current time, to c's EventSink.
+func (c Cloner) emit(e Event) {
+	if c.events == nil {
+		return
+	}
+	e.Time = c.now()
+	c.events.Event(e)
+}
+
+// endEvent returns e with the outcome of a step that returned err, and took
+// since start.
+func endEvent(e Event, eventType EventType, start time.Time, err error) Event {
+	e.Type = eventType
+	e.Outcome = OutcomeSuccess
+	if err != nil {
+		e.Outcome = OutcomeFailure
+		e.Error = err.Error()
+	}
+	if !start.IsZero() {
+		e.DurationSeconds = time.Since(start).Seconds()
+	}
+	return e
+}
@@ -0,0 +1,111 @@
+package cloner
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/diskutil"
+)
+
+type fakeEventSink struct {
+	events []Event
+}
+
+func (s *fakeEventSink) Event(e Event) {
+	s.events = append(s.events, e)
+}
+
+func TestClone_Events(t *testing.T) {
+	devices := newFakeDevices(t,
+		withFakeVolume(hookSource, hookLatestSnap, hookCommonSnap),
+		withFakeVolume(hookTarget, hookCommonSnap),
+	)
+	sink := &fakeEventSink{}
+	c := New(&fakeDiskUtil{devices}, &fakeASR{devices},
+		Prune(true),
+		Verify(true),
+		Events(sink),
+	)
+	if _, err := c.Clone(hookSource.MountPoint, hookTarget.MountPoint); err != nil {
+		t.Fatalf("Clone(...) returned unexpected error: %q, want: nil", err)
+	}
+	want := []Event{
+		{Type: EventValidate, Source: &hookSource, Target: &hookTarget, Outcome: OutcomeSuccess},
+		{Type: EventSelectSnapshot, Source: &hookSource, Target: &hookTarget, From: &hookCommonSnap, To: &hookLatestSnap},
+		{Type: EventRestoreStart, Source: &hookSource, Target: &hookTarget, From: &hookCommonSnap, To: &hookLatestSnap},
+		{Type: EventRestoreEnd, Source: &hookSource, Target: &hookTarget, From: &hookCommonSnap, To: &hookLatestSnap, Outcome: OutcomeSuccess},
+		{Type: EventPrune, Source: &hookSource, Target: &hookTarget, Snapshot: &hookCommonSnap, Outcome: OutcomeSuccess},
+		{Type: EventRename, Source: &hookSource, Target: &hookTarget, Outcome: OutcomeSuccess},
+		{Type: EventVerify, Source: &hookSource, Target: &hookTarget, Outcome: OutcomeSuccess},
+	}
+	opts := cmpopts.IgnoreFields(Event{}, "Time", "DurationSeconds")
+	if diff := cmp.Diff(want, sink.events, opts); diff != "" {
+		t.Errorf("Clone(...) emitted unexpected events. -want +got:\n%s", diff)
+	}
+	for _, e := range sink.events {
+		if e.Time.IsZero() {
+			t.Errorf("%s event has no time", e.Type)
+		}
+	}
+}
+
+func TestClone_EventsCatchUp(t *testing.T) {
+	devices := newFakeDevices(t,
+		withFakeVolume(catchUpSource, catchUpSnap3, catchUpSnap2, catchUpSnap1),
+		withFakeVolume(catchUpTarget, catchUpSnap1),
+	)
+	sink := &fakeEventSink{}
+	c := New(&fakeDiskUtil{devices}, &fakeASR{devices},
+		CatchUp(true),
+		Events(sink),
+	)
+	if _, err := c.Clone(catchUpSource.MountPoint, catchUpTarget.MountPoint); err != nil {
+		t.Fatalf("Clone(...) returned unexpected error: %q, want: nil", err)
+	}
+	type restore struct {
+		Type     EventType
+		From, To string
+		Hop      int
+		Hops     int
+	}
+	var got []restore
+	for _, e := range sink.events {
+		if e.Type == EventRestoreStart || e.Type == EventRestoreEnd {
+			got = append(got, restore{e.Type, e.From.Name, e.To.Name, e.Hop, e.Hops})
+		}
+	}
+	want := []restore{
+		{EventRestoreStart, catchUpSnap1.Name, catchUpSnap2.Name, 1, 2},
+		{EventRestoreEnd, catchUpSnap1.Name, catchUpSnap2.Name, 1, 2},
+		{EventRestoreStart, catchUpSnap2.Name, catchUpSnap3.Name, 2, 2},
+		{EventRestoreEnd, catchUpSnap2.Name, catchUpSnap3.Name, 2, 2},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Clone(...) emitted unexpected restore events. -want +got:\n%s", diff)
+	}
+}
+
+func TestExecuteTarget_EventsStalePlan(t *testing.T) {
+	devices := newFakeDevices(t,
+		withFakeVolume(hookSource, hookLatestSnap, hookCommonSnap),
+		withFakeVolume(hookTarget, hookCommonSnap),
+	)
+	sink := &fakeEventSink{}
+	c := New(&fakeDiskUtil{devices}, &fakeASR{devices}, Events(sink))
+	plan, err := c.Plan(hookSource.MountPoint, hookTarget.MountPoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan.Targets[0].TargetSnapshots = []diskutil.Snapshot{hookLatestSnap}
+	if _, err := c.ExecuteTarget(plan, plan.Targets[0]); err == nil {
+		t.Fatal("ExecuteTarget(...) returned no error for a stale plan")
+	}
+	if len(sink.events) != 1 {
+		t.Fatalf("ExecuteTarget(...) emitted %d events, want: 1", len(sink.events))
+	}
+	if got := sink.events[0]; got.Type != EventValidate || got.Outcome != OutcomeFailure || got.Error == "" {
+		t.Errorf("ExecuteTarget(...) emitted %+v, want: failed validate event", got)
+	}
+}
@@ -0,0 +1,356 @@
+package cloner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/diskutil"
+)
+
+// Execute executes plan, cloning to each of plan's targets in order. Execute
+// stops at the first target that fails, and returns the CloneResults of all
+// targets attempted so far.
+//
+// To avoid acting on outdated information, Execute refuses to clone to a
+// target whose volume info or snapshots have changed since plan was created,
+// or if any of the source snapshots the plan restores no longer exist.
+func (c Cloner) Execute(plan ClonePlan) ([]CloneResult, error) {
+	return c.ExecuteContext(context.Background(), plan)
+}
+
+// ExecuteContext is like Execute, but stops if ctx is done first. See
+// ExecuteTargetContext.
+func (c Cloner) ExecuteContext(ctx context.Context, plan ClonePlan) ([]CloneResult, error) {
+	var results []CloneResult
+	for _, tp := range plan.Targets {
+		result, err := c.ExecuteTargetContext(ctx, plan, tp)
+		results = append(results, result)
+		if err != nil {
+			return results, fmt.Errorf("error cloning to %q: %w", tp.Arg, err)
+		}
+	}
+	return results, nil
+}
+
+// ExecuteTarget executes the part of plan that clones to a single target, tp.
+// See Execute.
+func (c Cloner) ExecuteTarget(plan ClonePlan, tp TargetPlan) (CloneResult, error) {
+	return c.ExecuteTargetContext(context.Background(), plan, tp)
+}
+
+// ExecuteTargetContext is like ExecuteTarget, but stops if ctx is done
+// first, and returns an *InterruptedError. Target is still renamed back to
+// its original name if it was restored, unless ctx is done during the rename.
+func (c Cloner) ExecuteTargetContext(ctx context.Context, plan ClonePlan, tp TargetPlan) (result CloneResult, err error) {
+	defer func() {
+		if err != nil && ctx.Err() != nil {
+			err = &InterruptedError{
+				Target: tp.Target,
+				Phase:  interruptedPhase(result),
+				Err:    err,
+				ctxErr: ctx.Err(),
+			}
+		}
+	}()
+	result = CloneResult{
+		Source: plan.Source,
+		Target: tp.Target,
+		To:     tp.To,
+	}
+	base := Event{
+		Source: &plan.Source,
+		Target: &tp.Target,
+	}
+	start := time.Now()
+	err = c.checkPlan(ctx, plan, tp)
+	c.emit(endEvent(base, EventValidate, start, err))
+	if err != nil {
+		return result, err
+	}
+	if len(plan.SourceSnapshots) > 0 && plan.SourceSnapshots[0].UUID == tp.To.UUID {
+		fmt.Fprintf(c.stdout, "Latest snapshot in source:\n\t%s\n", tp.To)
+	} else {
+		fmt.Fprintf(c.stdout, "Selected snapshot in source:\n\t%s\n", tp.To)
+	}
+	if tp.From != nil {
+		fmt.Fprintf(c.stdout, "Snapshot in common:\n\t%s\n", *tp.From)
+		result.From = *tp.From
+	}
+	selected := base
+	selected.Type = EventSelectSnapshot
+	selected.From = tp.From
+	selected.To = &tp.To
+	c.emit(selected)
+	result.timePhase(PhaseValidate, start)
+
+	event := HookEvent{
+		Source: &plan.Source,
+		Target: &tp.Target,
+		From:   tp.From,
+		To:     &tp.To,
+	}
+	pre := event
+	pre.Point = HookPreRestore
+	if err := ctx.Err(); err != nil {
+		return result, err
+	}
+	if err := c.runHooks(ctx, pre); err != nil {
+		fmt.Fprintf(c.stdout, "Skipping target: %v\n", err)
+		result.Skipped = true
+		return result, err
+	}
+	if len(tp.Discard) > 0 {
+		if err := c.discardPlanned(ctx, plan.Source, tp, &result); err != nil {
+			return result, err
+		}
+	}
+	cloneErr := c.restore(ctx, plan, tp, &result)
+	c.runPostHooks(ctx, postHookEvent(event, HookPostRestore, cloneErr), &result)
+	// Target was restored even if pruning fails, so target still needs to
+	// be renamed. The prune error is returned after the rename.
+	var pruneErr error
+	if cloneErr == nil && tp.From != nil && result.From.UUID != tp.From.UUID {
+		tp.Prune = c.fallbackPrune(tp)
+	}
+	if cloneErr == nil && len(tp.Prune) > 0 {
+		pruneErr = c.prunePlanned(ctx, plan.Source, tp, &result)
+		c.runPostHooks(ctx, postHookEvent(event, HookPostPrune, pruneErr), &result)
+	}
+	// A catch-up clone that fails partway through leaves target at the
+	// last successfully restored snapshot, so target still needs to be
+	// renamed.
+	var hopErr *HopError
+	if cloneErr != nil && !(errors.As(cloneErr, &hopErr) && hopErr.Completed > 0) {
+		c.clearFailedRestore(ctx, tp, &result, cloneErr)
+		return result, cloneErr
+	}
+	if cloneErr == nil {
+		cloneErr = pruneErr
+	}
+	// ASR renames the volume to source's name after a restore. Change it
+	// back.
+	start = time.Now()
+	entry := JournalEntry{
+		Step:   PhaseRename,
+		Source: plan.Source,
+		Target: tp.Target,
+		From:   tp.From,
+		To:     tp.To,
+	}
+	err = c.journaled(entry, func() error {
+		return c.diskutil.RenameContext(ctx, tp.Target, tp.Target.Name)
+	})
+	c.emit(endEvent(base, EventRename, time.Time{}, err))
+	if err != nil {
+		warning := fmt.Sprintf("error renaming volume to original name %q: %v", tp.Target.Name, err)
+		fmt.Fprintf(c.stdout, "Warning: %s\n", warning)
+		result.Warnings = append(result.Warnings, warning)
+	} else {
+		result.Renamed = true
+		c.finishJournal(tp.Target, &result)
+	}
+	result.timePhase(PhaseRename, start)
+	if cloneErr == nil && c.verify {
+		start = time.Now()
+		cloneErr = c.verifyTarget(ctx, tp, &result)
+		c.emit(endEvent(base, EventVerify, start, cloneErr))
+	}
+	return result, cloneErr
+}
+
+// postHookEvent returns event at point, with the outcome of a step that
+// returned err.
+func postHookEvent(event HookEvent, point HookPoint, err error) HookEvent {
+	event.Point = point
+	event.Outcome = outcomeOf(err)
+	if err != nil {
+		event.Error = err.Error()
+	}
+	return event
+}
+
+// checkPlan returns an error if source or tp's target have changed since plan
+// was created in a way that invalidates the plan. New source snapshots are
+// allowed, as e.g. Time Machine may create snapshots at any time.
+func (c Cloner) checkPlan(ctx context.Context, plan ClonePlan, tp TargetPlan) error {
+	sourceInfo, err := c.diskutil.InfoContext(ctx, plan.Source.UUID)
+	if err != nil {
+		return fmt.Errorf("error getting volume info of source %q: %w", plan.Source.UUID, err)
+	}
+	if !sameVolume(sourceInfo, plan.Source) {
+		return errors.New("stale plan: source volume has changed since the plan was created")
+	}
+	sourceSnaps, err := c.diskutil.ListSnapshotsContext(ctx, sourceInfo)
+	if err != nil {
+		return fmt.Errorf("error listing snapshots of source: %v", err)
+	}
+	needed := []diskutil.Snapshot{tp.To}
+	if tp.From != nil {
+		needed = append(needed, *tp.From)
+	}
+	for _, hop := range tp.Hops {
+		needed = append(needed, hop.From, hop.To)
+	}
+	for _, s := range needed {
+		if !containsSnapshot(sourceSnaps, s) {
+			return fmt.Errorf("stale plan: snapshot %s no longer exists in source", s)
+		}
+	}
+
+	targetInfo, err := c.diskutil.InfoContext(ctx, tp.Target.UUID)
+	if err != nil {
+		return fmt.Errorf("error getting volume info of target %q: %w", tp.Target.UUID, err)
+	}
+	if !sameVolume(targetInfo, tp.Target) {
+		return errors.New("stale plan: target volume has changed since the plan was created")
+	}
+	targetSnaps, err := c.diskutil.ListSnapshotsContext(ctx, targetInfo)
+	if err != nil {
+		return fmt.Errorf("error listing snapshots of target: %v", err)
+	}
+	if !sameSnapshots(targetSnaps, tp.TargetSnapshots) {
+		return errors.New("stale plan: target snapshots have changed since the plan was created")
+	}
+	return nil
+}
+
+// sameVolume returns true if lhs and rhs describe the same volume with the
+// same properties. Mount points are ignored, as they may change between
+// mounts.
+func sameVolume(lhs, rhs diskutil.VolumeInfo) bool {
+	lhs.MountPoint = ""
+	rhs.MountPoint = ""
+	return lhs == rhs
+}
+
+func sameSnapshots(lhs, rhs []diskutil.Snapshot) bool {
+	if len(lhs) != len(rhs) {
+		return false
+	}
+	for i := range lhs {
+		if lhs[i].UUID != rhs[i].UUID {
+			return false
+		}
+	}
+	return true
+}
+
+func containsSnapshot(snaps []diskutil.Snapshot, snap diskutil.Snapshot) bool {
+	for _, s := range snaps {
+		if s.UUID == snap.UUID {
+			return true
+		}
+	}
+	return false
+}
+
+func (c Cloner) restore(ctx context.Context, plan ClonePlan, tp TargetPlan, result *CloneResult) error {
+	source := plan.Source
+	start := time.Now()
+	defer result.timePhase(PhaseRestore, start)
+	entry := JournalEntry{
+		Step:   PhaseRestore,
+		Source: source,
+		Target: tp.Target,
+		From:   tp.From,
+		To:     tp.To,
+	}
+	event := Event{
+		Source: &source,
+		Target: &tp.Target,
+		From:   tp.From,
+		To:     &tp.To,
+	}
+	switch {
+	case tp.Type == RestoreDestructive:
+		fmt.Fprintln(c.stdout, "Restoring to selected snapshot in source...")
+		err := c.restoreEvents(event, func() error {
+			return c.journaled(entry, func() error {
+				return c.asr.DestructiveRestoreContext(ctx, source, tp.Target, tp.To)
+			})
+		})
+		if err != nil {
+			return fmt.Errorf("error restoring: %w", err)
+		}
+		result.Initialized = true
+	case tp.From == nil:
+		return errors.New("invalid plan: incremental restore without a snapshot in common")
+	case len(tp.Hops) > 0:
+		return c.restoreHops(ctx, source, tp.Target, tp.Hops, result)
+	default:
+		fmt.Fprintln(c.stdout, "Restoring to selected snapshot in source from common snapshot...")
+		froms := c.restoreFroms(plan.SourceSnapshots, tp)
+		var err error
+		var attempts int
+		for i, from := range froms {
+			from := from
+			attempts++
+			if i > 0 {
+				fmt.Fprintf(c.stdout, "Restore from %s failed: %v\n", froms[i-1], err)
+				fmt.Fprintf(c.stdout, "Restoring from older common snapshot %s (attempt %d/%d)...\n", from, i+1, len(froms))
+			}
+			entry.From = &from
+			event.From = &from
+			err = c.restoreEvents(event, func() error {
+				return c.journaled(entry, func() error {
+					return c.asr.RestoreContext(ctx, source, tp.Target, tp.To, from)
+				})
+			})
+			if err == nil {
+				result.From = from
+				break
+			}
+			if ctx.Err() != nil {
+				break
+			}
+		}
+		if err != nil && attempts > 1 {
+			return fmt.Errorf("error restoring after %d attempts: %w", attempts, err)
+		}
+		if err != nil {
+			return fmt.Errorf("error restoring: %w", err)
+		}
+	}
+	return nil
+}
+
+// restoreEvents runs restore, emitting EventRestoreStart before and
+// EventRestoreEnd after it.
+func (c Cloner) restoreEvents(event Event, restore func() error) error {
+	event.Type = EventRestoreStart
+	c.emit(event)
+	start := time.Now()
+	err := restore()
+	c.emit(endEvent(event, EventRestoreEnd, start, err))
+	return err
+}
+
+// prunePlanned deletes the snapshots tp plans to prune from target.
+func (c Cloner) prunePlanned(ctx context.Context, source diskutil.VolumeInfo, tp TargetPlan, result *CloneResult) error {
+	start := time.Now()
+	defer result.timePhase(PhasePrune, start)
+	fmt.Fprintln(c.stdout, "Pruning snapshots from target:")
+	for _, s := range tp.Prune {
+		fmt.Fprintf(c.stdout, "\t%s\n", s)
+		s := s
+		entry := JournalEntry{
+			Step:     PhasePrune,
+			Source:   source,
+			Target:   tp.Target,
+			From:     tp.From,
+			To:       tp.To,
+			Snapshot: &s,
+		}
+		err := c.journaled(entry, func() error {
+			return c.diskutil.DeleteSnapshotContext(ctx, tp.Target, s)
+		})
+		c.emit(endEvent(Event{Source: &source, Target: &tp.Target, Snapshot: &s}, EventPrune, time.Time{}, err))
+		if err != nil {
+			return fmt.Errorf("error deleting snapshot %q from target: %v", s, err)
+		}
+		result.Pruned = append(result.Pruned, s)
+	}
+	return nil
+}
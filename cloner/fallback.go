@@ -0,0 +1,65 @@
+package cloner
+
+import (
+	"github.com/voidingwarranties/offsite-apfs-backup/diskutil"
+)
+
+// FallbackRestores returns an Option that, if attempts is greater than 0,
+// retries an incremental restore that fails from the next-older snapshot
+// that source and target have in common, then the next, up to attempts more
+// restores, until one succeeds. This helps if asr rejects the latest snapshot
+// in common, e.g. because it's damaged or purgeable on target. Each attempt is
+// written to stdout. Has no effect on catch-up clones (see CatchUp). By
+// default, failed restores are not retried from older snapshots.
+//
+// If target is restored from an older snapshot, the snapshot in common that
+// Prune would delete is kept, and retention policies are applied again to the
+// snapshots target has after the restore.
+func FallbackRestores(attempts int) Option {
+	return func(c *Cloner) {
+		c.fallbackRestores = attempts
+	}
+}
+
+// restoreFroms returns the snapshots to restore tp's target from, in the order
+// they are attempted: tp.From, followed by up to c.fallbackRestores of the
+// next-older snapshots in common with source.
+func (c Cloner) restoreFroms(sourceSnaps []diskutil.Snapshot, tp TargetPlan) []diskutil.Snapshot {
+	froms := []diskutil.Snapshot{*tp.From}
+	older := false
+	for _, s := range tp.TargetSnapshots {
+		if len(froms) > c.fallbackRestores {
+			break
+		}
+		if s.UUID == tp.From.UUID {
+			older = true
+			continue
+		}
+		if older && containsSnapshot(sourceSnaps, s) && !containsSnapshot(tp.Discard, s) {
+			froms = append(froms, s)
+		}
+	}
+	return froms
+}
+
+// fallbackPrune returns the snapshots to prune from tp's target after it was
+// restored from an older snapshot in common than tp.From. tp.Prune was planned
+// for a restore from tp.From: tp.From itself isn't pruned, as target was not
+// restored from it, and retention policies are applied again to the
+// snapshots target has after the restore. Only snapshots in tp.Prune are
+// returned, so nothing that wasn't planned is deleted.
+func (c Cloner) fallbackPrune(tp TargetPlan) []diskutil.Snapshot {
+	restored := []diskutil.Snapshot{tp.To}
+	for _, s := range tp.TargetSnapshots {
+		if !containsSnapshot(tp.Discard, s) {
+			restored = append(restored, s)
+		}
+	}
+	var prune []diskutil.Snapshot
+	for _, s := range c.retentionPrune(restored, tp.To) {
+		if containsSnapshot(tp.Prune, s) {
+			prune = append(prune, s)
+		}
+	}
+	return prune
+}
@@ -0,0 +1,169 @@
+package cloner
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/diskutil"
+)
+
+// rejectingFakeASR fails restores from any of the rejected snapshots.
+type rejectingFakeASR struct {
+	fakeASR
+	rejected []diskutil.Snapshot
+	froms    []diskutil.Snapshot
+}
+
+func (asr *rejectingFakeASR) Restore(source, target diskutil.VolumeInfo, to, from diskutil.Snapshot) error {
+	asr.froms = append(asr.froms, from)
+	if containsSnapshot(asr.rejected, from) {
+		return errors.New("fake restore failure")
+	}
+	return asr.fakeASR.Restore(source, target, to, from)
+}
+
+func TestClone_FallbackRestores(t *testing.T) {
+	tests := []struct {
+		name      string
+		attempts  int
+		rejected  []diskutil.Snapshot
+		wantFroms []diskutil.Snapshot
+		wantFrom  diskutil.Snapshot
+		wantErr   bool
+	}{
+		{
+			name:      "latest common snapshot succeeds",
+			attempts:  2,
+			wantFroms: []diskutil.Snapshot{catchUpSnap3},
+			wantFrom:  catchUpSnap3,
+		},
+		{
+			name:      "falls back to older common snapshot",
+			attempts:  2,
+			rejected:  []diskutil.Snapshot{catchUpSnap3},
+			wantFroms: []diskutil.Snapshot{catchUpSnap3, catchUpSnap2},
+			wantFrom:  catchUpSnap2,
+		},
+		{
+			name:      "falls back to oldest common snapshot",
+			attempts:  2,
+			rejected:  []diskutil.Snapshot{catchUpSnap3, catchUpSnap2},
+			wantFroms: []diskutil.Snapshot{catchUpSnap3, catchUpSnap2, catchUpSnap1},
+			wantFrom:  catchUpSnap1,
+		},
+		{
+			name:      "attempts exhausted",
+			attempts:  1,
+			rejected:  []diskutil.Snapshot{catchUpSnap3, catchUpSnap2},
+			wantFroms: []diskutil.Snapshot{catchUpSnap3, catchUpSnap2},
+			wantErr:   true,
+		},
+		{
+			name:      "disabled",
+			rejected:  []diskutil.Snapshot{catchUpSnap3},
+			wantFroms: []diskutil.Snapshot{catchUpSnap3},
+			wantErr:   true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			devices := newFakeDevices(t,
+				withFakeVolume(catchUpSource, catchUpSnap4, catchUpSnap3, catchUpSnap2, catchUpSnap1),
+				withFakeVolume(catchUpTarget, catchUpSnap3, catchUpSnap2, catchUpSnap1),
+			)
+			du := &fakeDiskUtil{devices}
+			r := &rejectingFakeASR{
+				fakeASR:  fakeASR{devices},
+				rejected: test.rejected,
+			}
+			stdout := new(bytes.Buffer)
+			c := New(du, r, FallbackRestores(test.attempts), Stdout(stdout))
+			result, err := c.Clone(catchUpSource.MountPoint, catchUpTarget.MountPoint)
+			if gotErr := err != nil; gotErr != test.wantErr {
+				t.Errorf("Clone(...) returned error: %v, want error: %t", err, test.wantErr)
+			}
+			if diff := cmp.Diff(test.wantFroms, r.froms); diff != "" {
+				t.Errorf("Clone(...) restored from unexpected snapshots. -want +got:\n%s", diff)
+			}
+			if got, want := strings.Count(stdout.String(), "Restoring from older common snapshot"), len(test.wantFroms)-1; got != want {
+				t.Errorf("Clone(...) logged %d fallback attempts, want: %d. Output:\n%s", got, want, stdout)
+			}
+			if !test.wantErr && result.From != test.wantFrom {
+				t.Errorf("CloneResult.From = %s, want: %s", result.From, test.wantFrom)
+			}
+		})
+	}
+}
+
+func TestClone_FallbackRestoresPrune(t *testing.T) {
+	// Most recent first.
+	snap4 := snapAt("snap-4", time.Date(2021, 3, 4, 1, 0, 0, 0, time.UTC))
+	snap3 := snapAt("snap-3", time.Date(2021, 3, 3, 1, 0, 0, 0, time.UTC))
+	snap2 := snapAt("snap-2", time.Date(2021, 3, 2, 1, 0, 0, 0, time.UTC))
+	snap1 := snapAt("snap-1", time.Date(2021, 3, 1, 1, 0, 0, 0, time.UTC))
+
+	tests := []struct {
+		name            string
+		opts            []Option
+		wantPruned      []diskutil.Snapshot
+		wantTargetSnaps []diskutil.Snapshot
+	}{
+		{
+			name:            "planned snapshot in common is kept",
+			opts:            []Option{Prune(true)},
+			wantTargetSnaps: []diskutil.Snapshot{snap4, snap3, snap2, snap1},
+		},
+		{
+			name: "retention applied to restored snapshots",
+			// Planned to prune snap3 by Prune, and snap1 to keep the last
+			// 2 of snap4, snap2, and snap1. Once restored from snap2,
+			// snap3 is kept, and so is snap2, as it was not planned to be
+			// pruned.
+			opts:            []Option{Prune(true), Retention(KeepLast(2))},
+			wantPruned:      []diskutil.Snapshot{snap1},
+			wantTargetSnaps: []diskutil.Snapshot{snap4, snap3, snap2},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			devices := newFakeDevices(t,
+				withFakeVolume(catchUpSource, snap4, snap3, snap2, snap1),
+				withFakeVolume(catchUpTarget, snap3, snap2, snap1),
+			)
+			du := &fakeDiskUtil{devices}
+			r := &rejectingFakeASR{
+				fakeASR:  fakeASR{devices},
+				rejected: []diskutil.Snapshot{snap3},
+			}
+			opts := append([]Option{FallbackRestores(1), Stdout(io.Discard)}, test.opts...)
+			c := New(du, r, opts...)
+			result, err := c.Clone(catchUpSource.MountPoint, catchUpTarget.MountPoint)
+			if err != nil {
+				t.Fatalf("Clone(...) returned unexpected error: %v", err)
+			}
+			if result.From != snap2 {
+				t.Errorf("CloneResult.From = %s, want: %s", result.From, snap2)
+			}
+			if diff := cmp.Diff(test.wantPruned, result.Pruned); diff != "" {
+				t.Errorf("Clone(...) pruned unexpected snapshots. -want +got:\n%s", diff)
+			}
+			gotTargetSnaps, err := devices.Snapshots(catchUpTarget.UUID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cmpOpts := cmpopts.SortSlices(func(lhs, rhs diskutil.Snapshot) bool {
+				return lhs.UUID < rhs.UUID
+			})
+			if diff := cmp.Diff(test.wantTargetSnaps, gotTargetSnaps, cmpOpts); diff != "" {
+				t.Errorf("Clone(...) resulted in unexpected snapshots in target. -want +got:\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,214 @@
+package cloner
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/diskutil"
+)
+
+// Relation describes how target's snapshot history relates to source's.
+type Relation string
+
+const (
+	// RelationInSync means target's latest snapshot is the selected source
+	// snapshot.
+	RelationInSync Relation = "in_sync"
+	// RelationBehind means target's latest snapshot is a source snapshot
+	// older than the selected one. Target can be cloned to incrementally.
+	RelationBehind Relation = "behind"
+	// RelationAhead means target has a source snapshot newer than the
+	// selected one, or target has the selected snapshot followed by
+	// snapshots that source doesn't have.
+	RelationAhead Relation = "ahead"
+	// RelationDiverged means that since their latest snapshot in common,
+	// target has snapshots that source doesn't have, and source has
+	// snapshots up to the selected one that target doesn't have.
+	RelationDiverged Relation = "diverged"
+	// RelationDisjoint means source and target have no snapshots in common.
+	RelationDisjoint Relation = "disjoint"
+)
+
+// History is the result of comparing target's snapshots with source's, as of
+// the source snapshot selected to be cloned. See CompareHistories.
+type History struct {
+	Relation Relation `json:"relation"`
+	// Selected is the source snapshot selected to be cloned.
+	Selected diskutil.Snapshot `json:"selected"`
+	// Common is the latest snapshot that target has in common with source,
+	// excluding source snapshots newer than Selected. Nil if there is none.
+	Common *diskutil.Snapshot `json:"common,omitempty"`
+	// SourceOnly are the source snapshots newer than Common, up to and
+	// including Selected, that target doesn't have. Most recent first.
+	SourceOnly []diskutil.Snapshot `json:"source_only,omitempty"`
+	// TargetOnly are the target snapshots newer than Common. These are
+	// snapshots that source doesn't have, or source snapshots newer than
+	// Selected. Most recent first.
+	TargetOnly []diskutil.Snapshot `json:"target_only,omitempty"`
+}
+
+// CompareHistories compares target's snapshots with source's, as of the
+// selected source snapshot source[selected]. Both source and target must be
+// ordered most recent first.
+func CompareHistories(source []diskutil.Snapshot, selected int, target []diskutil.Snapshot) History {
+	h := History{Selected: source[selected]}
+	sourceIndices := make(map[string]int)
+	for i, s := range source {
+		sourceIndices[s.UUID] = i
+	}
+	commonSourceI := len(source)
+	// Whether target has a source snapshot newer than the selected one.
+	var newerThanSelected bool
+	for _, s := range target {
+		sourceI, ok := sourceIndices[s.UUID]
+		if ok && sourceI >= selected {
+			common := s
+			h.Common = &common
+			commonSourceI = sourceI
+			break
+		}
+		if ok {
+			newerThanSelected = true
+		}
+		h.TargetOnly = append(h.TargetOnly, s)
+	}
+	inTarget := make(map[string]bool)
+	for _, s := range target {
+		inTarget[s.UUID] = true
+	}
+	for _, s := range source[selected:commonSourceI] {
+		if !inTarget[s.UUID] {
+			h.SourceOnly = append(h.SourceOnly, s)
+		}
+	}
+
+	switch {
+	case newerThanSelected:
+		h.Relation = RelationAhead
+	case h.Common == nil:
+		h.Relation = RelationDisjoint
+	case len(h.TargetOnly) == 0 && commonSourceI == selected:
+		h.Relation = RelationInSync
+	case len(h.TargetOnly) == 0:
+		h.Relation = RelationBehind
+	case commonSourceI == selected:
+		// Target has the selected snapshot, followed by snapshots that
+		// source doesn't have.
+		h.Relation = RelationAhead
+	default:
+		h.Relation = RelationDiverged
+	}
+	return h
+}
+
+// Err returns nil if target can be cloned to incrementally, i.e. if Relation
+// is RelationBehind. Otherwise, it returns an error wrapping
+// ErrNoCommonSnapshot, ErrUpToDate, ErrTargetAhead, or ErrDiverged.
+func (h History) Err() error {
+	switch h.Relation {
+	case RelationBehind:
+		return nil
+	case RelationInSync:
+		return fmt.Errorf("%w: target's latest snapshot is already the selected snapshot %s", ErrUpToDate, h.Selected)
+	case RelationAhead:
+		return fmt.Errorf("%w: target has %s, which are newer than the selected snapshot %s", ErrTargetAhead, listSnapshots(h.TargetOnly), h.Selected)
+	case RelationDiverged:
+		return fmt.Errorf("%w: since the latest snapshot in common %s, target has %s that source doesn't have", ErrDiverged, *h.Common, listSnapshots(h.TargetOnly))
+	default:
+		return ErrNoCommonSnapshot
+	}
+}
+
+// String describes h on multiple lines.
+func (h History) String() string {
+	var b strings.Builder
+	switch h.Relation {
+	case RelationInSync:
+		fmt.Fprintf(&b, "In sync: target's latest snapshot is the selected snapshot %s.\n", h.Selected)
+	case RelationBehind:
+		fmt.Fprintf(&b, "Behind: target can be cloned to from the latest snapshot in common %s.\n", *h.Common)
+	case RelationAhead:
+		fmt.Fprintf(&b, "Ahead: target has snapshots newer than the selected snapshot %s.\n", h.Selected)
+	case RelationDiverged:
+		fmt.Fprintf(&b, "Diverged: target has snapshots that source doesn't have since the latest snapshot in common %s.\n", *h.Common)
+	case RelationDisjoint:
+		b.WriteString("Disjoint: source and target have no snapshots in common. Target must be initialized.\n")
+	}
+	if len(h.SourceOnly) > 0 {
+		b.WriteString("Only in source:\n")
+		for _, s := range h.SourceOnly {
+			fmt.Fprintf(&b, "\t%s\n", s)
+		}
+	}
+	if len(h.TargetOnly) > 0 {
+		b.WriteString("Only in target:\n")
+		for _, s := range h.TargetOnly {
+			fmt.Fprintf(&b, "\t%s\n", s)
+		}
+	}
+	return b.String()
+}
+
+// listSnapshots returns a short description of snaps for error messages.
+func listSnapshots(snaps []diskutil.Snapshot) string {
+	if len(snaps) == 1 {
+		return fmt.Sprintf("snapshot %s", snaps[0])
+	}
+	var names []string
+	for _, s := range snaps {
+		names = append(names, s.String())
+	}
+	return fmt.Sprintf("%d snapshots (%s)", len(snaps), strings.Join(names, ", "))
+}
+
+// TargetHistory is the History of a single target. See Cloner.Diagnose.
+type TargetHistory struct {
+	// Arg is the target as given to Cloner.Diagnose.
+	Arg     string              `json:"arg"`
+	Target  diskutil.VolumeInfo `json:"target"`
+	History History             `json:"history"`
+}
+
+// Diagnose compares the snapshots of each of targets with source's, as of the
+// source snapshot selected by the ToSnapshot option. Unlike Plan, Diagnose
+// doesn't fail if a target can't be cloned to; see History.Err.
+func (c Cloner) Diagnose(source string, targets ...string) ([]TargetHistory, error) {
+	return c.DiagnoseContext(context.Background(), source, targets...)
+}
+
+// DiagnoseContext is like Diagnose, but stops if ctx is done first.
+func (c Cloner) DiagnoseContext(ctx context.Context, source string, targets ...string) ([]TargetHistory, error) {
+	sourceInfo, err := c.diskutil.InfoContext(ctx, source)
+	if err != nil {
+		return nil, fmt.Errorf("invalid source volume: %w", err)
+	}
+	sourceSnaps, err := c.diskutil.ListSnapshotsContext(ctx, sourceInfo)
+	if err != nil {
+		return nil, fmt.Errorf("error listing snapshots of source: %v", err)
+	}
+	if len(sourceSnaps) == 0 {
+		return nil, fmt.Errorf("invalid source: no snapshots to compare")
+	}
+	selected, err := c.selectSnapshot(sourceSnaps)
+	if err != nil {
+		return nil, fmt.Errorf("invalid source: %v", err)
+	}
+	var histories []TargetHistory
+	for _, t := range targets {
+		targetInfo, err := c.diskutil.InfoContext(ctx, t)
+		if err != nil {
+			return nil, fmt.Errorf("invalid target volume %q: %w", t, err)
+		}
+		targetSnaps, err := c.diskutil.ListSnapshotsContext(ctx, targetInfo)
+		if err != nil {
+			return nil, fmt.Errorf("error listing snapshots of target %q: %v", t, err)
+		}
+		histories = append(histories, TargetHistory{
+			Arg:     t,
+			Target:  targetInfo,
+			History: CompareHistories(sourceSnaps, selected, targetSnaps),
+		})
+	}
+	return histories, nil
+}
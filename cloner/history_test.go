@@ -0,0 +1,125 @@
+package cloner
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/diskutil"
+)
+
+func TestCompareHistories(t *testing.T) {
+	snap1 := diskutil.Snapshot{Name: "snap-1", UUID: "snap-1-uuid"}
+	snap2 := diskutil.Snapshot{Name: "snap-2", UUID: "snap-2-uuid"}
+	snap3 := diskutil.Snapshot{Name: "snap-3", UUID: "snap-3-uuid"}
+	targetSnap := diskutil.Snapshot{Name: "target-snap", UUID: "target-snap-uuid"}
+	sourceSnaps := []diskutil.Snapshot{snap3, snap2, snap1}
+
+	tests := []struct {
+		name     string
+		selected int
+		target   []diskutil.Snapshot
+		want     History
+		wantErr  error
+	}{
+		{
+			name:   "behind",
+			target: []diskutil.Snapshot{snap1},
+			want: History{
+				Relation:   RelationBehind,
+				Selected:   snap3,
+				Common:     &snap1,
+				SourceOnly: []diskutil.Snapshot{snap3, snap2},
+			},
+		},
+		{
+			name:     "behind selected snapshot",
+			selected: 1,
+			target:   []diskutil.Snapshot{snap1},
+			want: History{
+				Relation:   RelationBehind,
+				Selected:   snap2,
+				Common:     &snap1,
+				SourceOnly: []diskutil.Snapshot{snap2},
+			},
+		},
+		{
+			name:   "in sync",
+			target: []diskutil.Snapshot{snap3, snap1},
+			want: History{
+				Relation: RelationInSync,
+				Selected: snap3,
+				Common:   &snap3,
+			},
+			wantErr: ErrUpToDate,
+		},
+		{
+			name:     "ahead of selected snapshot",
+			selected: 1,
+			target:   []diskutil.Snapshot{snap3, snap1},
+			want: History{
+				Relation:   RelationAhead,
+				Selected:   snap2,
+				Common:     &snap1,
+				SourceOnly: []diskutil.Snapshot{snap2},
+				TargetOnly: []diskutil.Snapshot{snap3},
+			},
+			wantErr: ErrTargetAhead,
+		},
+		{
+			name:   "ahead with snapshot source doesn't have",
+			target: []diskutil.Snapshot{targetSnap, snap3},
+			want: History{
+				Relation:   RelationAhead,
+				Selected:   snap3,
+				Common:     &snap3,
+				TargetOnly: []diskutil.Snapshot{targetSnap},
+			},
+			wantErr: ErrTargetAhead,
+		},
+		{
+			name:   "diverged",
+			target: []diskutil.Snapshot{targetSnap, snap2, snap1},
+			want: History{
+				Relation:   RelationDiverged,
+				Selected:   snap3,
+				Common:     &snap2,
+				SourceOnly: []diskutil.Snapshot{snap3},
+				TargetOnly: []diskutil.Snapshot{targetSnap},
+			},
+			wantErr: ErrDiverged,
+		},
+		{
+			name:   "disjoint",
+			target: []diskutil.Snapshot{targetSnap},
+			want: History{
+				Relation:   RelationDisjoint,
+				Selected:   snap3,
+				SourceOnly: []diskutil.Snapshot{snap3, snap2, snap1},
+				TargetOnly: []diskutil.Snapshot{targetSnap},
+			},
+			wantErr: ErrNoCommonSnapshot,
+		},
+		{
+			name: "disjoint - target has no snapshots",
+			want: History{
+				Relation:   RelationDisjoint,
+				Selected:   snap3,
+				SourceOnly: []diskutil.Snapshot{snap3, snap2, snap1},
+			},
+			wantErr: ErrNoCommonSnapshot,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := CompareHistories(sourceSnaps, test.selected, test.target)
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("CompareHistories returned unexpected history. -want +got:\n%s", diff)
+			}
+			if err := got.Err(); !errors.Is(err, test.wantErr) {
+				t.Errorf("Err returned unexpected error: %v, want: %v", err, test.wantErr)
+			}
+		})
+	}
+}
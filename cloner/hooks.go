@@ -0,0 +1,276 @@
+package cloner
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/diskutil"
+)
+
+// HookPoint is a point during a run at which hooks are run.
+type HookPoint string
+
+// Hook points, in the order they are run.
+const (
+	// HookPreValidate is run by PreValidate at the start of a run, before
+	// any volumes are read. If a pre-validate hook fails, nothing is
+	// cloned.
+	HookPreValidate HookPoint = "pre-validate"
+	// HookPreRestore is run before each target is restored. If a
+	// pre-restore hook fails, the target is skipped.
+	HookPreRestore HookPoint = "pre-restore"
+	// HookPostRestore is run after each target's restore, whether or not
+	// it succeeded.
+	HookPostRestore HookPoint = "post-restore"
+	// HookPostPrune is run after snapshots are pruned from a target, if any
+	// were planned to be pruned.
+	HookPostPrune HookPoint = "post-prune"
+	// HookPostRun is run by PostRun at the end of a run.
+	HookPostRun HookPoint = "post-run"
+)
+
+// Outcomes of a step, passed to post hooks.
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+	OutcomeSkipped = "skipped"
+)
+
+// HookEvent describes the point at which a hook is run. Fields that are not
+// known at Point are empty.
+type HookEvent struct {
+	Point HookPoint `json:"point"`
+	// SourceArg and TargetArgs are the volumes as given to Cloner.Plan.
+	// Only set for HookPreValidate and HookPostRun.
+	SourceArg  string   `json:"source_arg,omitempty"`
+	TargetArgs []string `json:"target_args,omitempty"`
+
+	Source *diskutil.VolumeInfo `json:"source,omitempty"`
+	Target *diskutil.VolumeInfo `json:"target,omitempty"`
+	From   *diskutil.Snapshot   `json:"from,omitempty"`
+	To     *diskutil.Snapshot   `json:"to,omitempty"`
+
+	// Outcome is one of OutcomeSuccess, OutcomeFailure, or OutcomeSkipped.
+	// Only set for post hooks.
+	Outcome string `json:"outcome,omitempty"`
+	// Error describes the failure if Outcome is OutcomeFailure or
+	// OutcomeSkipped.
+	Error string `json:"error,omitempty"`
+	// Targets are the outcomes of each target. Only set for HookPostRun.
+	Targets []HookTargetOutcome `json:"targets,omitempty"`
+}
+
+// HookTargetOutcome is the outcome of a single target, passed to
+// HookPostRun hooks.
+type HookTargetOutcome struct {
+	Target  string `json:"target"`
+	Outcome string `json:"outcome"`
+	Error   string `json:"error,omitempty"`
+}
+
+// Env returns e as environment variables of the form OFFSITE_<NAME>=<value>.
+// Empty fields are omitted.
+func (e HookEvent) Env() []string {
+	var env []string
+	add := func(name, value string) {
+		if value != "" {
+			env = append(env, fmt.Sprintf("OFFSITE_%s=%s", name, value))
+		}
+	}
+	add("HOOK", string(e.Point))
+	add("SOURCE_ARG", e.SourceArg)
+	add("TARGET_ARGS", strings.Join(e.TargetArgs, "\n"))
+	if e.Source != nil {
+		add("SOURCE_UUID", e.Source.UUID)
+		add("SOURCE_NAME", e.Source.Name)
+	}
+	if e.Target != nil {
+		add("TARGET_UUID", e.Target.UUID)
+		add("TARGET_NAME", e.Target.Name)
+	}
+	if e.From != nil {
+		add("FROM_SNAPSHOT_UUID", e.From.UUID)
+		add("FROM_SNAPSHOT_NAME", e.From.Name)
+	}
+	if e.To != nil {
+		add("TO_SNAPSHOT_UUID", e.To.UUID)
+		add("TO_SNAPSHOT_NAME", e.To.Name)
+	}
+	add("OUTCOME", e.Outcome)
+	add("ERROR", e.Error)
+	return env
+}
+
+// Hook is run at a HookPoint.
+type Hook interface {
+	// Run the hook, writing any output to stdout.
+	Run(event HookEvent, stdout io.Writer) error
+}
+
+// ContextHook is a Hook that stops early if ctx is done: RunContext is like
+// Run, but returns an error once ctx is done. Hooks returned by CommandHook
+// implement ContextHook. Hooks that don't are run to completion, unless ctx
+// is done before they start.
+type ContextHook interface {
+	Hook
+	RunContext(ctx context.Context, event HookEvent, stdout io.Writer) error
+}
+
+// OnHook returns an Option that runs h at point. Multiple hooks at the same
+// point are run in the order they are added, stopping at the first failure.
+func OnHook(point HookPoint, h Hook) Option {
+	return func(c *Cloner) {
+		if c.hooks == nil {
+			c.hooks = make(map[HookPoint][]Hook)
+		}
+		c.hooks[point] = append(c.hooks[point], h)
+	}
+}
+
+// CommandHook returns a Hook that runs command using `sh -c`. The command
+// receives the HookEvent both as environment variables (see HookEvent.Env)
+// and as JSON on stdin. The hook fails if command exits with a non-zero
+// status. RunContext kills command if ctx is done first.
+func CommandHook(command string) Hook {
+	return commandHook{
+		command:     command,
+		execCommand: exec.CommandContext,
+	}
+}
+
+type commandHook struct {
+	command     string
+	execCommand func(context.Context, string, ...string) *exec.Cmd
+}
+
+func (h commandHook) Run(event HookEvent, stdout io.Writer) error {
+	return h.RunContext(context.Background(), event, stdout)
+}
+
+func (h commandHook) RunContext(ctx context.Context, event HookEvent, stdout io.Writer) error {
+	stdin, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	cmd := h.execCommand(ctx, "sh", "-c", h.command)
+	cmd.Env = append(os.Environ(), event.Env()...)
+	cmd.Stdin = bytes.NewReader(stdin)
+	cmd.Stdout = stdout
+	cmd.Stderr = stdout
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("`%s` failed: %w", h.command, err)
+	}
+	return nil
+}
+
+// HookError is returned when a hook fails.
+type HookError struct {
+	Point HookPoint
+	Err   error
+}
+
+func (e *HookError) Error() string {
+	return fmt.Sprintf("%s hook failed: %v", e.Point, e.Err)
+}
+
+func (e *HookError) Unwrap() error {
+	return e.Err
+}
+
+// runHooks runs the hooks at event's point, stopping at the first failure or
+// once ctx is done.
+func (c Cloner) runHooks(ctx context.Context, event HookEvent) error {
+	for _, h := range c.hooks[event.Point] {
+		fmt.Fprintf(c.stdout, "Running %s hook...\n", event.Point)
+		if err := runHook(ctx, h, event, c.stdout); err != nil {
+			return &HookError{
+				Point: event.Point,
+				Err:   err,
+			}
+		}
+	}
+	return nil
+}
+
+// runHook runs h, with RunContext if h is a ContextHook.
+func runHook(ctx context.Context, h Hook, event HookEvent, stdout io.Writer) error {
+	if ch, ok := h.(ContextHook); ok {
+		return ch.RunContext(ctx, event, stdout)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return h.Run(event, stdout)
+}
+
+// runPostHooks runs the hooks at event's point. Failures are reported as
+// warnings in result, as the step the hooks follow has already completed.
+func (c Cloner) runPostHooks(ctx context.Context, event HookEvent, result *CloneResult) {
+	if err := c.runHooks(ctx, event); err != nil {
+		warning := err.Error()
+		fmt.Fprintf(c.stdout, "Warning: %s\n", warning)
+		result.Warnings = append(result.Warnings, warning)
+	}
+}
+
+// PreValidate runs HookPreValidate hooks. Call PreValidate at the start of a
+// run, before creating snapshots (see CreateSnapshot) or planning the clone.
+func (c Cloner) PreValidate(source string, targets ...string) error {
+	return c.PreValidateContext(context.Background(), source, targets...)
+}
+
+// PreValidateContext is like PreValidate, but stops hooks once ctx is done.
+func (c Cloner) PreValidateContext(ctx context.Context, source string, targets ...string) error {
+	return c.runHooks(ctx, HookEvent{
+		Point:      HookPreValidate,
+		SourceArg:  source,
+		TargetArgs: targets,
+	})
+}
+
+// PostRun runs HookPostRun hooks with the outcomes of each target in
+// summary. Call PostRun at the end of a run.
+func (c Cloner) PostRun(source string, summary Summary) error {
+	return c.PostRunContext(context.Background(), source, summary)
+}
+
+// PostRunContext is like PostRun, but stops hooks once ctx is done.
+func (c Cloner) PostRunContext(ctx context.Context, source string, summary Summary) error {
+	event := HookEvent{
+		Point:     HookPostRun,
+		SourceArg: source,
+		Outcome:   OutcomeSuccess,
+	}
+	for _, t := range summary.Targets {
+		event.TargetArgs = append(event.TargetArgs, t.Target)
+		outcome := HookTargetOutcome{
+			Target:  t.Target,
+			Outcome: outcomeOf(t.Err),
+		}
+		if t.Err != nil {
+			outcome.Error = t.Err.Error()
+			event.Outcome = OutcomeFailure
+		}
+		event.Targets = append(event.Targets, outcome)
+	}
+	return c.runHooks(ctx, event)
+}
+
+// outcomeOf returns the outcome of a step that returned err.
+func outcomeOf(err error) string {
+	var hookErr *HookError
+	switch {
+	case err == nil:
+		return OutcomeSuccess
+	case errors.As(err, &hookErr) && hookErr.Point == HookPreRestore:
+		return OutcomeSkipped
+	}
+	return OutcomeFailure
+}
@@ -0,0 +1,204 @@
+package cloner
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/diskutil"
+)
+
+var (
+	hookSource = diskutil.VolumeInfo{
+		Name:           "source-name",
+		UUID:           "source-uuid",
+		MountPoint:     "/source/mount/point",
+		FileSystemType: "apfs",
+	}
+	hookTarget = diskutil.VolumeInfo{
+		Name:           "target-name",
+		UUID:           "target-uuid",
+		MountPoint:     "/target/mount/point",
+		Writable:       true,
+		FileSystemType: "apfs",
+	}
+	hookCommonSnap = diskutil.Snapshot{Name: "common-snap", UUID: "common-snap-uuid"}
+	hookLatestSnap = diskutil.Snapshot{Name: "latest-snap", UUID: "latest-snap-uuid"}
+)
+
+func TestClone_Hooks(t *testing.T) {
+	devices := newFakeDevices(t,
+		withFakeVolume(hookSource, hookLatestSnap, hookCommonSnap),
+		withFakeVolume(hookTarget, hookCommonSnap),
+	)
+	h := &fakeHook{}
+	c := New(&fakeDiskUtil{devices}, &fakeASR{devices},
+		Prune(true),
+		OnHook(HookPreRestore, h),
+		OnHook(HookPostRestore, h),
+		OnHook(HookPostPrune, h),
+	)
+	if _, err := c.Clone(hookSource.MountPoint, hookTarget.MountPoint); err != nil {
+		t.Fatalf("Clone(...) returned unexpected error: %q, want: nil", err)
+	}
+	event := HookEvent{
+		Source: &hookSource,
+		Target: &hookTarget,
+		From:   &hookCommonSnap,
+		To:     &hookLatestSnap,
+	}
+	pre := event
+	pre.Point = HookPreRestore
+	postRestore := event
+	postRestore.Point = HookPostRestore
+	postRestore.Outcome = OutcomeSuccess
+	postPrune := event
+	postPrune.Point = HookPostPrune
+	postPrune.Outcome = OutcomeSuccess
+	want := []HookEvent{pre, postRestore, postPrune}
+	if diff := cmp.Diff(want, h.events); diff != "" {
+		t.Errorf("Clone(...) ran hooks with unexpected events. -want +got:\n%s", diff)
+	}
+}
+
+func TestClone_PreRestoreHookFailureSkipsTarget(t *testing.T) {
+	devices := newFakeDevices(t,
+		withFakeVolume(hookSource, hookLatestSnap, hookCommonSnap),
+		withFakeVolume(hookTarget, hookCommonSnap),
+	)
+	post := &fakeHook{}
+	c := New(&fakeDiskUtil{devices}, &fakeASR{devices},
+		OnHook(HookPreRestore, &fakeHook{err: errors.New("fake hook failure")}),
+		OnHook(HookPostRestore, post),
+	)
+	result, err := c.Clone(hookSource.MountPoint, hookTarget.MountPoint)
+	var hookErr *HookError
+	if !errors.As(err, &hookErr) || hookErr.Point != HookPreRestore {
+		t.Fatalf("Clone(...) returned unexpected error: %v, want: pre-restore *HookError", err)
+	}
+	if !result.Skipped {
+		t.Error("CloneResult.Skipped = false, want: true")
+	}
+	if got := outcomeOf(err); got != OutcomeSkipped {
+		t.Errorf("outcomeOf(err) = %q, want: %q", got, OutcomeSkipped)
+	}
+	if len(post.events) > 0 {
+		t.Errorf("post-restore hook ran for skipped target: %v", post.events)
+	}
+	gotTargetSnaps, err := devices.Snapshots(hookTarget.UUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]diskutil.Snapshot{hookCommonSnap}, gotTargetSnaps); diff != "" {
+		t.Errorf("Clone(...) modified skipped target. -want +got:\n%s", diff)
+	}
+}
+
+func TestClone_PostHookFailureIsWarning(t *testing.T) {
+	devices := newFakeDevices(t,
+		withFakeVolume(hookSource, hookLatestSnap, hookCommonSnap),
+		withFakeVolume(hookTarget, hookCommonSnap),
+	)
+	c := New(&fakeDiskUtil{devices}, &fakeASR{devices},
+		OnHook(HookPostRestore, &fakeHook{err: errors.New("fake hook failure")}),
+	)
+	result, err := c.Clone(hookSource.MountPoint, hookTarget.MountPoint)
+	if err != nil {
+		t.Fatalf("Clone(...) returned unexpected error: %q, want: nil", err)
+	}
+	want := []string{"post-restore hook failed: fake hook failure"}
+	if diff := cmp.Diff(want, result.Warnings); diff != "" {
+		t.Errorf("Clone(...) returned unexpected warnings. -want +got:\n%s", diff)
+	}
+}
+
+func TestPostRun(t *testing.T) {
+	h := &fakeHook{}
+	c := New(nil, nil, OnHook(HookPostRun, h), Stdout(new(bytes.Buffer)))
+	summary := Summary{
+		Targets: []TargetSummary{
+			{Target: "/ok"},
+			{Target: "/skipped", Err: &HookError{Point: HookPreRestore, Err: errors.New("exit status 1")}},
+			{Target: "/failed", Err: errors.New("restore failed")},
+		},
+	}
+	if err := c.PostRun("/source", summary); err != nil {
+		t.Fatalf("PostRun(...) returned unexpected error: %v, want: nil", err)
+	}
+	want := []HookEvent{{
+		Point:      HookPostRun,
+		SourceArg:  "/source",
+		TargetArgs: []string{"/ok", "/skipped", "/failed"},
+		Outcome:    OutcomeFailure,
+		Targets: []HookTargetOutcome{
+			{Target: "/ok", Outcome: OutcomeSuccess},
+			{Target: "/skipped", Outcome: OutcomeSkipped, Error: "pre-restore hook failed: exit status 1"},
+			{Target: "/failed", Outcome: OutcomeFailure, Error: "restore failed"},
+		},
+	}}
+	if diff := cmp.Diff(want, h.events); diff != "" {
+		t.Errorf("PostRun(...) ran hooks with unexpected events. -want +got:\n%s", diff)
+	}
+}
+
+func TestCommandHook(t *testing.T) {
+	event := HookEvent{
+		Point:   HookPostRestore,
+		Source:  &hookSource,
+		Target:  &hookTarget,
+		From:    &hookCommonSnap,
+		To:      &hookLatestSnap,
+		Outcome: OutcomeSuccess,
+	}
+	t.Run("environment", func(t *testing.T) {
+		stdout := new(bytes.Buffer)
+		h := CommandHook(`echo "$OFFSITE_HOOK $OFFSITE_TARGET_UUID $OFFSITE_TO_SNAPSHOT_UUID $OFFSITE_OUTCOME"`)
+		if err := h.Run(event, stdout); err != nil {
+			t.Fatalf("Run(...) returned unexpected error: %v, want: nil", err)
+		}
+		want := "post-restore target-uuid latest-snap-uuid success\n"
+		if got := stdout.String(); got != want {
+			t.Errorf("hook output = %q, want: %q", got, want)
+		}
+	})
+	t.Run("stdin", func(t *testing.T) {
+		stdout := new(bytes.Buffer)
+		h := CommandHook("cat")
+		if err := h.Run(event, stdout); err != nil {
+			t.Fatalf("Run(...) returned unexpected error: %v, want: nil", err)
+		}
+		var got HookEvent
+		if err := json.Unmarshal(stdout.Bytes(), &got); err != nil {
+			t.Fatalf("hook stdin is not a JSON HookEvent: %v", err)
+		}
+		if diff := cmp.Diff(event, got, cmpopts.IgnoreFields(diskutil.Snapshot{}, "Created")); diff != "" {
+			t.Errorf("hook received unexpected event on stdin. -want +got:\n%s", diff)
+		}
+	})
+	t.Run("failure", func(t *testing.T) {
+		h := CommandHook("echo failing; exit 3")
+		err := h.Run(event, new(bytes.Buffer))
+		if err == nil || !strings.Contains(err.Error(), "exit status 3") {
+			t.Errorf("Run(...) returned unexpected error: %v, want: exit status 3", err)
+		}
+	})
+	t.Run("canceled", func(t *testing.T) {
+		h := CommandHook("exec sleep 3600").(ContextHook)
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		start := time.Now()
+		if err := h.RunContext(ctx, event, new(bytes.Buffer)); err == nil {
+			t.Error("RunContext(...) returned error: nil, want: non-nil")
+		}
+		if elapsed := time.Since(start); elapsed > 10*time.Second {
+			t.Errorf("RunContext(...) returned after %v, want: soon after ctx was done", elapsed)
+		}
+	})
+}
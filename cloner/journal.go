@@ -0,0 +1,316 @@
+package cloner
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/asr"
+	"github.com/voidingwarranties/offsite-apfs-backup/diskutil"
+)
+
+// JournalEntry records a step of a clone that modifies a target. An entry is
+// written to the journal before each step starts, replacing the entry of the
+// previous step, and removed once target is renamed back to its original name.
+// An entry that remains in the journal describes a clone that was
+// interrupted, e.g. by a crash or a disconnected target, or that failed,
+// during or after Step. Entries of restores that asr reports as failed are
+// removed if target is unchanged.
+type JournalEntry struct {
+	// Step is one of PhaseDiscard, PhaseRestore, PhasePrune, or PhaseRename.
+	Step   Phase               `json:"step"`
+	Source diskutil.VolumeInfo `json:"source"`
+	// Target is target's volume info before the clone, including the name
+	// that target is renamed back to.
+	Target diskutil.VolumeInfo `json:"target"`
+	// From is the snapshot being restored from. Nil for a destructive
+	// restore.
+	From *diskutil.Snapshot `json:"from,omitempty"`
+	// To is the snapshot being restored to, or that was restored to if Step
+	// is PhasePrune or PhaseRename.
+	To diskutil.Snapshot `json:"to"`
+	// Snapshot is the snapshot being deleted if Step is PhaseDiscard or
+	// PhasePrune.
+	Snapshot *diskutil.Snapshot `json:"snapshot,omitempty"`
+	Started  time.Time          `json:"started"`
+}
+
+func (e JournalEntry) String() string {
+	var step string
+	switch e.Step {
+	case PhaseDiscard:
+		if e.Snapshot != nil {
+			step = fmt.Sprintf("discard of %s", *e.Snapshot)
+		} else {
+			step = "discard"
+		}
+	case PhaseRestore:
+		if e.From == nil {
+			step = fmt.Sprintf("destructive restore to %s", e.To)
+		} else {
+			step = fmt.Sprintf("restore from %s to %s", *e.From, e.To)
+		}
+	case PhasePrune:
+		if e.Snapshot != nil {
+			step = fmt.Sprintf("prune of %s", *e.Snapshot)
+		} else {
+			step = "prune"
+		}
+	case PhaseRename:
+		step = fmt.Sprintf("rename to %q", e.Target.Name)
+	default:
+		step = string(e.Step)
+	}
+	return fmt.Sprintf("%s (%s): %s started at %s did not complete", e.Target.Name, e.Target.UUID, step, e.Started.Format(time.RFC3339))
+}
+
+// Journal records the steps of clones that modify targets, so that steps
+// interrupted by a crash can be detected and recovered (see Cloner.Recover).
+// Journal holds at most one entry per target.
+type Journal interface {
+	// Begin records that entry's step is starting, replacing any existing
+	// entry of the same target.
+	Begin(entry JournalEntry) error
+	// Finish removes target's entry.
+	Finish(target diskutil.VolumeInfo) error
+	// Pending returns all entries that have not been finished, oldest
+	// first.
+	Pending() ([]JournalEntry, error)
+}
+
+// Journaling returns an Option that records each step that modifies a target
+// in j. By default, or if j is nil, steps are not recorded.
+func Journaling(j Journal) Option {
+	return func(c *Cloner) {
+		if j == nil {
+			j = nopJournal{}
+		}
+		c.journal = j
+	}
+}
+
+// NewFileJournal returns a Journal that stores each target's entry as a JSON
+// file in dir. Dir is created if it does not exist. Entries are written
+// atomically and synced to disk before Begin returns.
+func NewFileJournal(dir string) Journal {
+	return fileJournal{dir: dir}
+}
+
+type fileJournal struct {
+	dir string
+}
+
+func (j fileJournal) path(target diskutil.VolumeInfo) string {
+	return filepath.Join(j.dir, target.UUID+".json")
+}
+
+func (j fileJournal) Begin(entry JournalEntry) error {
+	if err := os.MkdirAll(j.dir, 0700); err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(entry, "", "  ")
+	if err != nil {
+		return err
+	}
+	// Write to a temporary file and rename it over any existing entry, so
+	// that a crash never leaves a partially written entry.
+	f, err := os.CreateTemp(j.dir, ".entry-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), j.path(entry.Target))
+}
+
+func (j fileJournal) Finish(target diskutil.VolumeInfo) error {
+	err := os.Remove(j.path(target))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func (j fileJournal) Pending() ([]JournalEntry, error) {
+	files, err := os.ReadDir(j.dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entries []JournalEntry
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), ".json") {
+			continue
+		}
+		b, err := os.ReadFile(filepath.Join(j.dir, f.Name()))
+		if err != nil {
+			return nil, err
+		}
+		var entry JournalEntry
+		if err := json.Unmarshal(b, &entry); err != nil {
+			return nil, fmt.Errorf("error decoding journal entry %q: %v", f.Name(), err)
+		}
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, ii int) bool {
+		return entries[i].Started.Before(entries[ii].Started)
+	})
+	return entries, nil
+}
+
+// nopJournal is the Journal used when no journal is configured.
+type nopJournal struct{}
+
+func (nopJournal) Begin(JournalEntry) error         { return nil }
+func (nopJournal) Finish(diskutil.VolumeInfo) error { return nil }
+func (nopJournal) Pending() ([]JournalEntry, error) { return nil, nil }
+
+// journaled records entry in c's journal as target's current step, replacing
+// the previous step's entry, and runs step. The entry is left in the journal
+// even if step succeeds: target is only consistent once it is renamed back to
+// its original name, after which finishJournal removes the entry.
+func (c Cloner) journaled(entry JournalEntry, step func() error) error {
+	entry.Started = c.now()
+	if err := c.journal.Begin(entry); err != nil {
+		return fmt.Errorf("error writing journal: %v", err)
+	}
+	return step()
+}
+
+// finishJournal removes target's entry from c's journal.
+func (c Cloner) finishJournal(target diskutil.VolumeInfo, result *CloneResult) {
+	if err := c.journal.Finish(target); err != nil {
+		warning := fmt.Sprintf("error removing completed clone from journal: %v", err)
+		fmt.Fprintf(c.stdout, "Warning: %s\n", warning)
+		result.Warnings = append(result.Warnings, warning)
+	}
+}
+
+// clearFailedRestore removes tp's target from c's journal after asr reported
+// that restoring to it failed, i.e. err wraps asr.ErrRestoreFailed, so that
+// later runs aren't stopped by an entry that there is nothing to recover
+// from. The entry is kept if the restore was interrupted, i.e. ctx is done,
+// or if target no longer has its original name and the snapshots it had
+// before the restore, less those in result.Discarded.
+func (c Cloner) clearFailedRestore(ctx context.Context, tp TargetPlan, result *CloneResult, err error) {
+	if ctx.Err() != nil || !errors.Is(err, asr.ErrRestoreFailed) {
+		return
+	}
+	target, err := c.diskutil.InfoContext(ctx, tp.Target.UUID)
+	if err != nil || target.Name != tp.Target.Name {
+		return
+	}
+	targetSnaps, err := c.diskutil.ListSnapshotsContext(ctx, target)
+	if err != nil {
+		return
+	}
+	var want []diskutil.Snapshot
+	for _, s := range tp.TargetSnapshots {
+		if !containsSnapshot(result.Discarded, s) {
+			want = append(want, s)
+		}
+	}
+	if !sameSnapshots(targetSnaps, want) {
+		return
+	}
+	c.finishJournal(tp.Target, result)
+}
+
+// PendingJournalEntries returns the steps recorded in c's journal that did not
+// complete.
+func (c Cloner) PendingJournalEntries() ([]JournalEntry, error) {
+	return c.journal.Pending()
+}
+
+// Recover completes the clone interrupted during entry's step, and removes
+// entry from c's journal:
+//   - An interrupted restore is re-run, unless target already has the
+//     snapshot it was being restored to.
+//   - An interrupted discard or prune deletes the snapshot being deleted, if
+//     target still has it. Target is not restored after a discard; clone to
+//     it again instead.
+//
+// In all cases, target is then renamed back to its original name. Recover
+// returns an error if target cannot be recovered automatically, e.g. if an
+// incremental restore was interrupted and target no longer has the snapshot
+// it was being restored from.
+func (c Cloner) Recover(entry JournalEntry) error {
+	return c.RecoverContext(context.Background(), entry)
+}
+
+// RecoverContext is like Recover, but stops if ctx is done first. entry is
+// left in the journal if recovery is interrupted.
+func (c Cloner) RecoverContext(ctx context.Context, entry JournalEntry) error {
+	// Device nodes may change, e.g. if target was reconnected, so find
+	// target by UUID.
+	target, err := c.diskutil.InfoContext(ctx, entry.Target.UUID)
+	if err != nil {
+		return fmt.Errorf("error getting volume info of target %q: %w", entry.Target.UUID, err)
+	}
+	targetSnaps, err := c.diskutil.ListSnapshotsContext(ctx, target)
+	if err != nil {
+		return fmt.Errorf("error listing snapshots of target: %v", err)
+	}
+
+	switch entry.Step {
+	case PhaseRestore:
+		if containsSnapshot(targetSnaps, entry.To) {
+			fmt.Fprintf(c.stdout, "Target already has %s. Not restoring.\n", entry.To)
+			break
+		}
+		source, err := c.diskutil.InfoContext(ctx, entry.Source.UUID)
+		if err != nil {
+			return fmt.Errorf("error getting volume info of source %q: %w", entry.Source.UUID, err)
+		}
+		if entry.From == nil {
+			fmt.Fprintf(c.stdout, "Re-running destructive restore to %s...\n", entry.To)
+			if err := c.asr.DestructiveRestoreContext(ctx, source, target, entry.To); err != nil {
+				return fmt.Errorf("error restoring: %w", err)
+			}
+			break
+		}
+		if !containsSnapshot(targetSnaps, *entry.From) {
+			return fmt.Errorf("cannot recover target %q: it no longer has snapshot %s to restore from - initialize the target again", entry.Target.Name, *entry.From)
+		}
+		fmt.Fprintf(c.stdout, "Re-running restore from %s to %s...\n", *entry.From, entry.To)
+		if err := c.asr.RestoreContext(ctx, source, target, entry.To, *entry.From); err != nil {
+			return fmt.Errorf("error restoring: %w", err)
+		}
+	case PhaseDiscard, PhasePrune:
+		if entry.Snapshot != nil && containsSnapshot(targetSnaps, *entry.Snapshot) {
+			fmt.Fprintf(c.stdout, "Deleting snapshot %s...\n", *entry.Snapshot)
+			if err := c.diskutil.DeleteSnapshotContext(ctx, target, *entry.Snapshot); err != nil {
+				return fmt.Errorf("error deleting snapshot %q from target: %v", *entry.Snapshot, err)
+			}
+		}
+	case PhaseRename:
+	default:
+		return fmt.Errorf("unknown journal step %q", entry.Step)
+	}
+
+	if target.Name != entry.Target.Name {
+		fmt.Fprintf(c.stdout, "Renaming target to original name %q...\n", entry.Target.Name)
+		if err := c.diskutil.RenameContext(ctx, target, entry.Target.Name); err != nil {
+			return fmt.Errorf("error renaming volume to original name %q: %v", entry.Target.Name, err)
+		}
+	}
+	return c.journal.Finish(entry.Target)
+}
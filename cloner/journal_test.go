@@ -0,0 +1,326 @@
+package cloner
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/asr"
+	"github.com/voidingwarranties/offsite-apfs-backup/diskutil"
+)
+
+var (
+	journalSource = diskutil.VolumeInfo{
+		Name:           "source-name",
+		UUID:           "source-uuid",
+		MountPoint:     "/source/mount/point",
+		FileSystemType: "apfs",
+	}
+	journalTarget = diskutil.VolumeInfo{
+		Name:           "target-name",
+		UUID:           "target-uuid",
+		MountPoint:     "/target/mount/point",
+		Writable:       true,
+		FileSystemType: "apfs",
+	}
+	journalSnap1 = diskutil.Snapshot{Name: "snap-1", UUID: "snap-1-uuid"}
+	journalSnap2 = diskutil.Snapshot{Name: "snap-2", UUID: "snap-2-uuid"}
+)
+
+func TestFileJournal(t *testing.T) {
+	j := NewFileJournal(t.TempDir() + "/journal")
+	if got, err := j.Pending(); err != nil || len(got) != 0 {
+		t.Fatalf("Pending() of new journal = %v, %v, want: no entries, nil", got, err)
+	}
+
+	target2 := diskutil.VolumeInfo{Name: "target2-name", UUID: "target2-uuid"}
+	first := JournalEntry{
+		Step:    PhaseRestore,
+		Source:  journalSource,
+		Target:  journalTarget,
+		From:    &journalSnap1,
+		To:      journalSnap2,
+		Started: time.Date(2021, 3, 1, 1, 0, 0, 0, time.UTC),
+	}
+	second := JournalEntry{
+		Step:    PhaseRestore,
+		Source:  journalSource,
+		Target:  target2,
+		To:      journalSnap2,
+		Started: time.Date(2021, 3, 1, 2, 0, 0, 0, time.UTC),
+	}
+	// Replaces first.
+	third := first
+	third.Step = PhaseRename
+	third.Started = time.Date(2021, 3, 1, 3, 0, 0, 0, time.UTC)
+	for _, e := range []JournalEntry{first, second, third} {
+		if err := j.Begin(e); err != nil {
+			t.Fatalf("Begin(...) returned unexpected error: %v", err)
+		}
+	}
+	got, err := j.Pending()
+	if err != nil {
+		t.Fatalf("Pending() returned unexpected error: %v", err)
+	}
+	if diff := cmp.Diff([]JournalEntry{second, third}, got); diff != "" {
+		t.Errorf("Pending() returned unexpected entries. -want +got:\n%s", diff)
+	}
+
+	if err := j.Finish(journalTarget); err != nil {
+		t.Fatalf("Finish(...) returned unexpected error: %v", err)
+	}
+	if err := j.Finish(journalTarget); err != nil {
+		t.Fatalf("Finish(...) of finished target returned unexpected error: %v", err)
+	}
+	got, err = j.Pending()
+	if err != nil {
+		t.Fatalf("Pending() returned unexpected error: %v", err)
+	}
+	if diff := cmp.Diff([]JournalEntry{second}, got); diff != "" {
+		t.Errorf("Pending() returned unexpected entries after Finish. -want +got:\n%s", diff)
+	}
+}
+
+func TestClone_Journal(t *testing.T) {
+	tests := []struct {
+		name        string
+		opts        []Option
+		failRestore bool
+		restoreErr  error
+		wantBegun   []Phase
+		wantPending []Phase
+	}{
+		{
+			name:      "incremental",
+			wantBegun: []Phase{PhaseRestore, PhaseRename},
+		},
+		{
+			name:      "prune",
+			opts:      []Option{Prune(true)},
+			wantBegun: []Phase{PhaseRestore, PhasePrune, PhaseRename},
+		},
+		{
+			name:        "restore of unknown outcome is left pending",
+			failRestore: true,
+			wantBegun:   []Phase{PhaseRestore},
+			wantPending: []Phase{PhaseRestore},
+		},
+		{
+			name:        "restore failed by asr is cleared",
+			failRestore: true,
+			restoreErr:  fmt.Errorf("fake restore failure: %w", asr.ErrRestoreFailed),
+			wantBegun:   []Phase{PhaseRestore},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			devices := newFakeDevices(t,
+				withFakeVolume(journalSource, journalSnap2, journalSnap1),
+				withFakeVolume(journalTarget, journalSnap1),
+			)
+			j := newFakeJournal()
+			r := &failingFakeASR{fakeASR: fakeASR{devices}, succeed: 1, err: test.restoreErr}
+			if test.failRestore {
+				r.succeed = 0
+			}
+			opts := append([]Option{Journaling(j)}, test.opts...)
+			c := New(&fakeDiskUtil{devices}, r, opts...)
+			_, err := c.Clone(journalSource.MountPoint, journalTarget.MountPoint)
+			if gotErr := err != nil; gotErr != test.failRestore {
+				t.Fatalf("Clone(...) returned unexpected error: %v, want error: %t", err, test.failRestore)
+			}
+			if diff := cmp.Diff(test.wantBegun, j.begun); diff != "" {
+				t.Errorf("Clone(...) journaled unexpected steps. -want +got:\n%s", diff)
+			}
+			pending, err := c.PendingJournalEntries()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var gotPending []Phase
+			for _, e := range pending {
+				gotPending = append(gotPending, e.Step)
+			}
+			if diff := cmp.Diff(test.wantPending, gotPending, cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("Clone(...) left unexpected pending steps in journal. -want +got:\n%s", diff)
+			}
+		})
+	}
+}
+
+// pendingRecordingHook records the steps pending in j when it's run, and
+// fails.
+type pendingRecordingHook struct {
+	j       *fakeJournal
+	pending map[HookPoint][]Phase
+}
+
+func (h *pendingRecordingHook) Run(event HookEvent, stdout io.Writer) error {
+	pending, err := h.j.Pending()
+	if err != nil {
+		return err
+	}
+	for _, e := range pending {
+		h.pending[event.Point] = append(h.pending[event.Point], e.Step)
+	}
+	return errors.New("fake hook failure")
+}
+
+func TestClone_JournalUntilRename(t *testing.T) {
+	devices := newFakeDevices(t,
+		withFakeVolume(journalSource, journalSnap2, journalSnap1),
+		withFakeVolume(journalTarget, journalSnap1),
+	)
+	j := newFakeJournal()
+	h := &pendingRecordingHook{j: j, pending: make(map[HookPoint][]Phase)}
+	c := New(&fakeDiskUtil{devices}, &fakeASR{devices},
+		Journaling(j),
+		Prune(true),
+		OnHook(HookPostRestore, h),
+		OnHook(HookPostPrune, h),
+	)
+	if _, err := c.Clone(journalSource.MountPoint, journalTarget.MountPoint); err != nil {
+		t.Fatalf("Clone(...) returned unexpected error: %v", err)
+	}
+	// Target has source's name until it's renamed, so it must stay in the
+	// journal between steps.
+	want := map[HookPoint][]Phase{
+		HookPostRestore: {PhaseRestore},
+		HookPostPrune:   {PhasePrune},
+	}
+	if diff := cmp.Diff(want, h.pending); diff != "" {
+		t.Errorf("Clone(...) left unexpected steps in journal between restore and rename. -want +got:\n%s", diff)
+	}
+	if pending, _ := j.Pending(); len(pending) != 0 {
+		t.Errorf("Clone(...) left pending entries after rename: %v", pending)
+	}
+}
+
+func TestRecover(t *testing.T) {
+	// Target's name after an interrupted restore.
+	renamedTarget := journalTarget
+	renamedTarget.Name = journalSource.Name
+
+	tests := []struct {
+		name            string
+		target          diskutil.VolumeInfo
+		targetSnaps     []diskutil.Snapshot
+		entry           JournalEntry
+		wantErr         bool
+		wantTargetSnaps []diskutil.Snapshot
+	}{
+		{
+			name:        "interrupted rename",
+			target:      renamedTarget,
+			targetSnaps: []diskutil.Snapshot{journalSnap2, journalSnap1},
+			entry: JournalEntry{
+				Step:   PhaseRename,
+				Source: journalSource,
+				Target: journalTarget,
+				From:   &journalSnap1,
+				To:     journalSnap2,
+			},
+			wantTargetSnaps: []diskutil.Snapshot{journalSnap2, journalSnap1},
+		},
+		{
+			name:        "interrupted restore is re-run",
+			target:      renamedTarget,
+			targetSnaps: []diskutil.Snapshot{journalSnap1},
+			entry: JournalEntry{
+				Step:   PhaseRestore,
+				Source: journalSource,
+				Target: journalTarget,
+				From:   &journalSnap1,
+				To:     journalSnap2,
+			},
+			wantTargetSnaps: []diskutil.Snapshot{journalSnap2, journalSnap1},
+		},
+		{
+			name:        "completed restore is not re-run",
+			target:      renamedTarget,
+			targetSnaps: []diskutil.Snapshot{journalSnap2},
+			entry: JournalEntry{
+				Step:   PhaseRestore,
+				Source: journalSource,
+				Target: journalTarget,
+				From:   &journalSnap1,
+				To:     journalSnap2,
+			},
+			wantTargetSnaps: []diskutil.Snapshot{journalSnap2},
+		},
+		{
+			name:        "interrupted prune",
+			target:      renamedTarget,
+			targetSnaps: []diskutil.Snapshot{journalSnap2, journalSnap1},
+			entry: JournalEntry{
+				Step:     PhasePrune,
+				Source:   journalSource,
+				Target:   journalTarget,
+				From:     &journalSnap1,
+				To:       journalSnap2,
+				Snapshot: &journalSnap1,
+			},
+			wantTargetSnaps: []diskutil.Snapshot{journalSnap2},
+		},
+		{
+			name:   "restore without snapshot in common",
+			target: renamedTarget,
+			entry: JournalEntry{
+				Step:   PhaseRestore,
+				Source: journalSource,
+				Target: journalTarget,
+				From:   &journalSnap1,
+				To:     journalSnap2,
+			},
+			wantErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			devices := newFakeDevices(t,
+				withFakeVolume(journalSource, journalSnap2, journalSnap1),
+				withFakeVolume(test.target, test.targetSnaps...),
+			)
+			j := NewFileJournal(t.TempDir())
+			if err := j.Begin(test.entry); err != nil {
+				t.Fatal(err)
+			}
+			c := New(&fakeDiskUtil{devices}, &fakeASR{devices}, Journaling(j))
+			err := c.Recover(test.entry)
+			if gotErr := err != nil; gotErr != test.wantErr {
+				t.Fatalf("Recover(...) returned unexpected error: %v, want error: %t", err, test.wantErr)
+			}
+			pending, err := j.Pending()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.wantErr {
+				if len(pending) != 1 {
+					t.Errorf("Recover(...) failed but left %d pending entries, want: 1", len(pending))
+				}
+				return
+			}
+			if len(pending) != 0 {
+				t.Errorf("Recover(...) left pending entries: %v", pending)
+			}
+			gotTarget, err := devices.Volume(journalTarget.UUID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if gotTarget.Name != journalTarget.Name {
+				t.Errorf("target name = %q, want: %q", gotTarget.Name, journalTarget.Name)
+			}
+			gotTargetSnaps, err := devices.Snapshots(journalTarget.UUID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(test.wantTargetSnaps, gotTargetSnaps); diff != "" {
+				t.Errorf("Recover(...) resulted in unexpected snapshots in target. -want +got:\n%s", diff)
+			}
+		})
+	}
+}
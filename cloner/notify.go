@@ -0,0 +1,151 @@
+package cloner
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/diskutil"
+)
+
+// Notifier is told the outcome of each run of an Orchestrator, e.g. to alert
+// someone of failures of unattended runs. See the notify package for
+// implementations.
+type Notifier interface {
+	Notify(report RunReport) error
+}
+
+// ContextNotifier is a Notifier that stops early if ctx is done:
+// NotifyContext is like Notify, but returns an error once ctx is done.
+type ContextNotifier interface {
+	Notifier
+	NotifyContext(ctx context.Context, report RunReport) error
+}
+
+// Notify returns an OrchestratorOption that calls n with a RunReport at the
+// end of each run. Notifiers are called in the order they are added. If a
+// notifier fails, the error is written to the Orchestrator's stdout.
+func Notify(n Notifier) OrchestratorOption {
+	return func(o *Orchestrator) {
+		o.notifiers = append(o.notifiers, n)
+	}
+}
+
+// NotifyTimeout returns an OrchestratorOption that limits how long each
+// notifier that is a ContextNotifier may run. Notifiers are called even if
+// the run was interrupted, so the limit is independent of the run's context.
+// Defaults to 1 minute. If d is 0, notifiers may run indefinitely.
+func NotifyTimeout(d time.Duration) OrchestratorOption {
+	return func(o *Orchestrator) {
+		o.notifyTimeout = d
+	}
+}
+
+// RunReport summarizes the outcome of a run to multiple targets.
+type RunReport struct {
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+	// Outcome is OutcomeSuccess if every target succeeded, and
+	// OutcomeFailure otherwise.
+	Outcome string `json:"outcome"`
+	// Succeeded and Failed count targets. Skipped targets are failures.
+	Succeeded int            `json:"succeeded"`
+	Failed    int            `json:"failed"`
+	Targets   []TargetReport `json:"targets"`
+}
+
+// TargetReport is the outcome of a run to a single target.
+type TargetReport struct {
+	// Target is the target as given to Orchestrator.Run.
+	Target string `json:"target"`
+	// Name is the target's volume name, if known.
+	Name string `json:"name,omitempty"`
+	// Source is the source's volume name, if known.
+	Source string `json:"source,omitempty"`
+	// Outcome is one of OutcomeSuccess, OutcomeFailure, or OutcomeSkipped.
+	Outcome string `json:"outcome"`
+	Error   string `json:"error,omitempty"`
+
+	From *diskutil.Snapshot `json:"from,omitempty"`
+	To   *diskutil.Snapshot `json:"to,omitempty"`
+	// DurationSeconds is the total duration of all phases of the clone.
+	DurationSeconds float64             `json:"duration_seconds"`
+	Discarded       []diskutil.Snapshot `json:"discarded,omitempty"`
+	Pruned          []diskutil.Snapshot `json:"pruned,omitempty"`
+	Warnings        []string            `json:"warnings,omitempty"`
+}
+
+// NewRunReport returns a RunReport of summary, for a run between started and
+// finished.
+func NewRunReport(summary Summary, started, finished time.Time) RunReport {
+	report := RunReport{
+		Started:  started,
+		Finished: finished,
+		Outcome:  OutcomeSuccess,
+	}
+	for _, t := range summary.Targets {
+		r := t.Result
+		tr := TargetReport{
+			Target:          t.Target,
+			Name:            r.Target.Name,
+			Source:          r.Source.Name,
+			Outcome:         outcomeOf(t.Err),
+			DurationSeconds: r.Duration().Seconds(),
+			Discarded:       r.Discarded,
+			Pruned:          r.Pruned,
+			Warnings:        r.Warnings,
+		}
+		if t.Err != nil {
+			tr.Error = t.Err.Error()
+			report.Failed++
+			report.Outcome = OutcomeFailure
+		} else {
+			report.Succeeded++
+		}
+		if r.From.UUID != "" {
+			from := r.From
+			tr.From = &from
+		}
+		if r.To.UUID != "" {
+			to := r.To
+			tr.To = &to
+		}
+		report.Targets = append(report.Targets, tr)
+	}
+	return report
+}
+
+// String returns a short, human readable description of r, e.g. for the
+// subject of a notification.
+func (r RunReport) String() string {
+	total := r.Succeeded + r.Failed
+	if r.Failed == 0 {
+		return fmt.Sprintf("Cloned to %d/%d targets", r.Succeeded, total)
+	}
+	return fmt.Sprintf("Failed to clone to %d/%d targets", r.Failed, total)
+}
+
+// notify calls each of o's notifiers with a report of summary.
+func (o Orchestrator) notify(summary Summary, started time.Time) {
+	if len(o.notifiers) == 0 {
+		return
+	}
+	report := NewRunReport(summary, started, o.now())
+	for _, n := range o.notifiers {
+		if err := o.notifyOne(n, report); err != nil {
+			fmt.Fprintf(o.stdout, "Warning: error sending notification: %v\n", err)
+		}
+	}
+}
+
+// notifyOne calls n with report, limited by o's notify timeout if n is a
+// ContextNotifier.
+func (o Orchestrator) notifyOne(n Notifier, report RunReport) error {
+	cn, ok := n.(ContextNotifier)
+	if !ok {
+		return n.Notify(report)
+	}
+	return withTimeout(context.Background(), o.notifyTimeout, func(ctx context.Context) error {
+		return cn.NotifyContext(ctx, report)
+	})
+}
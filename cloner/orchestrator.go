@@ -0,0 +1,197 @@
+package cloner
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// OrchestratorOption configures Orchestrator.
+type OrchestratorOption func(*Orchestrator)
+
+// Concurrency returns an OrchestratorOption that sets the maximum number of
+// targets cloned at the same time. Values less than 1 are treated as 1.
+func Concurrency(n int) OrchestratorOption {
+	return func(o *Orchestrator) {
+		if n < 1 {
+			n = 1
+		}
+		o.concurrency = n
+	}
+}
+
+// OrchestratorStdout returns an OrchestratorOption that sets the stdout to
+// the given io.Writer.
+func OrchestratorStdout(w io.Writer) OrchestratorOption {
+	return func(o *Orchestrator) {
+		o.stdout = w
+	}
+}
+
+// NewOrchestrator returns a new Orchestrator with the given options.
+func NewOrchestrator(opts ...OrchestratorOption) Orchestrator {
+	o := Orchestrator{
+		stdout:        os.Stdout,
+		concurrency:   1,
+		notifyTimeout: time.Minute,
+		now:           time.Now,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// Orchestrator clones to multiple targets, up to a limited number of targets
+// at the same time.
+//
+// Output of each target is kept separate. If only one target is cloned at a
+// time, output is written to stdout as it is produced. Otherwise, each
+// target's output is buffered and written to stdout once the target's clone
+// completes.
+type Orchestrator struct {
+	stdout        io.Writer
+	concurrency   int
+	notifiers     []Notifier
+	notifyTimeout time.Duration
+	now           func() time.Time
+}
+
+// CloneFunc clones to a single target, writing all output to stdout.
+// Typically it constructs a Cloner (as well as its DiskUtil and ASR) that
+// write to stdout, and calls Cloner.Clone.
+type CloneFunc func(target string, stdout io.Writer) (CloneResult, error)
+
+// Summary is the outcome of cloning to multiple targets.
+type Summary struct {
+	// Targets are in the same order as the targets given to
+	// Orchestrator.Run.
+	Targets []TargetSummary
+}
+
+// TargetSummary is the outcome of cloning to a single target.
+type TargetSummary struct {
+	Target string
+	Result CloneResult
+	// Err is nil if the clone succeeded.
+	Err error
+}
+
+// Failed returns the summaries of the targets that failed to clone.
+func (s Summary) Failed() []TargetSummary {
+	var failed []TargetSummary
+	for _, t := range s.Targets {
+		if t.Err != nil {
+			failed = append(failed, t)
+		}
+	}
+	return failed
+}
+
+// Run calls clone for each target, and returns once all have completed and
+// notifiers (see Notify) have been called. Source is only used to describe
+// each clone in the output.
+func (o Orchestrator) Run(source string, targets []string, clone CloneFunc) Summary {
+	return o.RunContext(context.Background(), source, targets, clone)
+}
+
+// RunContext is like Run, but once ctx is done, targets that haven't started
+// cloning are not cloned, and fail with an error wrapping ctx.Err(). Clones
+// in progress are expected to be stopped by clone, e.g. by calling
+// Cloner.CloneContext with ctx.
+func (o Orchestrator) RunContext(ctx context.Context, source string, targets []string, clone CloneFunc) Summary {
+	started := o.now()
+	summary := o.run(ctx, source, targets, clone)
+	o.notify(summary, started)
+	return summary
+}
+
+// notStarted returns the summary of a target that wasn't cloned because ctx
+// is done.
+func notStarted(ctx context.Context, target string) TargetSummary {
+	return TargetSummary{
+		Target: target,
+		Err:    fmt.Errorf("not started: %w", ctx.Err()),
+	}
+}
+
+// Fail reports a run that failed with err before any of targets were cloned,
+// e.g. because they couldn't be planned, as if each target failed with err.
+// Notifiers are called as they are at the end of Run.
+func (o Orchestrator) Fail(targets []string, err error) Summary {
+	started := o.now()
+	summary := Summary{
+		Targets: make([]TargetSummary, len(targets)),
+	}
+	for i, target := range targets {
+		summary.Targets[i] = TargetSummary{
+			Target: target,
+			Err:    err,
+		}
+	}
+	o.notify(summary, started)
+	return summary
+}
+
+func (o Orchestrator) run(ctx context.Context, source string, targets []string, clone CloneFunc) Summary {
+	summary := Summary{
+		Targets: make([]TargetSummary, len(targets)),
+	}
+	if o.concurrency <= 1 {
+		for i, target := range targets {
+			if ctx.Err() != nil {
+				summary.Targets[i] = notStarted(ctx, target)
+				continue
+			}
+			fmt.Fprintf(o.stdout, "Cloning %q to %q...\n", source, target)
+			result, err := clone(target, o.stdout)
+			summary.Targets[i] = TargetSummary{
+				Target: target,
+				Result: result,
+				Err:    err,
+			}
+		}
+		return summary
+	}
+
+	var (
+		wg sync.WaitGroup
+		// Guards writes to o.stdout.
+		mu  sync.Mutex
+		sem = make(chan struct{}, o.concurrency)
+	)
+	for i, target := range targets {
+		wg.Add(1)
+		go func(i int, target string) {
+			defer wg.Done()
+			select {
+			case sem <- struct{}{}:
+				defer func() { <-sem }()
+			case <-ctx.Done():
+			}
+			if ctx.Err() != nil {
+				summary.Targets[i] = notStarted(ctx, target)
+				return
+			}
+
+			out := new(bytes.Buffer)
+			result, err := clone(target, out)
+			summary.Targets[i] = TargetSummary{
+				Target: target,
+				Result: result,
+				Err:    err,
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			fmt.Fprintf(o.stdout, "Cloning %q to %q...\n", source, target)
+			o.stdout.Write(out.Bytes())
+		}(i, target)
+	}
+	wg.Wait()
+	return summary
+}
@@ -0,0 +1,259 @@
+package cloner
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/diskutil"
+)
+
+func TestOrchestratorRun(t *testing.T) {
+	for _, concurrency := range []int{1, 2, 4} {
+		t.Run(fmt.Sprintf("concurrency %d", concurrency), func(t *testing.T) {
+			var (
+				mu          sync.Mutex
+				inFlight    int
+				maxInFlight int
+			)
+			clone := func(target string, stdout io.Writer) (CloneResult, error) {
+				mu.Lock()
+				inFlight++
+				if inFlight > maxInFlight {
+					maxInFlight = inFlight
+				}
+				mu.Unlock()
+				defer func() {
+					mu.Lock()
+					inFlight--
+					mu.Unlock()
+				}()
+
+				// Write output in multiple chunks, sleeping in
+				// between, so that interleaved output would be
+				// detected.
+				for i := 0; i < 3; i++ {
+					fmt.Fprintf(stdout, "%s line %d\n", target, i)
+					time.Sleep(time.Millisecond)
+				}
+				if strings.HasPrefix(target, "bad") {
+					return CloneResult{}, errors.New("fake clone failure")
+				}
+				return CloneResult{}, nil
+			}
+
+			stdout := new(bytes.Buffer)
+			o := NewOrchestrator(Concurrency(concurrency), OrchestratorStdout(stdout))
+			targets := []string{"good-1", "bad-1", "good-2", "good-3", "bad-2"}
+			summary := o.Run("source", targets, clone)
+
+			t.Run("concurrency limit respected", func(t *testing.T) {
+				if maxInFlight > concurrency {
+					t.Errorf("Run cloned %d targets at once, want at most %d", maxInFlight, concurrency)
+				}
+			})
+			t.Run("per-target summary", func(t *testing.T) {
+				var gotTargets, gotFailed []string
+				for _, ts := range summary.Targets {
+					gotTargets = append(gotTargets, ts.Target)
+				}
+				for _, ts := range summary.Failed() {
+					gotFailed = append(gotFailed, ts.Target)
+				}
+				if diff := cmp.Diff(targets, gotTargets); diff != "" {
+					t.Errorf("Run returned unexpected summary targets. -want +got:\n%s", diff)
+				}
+				if diff := cmp.Diff([]string{"bad-1", "bad-2"}, gotFailed); diff != "" {
+					t.Errorf("Run returned unexpected failed targets. -want +got:\n%s", diff)
+				}
+			})
+			t.Run("output not interleaved", func(t *testing.T) {
+				var got []string
+				for _, block := range strings.SplitAfter(stdout.String(), "line 2\n") {
+					if block != "" {
+						got = append(got, block)
+					}
+				}
+				var want []string
+				for _, target := range targets {
+					want = append(want, fmt.Sprintf("Cloning \"source\" to %q...\n%[1]s line 0\n%[1]s line 1\n%[1]s line 2\n", target))
+				}
+				sortOpt := cmpopts.SortSlices(func(lhs, rhs string) bool { return lhs < rhs })
+				if diff := cmp.Diff(want, got, sortOpt); diff != "" {
+					t.Errorf("Run wrote unexpected output. -want +got:\n%s", diff)
+				}
+			})
+		})
+	}
+}
+
+type fakeNotifier struct {
+	reports []RunReport
+	err     error
+}
+
+func (n *fakeNotifier) Notify(report RunReport) error {
+	n.reports = append(n.reports, report)
+	return n.err
+}
+
+func TestOrchestratorRun_Notify(t *testing.T) {
+	snap := diskutil.Snapshot{Name: "snap", UUID: "snap-uuid"}
+	clone := func(target string, stdout io.Writer) (CloneResult, error) {
+		result := CloneResult{
+			Target:  diskutil.VolumeInfo{Name: strings.ToUpper(target)},
+			To:      snap,
+			Timings: []PhaseTiming{{Phase: PhaseRestore, Duration: time.Minute}},
+		}
+		if target == "bad" {
+			return result, errors.New("fake clone failure")
+		}
+		return result, nil
+	}
+	start := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	now := start
+	tick := func() time.Time {
+		defer func() { now = now.Add(time.Minute) }()
+		return now
+	}
+
+	stdout := new(bytes.Buffer)
+	first := &fakeNotifier{err: errors.New("fake notifier failure")}
+	second := &fakeNotifier{}
+	o := NewOrchestrator(OrchestratorStdout(stdout), Notify(first), Notify(second))
+	o.now = tick
+	o.Run("source", []string{"good", "bad"}, clone)
+
+	want := RunReport{
+		Started:   start,
+		Finished:  start.Add(time.Minute),
+		Outcome:   OutcomeFailure,
+		Succeeded: 1,
+		Failed:    1,
+		Targets: []TargetReport{
+			{
+				Target:          "good",
+				Name:            "GOOD",
+				Outcome:         OutcomeSuccess,
+				To:              &snap,
+				DurationSeconds: 60,
+			},
+			{
+				Target:          "bad",
+				Name:            "BAD",
+				Outcome:         OutcomeFailure,
+				Error:           "fake clone failure",
+				To:              &snap,
+				DurationSeconds: 60,
+			},
+		},
+	}
+	for _, n := range []*fakeNotifier{first, second} {
+		if diff := cmp.Diff([]RunReport{want}, n.reports); diff != "" {
+			t.Errorf("Run notified unexpected reports. -want +got:\n%s", diff)
+		}
+	}
+	if !strings.Contains(stdout.String(), "Warning: error sending notification: fake notifier failure") {
+		t.Errorf("Run did not warn of notifier failure, got stdout:\n%s", stdout)
+	}
+}
+
+// hangingNotifier's NotifyContext doesn't return until ctx is done.
+type hangingNotifier struct {
+	Notifier
+}
+
+func (hangingNotifier) NotifyContext(ctx context.Context, report RunReport) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestOrchestratorRunContext_NotifyTimeout(t *testing.T) {
+	stdout := new(bytes.Buffer)
+	next := &fakeNotifier{}
+	o := NewOrchestrator(OrchestratorStdout(stdout), Notify(hangingNotifier{}), Notify(next), NotifyTimeout(10*time.Millisecond))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	o.RunContext(ctx, "source", []string{"target"}, func(target string, stdout io.Writer) (CloneResult, error) {
+		return CloneResult{}, nil
+	})
+	// The run was interrupted, so the notifiers must be called with a
+	// context of their own.
+	if !strings.Contains(stdout.String(), "Warning: error sending notification: timed out after 10ms") {
+		t.Errorf("RunContext did not warn of notifier timeout, got stdout:\n%s", stdout)
+	}
+	if len(next.reports) != 1 {
+		t.Errorf("RunContext notified %d reports after notifier timed out, want: 1", len(next.reports))
+	}
+}
+
+func TestOrchestratorFail(t *testing.T) {
+	start := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	n := &fakeNotifier{}
+	o := NewOrchestrator(OrchestratorStdout(io.Discard), Notify(n))
+	o.now = func() time.Time { return start }
+	err := errors.New("fake planning failure")
+	summary := o.Fail([]string{"target-1", "target-2"}, err)
+
+	if got := len(summary.Failed()); got != 2 {
+		t.Errorf("Fail(...) returned %d failed targets, want: 2", got)
+	}
+	want := RunReport{
+		Started:  start,
+		Finished: start,
+		Outcome:  OutcomeFailure,
+		Failed:   2,
+		Targets: []TargetReport{
+			{
+				Target:  "target-1",
+				Outcome: OutcomeFailure,
+				Error:   "fake planning failure",
+			},
+			{
+				Target:  "target-2",
+				Outcome: OutcomeFailure,
+				Error:   "fake planning failure",
+			},
+		},
+	}
+	if diff := cmp.Diff([]RunReport{want}, n.reports); diff != "" {
+		t.Errorf("Fail(...) notified unexpected reports. -want +got:\n%s", diff)
+	}
+}
+
+func TestOrchestratorRunContext_Canceled(t *testing.T) {
+	for _, concurrency := range []int{1, 2} {
+		t.Run(fmt.Sprintf("concurrency %d", concurrency), func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			var cloned []string
+			clone := func(target string, stdout io.Writer) (CloneResult, error) {
+				cloned = append(cloned, target)
+				// Interrupted while cloning to the first target.
+				cancel()
+				return CloneResult{}, ctx.Err()
+			}
+			o := NewOrchestrator(Concurrency(concurrency), OrchestratorStdout(io.Discard))
+			// With a concurrency of 2, the first two targets may
+			// start before the context is canceled.
+			summary := o.RunContext(ctx, "source", []string{"target-1", "target-2", "target-3"}, clone)
+			if len(cloned) < 1 || len(cloned) > concurrency {
+				t.Errorf("RunContext cloned %v, want between 1 and %d targets", cloned, concurrency)
+			}
+			for _, ts := range summary.Targets {
+				if !errors.Is(ts.Err, context.Canceled) {
+					t.Errorf("RunContext returned unexpected error for %q: %v, want: context.Canceled", ts.Target, ts.Err)
+				}
+			}
+		})
+	}
+}
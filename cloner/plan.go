@@ -0,0 +1,260 @@
+package cloner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/diskutil"
+)
+
+// RestoreType is the type of restore used to clone to a target.
+type RestoreType string
+
+const (
+	// RestoreIncremental restores target from a snapshot in common with
+	// source, keeping target's existing snapshots.
+	RestoreIncremental RestoreType = "incremental"
+	// RestoreDestructive erases target before restoring. See
+	// InitializeTargets.
+	RestoreDestructive RestoreType = "destructive"
+)
+
+// ClonePlan describes everything a clone of source to one or more targets
+// will do. Plans are created by Cloner.Plan and executed as-is by
+// Cloner.Execute. ClonePlan may be serialized to, and deserialized from, JSON.
+type ClonePlan struct {
+	Source diskutil.VolumeInfo `json:"source"`
+	// SourceSnapshots are source's snapshots at the time of planning, most
+	// recent first.
+	SourceSnapshots []diskutil.Snapshot `json:"source_snapshots"`
+	Targets         []TargetPlan        `json:"targets"`
+}
+
+// TargetPlan describes what a clone will do to a single target.
+type TargetPlan struct {
+	// Arg is the target as given to Cloner.Plan, e.g. a mount point.
+	Arg    string              `json:"arg"`
+	Target diskutil.VolumeInfo `json:"target"`
+	// TargetSnapshots are target's snapshots at the time of planning, most
+	// recent first.
+	TargetSnapshots []diskutil.Snapshot `json:"target_snapshots"`
+
+	Type RestoreType `json:"type"`
+	// From is the snapshot in common to restore from. Nil if Type is
+	// RestoreDestructive.
+	From *diskutil.Snapshot `json:"from,omitempty"`
+	// To is the source snapshot to restore target to.
+	To diskutil.Snapshot `json:"to"`
+	// Discard are target's snapshots, newer than From, that source doesn't
+	// have. They are deleted from target before the restore. See
+	// DiscardDiverged.
+	Discard []diskutil.Snapshot `json:"discard,omitempty"`
+	// Hops are the restores of a catch-up clone (see CatchUp), oldest
+	// first. Empty if target is restored to To in a single restore.
+	Hops []Hop `json:"hops,omitempty"`
+	// Prune are the snapshots to delete from target after a successful
+	// restore, either by the Prune option or by retention policies.
+	Prune []diskutil.Snapshot `json:"prune,omitempty"`
+}
+
+// Target returns the TargetPlan of the target with the given argument or
+// UUID, and false if there is no such target.
+func (p ClonePlan) Target(target string) (TargetPlan, bool) {
+	for _, tp := range p.Targets {
+		if tp.Arg == target || tp.Target.UUID == target {
+			return tp, true
+		}
+	}
+	return TargetPlan{}, false
+}
+
+// Snapshots returns target's snapshots after tp is executed successfully, most
+// recent first.
+func (tp TargetPlan) Snapshots() []diskutil.Snapshot {
+	var snaps []diskutil.Snapshot
+	if len(tp.Hops) > 0 {
+		for i := len(tp.Hops) - 1; i >= 0; i-- {
+			snaps = append(snaps, tp.Hops[i].To)
+		}
+	} else {
+		snaps = append(snaps, tp.To)
+	}
+	snaps = append(snaps, tp.TargetSnapshots...)
+	var kept []diskutil.Snapshot
+	for _, s := range snaps {
+		if !containsSnapshot(tp.Prune, s) && !containsSnapshot(tp.Discard, s) {
+			kept = append(kept, s)
+		}
+	}
+	return kept
+}
+
+// String returns a human readable description of the plan.
+func (p ClonePlan) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Source: %s (%s)\n", p.Source.Name, p.Source.UUID)
+	for _, tp := range p.Targets {
+		fmt.Fprintf(&b, "Target: %s (%s)\n", tp.Target.Name, tp.Target.UUID)
+		if len(tp.Discard) > 0 {
+			b.WriteString("\tDiscard snapshots that only target has:\n")
+			for _, s := range tp.Discard {
+				fmt.Fprintf(&b, "\t\t%s\n", s)
+			}
+		}
+		switch tp.Type {
+		case RestoreDestructive:
+			fmt.Fprintf(&b, "\tErase and restore to %s\n", tp.To)
+		default:
+			fmt.Fprintf(&b, "\tRestore to %s\n", tp.To)
+			if tp.From != nil {
+				fmt.Fprintf(&b, "\tfrom %s\n", *tp.From)
+			}
+		}
+		if len(tp.Hops) > 0 {
+			fmt.Fprintf(&b, "\tin %d hops:\n", len(tp.Hops))
+			for _, hop := range tp.Hops {
+				fmt.Fprintf(&b, "\t\t%s\n", hop)
+			}
+		}
+		if len(tp.Prune) > 0 {
+			b.WriteString("\tthen prune:\n")
+			for _, s := range tp.Prune {
+				fmt.Fprintf(&b, "\t\t%s\n", s)
+			}
+		}
+	}
+	return b.String()
+}
+
+// Plan validates that source is cloneable to all targets (see Cloneable), and
+// returns a ClonePlan describing what the clone will do to each target.
+func (c Cloner) Plan(source string, targets ...string) (ClonePlan, error) {
+	return c.PlanContext(context.Background(), source, targets...)
+}
+
+// PlanContext is like Plan, but stops if ctx is done first.
+func (c Cloner) PlanContext(ctx context.Context, source string, targets ...string) (ClonePlan, error) {
+	sourceInfo, err := c.diskutil.InfoContext(ctx, source)
+	if err != nil {
+		return ClonePlan{}, fmt.Errorf("invalid source volume: %w", err)
+	}
+	if sourceInfo.FileSystemType != "apfs" {
+		return ClonePlan{}, errors.New("invalid source volume: does not contain an APFS file system")
+	}
+	sourceSnaps, err := c.diskutil.ListSnapshotsContext(ctx, sourceInfo)
+	if err != nil {
+		return ClonePlan{}, fmt.Errorf("error listing snapshots of source: %v", err)
+	}
+	if len(sourceSnaps) == 0 {
+		return ClonePlan{}, errors.New("invalid source: no snapshots to clone")
+	}
+	toIndex, err := c.selectSnapshot(sourceSnaps)
+	if err != nil {
+		return ClonePlan{}, fmt.Errorf("invalid source: %v", err)
+	}
+
+	if len(targets) == 0 {
+		return ClonePlan{}, errors.New("no targets")
+	}
+	plan := ClonePlan{
+		Source:          sourceInfo,
+		SourceSnapshots: sourceSnaps,
+	}
+	// Map of target UUIDs to the target argument.
+	targetUUIDs := make(map[string]string)
+	for _, t := range targets {
+		targetInfo, err := c.diskutil.InfoContext(ctx, t)
+		if err != nil {
+			return ClonePlan{}, fmt.Errorf("invalid target volume: %w", err)
+		}
+		if sourceInfo.UUID == targetInfo.UUID {
+			return ClonePlan{}, errors.New("source and target must be different volumes")
+		}
+		if duplicate := targetUUIDs[targetInfo.UUID]; duplicate != "" {
+			return ClonePlan{}, fmt.Errorf("invalid target: %q is the same as %q", t, duplicate)
+		}
+		targetUUIDs[targetInfo.UUID] = t
+		if targetInfo.FileSystemType != "apfs" {
+			return ClonePlan{}, errors.New("invalid target volume: does not contain an APFS file system")
+		}
+		// `asr restore` will restore the target volume to the same file system
+		// as source. To be safe, error here to prevent changing the file
+		// system without the user knowing.
+		if sourceInfo.FileSystem != targetInfo.FileSystem {
+			return ClonePlan{}, fmt.Errorf("invalid source + target combination: %w: source is formatted as %s, but target is formatted as %s", ErrFileSystemMismatch, sourceInfo.FileSystem, targetInfo.FileSystem)
+		}
+		if !targetInfo.Writable {
+			return ClonePlan{}, fmt.Errorf("invalid target volume: %w", ErrNotWritable)
+		}
+
+		targetSnaps, err := c.diskutil.ListSnapshotsContext(ctx, targetInfo)
+		if err != nil {
+			return ClonePlan{}, fmt.Errorf("error listing snapshots of target: %v", err)
+		}
+		tp, err := c.planTarget(sourceSnaps, toIndex, targetSnaps)
+		if err != nil {
+			return ClonePlan{}, err
+		}
+		tp.Arg = t
+		tp.Target = targetInfo
+		tp.TargetSnapshots = targetSnaps
+		plan.Targets = append(plan.Targets, tp)
+	}
+	return plan, nil
+}
+
+func (c Cloner) planTarget(sourceSnaps []diskutil.Snapshot, toIndex int, targetSnaps []diskutil.Snapshot) (TargetPlan, error) {
+	toSnap := sourceSnaps[toIndex]
+	if c.initTargets {
+		if len(targetSnaps) > 0 {
+			return TargetPlan{}, errors.New("invalid target: target has snapshots - erase the disk before using initialize")
+		}
+		return TargetPlan{
+			Type:  RestoreDestructive,
+			To:    toSnap,
+			Prune: c.retentionPrune([]diskutil.Snapshot{toSnap}, toSnap),
+		}, nil
+	}
+
+	h := CompareHistories(sourceSnaps, toIndex, targetSnaps)
+	discard, err := c.discardable(h, sourceSnaps)
+	if err != nil {
+		return TargetPlan{}, err
+	}
+	if err := h.Err(); err != nil && len(discard) == 0 {
+		return TargetPlan{}, err
+	}
+	commonSnap := *h.Common
+	tp := TargetPlan{
+		Type:    RestoreIncremental,
+		From:    &commonSnap,
+		To:      toSnap,
+		Discard: discard,
+	}
+	if c.catchUp {
+		tp.Hops = catchUpHops(sourceSnaps, commonSnap, toIndex)
+	}
+
+	// Target's snapshots after the restore, most recent first.
+	var restored []diskutil.Snapshot
+	if len(tp.Hops) > 0 {
+		for i := len(tp.Hops) - 1; i >= 0; i-- {
+			restored = append(restored, tp.Hops[i].To)
+		}
+	} else {
+		restored = append(restored, toSnap)
+	}
+	for _, s := range targetSnaps {
+		if c.prune && s.UUID == commonSnap.UUID || containsSnapshot(discard, s) {
+			continue
+		}
+		restored = append(restored, s)
+	}
+	if c.prune {
+		tp.Prune = append(tp.Prune, commonSnap)
+	}
+	tp.Prune = append(tp.Prune, c.retentionPrune(restored, toSnap)...)
+	return tp, nil
+}
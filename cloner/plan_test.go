@@ -0,0 +1,346 @@
+package cloner
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/diskutil"
+)
+
+var (
+	planSource = diskutil.VolumeInfo{
+		Name:           "source-name",
+		UUID:           "source-uuid",
+		MountPoint:     "/source/mount/point",
+		Device:         "/dev/disk-source",
+		FileSystemType: "apfs",
+	}
+	planTarget1 = diskutil.VolumeInfo{
+		Name:           "target1-name",
+		UUID:           "target1-uuid",
+		MountPoint:     "/target1/mount/point",
+		Device:         "/dev/disk-target1",
+		Writable:       true,
+		FileSystemType: "apfs",
+	}
+	planTarget2 = diskutil.VolumeInfo{
+		Name:           "target2-name",
+		UUID:           "target2-uuid",
+		MountPoint:     "/target2/mount/point",
+		Device:         "/dev/disk-target2",
+		Writable:       true,
+		FileSystemType: "apfs",
+	}
+	planSnap1 = diskutil.Snapshot{Name: "snap-1", UUID: "snap-1-uuid"}
+	planSnap2 = diskutil.Snapshot{Name: "snap-2", UUID: "snap-2-uuid"}
+	planSnap3 = diskutil.Snapshot{Name: "snap-3", UUID: "snap-3-uuid"}
+)
+
+func TestPlan(t *testing.T) {
+	tests := []struct {
+		name        string
+		fakeDevices *fakeDevices
+		opts        []Option
+		targets     []string
+		want        ClonePlan
+	}{
+		{
+			name: "incremental",
+			fakeDevices: newFakeDevices(t,
+				withFakeVolume(planSource, planSnap3, planSnap2, planSnap1),
+				withFakeVolume(planTarget1, planSnap2, planSnap1),
+				withFakeVolume(planTarget2, planSnap1),
+			),
+			opts:    []Option{Prune(true)},
+			targets: []string{planTarget1.MountPoint, planTarget2.MountPoint},
+			want: ClonePlan{
+				Source:          planSource,
+				SourceSnapshots: []diskutil.Snapshot{planSnap3, planSnap2, planSnap1},
+				Targets: []TargetPlan{
+					{
+						Arg:             planTarget1.MountPoint,
+						Target:          planTarget1,
+						TargetSnapshots: []diskutil.Snapshot{planSnap2, planSnap1},
+						Type:            RestoreIncremental,
+						From:            &planSnap2,
+						To:              planSnap3,
+						Prune:           []diskutil.Snapshot{planSnap2},
+					},
+					{
+						Arg:             planTarget2.MountPoint,
+						Target:          planTarget2,
+						TargetSnapshots: []diskutil.Snapshot{planSnap1},
+						Type:            RestoreIncremental,
+						From:            &planSnap1,
+						To:              planSnap3,
+						Prune:           []diskutil.Snapshot{planSnap1},
+					},
+				},
+			},
+		},
+		{
+			name: "catch up",
+			fakeDevices: newFakeDevices(t,
+				withFakeVolume(planSource, planSnap3, planSnap2, planSnap1),
+				withFakeVolume(planTarget1, planSnap1),
+			),
+			opts:    []Option{CatchUp(true), Retention(KeepLast(2))},
+			targets: []string{planTarget1.MountPoint},
+			want: ClonePlan{
+				Source:          planSource,
+				SourceSnapshots: []diskutil.Snapshot{planSnap3, planSnap2, planSnap1},
+				Targets: []TargetPlan{
+					{
+						Arg:             planTarget1.MountPoint,
+						Target:          planTarget1,
+						TargetSnapshots: []diskutil.Snapshot{planSnap1},
+						Type:            RestoreIncremental,
+						From:            &planSnap1,
+						To:              planSnap3,
+						Hops: []Hop{
+							{From: planSnap1, To: planSnap2},
+							{From: planSnap2, To: planSnap3},
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "destructive",
+			fakeDevices: newFakeDevices(t,
+				withFakeVolume(planSource, planSnap3, planSnap2, planSnap1),
+				withFakeVolume(planTarget1),
+			),
+			opts:    []Option{InitializeTargets(true), ToSnapshot(SnapshotByName("snap-2"))},
+			targets: []string{planTarget1.MountPoint},
+			want: ClonePlan{
+				Source:          planSource,
+				SourceSnapshots: []diskutil.Snapshot{planSnap3, planSnap2, planSnap1},
+				Targets: []TargetPlan{
+					{
+						Arg:    planTarget1.MountPoint,
+						Target: planTarget1,
+						Type:   RestoreDestructive,
+						To:     planSnap2,
+					},
+				},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := New(&fakeDiskUtil{test.fakeDevices}, &fakeASR{test.fakeDevices}, test.opts...)
+			got, err := c.Plan(planSource.MountPoint, test.targets...)
+			if err != nil {
+				t.Fatalf("Plan(...) returned unexpected error: %q, want: nil", err)
+			}
+			if diff := cmp.Diff(test.want, got, cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("Plan(...) returned unexpected plan. -want +got:\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestClonePlan_JSON(t *testing.T) {
+	devices := newFakeDevices(t,
+		withFakeVolume(planSource, planSnap3, planSnap2, planSnap1),
+		withFakeVolume(planTarget1, planSnap1),
+	)
+	c := New(&fakeDiskUtil{devices}, &fakeASR{devices}, CatchUp(true), Prune(true))
+	plan, err := c.Plan(planSource.MountPoint, planTarget1.MountPoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := json.Marshal(plan)
+	if err != nil {
+		t.Fatalf("json.Marshal(plan) returned unexpected error: %v", err)
+	}
+	var got ClonePlan
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatalf("json.Unmarshal(...) returned unexpected error: %v", err)
+	}
+	if diff := cmp.Diff(plan, got); diff != "" {
+		t.Errorf("ClonePlan did not round trip through JSON. -want +got:\n%s", diff)
+	}
+
+	// Executing the deserialized plan clones as planned.
+	if _, err := c.Execute(got); err != nil {
+		t.Fatalf("Execute(...) returned unexpected error: %q, want: nil", err)
+	}
+	gotTargetSnaps, err := devices.Snapshots(planTarget1.UUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantTargetSnaps := []diskutil.Snapshot{planSnap3, planSnap2}
+	if diff := cmp.Diff(wantTargetSnaps, gotTargetSnaps); diff != "" {
+		t.Errorf("Execute(...) resulted in unexpected snapshots in target. -want +got:\n%s", diff)
+	}
+}
+
+func TestExecute_StalePlan(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(*testing.T, *fakeDevices)
+		wantErr string
+	}{
+		{
+			name: "target snapshot added",
+			modify: func(t *testing.T, d *fakeDevices) {
+				if err := d.AddSnapshot(planTarget1.UUID, planSnap2); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantErr: "target snapshots have changed",
+		},
+		{
+			name: "target snapshot deleted",
+			modify: func(t *testing.T, d *fakeDevices) {
+				if err := d.DeleteSnapshot(planTarget1.UUID, planSnap1.UUID); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantErr: "target snapshots have changed",
+		},
+		{
+			name: "target volume changed",
+			modify: func(t *testing.T, d *fakeDevices) {
+				snaps, err := d.Snapshots(planTarget1.UUID)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := d.RemoveVolume(planTarget1.UUID); err != nil {
+					t.Fatal(err)
+				}
+				target := planTarget1
+				target.Writable = false
+				if err := d.AddVolume(target, snaps...); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantErr: "target volume has changed",
+		},
+		{
+			name: "planned source snapshot deleted",
+			modify: func(t *testing.T, d *fakeDevices) {
+				if err := d.DeleteSnapshot(planSource.UUID, planSnap3.UUID); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantErr: "no longer exists in source",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			devices := newFakeDevices(t,
+				withFakeVolume(planSource, planSnap3, planSnap2, planSnap1),
+				withFakeVolume(planTarget1, planSnap1),
+			)
+			c := New(&fakeDiskUtil{devices}, &fakeASR{devices})
+			plan, err := c.Plan(planSource.MountPoint, planTarget1.MountPoint)
+			if err != nil {
+				t.Fatal(err)
+			}
+			test.modify(t, devices)
+			targetSnaps, err := devices.Snapshots(planTarget1.UUID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			wantTargetSnaps := append([]diskutil.Snapshot(nil), targetSnaps...)
+
+			_, err = c.Execute(plan)
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Fatalf("Execute(...) returned unexpected error: %v, want error containing: %q", err, test.wantErr)
+			}
+			gotTargetSnaps, err := devices.Snapshots(planTarget1.UUID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(wantTargetSnaps, gotTargetSnaps, cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("Execute(...) modified target. -want +got:\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestExecute_NewSourceSnapshot(t *testing.T) {
+	devices := newFakeDevices(t,
+		withFakeVolume(planSource, planSnap2, planSnap1),
+		withFakeVolume(planTarget1, planSnap1),
+	)
+	c := New(&fakeDiskUtil{devices}, &fakeASR{devices})
+	plan, err := c.Plan(planSource.MountPoint, planTarget1.MountPoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// e.g. Time Machine created a snapshot after planning.
+	if err := devices.AddSnapshot(planSource.UUID, planSnap3); err != nil {
+		t.Fatal(err)
+	}
+	results, err := c.Execute(plan)
+	if err != nil {
+		t.Fatalf("Execute(...) returned unexpected error: %q, want: nil", err)
+	}
+	if got := results[0].To; got != planSnap2 {
+		t.Errorf("Execute(...) restored to %s, want: %s", got, planSnap2)
+	}
+}
+
+func TestTargetPlan_Snapshots(t *testing.T) {
+	tests := []struct {
+		name string
+		tp   TargetPlan
+		want []diskutil.Snapshot
+	}{
+		{
+			name: "incremental",
+			tp: TargetPlan{
+				TargetSnapshots: []diskutil.Snapshot{planSnap2, planSnap1},
+				From:            &planSnap2,
+				To:              planSnap3,
+			},
+			want: []diskutil.Snapshot{planSnap3, planSnap2, planSnap1},
+		},
+		{
+			name: "prune",
+			tp: TargetPlan{
+				TargetSnapshots: []diskutil.Snapshot{planSnap2, planSnap1},
+				From:            &planSnap2,
+				To:              planSnap3,
+				Prune:           []diskutil.Snapshot{planSnap2},
+			},
+			want: []diskutil.Snapshot{planSnap3, planSnap1},
+		},
+		{
+			name: "catch up",
+			tp: TargetPlan{
+				TargetSnapshots: []diskutil.Snapshot{planSnap1},
+				From:            &planSnap1,
+				To:              planSnap3,
+				Hops: []Hop{
+					{From: planSnap1, To: planSnap2},
+					{From: planSnap2, To: planSnap3},
+				},
+			},
+			want: []diskutil.Snapshot{planSnap3, planSnap2, planSnap1},
+		},
+		{
+			name: "destructive",
+			tp: TargetPlan{
+				Type: RestoreDestructive,
+				To:   planSnap3,
+			},
+			want: []diskutil.Snapshot{planSnap3},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if diff := cmp.Diff(test.want, test.tp.Snapshots()); diff != "" {
+				t.Errorf("Snapshots() returned unexpected snapshots. -want +got:\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,116 @@
+package cloner
+
+import (
+	"time"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/diskutil"
+)
+
+// Phase is a step of a clone to a single target.
+type Phase string
+
+// Phases of a clone, in the order they are run.
+const (
+	// PhaseValidate reads volume info and lists snapshots of source and
+	// target.
+	PhaseValidate Phase = "validate"
+	// PhaseDiscard deletes snapshots that only target has. See
+	// DiscardDiverged.
+	PhaseDiscard Phase = "discard"
+	// PhaseRestore restores target using asr.
+	PhaseRestore Phase = "restore"
+	// PhasePrune deletes snapshots from target.
+	PhasePrune Phase = "prune"
+	// PhaseRename renames target back to its original name.
+	PhaseRename Phase = "rename"
+	// PhaseVerify checks target after the clone. See Verify.
+	PhaseVerify Phase = "verify"
+)
+
+// PhaseTiming is the time taken by a single phase of a clone.
+type PhaseTiming struct {
+	Phase    Phase
+	Duration time.Duration
+}
+
+// HopResult is the outcome of a single hop of a catch-up clone.
+type HopResult struct {
+	Hop
+	Duration time.Duration
+	// Err is nil if the hop was restored successfully.
+	Err error
+}
+
+// CloneResult describes what Clone did to a single target. If Clone returns
+// an error, CloneResult describes the steps completed before the error.
+type CloneResult struct {
+	Source diskutil.VolumeInfo
+	// Target is target's volume info before the clone.
+	Target diskutil.VolumeInfo
+
+	// From is the snapshot in common between source and target that target
+	// was restored from. Zero if Initialized.
+	From diskutil.Snapshot
+	// To is the source snapshot that target was restored to.
+	To diskutil.Snapshot
+	// Initialized is true if target was destructively restored (see
+	// InitializeTargets).
+	Initialized bool
+	// Skipped is true if target was not restored because a pre-restore
+	// hook failed (see HookPreRestore).
+	Skipped bool
+	// Hops are the restores of a catch-up clone (see CatchUp), up to and
+	// including the first failed hop.
+	Hops []HopResult
+
+	// Discarded are the snapshots deleted from target before restoring it.
+	// See DiscardDiverged.
+	Discarded []diskutil.Snapshot
+	// Pruned are the snapshots deleted from target.
+	Pruned []diskutil.Snapshot
+	// Renamed is true if target was renamed back to its original name.
+	Renamed bool
+	// Verified is true if target passed verification. See Verify.
+	Verified bool
+
+	// Warnings describe non-fatal issues, e.g. failing to rename target.
+	Warnings []string
+	// Timings are the durations of each phase, in the order they were run.
+	Timings []PhaseTiming
+}
+
+// Duration returns the total duration of all phases.
+func (r CloneResult) Duration() time.Duration {
+	var total time.Duration
+	for _, t := range r.Timings {
+		total += t.Duration
+	}
+	return total
+}
+
+// PhaseDuration returns the duration of the given phase, and false if the
+// phase was not run.
+func (r CloneResult) PhaseDuration(phase Phase) (time.Duration, bool) {
+	for _, t := range r.Timings {
+		if t.Phase == phase {
+			return t.Duration, true
+		}
+	}
+	return 0, false
+}
+
+// timePhase records the duration of phase, which started at start. Phases
+// that are run in multiple parts have the durations of each part summed.
+func (r *CloneResult) timePhase(phase Phase, start time.Time) {
+	d := time.Since(start)
+	for i := range r.Timings {
+		if r.Timings[i].Phase == phase {
+			r.Timings[i].Duration += d
+			return
+		}
+	}
+	r.Timings = append(r.Timings, PhaseTiming{
+		Phase:    phase,
+		Duration: d,
+	})
+}
@@ -0,0 +1,185 @@
+package cloner
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/diskutil"
+)
+
+func TestClone_Result(t *testing.T) {
+	source := diskutil.VolumeInfo{
+		Name:           "source-name",
+		UUID:           "source-uuid",
+		MountPoint:     "/source/mount/point",
+		Writable:       true,
+		FileSystemType: "apfs",
+	}
+	target := diskutil.VolumeInfo{
+		Name:           "target-name",
+		UUID:           "target-uuid",
+		MountPoint:     "/target/mount/point",
+		Writable:       true,
+		FileSystemType: "apfs",
+	}
+	commonSnap := diskutil.Snapshot{Name: "common-snap", UUID: "common-snap-uuid"}
+	latestSnap := diskutil.Snapshot{Name: "latest-snap", UUID: "latest-snap-uuid"}
+
+	tests := []struct {
+		name        string
+		fakeDevices *fakeDevices
+		opts        []Option
+		want        CloneResult
+		wantPhases  []Phase
+	}{
+		{
+			name: "incremental clone",
+			fakeDevices: newFakeDevices(t,
+				withFakeVolume(source, latestSnap, commonSnap),
+				withFakeVolume(target, commonSnap),
+			),
+			want: CloneResult{
+				Source:  source,
+				Target:  target,
+				From:    commonSnap,
+				To:      latestSnap,
+				Renamed: true,
+			},
+			wantPhases: []Phase{PhaseValidate, PhaseRestore, PhaseRename},
+		},
+		{
+			name: "incremental clone - prune",
+			fakeDevices: newFakeDevices(t,
+				withFakeVolume(source, latestSnap, commonSnap),
+				withFakeVolume(target, commonSnap),
+			),
+			opts: []Option{Prune(true)},
+			want: CloneResult{
+				Source:  source,
+				Target:  target,
+				From:    commonSnap,
+				To:      latestSnap,
+				Pruned:  []diskutil.Snapshot{commonSnap},
+				Renamed: true,
+			},
+			wantPhases: []Phase{PhaseValidate, PhaseRestore, PhasePrune, PhaseRename},
+		},
+		{
+			name: "initialize clone",
+			fakeDevices: newFakeDevices(t,
+				withFakeVolume(source, latestSnap, commonSnap),
+				withFakeVolume(target),
+			),
+			opts: []Option{InitializeTargets(true)},
+			want: CloneResult{
+				Source:      source,
+				Target:      target,
+				To:          latestSnap,
+				Initialized: true,
+				Renamed:     true,
+			},
+			wantPhases: []Phase{PhaseValidate, PhaseRestore, PhaseRename},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			du := &fakeDiskUtil{test.fakeDevices}
+			r := &fakeASR{test.fakeDevices}
+			c := New(du, r, test.opts...)
+			got, err := c.Clone(source.MountPoint, target.MountPoint)
+			if err != nil {
+				t.Fatalf("Clone(...) returned unexpected error: %q, want: nil", err)
+			}
+			if diff := cmp.Diff(test.want, got, cmpopts.IgnoreFields(CloneResult{}, "Timings")); diff != "" {
+				t.Errorf("Clone(...) returned unexpected CloneResult. -want +got:\n%s", diff)
+			}
+			var gotPhases []Phase
+			for _, timing := range got.Timings {
+				gotPhases = append(gotPhases, timing.Phase)
+			}
+			if diff := cmp.Diff(test.wantPhases, gotPhases); diff != "" {
+				t.Errorf("Clone(...) returned unexpected CloneResult.Timings phases. -want +got:\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestClone_RenameFailureIsWarning(t *testing.T) {
+	source := diskutil.VolumeInfo{
+		Name:           "source-name",
+		UUID:           "source-uuid",
+		MountPoint:     "/source/mount/point",
+		Writable:       true,
+		FileSystemType: "apfs",
+	}
+	target := diskutil.VolumeInfo{
+		Name:           "target-name",
+		UUID:           "target-uuid",
+		MountPoint:     "/target/mount/point",
+		Writable:       true,
+		FileSystemType: "apfs",
+	}
+	commonSnap := diskutil.Snapshot{Name: "common-snap", UUID: "common-snap-uuid"}
+	latestSnap := diskutil.Snapshot{Name: "latest-snap", UUID: "latest-snap-uuid"}
+	devices := newFakeDevices(t,
+		withFakeVolume(source, latestSnap, commonSnap),
+		withFakeVolume(target, commonSnap),
+	)
+
+	du := &renameFailingFakeDiskUtil{fakeDiskUtil{devices}}
+	r := &fakeASR{devices}
+	c := New(du, r)
+	got, err := c.Clone(source.MountPoint, target.MountPoint)
+	if err != nil {
+		t.Fatalf("Clone(...) returned unexpected error: %q, want: nil", err)
+	}
+	if got.Renamed {
+		t.Error("CloneResult.Renamed = true, want: false")
+	}
+	if len(got.Warnings) != 1 {
+		t.Errorf("CloneResult.Warnings = %q, want 1 warning", got.Warnings)
+	}
+}
+
+func TestClone_PruneFailureStillRenames(t *testing.T) {
+	source := diskutil.VolumeInfo{
+		Name:           "source-name",
+		UUID:           "source-uuid",
+		MountPoint:     "/source/mount/point",
+		Writable:       true,
+		FileSystemType: "apfs",
+	}
+	target := diskutil.VolumeInfo{
+		Name:           "target-name",
+		UUID:           "target-uuid",
+		MountPoint:     "/target/mount/point",
+		Writable:       true,
+		FileSystemType: "apfs",
+	}
+	commonSnap := diskutil.Snapshot{Name: "common-snap", UUID: "common-snap-uuid"}
+	latestSnap := diskutil.Snapshot{Name: "latest-snap", UUID: "latest-snap-uuid"}
+	devices := newFakeDevices(t,
+		withFakeVolume(source, latestSnap, commonSnap),
+		withFakeVolume(target, commonSnap),
+	)
+
+	du := &deleteFailingFakeDiskUtil{fakeDiskUtil{devices}}
+	r := &fakeASR{devices}
+	c := New(du, r, Prune(true))
+	got, err := c.Clone(source.MountPoint, target.MountPoint)
+	if err == nil {
+		t.Fatal("Clone(...) returned nil error, want: prune error")
+	}
+	if !got.Renamed {
+		t.Error("CloneResult.Renamed = false, want: true")
+	}
+	gotTarget, err := devices.Volume(target.UUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotTarget.Name != target.Name {
+		t.Errorf("target name = %q, want: %q", gotTarget.Name, target.Name)
+	}
+}
@@ -0,0 +1,167 @@
+package cloner
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/diskutil"
+)
+
+// RetentionPolicy selects which of a target's snapshots to keep. When
+// multiple policies are applied, a snapshot is kept if any policy keeps it.
+type RetentionPolicy interface {
+	// Keep returns the UUIDs of the snapshots in snaps to keep. snaps are
+	// ordered most recent first, and all have a known creation time.
+	Keep(snaps []diskutil.Snapshot, now time.Time) map[string]bool
+	String() string
+}
+
+// KeepLast returns a RetentionPolicy that keeps the n most recent snapshots.
+func KeepLast(n int) RetentionPolicy {
+	return keepLast(n)
+}
+
+type keepLast int
+
+func (n keepLast) Keep(snaps []diskutil.Snapshot, now time.Time) map[string]bool {
+	keep := make(map[string]bool)
+	for i := 0; i < int(n) && i < len(snaps); i++ {
+		keep[snaps[i].UUID] = true
+	}
+	return keep
+}
+
+func (n keepLast) String() string {
+	return fmt.Sprintf("keep last %d", int(n))
+}
+
+// KeepDaily returns a RetentionPolicy that keeps the most recent snapshot of
+// each of the n most recent days that have snapshots.
+func KeepDaily(n int) RetentionPolicy {
+	return keepBuckets{
+		name: "daily",
+		n:    n,
+		bucket: func(t time.Time) string {
+			return t.Format("2006-01-02")
+		},
+	}
+}
+
+// KeepWeekly returns a RetentionPolicy that keeps the most recent snapshot of
+// each of the n most recent ISO 8601 weeks that have snapshots.
+func KeepWeekly(n int) RetentionPolicy {
+	return keepBuckets{
+		name: "weekly",
+		n:    n,
+		bucket: func(t time.Time) string {
+			year, week := t.ISOWeek()
+			return fmt.Sprintf("%d-W%02d", year, week)
+		},
+	}
+}
+
+// KeepMonthly returns a RetentionPolicy that keeps the most recent snapshot of
+// each of the n most recent months that have snapshots.
+func KeepMonthly(n int) RetentionPolicy {
+	return keepBuckets{
+		name: "monthly",
+		n:    n,
+		bucket: func(t time.Time) string {
+			return t.Format("2006-01")
+		},
+	}
+}
+
+// keepBuckets implements grandfather-father-son retention for a single
+// bucket size.
+type keepBuckets struct {
+	name   string
+	n      int
+	bucket func(time.Time) string
+}
+
+func (b keepBuckets) Keep(snaps []diskutil.Snapshot, now time.Time) map[string]bool {
+	keep := make(map[string]bool)
+	seen := make(map[string]bool)
+	for _, s := range snaps {
+		if len(seen) >= b.n {
+			break
+		}
+		bucket := b.bucket(s.Created)
+		if seen[bucket] {
+			continue
+		}
+		seen[bucket] = true
+		keep[s.UUID] = true
+	}
+	return keep
+}
+
+func (b keepBuckets) String() string {
+	return fmt.Sprintf("keep %d %s", b.n, b.name)
+}
+
+// MaxAge returns a RetentionPolicy that keeps snapshots created no more than
+// d before now.
+func MaxAge(d time.Duration) RetentionPolicy {
+	return maxAge(d)
+}
+
+type maxAge time.Duration
+
+func (d maxAge) Keep(snaps []diskutil.Snapshot, now time.Time) map[string]bool {
+	keep := make(map[string]bool)
+	for _, s := range snaps {
+		if now.Sub(s.Created) <= time.Duration(d) {
+			keep[s.UUID] = true
+		}
+	}
+	return keep
+}
+
+func (d maxAge) String() string {
+	return fmt.Sprintf("keep snapshots newer than %s", time.Duration(d))
+}
+
+// PlanRetention applies policies to snaps, which must be ordered most recent
+// first, and returns the snapshots to keep and to prune. Protected snapshots
+// and snapshots without a known creation time are always kept. If there are
+// no policies, all snapshots are kept.
+func PlanRetention(snaps []diskutil.Snapshot, protected []diskutil.Snapshot, now time.Time, policies ...RetentionPolicy) (keep, prune []diskutil.Snapshot) {
+	if len(policies) == 0 {
+		return snaps, nil
+	}
+	keepUUIDs := make(map[string]bool)
+	for _, s := range protected {
+		keepUUIDs[s.UUID] = true
+	}
+	var dated []diskutil.Snapshot
+	for _, s := range snaps {
+		if s.Created.IsZero() {
+			keepUUIDs[s.UUID] = true
+			continue
+		}
+		dated = append(dated, s)
+	}
+	for _, p := range policies {
+		for uuid := range p.Keep(dated, now) {
+			keepUUIDs[uuid] = true
+		}
+	}
+	for _, s := range snaps {
+		if keepUUIDs[s.UUID] {
+			keep = append(keep, s)
+		} else {
+			prune = append(prune, s)
+		}
+	}
+	return keep, prune
+}
+
+// retentionPrune returns the snapshots of restored, target's snapshots as
+// they will be after a clone to to, that are not kept by c's retention
+// policies. to is the base of the next incremental clone, so is never pruned.
+func (c Cloner) retentionPrune(restored []diskutil.Snapshot, to diskutil.Snapshot) []diskutil.Snapshot {
+	_, prune := PlanRetention(restored, []diskutil.Snapshot{to}, c.now(), c.retention...)
+	return prune
+}
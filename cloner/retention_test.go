@@ -0,0 +1,279 @@
+package cloner
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/asr"
+	"github.com/voidingwarranties/offsite-apfs-backup/diskutil"
+)
+
+func snapAt(name string, created time.Time) diskutil.Snapshot {
+	return diskutil.Snapshot{
+		Name:    name,
+		UUID:    name + "-uuid",
+		Created: created,
+	}
+}
+
+func snapNames(snaps []diskutil.Snapshot) []string {
+	var names []string
+	for _, s := range snaps {
+		names = append(names, s.Name)
+	}
+	return names
+}
+
+func TestPlanRetention(t *testing.T) {
+	now := time.Date(2021, 3, 31, 12, 0, 0, 0, time.UTC)
+	// Most recent first.
+	snaps := []diskutil.Snapshot{
+		snapAt("mar-31-b", time.Date(2021, 3, 31, 6, 0, 0, 0, time.UTC)),
+		snapAt("mar-31-a", time.Date(2021, 3, 31, 1, 0, 0, 0, time.UTC)),
+		snapAt("mar-30", time.Date(2021, 3, 30, 1, 0, 0, 0, time.UTC)),
+		snapAt("mar-22", time.Date(2021, 3, 22, 1, 0, 0, 0, time.UTC)),
+		snapAt("mar-15", time.Date(2021, 3, 15, 1, 0, 0, 0, time.UTC)),
+		snapAt("feb-10", time.Date(2021, 2, 10, 1, 0, 0, 0, time.UTC)),
+		snapAt("jan-05", time.Date(2021, 1, 5, 1, 0, 0, 0, time.UTC)),
+	}
+
+	tests := []struct {
+		name      string
+		snaps     []diskutil.Snapshot
+		protected []diskutil.Snapshot
+		policies  []RetentionPolicy
+		wantKeep  []string
+		wantPrune []string
+	}{
+		{
+			name:     "no policies keeps all",
+			snaps:    snaps,
+			wantKeep: []string{"mar-31-b", "mar-31-a", "mar-30", "mar-22", "mar-15", "feb-10", "jan-05"},
+		},
+		{
+			name:      "keep last",
+			snaps:     snaps,
+			policies:  []RetentionPolicy{KeepLast(2)},
+			wantKeep:  []string{"mar-31-b", "mar-31-a"},
+			wantPrune: []string{"mar-30", "mar-22", "mar-15", "feb-10", "jan-05"},
+		},
+		{
+			name:      "keep daily",
+			snaps:     snaps,
+			policies:  []RetentionPolicy{KeepDaily(3)},
+			wantKeep:  []string{"mar-31-b", "mar-30", "mar-22"},
+			wantPrune: []string{"mar-31-a", "mar-15", "feb-10", "jan-05"},
+		},
+		{
+			name:     "keep weekly",
+			snaps:    snaps,
+			policies: []RetentionPolicy{KeepWeekly(3)},
+			// Mar 29-31 are in the same ISO week.
+			wantKeep:  []string{"mar-31-b", "mar-22", "mar-15"},
+			wantPrune: []string{"mar-31-a", "mar-30", "feb-10", "jan-05"},
+		},
+		{
+			name:      "keep monthly",
+			snaps:     snaps,
+			policies:  []RetentionPolicy{KeepMonthly(2)},
+			wantKeep:  []string{"mar-31-b", "feb-10"},
+			wantPrune: []string{"mar-31-a", "mar-30", "mar-22", "mar-15", "jan-05"},
+		},
+		{
+			name:      "max age",
+			snaps:     snaps,
+			policies:  []RetentionPolicy{MaxAge(10 * 24 * time.Hour)},
+			wantKeep:  []string{"mar-31-b", "mar-31-a", "mar-30", "mar-22"},
+			wantPrune: []string{"mar-15", "feb-10", "jan-05"},
+		},
+		{
+			name:      "grandfather-father-son keeps union of policies",
+			snaps:     snaps,
+			policies:  []RetentionPolicy{KeepDaily(2), KeepWeekly(2), KeepMonthly(3)},
+			wantKeep:  []string{"mar-31-b", "mar-30", "mar-22", "feb-10", "jan-05"},
+			wantPrune: []string{"mar-31-a", "mar-15"},
+		},
+		{
+			name:      "protected snapshots kept",
+			snaps:     snaps,
+			protected: []diskutil.Snapshot{snaps[4]},
+			policies:  []RetentionPolicy{KeepLast(1)},
+			wantKeep:  []string{"mar-31-b", "mar-15"},
+			wantPrune: []string{"mar-31-a", "mar-30", "mar-22", "feb-10", "jan-05"},
+		},
+		{
+			name: "snapshots without creation time kept",
+			snaps: []diskutil.Snapshot{
+				snaps[0],
+				snapAt("manual", time.Time{}),
+				snaps[1],
+			},
+			policies:  []RetentionPolicy{KeepLast(1)},
+			wantKeep:  []string{"mar-31-b", "manual"},
+			wantPrune: []string{"mar-31-a"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			keep, prune := PlanRetention(test.snaps, test.protected, now, test.policies...)
+			cmpOpts := []cmp.Option{cmpopts.EquateEmpty()}
+			if diff := cmp.Diff(test.wantKeep, snapNames(keep), cmpOpts...); diff != "" {
+				t.Errorf("PlanRetention returned unexpected snapshots to keep. -want +got:\n%s", diff)
+			}
+			if diff := cmp.Diff(test.wantPrune, snapNames(prune), cmpOpts...); diff != "" {
+				t.Errorf("PlanRetention returned unexpected snapshots to prune. -want +got:\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestClone_Retention(t *testing.T) {
+	now := time.Date(2021, 3, 31, 12, 0, 0, 0, time.UTC)
+	source := diskutil.VolumeInfo{
+		Name:           "source-name",
+		UUID:           "source-uuid",
+		MountPoint:     "/source/mount/point",
+		Writable:       true,
+		FileSystemType: "apfs",
+	}
+	target := diskutil.VolumeInfo{
+		Name:           "target-name",
+		UUID:           "target-uuid",
+		MountPoint:     "/target/mount/point",
+		Writable:       true,
+		FileSystemType: "apfs",
+	}
+	// Created in the past, so that a max age of 0 would delete the
+	// latest snapshot if it weren't protected.
+	latest := snapAt("latest", now.Add(-time.Hour))
+	common := snapAt("common", now.Add(-48*time.Hour))
+	old1 := snapAt("old-1", now.Add(-72*time.Hour))
+	old2 := snapAt("old-2", now.Add(-96*time.Hour))
+
+	tests := []struct {
+		name            string
+		initialize      bool
+		sourceSnaps     []diskutil.Snapshot
+		targetSnaps     []diskutil.Snapshot
+		policies        []RetentionPolicy
+		wantPruned      []diskutil.Snapshot
+		wantTargetSnaps []diskutil.Snapshot
+	}{
+		{
+			name:            "keep last",
+			sourceSnaps:     []diskutil.Snapshot{latest, common, old1, old2},
+			targetSnaps:     []diskutil.Snapshot{common, old1, old2},
+			policies:        []RetentionPolicy{KeepLast(2)},
+			wantPruned:      []diskutil.Snapshot{old1, old2},
+			wantTargetSnaps: []diskutil.Snapshot{latest, common},
+		},
+		{
+			name:            "restored snapshot is never pruned",
+			sourceSnaps:     []diskutil.Snapshot{latest, common, old1},
+			targetSnaps:     []diskutil.Snapshot{common, old1},
+			policies:        []RetentionPolicy{MaxAge(0)},
+			wantPruned:      []diskutil.Snapshot{common, old1},
+			wantTargetSnaps: []diskutil.Snapshot{latest},
+		},
+		{
+			name:            "initialize",
+			initialize:      true,
+			sourceSnaps:     []diskutil.Snapshot{latest, common},
+			targetSnaps:     nil,
+			policies:        []RetentionPolicy{MaxAge(0)},
+			wantPruned:      nil,
+			wantTargetSnaps: []diskutil.Snapshot{latest},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			devices := newFakeDevices(t,
+				withFakeVolume(source, test.sourceSnaps...),
+				withFakeVolume(target, test.targetSnaps...),
+			)
+			du := &fakeDiskUtil{devices}
+			r := &fakeASR{devices}
+			c := New(du, r,
+				InitializeTargets(test.initialize),
+				Retention(test.policies...),
+				withNow(func() time.Time { return now }),
+			)
+			result, err := c.Clone(source.MountPoint, target.MountPoint)
+			if err != nil {
+				t.Fatalf("Clone(...) returned unexpected error: %q, want: nil", err)
+			}
+
+			cmpOpts := []cmp.Option{
+				cmpopts.SortSlices(func(lhs, rhs diskutil.Snapshot) bool {
+					return lhs.UUID < rhs.UUID
+				}),
+				cmpopts.EquateEmpty(),
+			}
+			if diff := cmp.Diff(test.wantPruned, result.Pruned, cmpOpts...); diff != "" {
+				t.Errorf("Clone(...) returned unexpected CloneResult.Pruned. -want +got:\n%s", diff)
+			}
+			gotTargetSnaps, err := devices.Snapshots(target.UUID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(test.wantTargetSnaps, gotTargetSnaps, cmpOpts...); diff != "" {
+				t.Errorf("Clone(...) resulted in unexpected snapshots in target. -want +got:\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestClone_RetentionDryRun(t *testing.T) {
+	now := time.Date(2021, 3, 31, 12, 0, 0, 0, time.UTC)
+	source := diskutil.VolumeInfo{
+		Name:           "source-name",
+		UUID:           "source-uuid",
+		MountPoint:     "/source/mount/point",
+		Writable:       true,
+		FileSystemType: "apfs",
+	}
+	target := diskutil.VolumeInfo{
+		Name:           "target-name",
+		UUID:           "target-uuid",
+		MountPoint:     "/target/mount/point",
+		Writable:       true,
+		FileSystemType: "apfs",
+	}
+	latest := snapAt("latest", now.Add(-time.Hour))
+	common := snapAt("common", now.Add(-48*time.Hour))
+	old := snapAt("old", now.Add(-72*time.Hour))
+	devices := newFakeDevices(t,
+		withFakeVolume(source, latest, common, old),
+		withFakeVolume(target, common, old),
+	)
+
+	// Use a readonly fake diskutil underlying the dryrun diskutil so that
+	// the test panics if any modifying methods of the underlying diskutil
+	// are called.
+	du := diskutil.NewDryRun(&readonlyFakeDiskUtil{
+		du: &fakeDiskUtil{devices},
+	})
+	c := New(du, asr.NewDryRun(),
+		Retention(KeepLast(1)),
+		withNow(func() time.Time { return now }),
+	)
+	result, err := c.Clone(source.MountPoint, target.MountPoint)
+	if err != nil {
+		t.Fatalf("Clone(...) returned unexpected error: %q, want: nil", err)
+	}
+	// Snapshots to prune are planned against target as it would be after
+	// the restore, so the dry run previews pruning the common snapshot.
+	if diff := cmp.Diff([]diskutil.Snapshot{common, old}, result.Pruned); diff != "" {
+		t.Errorf("Clone(...) returned unexpected CloneResult.Pruned preview. -want +got:\n%s", diff)
+	}
+	gotTargetSnaps, err := devices.Snapshots(target.UUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]diskutil.Snapshot{common, old}, gotTargetSnaps); diff != "" {
+		t.Errorf("Clone(...) resulted in unexpected snapshots in target. -want +got:\n%s", diff)
+	}
+}
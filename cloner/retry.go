@@ -0,0 +1,128 @@
+package cloner
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/asr"
+	"github.com/voidingwarranties/offsite-apfs-backup/diskutil"
+)
+
+// RetryPolicy configures how diskutil commands that read volumes, and
+// restores, that fail transiently, e.g. because target is busy right after
+// it's mounted, are retried. See diskutil.IsTransient and asr.IsTransient.
+type RetryPolicy struct {
+	// Retries is the maximum number of times a failed command is retried.
+	// 0 disables retries.
+	Retries int
+	// Backoff is how long to wait before the first retry. The wait is
+	// doubled after each retry, up to MaxBackoff.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+}
+
+// DefaultRetryPolicy suits targets that are briefly busy, e.g. right after
+// they're mounted. Commands are not retried unless the Retry option is given.
+var DefaultRetryPolicy = RetryPolicy{
+	Retries:    3,
+	Backoff:    5 * time.Second,
+	MaxBackoff: time.Minute,
+}
+
+// Retry returns an Option that retries diskutil commands that read volumes
+// (Info and ListSnapshots), and restores, that fail transiently according to
+// policy. Each retry is written to stdout. Renames and snapshot deletions are
+// never retried, as they may have taken effect even if diskutil reports that
+// they failed. By default, nothing is retried.
+func Retry(policy RetryPolicy) Option {
+	return func(c *Cloner) {
+		c.retry = policy
+	}
+}
+
+// retrier retries functions that fail transiently.
+type retrier struct {
+	policy      RetryPolicy
+	isTransient func(error) bool
+	stdout      io.Writer
+}
+
+// do calls f until it succeeds, fails with an error that isn't transient, is
+// retried r.policy.Retries times, or ctx is done. op describes f in logs.
+func (r retrier) do(ctx context.Context, op string, f func() error) error {
+	backoff := r.policy.Backoff
+	for retry := 1; ; retry++ {
+		err := f()
+		if err == nil || retry > r.policy.Retries || !r.isTransient(err) {
+			return err
+		}
+		fmt.Fprintf(r.stdout, "Transient error from %s, retrying in %v (retry %d/%d): %v\n", op, backoff, retry, r.policy.Retries, err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if r.policy.MaxBackoff > 0 && backoff > r.policy.MaxBackoff {
+			backoff = r.policy.MaxBackoff
+		}
+	}
+}
+
+// retryDiskUtil retries calls to InfoContext and ListSnapshotsContext that
+// fail transiently. Other methods are not retried.
+type retryDiskUtil struct {
+	diskutil.ContextDiskUtil
+	retrier retrier
+}
+
+func (du retryDiskUtil) InfoContext(ctx context.Context, volume string) (info diskutil.VolumeInfo, err error) {
+	err = du.retrier.do(ctx, fmt.Sprintf("getting volume info of %q", volume), func() error {
+		info, err = du.ContextDiskUtil.InfoContext(ctx, volume)
+		return err
+	})
+	return info, err
+}
+
+func (du retryDiskUtil) ListSnapshotsContext(ctx context.Context, volume diskutil.VolumeInfo) (snaps []diskutil.Snapshot, err error) {
+	err = du.retrier.do(ctx, fmt.Sprintf("listing snapshots of %q", volume.Name), func() error {
+		snaps, err = du.ContextDiskUtil.ListSnapshotsContext(ctx, volume)
+		return err
+	})
+	return snaps, err
+}
+
+// retryASR retries restores that fail transiently.
+type retryASR struct {
+	asr.ContextASR
+	retrier retrier
+}
+
+func (r retryASR) RestoreContext(ctx context.Context, source, target diskutil.VolumeInfo, to, from diskutil.Snapshot) error {
+	return r.retrier.do(ctx, fmt.Sprintf("restoring %q", target.Name), func() error {
+		return r.ContextASR.RestoreContext(ctx, source, target, to, from)
+	})
+}
+
+func (r retryASR) DestructiveRestoreContext(ctx context.Context, source, target diskutil.VolumeInfo, to diskutil.Snapshot) error {
+	return r.retrier.do(ctx, fmt.Sprintf("restoring %q", target.Name), func() error {
+		return r.ContextASR.DestructiveRestoreContext(ctx, source, target, to)
+	})
+}
+
+// applyRetries wraps c's diskutil and asr to retry transient failures. Each
+// attempt is limited by c's timeouts, so applyRetries must be called after
+// applyTimeouts.
+func (c *Cloner) applyRetries() {
+	if c.retry.Retries <= 0 {
+		return
+	}
+	if c.diskutil != nil {
+		c.diskutil = retryDiskUtil{c.diskutil, retrier{c.retry, diskutil.IsTransient, c.stdout}}
+	}
+	if c.asr != nil {
+		c.asr = retryASR{c.asr, retrier{c.retry, asr.IsTransient, c.stdout}}
+	}
+}
@@ -0,0 +1,177 @@
+package cloner
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/asr"
+	"github.com/voidingwarranties/offsite-apfs-backup/diskutil"
+)
+
+var (
+	errTransient = errors.New("fake transient failure")
+	errPermanent = errors.New("fake permanent failure")
+)
+
+func TestRetrier(t *testing.T) {
+	tests := []struct {
+		name string
+		// errs are returned by each call, followed by nil.
+		errs        []error
+		wantErr     error
+		wantCalls   int
+		wantRetries int
+	}{
+		{
+			name:      "success",
+			wantCalls: 1,
+		},
+		{
+			name:        "succeeds after transient failures",
+			errs:        []error{errTransient, errTransient},
+			wantCalls:   3,
+			wantRetries: 2,
+		},
+		{
+			name:      "permanent failure not retried",
+			errs:      []error{errPermanent},
+			wantErr:   errPermanent,
+			wantCalls: 1,
+		},
+		{
+			name:        "permanent failure after transient failure",
+			errs:        []error{errTransient, errPermanent},
+			wantErr:     errPermanent,
+			wantCalls:   2,
+			wantRetries: 1,
+		},
+		{
+			name:        "gives up after retries",
+			errs:        []error{errTransient, errTransient, errTransient, errTransient},
+			wantErr:     errTransient,
+			wantCalls:   3,
+			wantRetries: 2,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stdout := new(bytes.Buffer)
+			r := retrier{
+				policy: RetryPolicy{
+					Retries:    2,
+					Backoff:    time.Millisecond,
+					MaxBackoff: 2 * time.Millisecond,
+				},
+				isTransient: func(err error) bool { return err == errTransient },
+				stdout:      stdout,
+			}
+			var calls int
+			err := r.do(context.Background(), "fake op", func() error {
+				calls++
+				if calls <= len(test.errs) {
+					return test.errs[calls-1]
+				}
+				return nil
+			})
+			if err != test.wantErr {
+				t.Errorf("do returned unexpected error: %v, want: %v", err, test.wantErr)
+			}
+			if calls != test.wantCalls {
+				t.Errorf("do called f %d times, want: %d", calls, test.wantCalls)
+			}
+			if got := strings.Count(stdout.String(), "Transient error from fake op, retrying"); got != test.wantRetries {
+				t.Errorf("do logged %d retries, want: %d. Output:\n%s", got, test.wantRetries, stdout)
+			}
+		})
+	}
+}
+
+func TestRetrier_StopsWhenDone(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	r := retrier{
+		policy:      RetryPolicy{Retries: 3, Backoff: time.Hour},
+		isTransient: func(err error) bool { return true },
+		stdout:      new(bytes.Buffer),
+	}
+	var calls int
+	err := r.do(ctx, "fake op", func() error {
+		calls++
+		cancel()
+		return errTransient
+	})
+	if err != errTransient || calls != 1 {
+		t.Errorf("do returned %v after %d calls, want: %v after 1 call", err, calls, errTransient)
+	}
+}
+
+func TestNew_NoRetriesOrTimeoutsByDefault(t *testing.T) {
+	du := &fakeDiskUtil{}
+	r := &fakeASR{}
+	c := New(du, r)
+	if c.diskutil != diskutil.WithContext(du) {
+		t.Errorf("New(...) wrapped diskutil in %T, want: not wrapped", c.diskutil)
+	}
+	if c.asr != asr.WithContext(r) {
+		t.Errorf("New(...) wrapped asr in %T, want: not wrapped", c.asr)
+	}
+}
+
+// transientFakeDiskUtil fails every call transiently, and counts its calls.
+type transientFakeDiskUtil struct {
+	calls map[string]int
+}
+
+func (du *transientFakeDiskUtil) Info(volume string) (diskutil.VolumeInfo, error) {
+	du.calls["Info"]++
+	return diskutil.VolumeInfo{}, errTransient
+}
+
+func (du *transientFakeDiskUtil) Rename(volume diskutil.VolumeInfo, name string) error {
+	du.calls["Rename"]++
+	return errTransient
+}
+
+func (du *transientFakeDiskUtil) ListSnapshots(volume diskutil.VolumeInfo) ([]diskutil.Snapshot, error) {
+	du.calls["ListSnapshots"]++
+	return nil, errTransient
+}
+
+func (du *transientFakeDiskUtil) DeleteSnapshot(volume diskutil.VolumeInfo, snap diskutil.Snapshot) error {
+	du.calls["DeleteSnapshot"]++
+	return errTransient
+}
+
+func TestRetryDiskUtil_OnlyRetriesReads(t *testing.T) {
+	fake := &transientFakeDiskUtil{calls: make(map[string]int)}
+	du := retryDiskUtil{
+		ContextDiskUtil: diskutil.WithContext(fake),
+		retrier: retrier{
+			policy:      RetryPolicy{Retries: 2, Backoff: time.Millisecond},
+			isTransient: func(err error) bool { return err == errTransient },
+			stdout:      new(bytes.Buffer),
+		},
+	}
+	ctx := context.Background()
+	du.InfoContext(ctx, "volume")
+	du.RenameContext(ctx, diskutil.VolumeInfo{}, "name")
+	du.ListSnapshotsContext(ctx, diskutil.VolumeInfo{})
+	du.DeleteSnapshotContext(ctx, diskutil.VolumeInfo{}, diskutil.Snapshot{})
+
+	// Renames and deletions may have taken effect despite failing, so
+	// they're only run once.
+	want := map[string]int{
+		"Info":           3,
+		"Rename":         1,
+		"ListSnapshots":  3,
+		"DeleteSnapshot": 1,
+	}
+	if diff := cmp.Diff(want, fake.calls); diff != "" {
+		t.Errorf("unexpected calls (-want +got):\n%s", diff)
+	}
+}
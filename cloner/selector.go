@@ -0,0 +1,122 @@
+package cloner
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/diskutil"
+)
+
+// SnapshotSelector selects the source snapshot to clone to targets. snaps are
+// source's snapshots, ordered most recent first, as returned by
+// diskutil.ListSnapshots. SnapshotSelector returns the index of the selected
+// snapshot in snaps.
+type SnapshotSelector func(snaps []diskutil.Snapshot) (int, error)
+
+// LatestSnapshot returns a SnapshotSelector that selects the most recent
+// snapshot. This is the default behavior of Cloner.
+func LatestSnapshot() SnapshotSelector {
+	return func(snaps []diskutil.Snapshot) (int, error) {
+		if len(snaps) == 0 {
+			return 0, errors.New("no snapshots to select from")
+		}
+		return 0, nil
+	}
+}
+
+// SnapshotByUUID returns a SnapshotSelector that selects the snapshot with the
+// given UUID.
+func SnapshotByUUID(uuid string) SnapshotSelector {
+	return func(snaps []diskutil.Snapshot) (int, error) {
+		for i, s := range snaps {
+			if s.UUID == uuid {
+				return i, nil
+			}
+		}
+		return 0, fmt.Errorf("no snapshot with UUID %q", uuid)
+	}
+}
+
+// SnapshotByName returns a SnapshotSelector that selects the snapshot with
+// exactly the given name.
+func SnapshotByName(name string) SnapshotSelector {
+	return func(snaps []diskutil.Snapshot) (int, error) {
+		for i, s := range snaps {
+			if s.Name == name {
+				return i, nil
+			}
+		}
+		return 0, fmt.Errorf("no snapshot named %q", name)
+	}
+}
+
+// SnapshotByNamePrefix returns a SnapshotSelector that selects the most recent
+// snapshot whose name starts with prefix.
+func SnapshotByNamePrefix(prefix string) SnapshotSelector {
+	return func(snaps []diskutil.Snapshot) (int, error) {
+		for i, s := range snaps {
+			if strings.HasPrefix(s.Name, prefix) {
+				return i, nil
+			}
+		}
+		return 0, fmt.Errorf("no snapshot with name prefix %q", prefix)
+	}
+}
+
+// SnapshotAtOrBefore returns a SnapshotSelector that selects the most recent
+// snapshot created at or before t. Snapshots without a creation time (see
+// diskutil.Snapshot.Created) are skipped.
+func SnapshotAtOrBefore(t time.Time) SnapshotSelector {
+	return func(snaps []diskutil.Snapshot) (int, error) {
+		for i, s := range snaps {
+			if !s.Created.IsZero() && !s.Created.After(t) {
+				return i, nil
+			}
+		}
+		return 0, fmt.Errorf("no snapshot created at or before %s", t.Format(time.RFC3339))
+	}
+}
+
+// ParseSnapshotSelector parses a SnapshotSelector of one of the following
+// forms:
+//
+//	latest
+//	uuid:<snapshot UUID>
+//	name:<snapshot name>
+//	prefix:<snapshot name prefix>
+//	before:<RFC 3339 time or yyyy-mm-dd-hhmmss>
+//
+// A yyyy-mm-dd-hhmmss time is in local time, like the timestamps in snapshot
+// names (see diskutil.Snapshot.Created).
+func ParseSnapshotSelector(s string) (SnapshotSelector, error) {
+	if s == "latest" {
+		return LatestSnapshot(), nil
+	}
+	kind, value := s, ""
+	if i := strings.Index(s, ":"); i >= 0 {
+		kind, value = s[:i], s[i+1:]
+	}
+	if value == "" {
+		return nil, fmt.Errorf("invalid snapshot selector %q: want latest, uuid:<uuid>, name:<name>, prefix:<prefix>, or before:<time>", s)
+	}
+	switch kind {
+	case "uuid":
+		return SnapshotByUUID(value), nil
+	case "name":
+		return SnapshotByName(value), nil
+	case "prefix":
+		return SnapshotByNamePrefix(value), nil
+	case "before":
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			t, err = time.ParseInLocation("2006-01-02-150405", value, time.Local)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid snapshot selector %q: time must be RFC 3339 or yyyy-mm-dd-hhmmss", s)
+		}
+		return SnapshotAtOrBefore(t), nil
+	}
+	return nil, fmt.Errorf("invalid snapshot selector %q: unknown kind %q", s, kind)
+}
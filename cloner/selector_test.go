@@ -0,0 +1,140 @@
+package cloner
+
+import (
+	"testing"
+	"time"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/diskutil"
+)
+
+var selectorTestSnaps = []diskutil.Snapshot{
+	{
+		Name:    "com.example.2021-03-03-000000",
+		UUID:    "snap-3-uuid",
+		Created: time.Date(2021, 3, 3, 0, 0, 0, 0, time.UTC),
+	},
+	{
+		Name:    "com.example.2021-03-02-000000",
+		UUID:    "snap-2-uuid",
+		Created: time.Date(2021, 3, 2, 0, 0, 0, 0, time.UTC),
+	},
+	{
+		Name:    "com.other.2021-03-01-000000",
+		UUID:    "snap-1-uuid",
+		Created: time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC),
+	},
+}
+
+func TestParseSnapshotSelector(t *testing.T) {
+	tests := []struct {
+		selector string
+		want     int
+	}{
+		{
+			selector: "latest",
+			want:     0,
+		},
+		{
+			selector: "uuid:snap-2-uuid",
+			want:     1,
+		},
+		{
+			selector: "name:com.other.2021-03-01-000000",
+			want:     2,
+		},
+		{
+			selector: "prefix:com.example.",
+			want:     0,
+		},
+		{
+			selector: "prefix:com.other.",
+			want:     2,
+		},
+		{
+			selector: "before:2021-03-02T12:00:00Z",
+			want:     1,
+		},
+		{
+			selector: "before:2021-03-02-000000",
+			want:     1,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.selector, func(t *testing.T) {
+			sel, err := ParseSnapshotSelector(test.selector)
+			if err != nil {
+				t.Fatalf("ParseSnapshotSelector returned unexpected error: %v, want: nil", err)
+			}
+			got, err := sel(selectorTestSnaps)
+			if err != nil {
+				t.Fatalf("selector returned unexpected error: %v, want: nil", err)
+			}
+			if got != test.want {
+				t.Errorf("selector returned index %d, want: %d", got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseSnapshotSelector_Errors(t *testing.T) {
+	tests := []string{
+		"",
+		"uuid:",
+		"newest",
+		"unknown:value",
+		"before:yesterday",
+	}
+	for _, test := range tests {
+		t.Run(test, func(t *testing.T) {
+			if _, err := ParseSnapshotSelector(test); err == nil {
+				t.Error("ParseSnapshotSelector returned error: nil, want: non-nil")
+			}
+		})
+	}
+}
+
+func TestSnapshotSelector_NoMatch(t *testing.T) {
+	tests := []struct {
+		name  string
+		sel   SnapshotSelector
+		snaps []diskutil.Snapshot
+	}{
+		{
+			name:  "latest of no snapshots",
+			sel:   LatestSnapshot(),
+			snaps: nil,
+		},
+		{
+			name:  "unknown UUID",
+			sel:   SnapshotByUUID("not-a-snap-uuid"),
+			snaps: selectorTestSnaps,
+		},
+		{
+			name:  "unknown name",
+			sel:   SnapshotByName("com.example"),
+			snaps: selectorTestSnaps,
+		},
+		{
+			name:  "unknown prefix",
+			sel:   SnapshotByNamePrefix("com.unknown."),
+			snaps: selectorTestSnaps,
+		},
+		{
+			name:  "before all snapshots",
+			sel:   SnapshotAtOrBefore(time.Date(2021, 2, 1, 0, 0, 0, 0, time.UTC)),
+			snaps: selectorTestSnaps,
+		},
+		{
+			name:  "before - no snapshot has a creation time",
+			sel:   SnapshotAtOrBefore(time.Date(2021, 3, 2, 0, 0, 0, 0, time.UTC)),
+			snaps: []diskutil.Snapshot{{Name: "manual-snapshot", UUID: "manual-snapshot-uuid"}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := test.sel(test.snaps); err == nil {
+				t.Error("selector returned error: nil, want: non-nil")
+			}
+		})
+	}
+}
@@ -0,0 +1,166 @@
+package cloner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/asr"
+	"github.com/voidingwarranties/offsite-apfs-backup/diskutil"
+	"github.com/voidingwarranties/offsite-apfs-backup/tmutil"
+)
+
+// CommandTimeout returns an Option that limits how long each diskutil and
+// tmutil command may run before it is killed. By default, or if d is 0,
+// commands may run indefinitely.
+func CommandTimeout(d time.Duration) Option {
+	return func(c *Cloner) {
+		c.commandTimeout = d
+	}
+}
+
+// RestoreTimeout returns an Option that limits how long each restore may run
+// before it is interrupted. By default, restores may run indefinitely, as
+// large restores can take many hours.
+func RestoreTimeout(d time.Duration) Option {
+	return func(c *Cloner) {
+		c.restoreTimeout = d
+	}
+}
+
+// InterruptedError is returned by the Context variants of Cloner's methods
+// when their context is done before the clone to Target completes. If the
+// clone was interrupted while modifying target, the step is left in the
+// journal to be completed by Recover.
+type InterruptedError struct {
+	Target diskutil.VolumeInfo
+	// Phase is the phase that was interrupted.
+	Phase Phase
+	// Err is the error of the interrupted phase.
+	Err error
+
+	// ctxErr is the error of the context, e.g. context.Canceled.
+	ctxErr error
+}
+
+func (err *InterruptedError) Error() string {
+	return fmt.Sprintf("interrupted during %s: %v", err.Phase, err.Err)
+}
+
+func (err *InterruptedError) Unwrap() error {
+	return err.Err
+}
+
+// Is returns true if target is the reason the context was done, i.e.
+// context.Canceled or context.DeadlineExceeded.
+func (err *InterruptedError) Is(target error) bool {
+	return target == err.ctxErr
+}
+
+// interruptedPhase returns the phase in progress when a clone with result was
+// interrupted. Phases are timed when they end, except for restore and prune,
+// which are timed even if they fail.
+func interruptedPhase(result CloneResult) Phase {
+	if len(result.Timings) == 0 {
+		return PhaseValidate
+	}
+	return result.Timings[len(result.Timings)-1].Phase
+}
+
+// withTimeout runs f with a context that is done after timeout, or when ctx
+// is done. If the timeout is reached first, the returned error says so.
+func withTimeout(ctx context.Context, timeout time.Duration, f func(ctx context.Context) error) error {
+	if timeout <= 0 {
+		return f(ctx)
+	}
+	timeoutCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	err := f(timeoutCtx)
+	if err != nil && ctx.Err() == nil && errors.Is(timeoutCtx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("timed out after %v: %w", timeout, err)
+	}
+	return err
+}
+
+// timeoutDiskUtil limits the duration of each call to the Context variants of
+// DiskUtil's methods, which are the only ones Cloner calls.
+type timeoutDiskUtil struct {
+	diskutil.ContextDiskUtil
+	timeout time.Duration
+}
+
+func (du timeoutDiskUtil) InfoContext(ctx context.Context, volume string) (info diskutil.VolumeInfo, err error) {
+	err = withTimeout(ctx, du.timeout, func(ctx context.Context) error {
+		info, err = du.ContextDiskUtil.InfoContext(ctx, volume)
+		return err
+	})
+	return info, err
+}
+
+func (du timeoutDiskUtil) RenameContext(ctx context.Context, volume diskutil.VolumeInfo, name string) error {
+	return withTimeout(ctx, du.timeout, func(ctx context.Context) error {
+		return du.ContextDiskUtil.RenameContext(ctx, volume, name)
+	})
+}
+
+func (du timeoutDiskUtil) ListSnapshotsContext(ctx context.Context, volume diskutil.VolumeInfo) (snaps []diskutil.Snapshot, err error) {
+	err = withTimeout(ctx, du.timeout, func(ctx context.Context) error {
+		snaps, err = du.ContextDiskUtil.ListSnapshotsContext(ctx, volume)
+		return err
+	})
+	return snaps, err
+}
+
+func (du timeoutDiskUtil) DeleteSnapshotContext(ctx context.Context, volume diskutil.VolumeInfo, snap diskutil.Snapshot) error {
+	return withTimeout(ctx, du.timeout, func(ctx context.Context) error {
+		return du.ContextDiskUtil.DeleteSnapshotContext(ctx, volume, snap)
+	})
+}
+
+// timeoutASR limits the duration of each restore.
+type timeoutASR struct {
+	asr.ContextASR
+	timeout time.Duration
+}
+
+func (r timeoutASR) RestoreContext(ctx context.Context, source, target diskutil.VolumeInfo, to, from diskutil.Snapshot) error {
+	return withTimeout(ctx, r.timeout, func(ctx context.Context) error {
+		return r.ContextASR.RestoreContext(ctx, source, target, to, from)
+	})
+}
+
+func (r timeoutASR) DestructiveRestoreContext(ctx context.Context, source, target diskutil.VolumeInfo, to diskutil.Snapshot) error {
+	return withTimeout(ctx, r.timeout, func(ctx context.Context) error {
+		return r.ContextASR.DestructiveRestoreContext(ctx, source, target, to)
+	})
+}
+
+// timeoutTMUtil limits the duration of each call to LocalSnapshotContext.
+type timeoutTMUtil struct {
+	tmutil.ContextTMUtil
+	timeout time.Duration
+}
+
+func (tm timeoutTMUtil) LocalSnapshotContext(ctx context.Context) (date string, err error) {
+	err = withTimeout(ctx, tm.timeout, func(ctx context.Context) error {
+		date, err = tm.ContextTMUtil.LocalSnapshotContext(ctx)
+		return err
+	})
+	return date, err
+}
+
+// applyTimeouts wraps c's commands to enforce its timeouts.
+func (c *Cloner) applyTimeouts() {
+	if c.commandTimeout > 0 {
+		if c.diskutil != nil {
+			c.diskutil = timeoutDiskUtil{c.diskutil, c.commandTimeout}
+		}
+		if c.tmutil != nil {
+			c.tmutil = timeoutTMUtil{c.tmutil, c.commandTimeout}
+		}
+	}
+	if c.restoreTimeout > 0 && c.asr != nil {
+		c.asr = timeoutASR{c.asr, c.restoreTimeout}
+	}
+}
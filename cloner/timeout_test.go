@@ -0,0 +1,88 @@
+package cloner
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/asr"
+	"github.com/voidingwarranties/offsite-apfs-backup/diskutil"
+)
+
+// hangingFakeDiskUtil's InfoContext doesn't return until ctx is done.
+type hangingFakeDiskUtil struct {
+	diskutil.ContextDiskUtil
+}
+
+func (du hangingFakeDiskUtil) InfoContext(ctx context.Context, volume string) (diskutil.VolumeInfo, error) {
+	<-ctx.Done()
+	return diskutil.VolumeInfo{}, ctx.Err()
+}
+
+// cancelingFakeASR cancels its context during each restore, as if the
+// process was interrupted.
+type cancelingFakeASR struct {
+	asr.ContextASR
+	cancel context.CancelFunc
+}
+
+func (r cancelingFakeASR) RestoreContext(ctx context.Context, source, target diskutil.VolumeInfo, to, from diskutil.Snapshot) error {
+	r.cancel()
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestCommandTimeout(t *testing.T) {
+	devices := newFakeDevices(t,
+		withFakeVolume(journalSource, journalSnap2, journalSnap1),
+		withFakeVolume(journalTarget, journalSnap1),
+	)
+	du := hangingFakeDiskUtil{diskutil.WithContext(&fakeDiskUtil{devices})}
+	c := New(du, &fakeASR{devices}, CommandTimeout(10*time.Millisecond), Stdout(io.Discard))
+	_, err := c.Plan(journalSource.UUID, journalTarget.UUID)
+	if err == nil || !strings.Contains(err.Error(), "timed out after 10ms") {
+		t.Errorf("Plan returned unexpected error: %v, want: timed out after 10ms", err)
+	}
+}
+
+func TestExecuteTargetContext_Interrupted(t *testing.T) {
+	devices := newFakeDevices(t,
+		withFakeVolume(journalSource, journalSnap2, journalSnap1),
+		withFakeVolume(journalTarget, journalSnap1),
+	)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	j := newFakeJournal()
+	c := New(&fakeDiskUtil{devices}, cancelingFakeASR{asr.WithContext(&fakeASR{devices}), cancel}, Journaling(j), Stdout(io.Discard))
+	plan, err := c.Plan(journalSource.UUID, journalTarget.UUID)
+	if err != nil {
+		t.Fatalf("Plan returned unexpected error: %v", err)
+	}
+	result, err := c.ExecuteTargetContext(ctx, plan, plan.Targets[0])
+	var ierr *InterruptedError
+	if !errors.As(err, &ierr) || !errors.Is(err, context.Canceled) {
+		t.Fatalf("ExecuteTargetContext returned unexpected error: %v, want: *InterruptedError wrapping context.Canceled", err)
+	}
+	if ierr.Phase != PhaseRestore || ierr.Target != journalTarget {
+		t.Errorf("ExecuteTargetContext interrupted during %q of %v, want: %q of %v", ierr.Phase, ierr.Target, PhaseRestore, journalTarget)
+	}
+	if result.Renamed {
+		t.Error("ExecuteTargetContext renamed target after it was interrupted")
+	}
+	pending, err := j.Pending()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var gotSteps []Phase
+	for _, e := range pending {
+		gotSteps = append(gotSteps, e.Step)
+	}
+	if diff := cmp.Diff([]Phase{PhaseRestore}, gotSteps); diff != "" {
+		t.Errorf("ExecuteTargetContext left unexpected pending steps. -want +got:\n%s", diff)
+	}
+}
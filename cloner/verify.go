@@ -0,0 +1,89 @@
+package cloner
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/diskutil"
+)
+
+// Verify returns an Option that, if verify is true, checks target after a
+// successful clone:
+//   - Target's latest snapshot is the snapshot it was restored to.
+//   - Target's file system is unchanged.
+//   - Target has its original name, if it was renamed back to it.
+//   - Snapshots that were pruned no longer exist.
+//
+// If any check fails, Clone and Execute return a *VerificationError. Verify
+// must not be used with a dry run diskutil or asr, as targets are not
+// modified.
+func Verify(verify bool) Option {
+	return func(c *Cloner) {
+		c.verify = verify
+	}
+}
+
+// VerificationError is returned when a target does not match what a
+// successful clone should have left it as. Target was restored, so unlike
+// other errors, a VerificationError does not mean that the clone itself
+// failed.
+type VerificationError struct {
+	Target diskutil.VolumeInfo
+	// Mismatches describe each failed check.
+	Mismatches []string
+}
+
+func (e *VerificationError) Error() string {
+	return fmt.Sprintf("verification of target %q failed: %s", e.Target.Name, strings.Join(e.Mismatches, "; "))
+}
+
+// verifyTarget checks target after tp was executed. See Verify.
+func (c Cloner) verifyTarget(ctx context.Context, tp TargetPlan, result *CloneResult) error {
+	start := time.Now()
+	defer result.timePhase(PhaseVerify, start)
+	fmt.Fprintln(c.stdout, "Verifying target...")
+
+	verr := &VerificationError{Target: tp.Target}
+	target, err := c.diskutil.InfoContext(ctx, tp.Target.UUID)
+	if err != nil {
+		return fmt.Errorf("error getting volume info of target %q: %v", tp.Target.UUID, err)
+	}
+	if target.FileSystemType != tp.Target.FileSystemType || target.FileSystem != tp.Target.FileSystem {
+		verr.Mismatches = append(verr.Mismatches, fmt.Sprintf("file system is %s (%s), want: %s (%s)", target.FileSystem, target.FileSystemType, tp.Target.FileSystem, tp.Target.FileSystemType))
+	}
+	if result.Renamed && target.Name != tp.Target.Name {
+		verr.Mismatches = append(verr.Mismatches, fmt.Sprintf("name is %q, want: %q", target.Name, tp.Target.Name))
+	}
+
+	snaps, err := c.diskutil.ListSnapshotsContext(ctx, target)
+	if err != nil {
+		return fmt.Errorf("error listing snapshots of target: %v", err)
+	}
+	if len(snaps) == 0 {
+		verr.Mismatches = append(verr.Mismatches, fmt.Sprintf("target has no snapshots, want latest snapshot: %s", tp.To))
+	} else if snaps[0].UUID != tp.To.UUID {
+		verr.Mismatches = append(verr.Mismatches, fmt.Sprintf("latest snapshot is %s, want: %s", snaps[0], tp.To))
+	}
+	for _, s := range result.Discarded {
+		if containsSnapshot(snaps, s) {
+			verr.Mismatches = append(verr.Mismatches, fmt.Sprintf("discarded snapshot %s still exists", s))
+		}
+	}
+	for _, s := range result.Pruned {
+		if containsSnapshot(snaps, s) {
+			verr.Mismatches = append(verr.Mismatches, fmt.Sprintf("pruned snapshot %s still exists", s))
+		}
+	}
+
+	if len(verr.Mismatches) > 0 {
+		for _, m := range verr.Mismatches {
+			fmt.Fprintf(c.stdout, "Verification failed: %s\n", m)
+		}
+		return verr
+	}
+	result.Verified = true
+	fmt.Fprintln(c.stdout, "Verified target.")
+	return nil
+}
@@ -0,0 +1,106 @@
+package cloner
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/asr"
+	"github.com/voidingwarranties/offsite-apfs-backup/diskutil"
+)
+
+func TestClone_Verify(t *testing.T) {
+	source := diskutil.VolumeInfo{
+		Name:           "source-name",
+		UUID:           "source-uuid",
+		MountPoint:     "/source/mount/point",
+		FileSystemType: "apfs",
+	}
+	target := diskutil.VolumeInfo{
+		Name:           "target-name",
+		UUID:           "target-uuid",
+		MountPoint:     "/target/mount/point",
+		Writable:       true,
+		FileSystemType: "apfs",
+	}
+	commonSnap := diskutil.Snapshot{Name: "common-snap", UUID: "common-snap-uuid"}
+	latestSnap := diskutil.Snapshot{Name: "latest-snap", UUID: "latest-snap-uuid"}
+
+	tests := []struct {
+		name           string
+		diskutil       func(*fakeDevices) diskutil.DiskUtil
+		asr            func(*fakeDevices) asr.ASR
+		wantMismatches []string
+	}{
+		{
+			name: "verified",
+		},
+		{
+			name: "rename failure is not a mismatch",
+			diskutil: func(d *fakeDevices) diskutil.DiskUtil {
+				return &renameFailingFakeDiskUtil{fakeDiskUtil{d}}
+			},
+		},
+		{
+			name: "restore did not create snapshot",
+			asr: func(d *fakeDevices) asr.ASR {
+				return &noopRestoreFakeASR{fakeASR{d}}
+			},
+			wantMismatches: []string{
+				// The common snapshot was pruned.
+				"target has no snapshots, want latest snapshot: latest-snap (latest-snap-uuid)",
+			},
+		},
+		{
+			name: "pruned snapshot not deleted",
+			diskutil: func(d *fakeDevices) diskutil.DiskUtil {
+				return &noopDeleteFakeDiskUtil{fakeDiskUtil{d}}
+			},
+			wantMismatches: []string{
+				"pruned snapshot common-snap (common-snap-uuid) still exists",
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			devices := newFakeDevices(t,
+				withFakeVolume(source, latestSnap, commonSnap),
+				withFakeVolume(target, commonSnap),
+			)
+			var du diskutil.DiskUtil = &fakeDiskUtil{devices}
+			if test.diskutil != nil {
+				du = test.diskutil(devices)
+			}
+			var r asr.ASR = &fakeASR{devices}
+			if test.asr != nil {
+				r = test.asr(devices)
+			}
+			c := New(du, r, Prune(true), Verify(true))
+			result, err := c.Clone(source.MountPoint, target.MountPoint)
+
+			if len(test.wantMismatches) == 0 {
+				if err != nil {
+					t.Fatalf("Clone(...) returned unexpected error: %q, want: nil", err)
+				}
+				if !result.Verified {
+					t.Error("CloneResult.Verified = false, want: true")
+				}
+				if _, ok := result.PhaseDuration(PhaseVerify); !ok {
+					t.Error("CloneResult.Timings does not include PhaseVerify")
+				}
+				return
+			}
+			var verr *VerificationError
+			if !errors.As(err, &verr) {
+				t.Fatalf("Clone(...) returned unexpected error: %v, want: *VerificationError", err)
+			}
+			if diff := cmp.Diff(test.wantMismatches, verr.Mismatches); diff != "" {
+				t.Errorf("VerificationError.Mismatches unexpected. -want +got:\n%s", diff)
+			}
+			if result.Verified {
+				t.Error("CloneResult.Verified = true, want: false")
+			}
+		})
+	}
+}
@@ -0,0 +1,490 @@
+// Package config implements parsing config files that define named backup
+// jobs.
+//
+// A config file consists of job sections, each followed by the target sections
+// of that job. For example:
+//
+//	# Lines starting with # or ; are comments.
+//	[job "offsite"]
+//	source = /Volumes/Data
+//	create-snapshot = true
+//	schedule = daily 03:00
+//	# Options set in a job apply to all of its targets, unless the target
+//	# sets them too.
+//	prune = true
+//
+//	[target "vault"]
+//	uuid = 9B4E2C9A-5D6E-4C1F-8A2B-3C4D5E6F7A8B
+//	keep-daily = 7
+//	hook-post-restore = "say 'vault done'"
+//
+// Values may be double quoted, using Go string syntax.
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/mail"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/cloner"
+	"github.com/voidingwarranties/offsite-apfs-backup/launchd"
+)
+
+// Config is a parsed config file.
+type Config struct {
+	Jobs []Job
+}
+
+// Job returns the job with the given name, and false if there is no such job.
+func (c Config) Job(name string) (Job, bool) {
+	for _, j := range c.Jobs {
+		if j.Name == name {
+			return j, true
+		}
+	}
+	return Job{}, false
+}
+
+// Job clones a source volume to one or more targets.
+type Job struct {
+	Name string
+	// Line is the line of the job's section header.
+	Line int
+
+	// Source may be a mount point, /dev/ path, or volume UUID.
+	Source string
+	// Parallel is the maximum number of targets to clone to at the same
+	// time. Defaults to 1.
+	Parallel int
+	// CreateSnapshot creates a new snapshot of source to clone. See
+	// cloner.Cloner.CreateSnapshot.
+	CreateSnapshot bool
+	// Snapshot selects the snapshot of source to clone. See
+	// cloner.ParseSnapshotSelector. Defaults to "latest".
+	Snapshot string
+
+	// Hooks run once per run. See cloner.HookPreValidate and
+	// cloner.HookPostRun.
+	PreValidateHook string
+	PostRunHook     string
+
+	// Schedule is when launchd runs the job, if installed as a launchd
+	// daemon. See launchd.ParseSchedule.
+	Schedule string
+	// Log is the file launchd appends the job's output to.
+	Log string
+
+	// Notifications of the outcome of each run. See the notify package.
+	NotifyWebhook string
+	NotifyCommand string
+	NotifyMacOS   bool
+	// NotifyEmail are the addresses emailed from SMTPFrom, using the SMTP
+	// server at SMTPServer (host:port).
+	NotifyEmail []string
+	SMTPServer  string
+	SMTPFrom    string
+
+	// Metrics is the Prometheus textfile updated after each run. See the
+	// metrics package.
+	Metrics string
+
+	Targets []Target
+}
+
+// Target is a volume that a job clones to.
+type Target struct {
+	// Alias is the target's name in the config file.
+	Alias string
+	// Line is the line of the target's section header.
+	Line int
+	// Volume may be a mount point, /dev/ path, or volume UUID. Config files
+	// always set it to a volume UUID.
+	Volume string
+	TargetOptions
+}
+
+// TargetOptions configure how a single target is cloned.
+type TargetOptions struct {
+	Prune   bool
+	CatchUp bool
+
+	KeepLast    int
+	KeepDaily   int
+	KeepWeekly  int
+	KeepMonthly int
+	MaxAge      time.Duration
+
+	// Verify defaults to true.
+	Verify         bool
+	VerifyContents bool
+	VerifyHashes   bool
+
+	PreRestoreHook  string
+	PostRestoreHook string
+	PostPruneHook   string
+}
+
+// RetentionPolicies returns the retention policies set by o.
+func (o TargetOptions) RetentionPolicies() []cloner.RetentionPolicy {
+	var policies []cloner.RetentionPolicy
+	if o.KeepLast > 0 {
+		policies = append(policies, cloner.KeepLast(o.KeepLast))
+	}
+	if o.KeepDaily > 0 {
+		policies = append(policies, cloner.KeepDaily(o.KeepDaily))
+	}
+	if o.KeepWeekly > 0 {
+		policies = append(policies, cloner.KeepWeekly(o.KeepWeekly))
+	}
+	if o.KeepMonthly > 0 {
+		policies = append(policies, cloner.KeepMonthly(o.KeepMonthly))
+	}
+	if o.MaxAge > 0 {
+		policies = append(policies, cloner.MaxAge(o.MaxAge))
+	}
+	return policies
+}
+
+// Error is an error in a config file.
+type Error struct {
+	Filename string
+	Line     int
+	Msg      string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s:%d: %s", e.Filename, e.Line, e.Msg)
+}
+
+// Load parses the config file at path.
+func Load(path string) (Config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Config{}, err
+	}
+	defer f.Close()
+	return Parse(f, path)
+}
+
+var (
+	sectionRegex = regexp.MustCompile(`^\[\s*(\S+)(?:\s+(?:"([^"]*)"|(\S+)))?\s*\]$`)
+	uuidRegex    = regexp.MustCompile(`^[0-9A-Fa-f]{8}-[0-9A-Fa-f]{4}-[0-9A-Fa-f]{4}-[0-9A-Fa-f]{4}-[0-9A-Fa-f]{12}$`)
+)
+
+// Parse parses a config file read from r. filename is used in errors.
+func Parse(r io.Reader, filename string) (Config, error) {
+	p := parser{filename: filename}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		p.line++
+		if err := p.parseLine(strings.TrimSpace(scanner.Text())); err != nil {
+			return Config{}, err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return Config{}, err
+	}
+	if err := p.finishJob(); err != nil {
+		return Config{}, err
+	}
+	return p.config, nil
+}
+
+type parser struct {
+	filename string
+	line     int
+	config   Config
+
+	// job is the job being parsed, and nil before the first job section.
+	job *Job
+	// defaults are the target options set in job's section.
+	defaults TargetOptions
+	// target is the target being parsed, and nil if in a job section.
+	target *Target
+	// keys are the keys set in the current section.
+	keys map[string]bool
+}
+
+func (p *parser) errorf(format string, a ...interface{}) error {
+	return p.errorAt(p.line, format, a...)
+}
+
+func (p *parser) errorAt(line int, format string, a ...interface{}) error {
+	return &Error{
+		Filename: p.filename,
+		Line:     line,
+		Msg:      fmt.Sprintf(format, a...),
+	}
+}
+
+func (p *parser) parseLine(line string) error {
+	if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+		return nil
+	}
+	if strings.HasPrefix(line, "[") {
+		return p.parseSection(line)
+	}
+	i := strings.Index(line, "=")
+	if i < 0 {
+		return p.errorf("expected a section header or key = value, got %q", line)
+	}
+	key := strings.TrimSpace(line[:i])
+	value := strings.TrimSpace(line[i+1:])
+	if strings.HasPrefix(value, `"`) {
+		unquoted, err := strconv.Unquote(value)
+		if err != nil {
+			return p.errorf("invalid quoted value for %q: %s", key, value)
+		}
+		value = unquoted
+	}
+	if p.job == nil {
+		return p.errorf("%q is not in a job or target section", key)
+	}
+	if p.keys[key] {
+		return p.errorf("%q is set more than once in this section", key)
+	}
+	p.keys[key] = true
+	if p.target != nil {
+		return p.setTargetKey(key, value)
+	}
+	return p.setJobKey(key, value)
+}
+
+func (p *parser) parseSection(line string) error {
+	m := sectionRegex.FindStringSubmatch(line)
+	if m == nil {
+		return p.errorf("invalid section header %q", line)
+	}
+	kind, name := m[1], m[2]+m[3]
+	if name == "" {
+		return p.errorf("%s section must have a name, e.g. [%s \"name\"]", kind, kind)
+	}
+	p.keys = make(map[string]bool)
+	switch kind {
+	case "job":
+		if err := p.finishJob(); err != nil {
+			return err
+		}
+		if _, exists := p.config.Job(name); exists {
+			return p.errorf("job %q is defined more than once", name)
+		}
+		p.job = &Job{
+			Name:     name,
+			Line:     p.line,
+			Parallel: 1,
+			Snapshot: "latest",
+		}
+		p.defaults = TargetOptions{Verify: true}
+		p.target = nil
+	case "target":
+		if p.job == nil {
+			return p.errorf("target %q must follow a job section", name)
+		}
+		p.finishTarget()
+		for _, t := range p.job.Targets {
+			if t.Alias == name {
+				return p.errorf("target %q is defined more than once in job %q", name, p.job.Name)
+			}
+		}
+		p.target = &Target{
+			Alias:         name,
+			Line:          p.line,
+			TargetOptions: p.defaults,
+		}
+	default:
+		return p.errorf("unknown section type %q, want: job or target", kind)
+	}
+	return nil
+}
+
+func (p *parser) finishTarget() {
+	if p.target != nil {
+		p.job.Targets = append(p.job.Targets, *p.target)
+		p.target = nil
+	}
+}
+
+// finishJob validates the job being parsed, and adds it to config.
+func (p *parser) finishJob() error {
+	if p.job == nil {
+		return nil
+	}
+	p.finishTarget()
+	job := p.job
+	p.job = nil
+	if job.Source == "" {
+		return p.errorAt(job.Line, "job %q has no source", job.Name)
+	}
+	if len(job.Targets) == 0 {
+		return p.errorAt(job.Line, "job %q has no targets", job.Name)
+	}
+	volumes := make(map[string]string)
+	for _, t := range job.Targets {
+		if t.Volume == "" {
+			return p.errorAt(t.Line, "target %q has no uuid", t.Alias)
+		}
+		if dup, exists := volumes[strings.ToUpper(t.Volume)]; exists {
+			return p.errorAt(t.Line, "target %q has the same uuid as target %q", t.Alias, dup)
+		}
+		volumes[strings.ToUpper(t.Volume)] = t.Alias
+	}
+	if job.CreateSnapshot && job.Snapshot != "latest" {
+		return p.errorAt(job.Line, "create-snapshot and snapshot are incompatible")
+	}
+	if len(job.NotifyEmail) > 0 && (job.SMTPServer == "" || job.SMTPFrom == "") {
+		return p.errorAt(job.Line, "notify-email requires smtp-server and smtp-from")
+	}
+	p.config.Jobs = append(p.config.Jobs, *job)
+	return nil
+}
+
+func (p *parser) setJobKey(key, value string) error {
+	var err error
+	switch key {
+	case "source":
+		p.job.Source = value
+	case "parallel":
+		p.job.Parallel, err = p.parseInt(key, value)
+		if err == nil && p.job.Parallel < 1 {
+			err = p.errorf("parallel must be at least 1")
+		}
+	case "create-snapshot":
+		p.job.CreateSnapshot, err = p.parseBool(key, value)
+	case "snapshot":
+		if _, serr := cloner.ParseSnapshotSelector(value); serr != nil {
+			return p.errorf("invalid snapshot: %v", serr)
+		}
+		p.job.Snapshot = value
+	case "hook-pre-validate":
+		p.job.PreValidateHook = value
+	case "hook-post-run":
+		p.job.PostRunHook = value
+	case "schedule":
+		if _, serr := launchd.ParseSchedule(value); serr != nil {
+			return p.errorf("%v", serr)
+		}
+		p.job.Schedule = value
+	case "log":
+		p.job.Log = value
+	case "notify-webhook":
+		if u, uerr := url.Parse(value); uerr != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return p.errorf("invalid notify-webhook %q, want an http:// or https:// URL", value)
+		}
+		p.job.NotifyWebhook = value
+	case "notify-command":
+		p.job.NotifyCommand = value
+	case "notify-macos":
+		p.job.NotifyMacOS, err = p.parseBool(key, value)
+	case "notify-email":
+		for _, addr := range strings.Split(value, ",") {
+			addr = strings.TrimSpace(addr)
+			if _, aerr := mail.ParseAddress(addr); aerr != nil {
+				return p.errorf("invalid notify-email address %q", addr)
+			}
+			p.job.NotifyEmail = append(p.job.NotifyEmail, addr)
+		}
+	case "smtp-server":
+		if _, _, serr := net.SplitHostPort(value); serr != nil {
+			return p.errorf("invalid smtp-server %q, want host:port", value)
+		}
+		p.job.SMTPServer = value
+	case "smtp-from":
+		p.job.SMTPFrom = value
+	case "metrics":
+		p.job.Metrics = value
+	case "uuid":
+		return p.errorf("uuid must be set in a target section")
+	default:
+		return p.setOption(&p.defaults, key, value)
+	}
+	return err
+}
+
+func (p *parser) setTargetKey(key, value string) error {
+	switch key {
+	case "uuid":
+		if !uuidRegex.MatchString(value) {
+			return p.errorf("invalid uuid %q", value)
+		}
+		p.target.Volume = value
+		return nil
+	case "source", "parallel", "create-snapshot", "snapshot", "hook-pre-validate", "hook-post-run", "schedule", "log",
+		"notify-webhook", "notify-command", "notify-macos", "notify-email", "smtp-server", "smtp-from", "metrics":
+		return p.errorf("%q must be set in a job section", key)
+	}
+	return p.setOption(&p.target.TargetOptions, key, value)
+}
+
+func (p *parser) setOption(o *TargetOptions, key, value string) error {
+	var err error
+	switch key {
+	case "prune":
+		o.Prune, err = p.parseBool(key, value)
+	case "catchup":
+		o.CatchUp, err = p.parseBool(key, value)
+	case "keep-last":
+		o.KeepLast, err = p.parseCount(key, value)
+	case "keep-daily":
+		o.KeepDaily, err = p.parseCount(key, value)
+	case "keep-weekly":
+		o.KeepWeekly, err = p.parseCount(key, value)
+	case "keep-monthly":
+		o.KeepMonthly, err = p.parseCount(key, value)
+	case "max-age":
+		o.MaxAge, err = time.ParseDuration(value)
+		if err != nil || o.MaxAge < 0 {
+			err = p.errorf("invalid duration for %q: %q", key, value)
+		}
+	case "verify":
+		o.Verify, err = p.parseBool(key, value)
+	case "verify-contents":
+		o.VerifyContents, err = p.parseBool(key, value)
+	case "verify-hashes":
+		o.VerifyHashes, err = p.parseBool(key, value)
+	case "hook-pre-restore":
+		o.PreRestoreHook = value
+	case "hook-post-restore":
+		o.PostRestoreHook = value
+	case "hook-post-prune":
+		o.PostPruneHook = value
+	default:
+		return p.errorf("unknown key %q", key)
+	}
+	return err
+}
+
+func (p *parser) parseBool(key, value string) (bool, error) {
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, p.errorf("invalid boolean for %q: %q", key, value)
+	}
+	return b, nil
+}
+
+func (p *parser) parseInt(key, value string) (int, error) {
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, p.errorf("invalid integer for %q: %q", key, value)
+	}
+	return n, nil
+}
+
+// parseCount parses a non-negative integer.
+func (p *parser) parseCount(key, value string) (int, error) {
+	n, err := p.parseInt(key, value)
+	if err != nil {
+		return 0, err
+	}
+	if n < 0 {
+		return 0, p.errorf("%q must not be negative", key)
+	}
+	return n, nil
+}
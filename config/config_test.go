@@ -0,0 +1,327 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+const (
+	uuid1 = "9B4E2C9A-5D6E-4C1F-8A2B-3C4D5E6F7A8B"
+	uuid2 = "0A1B2C3D-4E5F-6A7B-8C9D-0E1F2A3B4C5D"
+)
+
+func TestParse(t *testing.T) {
+	input := `
+# Comment.
+[job "offsite"]
+source = /Volumes/Data
+parallel = 2
+create-snapshot = true
+hook-pre-validate = "echo \"starting\""
+prune = true
+keep-daily = 7
+notify-webhook = https://example.com/hook
+notify-email = a@example.com, b@example.com
+smtp-server = smtp.example.com:587
+smtp-from = backup@example.com
+notify-macos = true
+
+[target "vault"]
+uuid = ` + uuid1 + `
+keep-daily = 30
+max-age = 720h
+hook-post-restore = say done
+
+; Another comment.
+[target usb]
+uuid = ` + uuid2 + `
+prune = false
+verify = false
+verify-hashes = true
+
+[job local]
+source = 11111111-2222-3333-4444-555555555555
+snapshot = name:weekly
+schedule = daily 03:00
+log = /tmp/local.log
+metrics = /tmp/local.prom
+[target "a"]
+uuid = ` + uuid1 + `
+catchup = true
+`
+	want := Config{
+		Jobs: []Job{
+			{
+				Name:            "offsite",
+				Line:            3,
+				Source:          "/Volumes/Data",
+				Parallel:        2,
+				CreateSnapshot:  true,
+				Snapshot:        "latest",
+				PreValidateHook: `echo "starting"`,
+				NotifyWebhook:   "https://example.com/hook",
+				NotifyMacOS:     true,
+				NotifyEmail:     []string{"a@example.com", "b@example.com"},
+				SMTPServer:      "smtp.example.com:587",
+				SMTPFrom:        "backup@example.com",
+				Targets: []Target{
+					{
+						Alias:  "vault",
+						Line:   16,
+						Volume: uuid1,
+						TargetOptions: TargetOptions{
+							Prune:           true,
+							KeepDaily:       30,
+							MaxAge:          720 * time.Hour,
+							Verify:          true,
+							PostRestoreHook: "say done",
+						},
+					},
+					{
+						Alias:  "usb",
+						Line:   23,
+						Volume: uuid2,
+						TargetOptions: TargetOptions{
+							KeepDaily:    7,
+							VerifyHashes: true,
+						},
+					},
+				},
+			},
+			{
+				Name:     "local",
+				Line:     29,
+				Source:   "11111111-2222-3333-4444-555555555555",
+				Parallel: 1,
+				Snapshot: "name:weekly",
+				Schedule: "daily 03:00",
+				Log:      "/tmp/local.log",
+				Metrics:  "/tmp/local.prom",
+				Targets: []Target{
+					{
+						Alias:  "a",
+						Line:   35,
+						Volume: uuid1,
+						TargetOptions: TargetOptions{
+							CatchUp: true,
+							Verify:  true,
+						},
+					},
+				},
+			},
+		},
+	}
+
+	got, err := Parse(strings.NewReader(input), "test.conf")
+	if err != nil {
+		t.Fatalf("Parse() returned error: %v", err)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Parse() returned unexpected config (-want +got):\n%s", diff)
+	}
+}
+
+func TestParse_Errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		wantErr string
+	}{
+		{
+			name:    "key before section",
+			input:   "source = /Volumes/Data\n",
+			wantErr: `test.conf:1: "source" is not in a job or target section`,
+		},
+		{
+			name:    "malformed line",
+			input:   "[job a]\nsource\n",
+			wantErr: `test.conf:2: expected a section header or key = value, got "source"`,
+		},
+		{
+			name:    "malformed section",
+			input:   "[job a b]\n",
+			wantErr: `test.conf:1: invalid section header "[job a b]"`,
+		},
+		{
+			name:    "unnamed section",
+			input:   "[job]\n",
+			wantErr: `test.conf:1: job section must have a name, e.g. [job "name"]`,
+		},
+		{
+			name:    "unknown section",
+			input:   "[volume a]\n",
+			wantErr: `test.conf:1: unknown section type "volume", want: job or target`,
+		},
+		{
+			name:    "target before job",
+			input:   "\n[target a]\n",
+			wantErr: `test.conf:2: target "a" must follow a job section`,
+		},
+		{
+			name:    "unknown key",
+			input:   "[job a]\nsource = /\ninitialize = true\n",
+			wantErr: `test.conf:3: unknown key "initialize"`,
+		},
+		{
+			name:    "duplicate key",
+			input:   "[job a]\nsource = /\nsource = /\n",
+			wantErr: `test.conf:3: "source" is set more than once in this section`,
+		},
+		{
+			name:    "invalid quoted value",
+			input:   "[job a]\nsource = \"/\n",
+			wantErr: `test.conf:2: invalid quoted value for "source": "/`,
+		},
+		{
+			name:    "invalid boolean",
+			input:   "[job a]\nprune = yes\n",
+			wantErr: `test.conf:2: invalid boolean for "prune": "yes"`,
+		},
+		{
+			name:    "invalid integer",
+			input:   "[job a]\nparallel = two\n",
+			wantErr: `test.conf:2: invalid integer for "parallel": "two"`,
+		},
+		{
+			name:    "parallel less than 1",
+			input:   "[job a]\nparallel = 0\n",
+			wantErr: `test.conf:2: parallel must be at least 1`,
+		},
+		{
+			name:    "negative retention",
+			input:   "[job a]\n[target b]\nkeep-last = -1\n",
+			wantErr: `test.conf:3: "keep-last" must not be negative`,
+		},
+		{
+			name:    "invalid duration",
+			input:   "[job a]\nmax-age = 30d\n",
+			wantErr: `test.conf:2: invalid duration for "max-age": "30d"`,
+		},
+		{
+			name:    "invalid snapshot",
+			input:   "[job a]\nsnapshot = newest\n",
+			wantErr: `test.conf:2: invalid snapshot: `,
+		},
+		{
+			name:    "invalid schedule",
+			input:   "[job a]\nschedule = daily 25:00\n",
+			wantErr: `test.conf:2: invalid schedule "daily 25:00"`,
+		},
+		{
+			name:    "invalid webhook",
+			input:   "[job a]\nnotify-webhook = example.com/hook\n",
+			wantErr: `test.conf:2: invalid notify-webhook "example.com/hook", want an http:// or https:// URL`,
+		},
+		{
+			name:    "invalid email",
+			input:   "[job a]\nnotify-email = a@example.com, nobody\n",
+			wantErr: `test.conf:2: invalid notify-email address "nobody"`,
+		},
+		{
+			name:    "invalid smtp server",
+			input:   "[job a]\nsmtp-server = smtp.example.com\n",
+			wantErr: `test.conf:2: invalid smtp-server "smtp.example.com", want host:port`,
+		},
+		{
+			name:    "email without smtp server",
+			input:   "[job a]\nsource = /\nnotify-email = a@example.com\n[target b]\nuuid = " + uuid1 + "\n",
+			wantErr: `test.conf:1: notify-email requires smtp-server and smtp-from`,
+		},
+		{
+			name:    "invalid uuid",
+			input:   "[job a]\n[target b]\nuuid = /Volumes/Backup\n",
+			wantErr: `test.conf:3: invalid uuid "/Volumes/Backup"`,
+		},
+		{
+			name:    "uuid in job",
+			input:   "[job a]\nuuid = " + uuid1 + "\n",
+			wantErr: `test.conf:2: uuid must be set in a target section`,
+		},
+		{
+			name:    "job key in target",
+			input:   "[job a]\n[target b]\nparallel = 2\n",
+			wantErr: `test.conf:3: "parallel" must be set in a job section`,
+		},
+		{
+			name:    "duplicate job",
+			input:   "[job a]\nsource = /\n[target b]\nuuid = " + uuid1 + "\n[job a]\n",
+			wantErr: `test.conf:5: job "a" is defined more than once`,
+		},
+		{
+			name:    "duplicate target",
+			input:   "[job a]\n[target b]\n[target b]\n",
+			wantErr: `test.conf:3: target "b" is defined more than once in job "a"`,
+		},
+		{
+			name:    "job without source",
+			input:   "\n[job a]\n[target b]\nuuid = " + uuid1 + "\n",
+			wantErr: `test.conf:2: job "a" has no source`,
+		},
+		{
+			name:    "job without targets",
+			input:   "[job a]\nsource = /\n",
+			wantErr: `test.conf:1: job "a" has no targets`,
+		},
+		{
+			name:    "target without uuid",
+			input:   "[job a]\nsource = /\n[target b]\nprune = true\n",
+			wantErr: `test.conf:3: target "b" has no uuid`,
+		},
+		{
+			name:    "duplicate uuid",
+			input:   "[job a]\nsource = /\n[target b]\nuuid = " + uuid1 + "\n[target c]\nuuid = " + strings.ToLower(uuid1) + "\n",
+			wantErr: `test.conf:5: target "c" has the same uuid as target "b"`,
+		},
+		{
+			name:    "create-snapshot and snapshot",
+			input:   "[job a]\nsource = /\ncreate-snapshot = true\nsnapshot = name:x\n[target b]\nuuid = " + uuid1 + "\n",
+			wantErr: `test.conf:1: create-snapshot and snapshot are incompatible`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := Parse(strings.NewReader(test.input), "test.conf")
+			if err == nil {
+				t.Fatalf("Parse() returned no error, want: %s", test.wantErr)
+			}
+			var cerr *Error
+			if !errors.As(err, &cerr) {
+				t.Errorf("Parse() returned %T, want *Error", err)
+			}
+			if !strings.HasPrefix(err.Error(), test.wantErr) {
+				t.Errorf("Parse() returned error %q, want: %q", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "offsite.conf")
+	if err := os.WriteFile(path, []byte("[job a]\nsource = /\n[target b]\nuuid = "+uuid1+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	conf, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() returned error: %v", err)
+	}
+	job, ok := conf.Job("a")
+	if !ok {
+		t.Fatalf("Job(%q) returned false, want true", "a")
+	}
+	if got := len(job.Targets); got != 1 {
+		t.Errorf("job %q has %d targets, want 1", job.Name, got)
+	}
+	if _, ok := conf.Job("b"); ok {
+		t.Errorf("Job(%q) returned true, want false", "b")
+	}
+
+	if _, err := Load(filepath.Join(t.TempDir(), "missing.conf")); err == nil {
+		t.Error("Load() of a missing file returned no error")
+	}
+}
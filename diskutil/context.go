@@ -0,0 +1,49 @@
+package diskutil
+
+import "context"
+
+// WithContext returns du as a ContextDiskUtil. If du doesn't implement
+// ContextDiskUtil, the Context variants of the returned DiskUtil's methods
+// return ctx's error if ctx is already done, and otherwise call du's methods,
+// which run to completion. WithContext returns nil if du is nil.
+func WithContext(du DiskUtil) ContextDiskUtil {
+	if du == nil {
+		return nil
+	}
+	if cdu, ok := du.(ContextDiskUtil); ok {
+		return cdu
+	}
+	return contextDiskUtil{du}
+}
+
+type contextDiskUtil struct {
+	DiskUtil
+}
+
+func (du contextDiskUtil) InfoContext(ctx context.Context, volume string) (VolumeInfo, error) {
+	if err := ctx.Err(); err != nil {
+		return VolumeInfo{}, err
+	}
+	return du.Info(volume)
+}
+
+func (du contextDiskUtil) RenameContext(ctx context.Context, volume VolumeInfo, name string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return du.Rename(volume, name)
+}
+
+func (du contextDiskUtil) ListSnapshotsContext(ctx context.Context, volume VolumeInfo) ([]Snapshot, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return du.ListSnapshots(volume)
+}
+
+func (du contextDiskUtil) DeleteSnapshotContext(ctx context.Context, volume VolumeInfo, snap Snapshot) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return du.DeleteSnapshot(volume, snap)
+}
@@ -4,10 +4,13 @@ package diskutil
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"os/exec"
 	"regexp"
 	"sort"
+	"strings"
 	"time"
 
 	"github.com/voidingwarranties/offsite-apfs-backup/plutil"
@@ -21,14 +24,30 @@ type DiskUtil interface {
 	DeleteSnapshot(volume VolumeInfo, snap Snapshot) error
 }
 
+// ContextDiskUtil is a DiskUtil with variants of its methods that stop early
+// if ctx is done: diskutil is killed and the method returns an error. The
+// DiskUtils returned by New and NewDryRun implement ContextDiskUtil. See
+// WithContext.
+type ContextDiskUtil interface {
+	DiskUtil
+	InfoContext(ctx context.Context, volume string) (VolumeInfo, error)
+	RenameContext(ctx context.Context, volume VolumeInfo, name string) error
+	ListSnapshotsContext(ctx context.Context, volume VolumeInfo) ([]Snapshot, error)
+	DeleteSnapshotContext(ctx context.Context, volume VolumeInfo, snap Snapshot) error
+}
+
+// ErrVolumeNotFound is wrapped by errors of DiskUtil's methods if the volume
+// doesn't exist, e.g. because it's not attached. Test for it with errors.Is.
+var ErrVolumeNotFound = errors.New("volume not found")
+
 type diskUtil struct {
-	execCommand func(string, ...string) *exec.Cmd
+	execCommand func(context.Context, string, ...string) *exec.Cmd
 	pl          plutil.PLUtil
 }
 
 type option func(*diskUtil)
 
-func withExecCommand(f func(string, ...string) *exec.Cmd) option {
+func withExecCommand(f func(context.Context, string, ...string) *exec.Cmd) option {
 	return func(du *diskUtil) {
 		du.execCommand = f
 	}
@@ -43,7 +62,7 @@ func withPLUtil(pl plutil.PLUtil) option {
 // New returns a new DiskUtil.
 func New(opts ...option) DiskUtil {
 	du := diskUtil{
-		execCommand: exec.Command,
+		execCommand: exec.CommandContext,
 		pl:          plutil.New(),
 	}
 	for _, opt := range opts {
@@ -71,7 +90,12 @@ type VolumeInfo struct {
 // Info returns the VolumeInfo of volume. Volume may be a volume name, UUID,
 // mount point, or device node.
 func (du diskUtil) Info(volume string) (VolumeInfo, error) {
-	cmd := du.execCommand("diskutil", "info", "-plist", volume)
+	return du.InfoContext(context.Background(), volume)
+}
+
+// InfoContext is like Info, but kills diskutil if ctx is done first.
+func (du diskUtil) InfoContext(ctx context.Context, volume string) (VolumeInfo, error) {
+	cmd := du.execCommand(ctx, "diskutil", "info", "-plist", volume)
 	var info VolumeInfo
 	err := du.runAndDecodePlist(cmd, &info)
 	return info, err
@@ -79,19 +103,36 @@ func (du diskUtil) Info(volume string) (VolumeInfo, error) {
 
 // Rename volume to name.
 func (du diskUtil) Rename(volume VolumeInfo, name string) error {
-	cmd := du.execCommand("diskutil", "rename", volume.Device, name)
+	return du.RenameContext(context.Background(), volume, name)
+}
+
+// RenameContext is like Rename, but kills diskutil if ctx is done first.
+func (du diskUtil) RenameContext(ctx context.Context, volume VolumeInfo, name string) error {
+	cmd := du.execCommand(ctx, "diskutil", "rename", volume.Device, name)
 	stderr := new(bytes.Buffer)
 	cmd.Stderr = stderr
 	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("`%s` failed (%w) with stderr: %s", cmd, err, stderr)
+		return fmt.Errorf("`%s` failed %w", cmd, stderrError{stderr: stderr.String(), cmdErr: err})
 	}
 	return nil
 }
 
 // Snapshot describes an APFS volume's snapshot.
 type Snapshot struct {
-	Name    string    `json:"SnapshotName"`
-	UUID    string    `json:"SnapshotUUID"`
+	Name string `json:"SnapshotName"`
+	UUID string `json:"SnapshotUUID"`
+	// XID is the APFS transaction ID at which the snapshot was taken. A
+	// more recent snapshot of a volume has a greater XID. XIDs of different
+	// volumes can't be compared.
+	XID uint64 `json:"SnapshotXID,omitempty"`
+	// Purgeable is true if macOS may delete the snapshot to free space.
+	Purgeable bool `json:"Purgeable,omitempty"`
+	// LimitingContainerShrink is true if the snapshot prevents the APFS
+	// container from being resized smaller.
+	LimitingContainerShrink bool `json:"LimitingContainerShrink,omitempty"`
+	// Created is parsed from a timestamp of the form yyyy-mm-dd-hhmmss in
+	// the snapshot's name, in local time, as Time Machine names snapshots.
+	// Zero if the name has no such timestamp.
 	Created time.Time `json:"-"`
 }
 
@@ -100,10 +141,17 @@ func (s Snapshot) String() string {
 }
 
 // ListSnapshots returns a volume's APFS snapshots. The snapshots are returned
-// in the order of most recent snapshot first. Note that this is the reverse of
-// the order returned by 'diskutil apfs listsnapshots`.
+// in the order of most recent snapshot first, i.e. by descending XID. Note
+// that this is the reverse of the order returned by 'diskutil apfs
+// listsnapshots`, which is used instead if any snapshot has no XID.
 func (du diskUtil) ListSnapshots(volume VolumeInfo) ([]Snapshot, error) {
-	cmd := du.execCommand("diskutil", "apfs", "listsnapshots", "-plist", volume.Device)
+	return du.ListSnapshotsContext(context.Background(), volume)
+}
+
+// ListSnapshotsContext is like ListSnapshots, but kills diskutil if ctx is
+// done first.
+func (du diskUtil) ListSnapshotsContext(ctx context.Context, volume VolumeInfo) ([]Snapshot, error) {
+	cmd := du.execCommand(ctx, "diskutil", "apfs", "listsnapshots", "-plist", volume.Device)
 	var snapshotList struct {
 		Snapshots []Snapshot `json:"Snapshots"`
 	}
@@ -112,60 +160,51 @@ func (du diskUtil) ListSnapshots(volume VolumeInfo) ([]Snapshot, error) {
 		return nil, err
 	}
 
-	// TODO: document why we sort here.
 	var snapshots []Snapshot
-	for _, snap := range snapshotList.Snapshots {
-		created, err := parseTimeFromSnapshotName(snap.Name)
-		if err != nil {
-			return nil, err
+	hasXIDs := true
+	for i := len(snapshotList.Snapshots) - 1; i >= 0; i-- {
+		snap := snapshotList.Snapshots[i]
+		snap.Created = parseTimeFromSnapshotName(snap.Name)
+		if snap.XID == 0 {
+			hasXIDs = false
 		}
-		snap.Created = created
 		snapshots = append(snapshots, snap)
 	}
-	isSorted := sort.SliceIsSorted(snapshots, func(i, ii int) bool {
-		lhs := snapshots[i]
-		rhs := snapshots[ii]
-		return lhs.Created.Before(rhs.Created)
-	})
-	if !isSorted {
-		return nil, validationError{
-			fmt.Errorf("`%s` returned snapshots in an unexpected order", cmd),
-		}
-	}
-	for i, ii := 0, len(snapshots)-1; i < ii; i, ii = i+1, ii-1 {
-		snapshots[i], snapshots[ii] = snapshots[ii], snapshots[i]
+	// Snapshot names are arbitrary, and several snapshots may be taken in
+	// the same second, so order by XID rather than by Created.
+	if hasXIDs {
+		sort.SliceStable(snapshots, func(i, ii int) bool {
+			return snapshots[i].XID > snapshots[ii].XID
+		})
 	}
 	return snapshots, nil
 }
 
-type validationError struct {
-	error
-}
+var snapshotTimeRegex = regexp.MustCompile(`\d{4}-\d{2}-\d{2}-\d{6}`)
 
-func parseTimeFromSnapshotName(name string) (time.Time, error) {
-	timeRegex := regexp.MustCompile(`\d{4}-\d{2}-\d{2}-\d{6}`)
-	timeMatch := timeRegex.FindString(name)
-	if len(timeMatch) == 0 {
-		return time.Time{}, validationError{
-			fmt.Errorf("snapshot name (%q) does not contain a timestamp of the form yyyy-mm-dd-hhmmss", name),
-		}
-	}
-	created, err := time.Parse("2006-01-02-150405", string(timeMatch))
+// parseTimeFromSnapshotName returns the time of the first yyyy-mm-dd-hhmmss
+// timestamp in name, in local time, or the zero time if there is none.
+func parseTimeFromSnapshotName(name string) time.Time {
+	created, err := time.ParseInLocation("2006-01-02-150405", snapshotTimeRegex.FindString(name), time.Local)
 	if err != nil {
-		return time.Time{}, validationError{
-			fmt.Errorf("failed to parse time substring (%q) from snapshot name", timeMatch),
-		}
+		return time.Time{}
 	}
-	return created, nil
+	return created
 }
 
 // DeleteSnapshot removes the given snapshot from the given volume.
 func (du diskUtil) DeleteSnapshot(volume VolumeInfo, snap Snapshot) error {
-	cmd := du.execCommand("diskutil", "apfs", "deletesnapshot", volume.Device, "-uuid", snap.UUID)
+	return du.DeleteSnapshotContext(context.Background(), volume, snap)
+}
+
+// DeleteSnapshotContext is like DeleteSnapshot, but kills diskutil if ctx is
+// done first.
+func (du diskUtil) DeleteSnapshotContext(ctx context.Context, volume VolumeInfo, snap Snapshot) error {
+	cmd := du.execCommand(ctx, "diskutil", "apfs", "deletesnapshot", volume.Device, "-uuid", snap.UUID)
 	stderr := new(bytes.Buffer)
 	cmd.Stderr = stderr
 	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("`%s` failed (%w) with stderr: %s", cmd, err, stderr)
+		return fmt.Errorf("`%s` failed %w", cmd, stderrError{stderr: stderr.String(), cmdErr: err})
 	}
 	return nil
 }
@@ -182,7 +221,7 @@ func (du diskUtil) runAndDecodePlist(cmd *exec.Cmd, v interface{}) error {
 			return fmt.Errorf("`%s` failed %w", cmd, plistErr)
 		}
 		if exitErr, ok := err.(*exec.ExitError); ok {
-			return fmt.Errorf("`%s` failed (%w) with stderr: %s", cmd, err, exitErr.Stderr)
+			return fmt.Errorf("`%s` failed %w", cmd, stderrError{stderr: string(exitErr.Stderr), cmdErr: err})
 		}
 		return fmt.Errorf("`%s` failed (%w)", cmd, err)
 	}
@@ -205,7 +244,97 @@ func (err plistError) Unwrap() error {
 	return err.cmdErr
 }
 
+func (err plistError) Is(target error) bool {
+	return target == ErrVolumeNotFound && isNotFoundMessage(err.message)
+}
+
 type plistErrorMessage struct {
 	IsError bool   `json:"Error"`
 	Message string `json:"ErrorMessage"`
 }
+
+type stderrError struct {
+	stderr string
+	cmdErr error
+}
+
+func (err stderrError) Error() string {
+	return fmt.Sprintf("(%s) with stderr: %s", err.cmdErr, err.stderr)
+}
+
+func (err stderrError) Unwrap() error {
+	return err.cmdErr
+}
+
+func (err stderrError) Is(target error) bool {
+	return target == ErrVolumeNotFound && isNotFoundMessage(err.stderr)
+}
+
+// notFoundMessages are parts of diskutil error messages, in lower case, of
+// failures caused by a volume that doesn't exist.
+var notFoundMessages = []string{
+	"could not find disk",
+	"unable to find disk",
+}
+
+func isNotFoundMessage(message string) bool {
+	return containsAny(strings.ToLower(message), notFoundMessages)
+}
+
+// transientMessages are parts of diskutil error messages, in lower case, of
+// failures that may succeed if retried, e.g. once Spotlight stops indexing a
+// newly mounted volume.
+var transientMessages = []string{
+	"resource busy",
+	"resource temporarily unavailable",
+	"timed out",
+	"try again",
+	"unable to unmount",
+	"couldn't unmount",
+	"could not unmount",
+}
+
+// transientExitCodes are exit codes of diskutil failures that may succeed if
+// retried. diskutil exits with an errno for some failures: EBUSY, and EAGAIN
+// and ETIMEDOUT as numbered on macOS.
+var transientExitCodes = map[int]bool{
+	16: true,
+	35: true,
+	60: true,
+}
+
+// IsTransient returns true if err, returned by a DiskUtil, is a failure that
+// may succeed if retried, based on diskutil's error message or exit code.
+// Errors caused by a done context are never transient.
+func IsTransient(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var message string
+	var plistErr plistError
+	var stdErr stderrError
+	switch {
+	case errors.As(err, &plistErr):
+		message = plistErr.message
+	case errors.As(err, &stdErr):
+		message = stdErr.stderr
+	}
+	if isTransientMessage(message) {
+		return true
+	}
+	var exitErr *exec.ExitError
+	return errors.As(err, &exitErr) && transientExitCodes[exitErr.ExitCode()]
+}
+
+func isTransientMessage(message string) bool {
+	return containsAny(strings.ToLower(message), transientMessages)
+}
+
+func containsAny(s string, substrs []string) bool {
+	for _, substr := range substrs {
+		if strings.Contains(s, substr) {
+			return true
+		}
+	}
+	return false
+}
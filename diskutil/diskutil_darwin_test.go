@@ -86,7 +86,7 @@ func TestListSnapshots(t *testing.T) {
 		t.Fatalf("ListSnapshots returned unexpected error: %v, want: nil", err)
 	}
 	want := diskimage.SourceImg.Snapshots(t)[:]
-	if diff := cmp.Diff(want, got); diff != "" {
+	if diff := cmp.Diff(want, got, diskimage.IgnoreSnapshotState); diff != "" {
 		t.Errorf("ListSnapshots returned unexpected snapshots: -want +got:\n%s", diff)
 	}
 }
@@ -136,7 +136,7 @@ func TestDeleteSnapshot(t *testing.T) {
 		t.Fatalf("ListSnapshots returned unexpected error: %v, want: nil", err)
 	}
 	want := diskimage.SourceImg.Snapshots(t)[:1]
-	if diff := cmp.Diff(want, got); diff != "" {
+	if diff := cmp.Diff(want, got, diskimage.IgnoreSnapshotState); diff != "" {
 		t.Errorf("DeleteSnapshot resulted in unexpected snapshots. -want +got:\n%s", diff)
 	}
 
@@ -149,7 +149,7 @@ func TestDeleteSnapshot(t *testing.T) {
 		t.Fatalf("ListSnapshots returned unexpected error: %v, want: nil", err)
 	}
 	want = nil
-	if diff := cmp.Diff(want, got); diff != "" {
+	if diff := cmp.Diff(want, got, diskimage.IgnoreSnapshotState); diff != "" {
 		t.Errorf("DeleteSnapshot resulted in unexpected snapshots. -want +got:\n%s", diff)
 	}
 }
@@ -1,6 +1,7 @@
 package diskutil
 
 import (
+	"context"
 	"errors"
 	"os/exec"
 	"reflect"
@@ -18,13 +19,12 @@ func TestHelperProcess(t *testing.T) {
 	fakecmd.HelperProcess(t)
 }
 
-func newWithFakeCmd(t *testing.T, opts ...fakecmd.Option) DiskUtil {
-	execCmd := fakecmd.FakeCommand(t, opts...)
-	pl := plutil.New(plutil.WithExecCommand(execCmd))
+func newWithFakeCmd(t *testing.T, opts ...fakecmd.Option) ContextDiskUtil {
+	pl := plutil.New(plutil.WithExecCommand(fakecmd.FakeCommand(t, opts...)))
 	return New(
-		withExecCommand(execCmd),
+		withExecCommand(fakecmd.FakeCommandContext(t, opts...)),
 		withPLUtil(pl),
-	)
+	).(ContextDiskUtil)
 }
 
 func TestInfo(t *testing.T) {
@@ -227,6 +227,83 @@ func TestListSnapshots(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "ordered by XID",
+			opts: []fakecmd.Option{
+				fakecmd.Stdout("diskutil", "<plist diskutil output>"),
+				fakecmd.Stdout("plutil", `{
+					"Snapshots": [
+						{
+							"SnapshotName": "manual-snapshot",
+							"SnapshotUUID": "manual-snapshot-uuid",
+							"SnapshotXID": 120,
+							"Purgeable": false,
+							"LimitingContainerShrink": true
+						},
+						{
+							"SnapshotName": "foo-snapshot-name-2021-03-02-012345",
+							"SnapshotUUID": "foo-snapshot-uuid",
+							"SnapshotXID": 200,
+							"Purgeable": true,
+							"LimitingContainerShrink": false
+						},
+						{
+							"SnapshotName": "bar-snapshot-name-2021-03-02-012345",
+							"SnapshotUUID": "bar-snapshot-uuid",
+							"SnapshotXID": 150,
+							"Purgeable": true,
+							"LimitingContainerShrink": false
+						}
+					]
+				}`),
+				fakecmd.WantStdin("plutil", "<plist diskutil output>"),
+			},
+			want: []Snapshot{
+				{
+					Name:      "foo-snapshot-name-2021-03-02-012345",
+					UUID:      "foo-snapshot-uuid",
+					XID:       200,
+					Purgeable: true,
+					Created:   time.Date(2021, 3, 2, 1, 23, 45, 0, time.UTC),
+				},
+				{
+					Name:      "bar-snapshot-name-2021-03-02-012345",
+					UUID:      "bar-snapshot-uuid",
+					XID:       150,
+					Purgeable: true,
+					Created:   time.Date(2021, 3, 2, 1, 23, 45, 0, time.UTC),
+				},
+				{
+					Name:                    "manual-snapshot",
+					UUID:                    "manual-snapshot-uuid",
+					XID:                     120,
+					LimitingContainerShrink: true,
+				},
+			},
+		},
+		{
+			name: "invalid time in name",
+			opts: []fakecmd.Option{
+				fakecmd.Stdout("diskutil", "<plist diskutil output>"),
+				fakecmd.Stdout("plutil", `{
+					"Snapshots": [
+						{
+							"SnapshotName": "foo-snapshot-name-2021-13-01-000000",
+							"SnapshotUUID": "foo-snapshot-uuid",
+							"SnapshotXID": 100
+						}
+					]
+				}`),
+				fakecmd.WantStdin("plutil", "<plist diskutil output>"),
+			},
+			want: []Snapshot{
+				{
+					Name: "foo-snapshot-name-2021-13-01-000000",
+					UUID: "foo-snapshot-uuid",
+					XID:  100,
+				},
+			},
+		},
 		{
 			name: "no snapshots",
 			opts: []fakecmd.Option{
@@ -259,6 +336,19 @@ func TestListSnapshots(t *testing.T) {
 	}
 }
 
+func TestParseTimeFromSnapshotName_LocalTime(t *testing.T) {
+	// Time Machine names snapshots with the local time.
+	local := time.Local
+	t.Cleanup(func() { time.Local = local })
+	time.Local = time.FixedZone("UTC+10", 10*60*60)
+
+	got := parseTimeFromSnapshotName("com.apple.TimeMachine.2021-03-01-203509.local")
+	want := time.Date(2021, 3, 1, 20, 35, 9, 0, time.Local)
+	if !got.Equal(want) {
+		t.Errorf("parseTimeFromSnapshotName(...) = %v, want: %v", got, want)
+	}
+}
+
 func TestListSnapshots_IDsVolumesByDevice(t *testing.T) {
 	du := newWithFakeCmd(t,
 		fakecmd.Stdout("plutil", `{
@@ -277,65 +367,12 @@ func TestListSnapshots_IDsVolumesByDevice(t *testing.T) {
 
 func TestListSnapshots_Errors(t *testing.T) {
 	var exitErr *exec.ExitError
-	var validationErr validationError
 
 	tests := []struct {
 		name      string
 		opts      []fakecmd.Option
 		wantErrAs interface{}
 	}{
-		{
-			name: "snapshots in unexpected order",
-			opts: []fakecmd.Option{
-				fakecmd.Stdout("diskutil", "foo-stdout"),
-				fakecmd.Stdout("plutil", `{
-					"Snapshots": [
-						{
-							"SnapshotName": "bar-snapshot-name-2021-04-03-012345",
-							"SnapshotUUID": "bar-snapshot-uuid"
-						},
-						{
-							"SnapshotName": "foo-snapshot-name-2021-03-02-012345",
-							"SnapshotUUID": "foo-snapshot-uuid"
-						}
-					]
-				}`),
-				fakecmd.WantStdin("plutil", "foo-stdout"),
-			},
-			wantErrAs: &validationErr,
-		},
-		{
-			name: "no time in name",
-			opts: []fakecmd.Option{
-				fakecmd.Stdout("diskutil", "foo-stdout"),
-				fakecmd.Stdout("plutil", `{
-					"Snapshots": [
-						{
-							"SnapshotName": "foo-snapshot-name",
-							"SnapshotUUID": "foo-snapshot-uuid"
-						}
-					]
-				}`),
-				fakecmd.WantStdin("plutil", "foo-stdout"),
-			},
-			wantErrAs: &validationErr,
-		},
-		{
-			name: "invalid time in name",
-			opts: []fakecmd.Option{
-				fakecmd.Stdout("diskutil", "foo-stdout"),
-				fakecmd.Stdout("plutil", `{
-					"Snapshots": [
-						{
-							"SnapshotName": "foo-snapshot-name-2021-13-01-000000",
-							"SnapshotUUID": "foo-snapshot-uuid"
-						}
-					]
-				}`),
-				fakecmd.WantStdin("plutil", "foo-stdout"),
-			},
-			wantErrAs: &validationErr,
-		},
 		{
 			name: "diskutil exec errors",
 			opts: []fakecmd.Option{
@@ -411,6 +448,20 @@ func TestRename_Errors(t *testing.T) {
 	}
 }
 
+func TestRename_Canceled(t *testing.T) {
+	du := newWithFakeCmd(t, fakecmd.Block("diskutil"))
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := du.RenameContext(ctx, exampleVolumeInfo, "newname")
+	if err == nil {
+		t.Error("RenameContext returned error: nil, want: non-nil")
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("RenameContext returned after %v, want: soon after ctx was done", elapsed)
+	}
+}
+
 func TestDeleteSnapshot(t *testing.T) {
 	du := newWithFakeCmd(t)
 	err := du.DeleteSnapshot(exampleVolumeInfo, Snapshot{
@@ -457,3 +508,168 @@ func TestDeleteSnapshot_Errors(t *testing.T) {
 		t.Errorf("DeleteSnapshot returned unexpected error: %v, want type: *exec.ExitError", err)
 	}
 }
+
+func TestIsTransient(t *testing.T) {
+	info := func(du DiskUtil) error {
+		_, err := du.Info("/example/volume")
+		return err
+	}
+	rename := func(du DiskUtil) error {
+		return du.Rename(exampleVolumeInfo, "newname")
+	}
+	tests := []struct {
+		name string
+		call func(DiskUtil) error
+		opts []fakecmd.Option
+		want bool
+	}{
+		{
+			name: "plist error message is transient",
+			call: info,
+			opts: []fakecmd.Option{
+				fakecmd.Stdout("diskutil", "diskutil-plist-err"),
+				fakecmd.Stdout("plutil", `{"Error": true, "ErrorMessage": "Unable to unmount volume: Resource busy"}`),
+				fakecmd.WantStdin("plutil", "diskutil-plist-err"),
+				fakecmd.ExitFail("diskutil"),
+			},
+			want: true,
+		},
+		{
+			name: "plist error message is not transient",
+			call: info,
+			opts: []fakecmd.Option{
+				fakecmd.Stdout("diskutil", "diskutil-plist-err"),
+				fakecmd.Stdout("plutil", `{"Error": true, "ErrorMessage": "Could not find disk"}`),
+				fakecmd.WantStdin("plutil", "diskutil-plist-err"),
+				fakecmd.ExitFail("diskutil"),
+			},
+			want: false,
+		},
+		{
+			name: "stderr is transient",
+			call: rename,
+			opts: []fakecmd.Option{
+				fakecmd.Stderr("diskutil", "Failed to rename volume: Resource busy"),
+				fakecmd.ExitFail("diskutil"),
+			},
+			want: true,
+		},
+		{
+			name: "stderr is not transient",
+			call: rename,
+			opts: []fakecmd.Option{
+				fakecmd.Stderr("diskutil", "Could not find disk"),
+				fakecmd.ExitFail("diskutil"),
+			},
+			want: false,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.call(newWithFakeCmd(t, test.opts...))
+			if err := fakecmd.AsHelperProcessErr(err); err != nil {
+				t.Fatal(err)
+			}
+			if err == nil {
+				t.Fatal("got error: nil, want: non-nil")
+			}
+			if got := IsTransient(err); got != test.want {
+				t.Errorf("IsTransient(%v) = %t, want: %t", err, got, test.want)
+			}
+		})
+	}
+}
+
+func TestErrVolumeNotFound(t *testing.T) {
+	tests := []struct {
+		name string
+		call func(DiskUtil) error
+		opts []fakecmd.Option
+		want bool
+	}{
+		{
+			name: "info of missing volume",
+			call: func(du DiskUtil) error {
+				_, err := du.Info("/example/volume")
+				return err
+			},
+			opts: []fakecmd.Option{
+				fakecmd.Stdout("diskutil", "diskutil-plist-err"),
+				fakecmd.Stdout("plutil", `{"Error": true, "ErrorMessage": "Could not find disk: /example/volume"}`),
+				fakecmd.WantStdin("plutil", "diskutil-plist-err"),
+				fakecmd.ExitFail("diskutil"),
+			},
+			want: true,
+		},
+		{
+			name: "rename of missing volume",
+			call: func(du DiskUtil) error {
+				return du.Rename(exampleVolumeInfo, "newname")
+			},
+			opts: []fakecmd.Option{
+				fakecmd.Stderr("diskutil", "Could not find disk: /dev/example-volume"),
+				fakecmd.ExitFail("diskutil"),
+			},
+			want: true,
+		},
+		{
+			name: "other failure",
+			call: func(du DiskUtil) error {
+				return du.Rename(exampleVolumeInfo, "newname")
+			},
+			opts: []fakecmd.Option{
+				fakecmd.Stderr("diskutil", "Resource busy"),
+				fakecmd.ExitFail("diskutil"),
+			},
+			want: false,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.call(newWithFakeCmd(t, test.opts...))
+			if err := fakecmd.AsHelperProcessErr(err); err != nil {
+				t.Fatal(err)
+			}
+			if got := errors.Is(err, ErrVolumeNotFound); got != test.want {
+				t.Errorf("errors.Is(%v, ErrVolumeNotFound) = %t, want: %t", err, got, test.want)
+			}
+		})
+	}
+}
+
+// renameRecordingDiskUtil is a DiskUtil without Context variants of its
+// methods, that records renames.
+type renameRecordingDiskUtil struct {
+	DiskUtil
+	renamed []string
+}
+
+func (du *renameRecordingDiskUtil) Rename(volume VolumeInfo, name string) error {
+	du.renamed = append(du.renamed, name)
+	return nil
+}
+
+func isDiskUtil(du ContextDiskUtil) bool {
+	_, ok := du.(diskUtil)
+	return ok
+}
+
+func TestWithContext(t *testing.T) {
+	if got := WithContext(New()); !isDiskUtil(got) {
+		t.Errorf("WithContext(New()) = %T, want: New's DiskUtil unchanged", got)
+	}
+
+	du := &renameRecordingDiskUtil{}
+	cdu := WithContext(du)
+	if err := cdu.RenameContext(context.Background(), exampleVolumeInfo, "newname"); err != nil {
+		t.Errorf("RenameContext returned unexpected error: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := cdu.RenameContext(ctx, exampleVolumeInfo, "canceled"); !errors.Is(err, context.Canceled) {
+		t.Errorf("RenameContext with canceled ctx returned error: %v, want: %v", err, context.Canceled)
+	}
+	if diff := cmp.Diff([]string{"newname"}, du.renamed); diff != "" {
+		t.Errorf("RenameContext renamed volume unexpectedly. -want +got:\n%s", diff)
+	}
+}
@@ -1,7 +1,9 @@
 package diskutil
 
+import "context"
+
 type dryRun struct {
-	du DiskUtil
+	du ContextDiskUtil
 }
 
 // NewDryRun returns a DiskUtil that cannot modify any volumes. All
@@ -9,7 +11,7 @@ type dryRun struct {
 // underlying DiskUtil, du.
 func NewDryRun(du DiskUtil) DiskUtil {
 	return dryRun{
-		du: du,
+		du: WithContext(du),
 	}
 }
 
@@ -17,14 +19,30 @@ func (dry dryRun) Info(volume string) (VolumeInfo, error) {
 	return dry.du.Info(volume)
 }
 
+func (dry dryRun) InfoContext(ctx context.Context, volume string) (VolumeInfo, error) {
+	return dry.du.InfoContext(ctx, volume)
+}
+
 func (dry dryRun) Rename(volume VolumeInfo, name string) error {
 	return nil
 }
 
+func (dry dryRun) RenameContext(ctx context.Context, volume VolumeInfo, name string) error {
+	return nil
+}
+
 func (dry dryRun) ListSnapshots(volume VolumeInfo) ([]Snapshot, error) {
 	return dry.du.ListSnapshots(volume)
 }
 
+func (dry dryRun) ListSnapshotsContext(ctx context.Context, volume VolumeInfo) ([]Snapshot, error) {
+	return dry.du.ListSnapshotsContext(ctx, volume)
+}
+
 func (dry dryRun) DeleteSnapshot(volume VolumeInfo, snap Snapshot) error {
 	return nil
 }
+
+func (dry dryRun) DeleteSnapshotContext(ctx context.Context, volume VolumeInfo, snap Snapshot) error {
+	return nil
+}
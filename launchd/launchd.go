@@ -0,0 +1,205 @@
+// Package launchd implements generating launchd job definitions that run
+// backup jobs on a schedule, or whenever a volume is mounted.
+package launchd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/plutil"
+)
+
+// DaemonsDir is the directory of system-wide launchd daemons, which run as
+// root.
+const DaemonsDir = "/Library/LaunchDaemons"
+
+// Schedule describes when launchd starts a job. Exactly one of its fields is
+// set.
+type Schedule struct {
+	// Calendar starts the job at the given time.
+	Calendar *CalendarInterval
+	// Interval starts the job every Interval.
+	Interval time.Duration
+	// OnMount starts the job whenever a volume is mounted.
+	OnMount bool
+	// KeepAlive starts the job at boot, and restarts it whenever it exits.
+	// Used for long-running jobs.
+	KeepAlive bool
+}
+
+// CalendarInterval is a launchd StartCalendarInterval. Nil fields match
+// every value, like * in crontab.
+type CalendarInterval struct {
+	Minute *int `json:",omitempty"`
+	Hour   *int `json:",omitempty"`
+	// Weekday is 0 (Sunday) to 6 (Saturday).
+	Weekday *int `json:",omitempty"`
+}
+
+var weekdays = map[string]int{
+	"sun": 0,
+	"mon": 1,
+	"tue": 2,
+	"wed": 3,
+	"thu": 4,
+	"fri": 5,
+	"sat": 6,
+}
+
+// ParseSchedule parses a schedule of one of the forms:
+//   - "hourly": every hour, on the hour.
+//   - "daily HH:MM": every day at the given time.
+//   - "weekly <day> HH:MM": every week on the given day (sun, mon, ..., sat)
+//     at the given time.
+//   - "every <duration>": every given duration (e.g. 6h), which must be at
+//     least a minute.
+//   - "on-mount": whenever a volume is mounted.
+//   - "keep-alive": at boot, and whenever the job exits.
+func ParseSchedule(s string) (Schedule, error) {
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		return Schedule{}, fmt.Errorf("empty schedule")
+	}
+	invalid := func() (Schedule, error) {
+		return Schedule{}, fmt.Errorf("invalid schedule %q, want one of: hourly, daily HH:MM, weekly <day> HH:MM, every <duration>, on-mount, keep-alive", s)
+	}
+	switch fields[0] {
+	case "hourly":
+		if len(fields) != 1 {
+			return invalid()
+		}
+		return Schedule{Calendar: &CalendarInterval{Minute: intPtr(0)}}, nil
+	case "daily":
+		if len(fields) != 2 {
+			return invalid()
+		}
+		hour, minute, ok := parseTime(fields[1])
+		if !ok {
+			return invalid()
+		}
+		return Schedule{Calendar: &CalendarInterval{Hour: &hour, Minute: &minute}}, nil
+	case "weekly":
+		if len(fields) != 3 {
+			return invalid()
+		}
+		weekday, ok := weekdays[strings.ToLower(fields[1])]
+		if !ok {
+			return invalid()
+		}
+		hour, minute, ok := parseTime(fields[2])
+		if !ok {
+			return invalid()
+		}
+		return Schedule{Calendar: &CalendarInterval{Weekday: &weekday, Hour: &hour, Minute: &minute}}, nil
+	case "every":
+		if len(fields) != 2 {
+			return invalid()
+		}
+		d, err := time.ParseDuration(fields[1])
+		if err != nil || d < time.Minute {
+			return invalid()
+		}
+		return Schedule{Interval: d}, nil
+	case "on-mount":
+		if len(fields) != 1 {
+			return invalid()
+		}
+		return Schedule{OnMount: true}, nil
+	case "keep-alive":
+		if len(fields) != 1 {
+			return invalid()
+		}
+		return Schedule{KeepAlive: true}, nil
+	}
+	return invalid()
+}
+
+// parseTime parses a time of the form HH:MM.
+func parseTime(s string) (hour, minute int, ok bool) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 2 {
+		return 0, 0, false
+	}
+	hour, err := strconv.Atoi(parts[0])
+	if err != nil || hour < 0 || hour > 23 {
+		return 0, 0, false
+	}
+	minute, err = strconv.Atoi(parts[1])
+	if err != nil || minute < 0 || minute > 59 {
+		return 0, 0, false
+	}
+	return hour, minute, true
+}
+
+func intPtr(i int) *int {
+	return &i
+}
+
+// Job is a launchd job definition. See launchd.plist(5).
+type Job struct {
+	Label                 string
+	ProgramArguments      []string
+	StartCalendarInterval *CalendarInterval `json:",omitempty"`
+	StartInterval         int               `json:",omitempty"`
+	StartOnMount          bool              `json:",omitempty"`
+	RunAtLoad             bool              `json:",omitempty"`
+	KeepAlive             bool              `json:",omitempty"`
+	StandardOutPath       string            `json:",omitempty"`
+	StandardErrorPath     string            `json:",omitempty"`
+}
+
+// NewJob returns a Job labeled label that runs args on schedule, and appends
+// its stdout and stderr to log, if set.
+func NewJob(label string, args []string, schedule Schedule, log string) Job {
+	return Job{
+		Label:                 label,
+		ProgramArguments:      args,
+		StartCalendarInterval: schedule.Calendar,
+		StartInterval:         int(schedule.Interval / time.Second),
+		StartOnMount:          schedule.OnMount,
+		RunAtLoad:             schedule.KeepAlive,
+		KeepAlive:             schedule.KeepAlive,
+		StandardOutPath:       log,
+		StandardErrorPath:     log,
+	}
+}
+
+// Marshal returns j as an XML plist.
+func (j Job) Marshal() ([]byte, error) {
+	return plutil.Marshal(j)
+}
+
+// Install writes j to <dir>/<label>.plist, replacing any existing definition,
+// and returns the path written to. The job is not loaded; see launchctl(1).
+func Install(j Job, dir string) (string, error) {
+	data, err := j.Marshal()
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, j.Label+".plist")
+	tmp, err := os.CreateTemp(dir, j.Label+".*.tmp")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	// launchd ignores definitions that are writable by anyone but their
+	// owner.
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return "", err
+	}
+	return path, nil
+}
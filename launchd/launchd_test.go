@@ -0,0 +1,190 @@
+package launchd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseSchedule(t *testing.T) {
+	tests := []struct {
+		schedule string
+		want     Schedule
+		wantErr  bool
+	}{
+		{
+			schedule: "hourly",
+			want:     Schedule{Calendar: &CalendarInterval{Minute: intPtr(0)}},
+		},
+		{
+			schedule: "daily 03:05",
+			want:     Schedule{Calendar: &CalendarInterval{Hour: intPtr(3), Minute: intPtr(5)}},
+		},
+		{
+			schedule: "daily 00:00",
+			want:     Schedule{Calendar: &CalendarInterval{Hour: intPtr(0), Minute: intPtr(0)}},
+		},
+		{
+			schedule: "weekly Sat 23:59",
+			want:     Schedule{Calendar: &CalendarInterval{Weekday: intPtr(6), Hour: intPtr(23), Minute: intPtr(59)}},
+		},
+		{
+			schedule: "every 6h",
+			want:     Schedule{Interval: 6 * time.Hour},
+		},
+		{
+			schedule: "on-mount",
+			want:     Schedule{OnMount: true},
+		},
+		{
+			schedule: "keep-alive",
+			want:     Schedule{KeepAlive: true},
+		},
+		{schedule: "", wantErr: true},
+		{schedule: "hourly 5", wantErr: true},
+		{schedule: "daily", wantErr: true},
+		{schedule: "daily 24:00", wantErr: true},
+		{schedule: "daily 3pm", wantErr: true},
+		{schedule: "weekly someday 03:00", wantErr: true},
+		{schedule: "every 30s", wantErr: true},
+		{schedule: "every day", wantErr: true},
+		{schedule: "monthly", wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.schedule, func(t *testing.T) {
+			got, err := ParseSchedule(test.schedule)
+			if test.wantErr {
+				if err == nil {
+					t.Errorf("ParseSchedule(%q) returned no error, want error", test.schedule)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseSchedule(%q) returned unexpected error: %v", test.schedule, err)
+			}
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("ParseSchedule(%q) returned unexpected schedule. -want +got:\n%s", test.schedule, diff)
+			}
+		})
+	}
+}
+
+func TestJob_Marshal(t *testing.T) {
+	tests := []struct {
+		name     string
+		schedule Schedule
+		want     string
+	}{
+		{
+			name:     "calendar",
+			schedule: Schedule{Calendar: &CalendarInterval{Hour: intPtr(3), Minute: intPtr(0)}},
+			want: `	<key>StartCalendarInterval</key>
+	<dict>
+		<key>Minute</key>
+		<integer>0</integer>
+		<key>Hour</key>
+		<integer>3</integer>
+	</dict>
+`,
+		},
+		{
+			name:     "interval",
+			schedule: Schedule{Interval: time.Hour},
+			want: `	<key>StartInterval</key>
+	<integer>3600</integer>
+`,
+		},
+		{
+			name:     "on mount",
+			schedule: Schedule{OnMount: true},
+			want: `	<key>StartOnMount</key>
+	<true/>
+`,
+		},
+		{
+			name:     "keep alive",
+			schedule: Schedule{KeepAlive: true},
+			want: `	<key>RunAtLoad</key>
+	<true/>
+	<key>KeepAlive</key>
+	<true/>
+`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			j := NewJob("com.example.backup", []string{"/usr/local/bin/backup", "run", "offsite"}, test.schedule, "/var/log/backup.log")
+			want := `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>Label</key>
+	<string>com.example.backup</string>
+	<key>ProgramArguments</key>
+	<array>
+		<string>/usr/local/bin/backup</string>
+		<string>run</string>
+		<string>offsite</string>
+	</array>
+` + test.want + `	<key>StandardOutPath</key>
+	<string>/var/log/backup.log</string>
+	<key>StandardErrorPath</key>
+	<string>/var/log/backup.log</string>
+</dict>
+</plist>
+`
+			got, err := j.Marshal()
+			if err != nil {
+				t.Fatalf("Marshal returned unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(want, string(got)); diff != "" {
+				t.Errorf("Marshal returned unexpected plist. -want +got:\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestInstall(t *testing.T) {
+	dir := t.TempDir()
+	j := NewJob("com.example.backup", []string{"backup"}, Schedule{OnMount: true}, "")
+	path := filepath.Join(dir, "com.example.backup.plist")
+	if err := os.WriteFile(path, []byte("old"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := Install(j, dir)
+	if err != nil {
+		t.Fatalf("Install returned unexpected error: %v", err)
+	}
+	if got != path {
+		t.Errorf("Install returned path %q, want: %q", got, path)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := j.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(string(want), string(data)); diff != "" {
+		t.Errorf("Install wrote unexpected plist. -want +got:\n%s", diff)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0644 {
+		t.Errorf("Install wrote plist with permissions %v, want: %v", perm, os.FileMode(0644))
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("Install left %d files in dir, want 1", len(entries))
+	}
+}
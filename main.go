@@ -2,17 +2,34 @@ package main
 
 import (
 	"bufio"
-	"bytes"
+	"context"
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
 	"io"
+	"net"
+	"net/mail"
+	"net/smtp"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/voidingwarranties/offsite-apfs-backup/asr"
 	"github.com/voidingwarranties/offsite-apfs-backup/cloner"
+	"github.com/voidingwarranties/offsite-apfs-backup/config"
 	"github.com/voidingwarranties/offsite-apfs-backup/diskutil"
+	"github.com/voidingwarranties/offsite-apfs-backup/launchd"
+	"github.com/voidingwarranties/offsite-apfs-backup/metrics"
+	"github.com/voidingwarranties/offsite-apfs-backup/ndjson"
+	"github.com/voidingwarranties/offsite-apfs-backup/notify"
+	"github.com/voidingwarranties/offsite-apfs-backup/tmutil"
+	"github.com/voidingwarranties/offsite-apfs-backup/verify"
+	"github.com/voidingwarranties/offsite-apfs-backup/watch"
 )
 
 var (
@@ -25,11 +42,169 @@ If false (default), nondestructively clone the latest APFS snapshot in source to
 Incompatible with -prune.`)
 	dryrun = flag.Bool("dryrun", false, `If true, only print the changes that would have been made to targets.
 Does not modify targets in any way.`)
+	catchUp = flag.Bool("catchup", false, `If true, restore every source snapshot between the latest snapshot in common and the selected snapshot, one at a time, so that targets keep the same snapshot history as source.
+If false (default), restore the selected snapshot in a single step.
+Incompatible with -initialize.`)
+	discardDiverged = flag.Bool("discard-diverged", false, `If true, clone to targets that have snapshots newer than the latest snapshot in common that source doesn't have, e.g. because a target was used as a working disk, by first deleting those snapshots from the target. The snapshots are listed when asking for confirmation.
+If false (default), such targets can't be cloned to. Targets that are ahead of the selected snapshot are never discarded. See 'diagnose' above.
+Incompatible with -initialize.`)
+	keepLast = flag.Int("keep-last", 0, `Retention: keep the given number of most recent snapshots on targets.
+See "Retention" above.`)
+	keepDaily = flag.Int("keep-daily", 0, `Retention: keep the most recent snapshot of each of the given number of most recent days on targets.
+See "Retention" above.`)
+	keepWeekly = flag.Int("keep-weekly", 0, `Retention: keep the most recent snapshot of each of the given number of most recent weeks on targets.
+See "Retention" above.`)
+	keepMonthly = flag.Int("keep-monthly", 0, `Retention: keep the most recent snapshot of each of the given number of most recent months on targets.
+See "Retention" above.`)
+	maxAge = flag.Duration("max-age", 0, `Retention: keep snapshots on targets that are newer than the given duration (e.g. 720h).
+See "Retention" above.`)
+	parallel = flag.Int("parallel", 1, `Maximum number of targets to clone to at the same time.
+Output of each target is printed once that target's clone completes if greater than 1.`)
+	createSnapshot = flag.Bool("create-snapshot", false, `If true, create a new snapshot of source using 'tmutil localsnapshot' and clone it to targets.
+Source must be included in Time Machine backups.
+Incompatible with -snapshot.`)
+	snapshot = flag.String("snapshot", "latest", `Snapshot of source to clone to targets. One of:
+  latest                  the most recent snapshot (default)
+  uuid:<uuid>             the snapshot with the given UUID
+  name:<name>             the snapshot with exactly the given name
+  prefix:<prefix>         the most recent snapshot whose name starts with prefix
+  before:<time>           the most recent snapshot created at or before time
+                          (RFC 3339, or yyyy-mm-dd-hhmmss in local time),
+                          according to the yyyy-mm-dd-hhmmss timestamp in
+                          its name, which is in local time`)
+	printPlan = flag.Bool("plan", false, `If true, print the plan of what would be done to each target as JSON and exit without cloning.
+The plan may later be executed with -from-plan.`)
+	fromPlan = flag.String("from-plan", "", `Path to a plan written by -plan to execute instead of planning a new clone.
+Source and target volumes are read from the plan, and must not be given as arguments.
+Targets are not modified if they have changed since the plan was written.`)
+	verifyTargets = flag.Bool("verify", true, `If true (default), check each target after it is cloned: its latest snapshot must be the restored snapshot, its file system and name must be unchanged, and pruned snapshots must be gone.
+Exits with status 2 if only verification failed.
+Ignored with -dryrun.`)
+	verifyContents = flag.Bool("verify-contents", false, `If true, after each target is cloned, mount the restored snapshot of both source and target read-only and compare their files' metadata.
+Exits with status 2 if any files differ.
+Ignored with -dryrun.`)
+	verifyHashes = flag.Bool("verify-hashes", false, `If true, also compare the contents of files with -verify-contents. Reads every file in both snapshots.
+Implies -verify-contents.`)
+	hookPreValidate = flag.String("hook-pre-validate", "", `Shell command to run at the start of a run, before any volumes are read or snapshots created. See "Hooks" above.
+If it fails, nothing is cloned.`)
+	hookPreRestore = flag.String("hook-pre-restore", "", `Shell command to run before each target is restored. See "Hooks" above.
+If it fails, the target is skipped.`)
+	hookPostRestore = flag.String("hook-post-restore", "", `Shell command to run after each target's restore, whether or not it succeeded. See "Hooks" above.`)
+	hookPostPrune   = flag.String("hook-post-prune", "", `Shell command to run after snapshots are pruned from each target. See "Hooks" above.`)
+	hookPostRun     = flag.String("hook-post-run", "", `Shell command to run at the end of a run. See "Hooks" above.`)
+	journalDir      = flag.String("journal", "/var/db/offsite-apfs-backup/journal", `Directory in which to record each step that modifies a target, so that steps interrupted by a crash or a disconnected target are detected by the next run.
+Set to "" to disable.`)
+	recoverInterrupted = flag.Bool("recover", false, `If true, recover steps recorded in -journal that did not complete, before cloning.
+Interrupted restores are re-run, and targets are renamed back to their original names.
+If false (default), exit if there are any such steps.`)
+	configPath    = flag.String("config", "/usr/local/etc/offsite-apfs-backup.conf", `Path to the config file that defines the jobs used by 'run' and 'watch'.`)
+	pollInterval  = flag.Duration("poll-interval", 30*time.Second, `How often 'watch' checks whether targets are attached.`)
+	cooldown      = flag.Duration("cooldown", time.Hour, `Minimum time between the end of a clone to a target by 'watch' and the start of the next clone to it.`)
+	yes           = flag.Bool("yes", false, `If true, clone without asking for confirmation. Required when run non-interactively, e.g. by launchd.`)
+	install       = flag.Bool("install", false, `If true, 'launchd <job>' writes the launchd daemon to `+launchd.DaemonsDir+` instead of printing it.`)
+	notifyWebhook = flag.String("notify-webhook", "", `URL to POST a JSON summary of each run to. See "Notifications" above.`)
+	notifyCommand = flag.String("notify-command", "", `Shell command to run with a JSON summary of each run on stdin. See "Notifications" above.`)
+	notifyMacOS   = flag.Bool("notify-macos", false, `If true, show a macOS notification with the outcome of each run.`)
+	smtpServer    = flag.String("smtp-server", "", `SMTP server (host:port) used to send -notify-email emails.`)
+	smtpFrom      = flag.String("smtp-from", "", `Address -notify-email emails are sent from.`)
+	output        = flag.String("output", "text", `Output format. One of:
+  text    human readable progress (default)
+  json    one JSON object per line for each step of each clone, and a summary of each run. Human readable progress is written to stderr instead.
+See "Machine-readable output" above.`)
+	metricsPath    = flag.String("metrics", "", `Path to a Prometheus textfile to update with metrics of each run. See "Metrics" above.`)
+	commandTimeout = flag.Duration("command-timeout", 2*time.Minute, `Maximum duration of each diskutil and tmutil command, after which it is killed and the clone fails.
+Set to 0 to disable.`)
+	restoreTimeout = flag.Duration("restore-timeout", 0, `Maximum duration of each asr restore, after which it is interrupted and the clone fails. See "Interruptions" above.
+Set to 0 (default) to disable.`)
+	retries = flag.Int("retries", cloner.DefaultRetryPolicy.Retries, `Maximum number of times to retry a diskutil command that reads a volume, or an asr restore, that fails transiently, e.g. because target is busy. Renames and snapshot deletions are not retried.
+Set to 0 to disable.`)
+	fallbackRestores = flag.Int("fallback-restores", 0, `Maximum number of times to retry a failed incremental restore from the next-older snapshot in common, e.g. because asr rejects the latest snapshot in common.
+Set to 0 (default) to disable.`)
+	retryBackoff = flag.Duration("retry-backoff", cloner.DefaultRetryPolicy.Backoff, `How long to wait before the first retry. The wait doubles after each retry, up to 1 minute.`)
 )
 
+// commandFlags are the flags that may be used with each command. All other
+// options are set in the config file.
+var commandFlags = map[string]map[string]bool{
+	"run": {
+		"command-timeout":   true,
+		"config":            true,
+		"discard-diverged":  true,
+		"dryrun":            true,
+		"fallback-restores": true,
+		"journal":           true,
+		"output":            true,
+		"plan":              true,
+		"recover":           true,
+		"restore-timeout":   true,
+		"retries":           true,
+		"retry-backoff":     true,
+		"yes":               true,
+	},
+	"watch": {
+		"command-timeout":   true,
+		"config":            true,
+		"dryrun":            true,
+		"fallback-restores": true,
+		"journal":           true,
+		"output":            true,
+		"recover":           true,
+		"restore-timeout":   true,
+		"retries":           true,
+		"retry-backoff":     true,
+		"poll-interval":     true,
+		"cooldown":          true,
+	},
+	"launchd": {
+		"config":  true,
+		"install": true,
+	},
+	"diagnose": {
+		"command-timeout": true,
+		"config":          true,
+		"output":          true,
+		"retries":         true,
+		"retry-backoff":   true,
+	},
+}
+
+// commandFlagsUsage describes the flags that may be used with command, as an
+// indented paragraph of the usage.
+func commandFlagsUsage(command string) string {
+	var names []string
+	for name := range commandFlags[command] {
+		names = append(names, "-"+name)
+	}
+	sort.Strings(names)
+	names[len(names)-1] = "and " + names[len(names)-1]
+	text := fmt.Sprintf("Only %s may be used with '%s'.", strings.Join(names, ", "), command)
+
+	const indent, width = "  ", 80
+	var b strings.Builder
+	line := indent
+	for _, word := range strings.Fields(text) {
+		if line != indent && len(line)+1+len(word) > width {
+			b.WriteString(line + "\n")
+			line = indent
+		}
+		if line != indent {
+			line += " "
+		}
+		line += word
+	}
+	b.WriteString(line)
+	return b.String()
+}
+
 func init() {
+	flag.Var(&notifyEmail, "notify-email", `Comma separated addresses to email a summary of each run to, using -smtp-server and -smtp-from. See "Notifications" above.`)
 	flag.Usage = func() {
-		fmt.Fprintf(flag.CommandLine.Output(), `Usage: %s [-prune] [-initialize] [-dryrun] [--] <source volume> <target volume> [<target volume>...]
+		fmt.Fprintf(flag.CommandLine.Output(), `Usage: %s [flags] [--] <source volume> <target volume> [<target volume>...]
+       %s [flags] -from-plan <plan file>
+       %s [-config <config file>] [-dryrun] [-output text|json] [-plan] [-yes] run <job>
+       %s [-config <config file>] [-output text|json] [-poll-interval <duration>] [-cooldown <duration>] watch [<job>...]
+       %s [-config <config file>] [-install] launchd <job>
+       %s [-config <config file>] [-output text|json] diagnose <job>
 
   <source volume>
     	Source APFS volume to clone.
@@ -38,11 +213,176 @@ func init() {
     	Target APFS volume(s) to clone to.
     	May be specified multiple times.
     	May be a mount point, /dev/ path, or volume UUID.
-`, os.Args[0])
+
+Retention:
+  If any of -keep-last, -keep-daily, -keep-weekly, -keep-monthly, or -max-age
+  are set, then after a successful clone, snapshots on targets that are not kept
+  by at least one of them are pruned. The snapshot targets were restored to is
+  never pruned. With -dryrun, the snapshots that would be pruned are printed.
+
+Jobs:
+  'run <job>' clones the source of the named job in -config to each of its
+  targets. For example:
+
+    [job "offsite"]
+    source = /Volumes/Data
+    create-snapshot = true
+    prune = true
+
+    [target "vault"]
+    uuid = 9B4E2C9A-5D6E-4C1F-8A2B-3C4D5E6F7A8B
+    keep-daily = 7
+
+  A job may set source, parallel, create-snapshot, snapshot, hook-pre-validate,
+  hook-post-run, schedule, log, metrics, and the notify-* and smtp-* options.
+  Jobs and targets may set prune, catchup, keep-*, max-age, verify,
+  verify-contents, verify-hashes, hook-pre-restore, hook-post-restore, and
+  hook-post-prune, each named after its flag; options set in a job apply to
+  all of its targets, unless the target sets them too.
+%s
+
+  'watch [<job>...]' runs until interrupted, and clones to each target of the
+  named jobs (or all jobs) whenever it's attached, without confirmation.
+  Targets attached when 'watch' starts are cloned to as well. A target is not
+  cloned to again within -cooldown of its last clone, even if it's detached
+  and attached again.
+%s
+
+  'launchd <job>' prints a launchd daemon definition that runs the job on the
+  schedule set by the job's schedule option, which is one of: hourly,
+  daily HH:MM, weekly <day> HH:MM, every <duration>, on-mount (starts
+  'watch <job>' the first time a volume is mounted), or keep-alive (runs
+  'watch <job>' continuously). Output
+  is appended to the job's log option, or /var/log/offsite-apfs-backup.<job>.log.
+  With -install, the definition is written to `+launchd.DaemonsDir+`.
+
+  'diagnose <job>' compares the snapshots of each target of the job with the
+  source's, without cloning. Each target is reported as in sync,
+  behind (cloneable), ahead of the selected snapshot, diverged from source
+  after their latest snapshot in common, or disjoint (no snapshots in
+  common), along with the snapshots only one of them has. With -output=json,
+  a "diagnosis" object is written for each target. Exits with status 3 if any
+  target can't be cloned to, including targets that aren't attached.
+%s
+
+Notifications:
+  At the end of each run, a summary of which targets succeeded or failed, the
+  snapshots they were restored from and to, and how long each took, is sent to
+  each of -notify-webhook, -notify-email, -notify-command, and -notify-macos
+  that are set. If the SMTP server requires authentication, set the
+  OFFSITE_SMTP_USERNAME and OFFSITE_SMTP_PASSWORD environment variables. If
+  a run fails before any target is cloned, e.g. because a target isn't
+  attached, every target is reported as failed with that error.
+  Notifications are not sent with -dryrun.
+
+Machine-readable output:
+  With -output=json, a JSON object is written to stdout on its own line for
+  each step of each clone: validate, select_snapshot, discard, restore_start,
+  restore_end, prune, rename, and verify. Each run ends with a "summary"
+  object, even if it fails before any target is cloned. Every object has a
+  "type" and a "schema_version", which only changes if fields are removed or
+  change meaning. Human readable output, and the output of asr and diskutil,
+  is written to stderr.
+
+Metrics:
+  If -metrics is set, the file is updated after each run with metrics for
+  node_exporter's textfile collector: when each target last succeeded, the
+  age of the snapshot last cloned to it, restore durations, snapshot counts of
+  source and targets, and failure counts. Metrics of targets not in the run
+  are kept, so several jobs may share a file. Metrics are not written with
+  -dryrun.
+
+Interruptions:
+  On SIGINT or SIGTERM, running restores are asked to stop, and killed after 30
+  seconds. Targets that haven't started are skipped, and interrupted targets
+  are reported; run again with -recover to complete them. A second signal exits
+  immediately.
+
+Hooks:
+  Hook commands are run with 'sh -c'. Each hook receives details of the run as
+  OFFSITE_* environment variables, e.g. OFFSITE_HOOK, OFFSITE_SOURCE_UUID,
+  OFFSITE_TARGET_UUID, OFFSITE_FROM_SNAPSHOT_UUID, OFFSITE_TO_SNAPSHOT_UUID, and
+  OFFSITE_OUTCOME, and as JSON on stdin. Hooks are not run with -dryrun.
+
+Exit status:
+  0  all targets were cloned
+  1  all targets failed to clone, or an error not specific to a target
+  2  all targets were cloned, but some failed verification
+  3  invalid flags, config, or volumes, e.g. no snapshots in common; nothing
+     was cloned
+  4  some targets were cloned, but others failed to clone
+  5  cloning wasn't confirmed, or was interrupted
+
+Flags:
+`, os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], commandFlagsUsage("run"), commandFlagsUsage("watch"), commandFlagsUsage("diagnose"))
 		flag.CommandLine.PrintDefaults()
 	}
 }
 
+// Exit statuses. See "Exit status" in the usage.
+const (
+	// exitFailure is returned if all targets failed to clone, or on errors
+	// that aren't specific to a target, e.g. reading the journal.
+	exitFailure = 1
+	// exitVerificationFailure is returned if all targets were cloned, but
+	// at least one target failed verification.
+	exitVerificationFailure = 2
+	// exitValidationFailure is returned if flags, the config file, or
+	// volumes are invalid, e.g. source and target have no snapshots in
+	// common. Nothing is cloned.
+	exitValidationFailure = 3
+	// exitPartialFailure is returned if some targets were cloned, but
+	// others failed to clone.
+	exitPartialFailure = 4
+	// exitAborted is returned if cloning wasn't confirmed, or was
+	// interrupted by SIGINT or SIGTERM.
+	exitAborted = 5
+)
+
+var (
+	// textOut is where human readable output is written: stdout, or with
+	// -output=json, stderr.
+	textOut io.Writer = os.Stdout
+	// events writes events and summaries with -output=json.
+	events *ndjson.Writer
+	// progressBars draws a progress bar of each restore, if set by execute.
+	progressBars bool
+)
+
+// setOutput configures textOut and events for -output.
+func setOutput() error {
+	switch *output {
+	case "text":
+	case "json":
+		textOut = os.Stderr
+		events = ndjson.New(os.Stdout)
+	default:
+		return fmt.Errorf("invalid -output %q, want text or json", *output)
+	}
+	return nil
+}
+
+// notifyEmail are the addresses set by -notify-email.
+var notifyEmail emailsFlag
+
+// emailsFlag is a comma separated list of email addresses.
+type emailsFlag []string
+
+func (f *emailsFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *emailsFlag) Set(value string) error {
+	for _, addr := range strings.Split(value, ",") {
+		addr = strings.TrimSpace(addr)
+		if _, err := mail.ParseAddress(addr); err != nil {
+			return fmt.Errorf("invalid email address %q", addr)
+		}
+		*f = append(*f, addr)
+	}
+	return nil
+}
+
 type targetsFlag []string
 
 func (f *targetsFlag) String() string {
@@ -56,56 +396,825 @@ func (f *targetsFlag) Set(value string) error {
 
 func main() {
 	flag.Parse()
+	if err := setOutput(); err != nil {
+		fmt.Fprintln(flag.CommandLine.Output(), "Error:", err)
+		flag.Usage()
+		os.Exit(exitValidationFailure)
+	}
+	if command := flag.Arg(0); commandFlags[command] != nil {
+		jobs, err := loadJobs(command, flag.Args()[1:])
+		if err != nil {
+			fmt.Fprintln(flag.CommandLine.Output(), "Error:", err)
+			var cerr *config.Error
+			if !errors.As(err, &cerr) {
+				flag.Usage()
+			}
+			os.Exit(exitValidationFailure)
+		}
+		switch command {
+		case "watch":
+			watchJobs(jobs)
+			return
+		case "launchd":
+			launchdJob(jobs[0])
+			return
+		case "diagnose":
+			diagnoseJob(jobs[0])
+			return
+		}
+		runJob(jobs[0])
+		return
+	}
+	if *fromPlan != "" {
+		if flag.NArg() > 0 {
+			fmt.Fprintln(flag.CommandLine.Output(), "Error: volumes must not be given with -from-plan")
+			flag.Usage()
+			os.Exit(exitValidationFailure)
+		}
+		if err := validateFlags(); err != nil {
+			fmt.Fprintln(flag.CommandLine.Output(), "Error:", err)
+			flag.Usage()
+			os.Exit(exitValidationFailure)
+		}
+		plan, err := readPlan(*fromPlan)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			os.Exit(exitValidationFailure)
+		}
+		var targets []string
+		for _, tp := range plan.Targets {
+			targets = append(targets, tp.Arg)
+		}
+		job := flagJob(plan.Source.MountPoint, targets)
+		if status, err := checkJournal(); err != nil {
+			failRun(job, targets, status, err)
+		}
+		if err := jobCloner(job).PreValidate(job.Source, targets...); err != nil {
+			failRun(job, targets, exitValidationFailure, err)
+		}
+		execute(plan, job)
+		return
+	}
+
 	source, targets, err := parseArguments()
 	if err != nil {
 		fmt.Fprintln(flag.CommandLine.Output(), "Error:", err)
 		flag.Usage()
-		os.Exit(1)
+		os.Exit(exitValidationFailure)
 	}
-	if err := validateFlags(targets); err != nil {
+	if err := validateFlags(); err != nil {
 		fmt.Fprintln(flag.CommandLine.Output(), "Error:", err)
 		flag.Usage()
-		os.Exit(1)
+		os.Exit(exitValidationFailure)
+	}
+	if _, err := cloner.ParseSnapshotSelector(*snapshot); err != nil {
+		fmt.Fprintln(flag.CommandLine.Output(), "Error:", err)
+		flag.Usage()
+		os.Exit(exitValidationFailure)
+	}
+	runJob(flagJob(source, targets))
+}
+
+// loadJobs returns the jobs named by the arguments of command from the config
+// file. 'run' requires exactly one job. 'watch' uses all jobs if none are
+// named.
+func loadJobs(command string, names []string) ([]config.Job, error) {
+	if command != "watch" && len(names) != 1 {
+		return nil, fmt.Errorf("%s requires exactly one <job>", command)
+	}
+	var err error
+	flag.Visit(func(f *flag.Flag) {
+		if !commandFlags[command][f.Name] && err == nil {
+			err = fmt.Errorf("-%s cannot be used with %s; set it in the config file instead", f.Name, command)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	conf, err := config.Load(*configPath)
+	if err != nil {
+		var cerr *config.Error
+		if errors.As(err, &cerr) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("error reading config: %v", err)
+	}
+	if len(names) == 0 {
+		if len(conf.Jobs) == 0 {
+			return nil, fmt.Errorf("no jobs in %s", *configPath)
+		}
+		return conf.Jobs, nil
+	}
+	var jobs []config.Job
+	for _, name := range names {
+		job, ok := conf.Job(name)
+		if !ok {
+			return nil, fmt.Errorf("no job named %q in %s", name, *configPath)
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+// watchJobs clones to each target of jobs whenever it's attached, until
+// interrupted.
+func watchJobs(jobs []config.Job) {
+	mustCheckJournal()
+	targets := make(map[string][]jobTarget)
+	var volumes []string
+	for _, job := range jobs {
+		for _, t := range job.Targets {
+			if targets[t.Volume] == nil {
+				volumes = append(volumes, t.Volume)
+			}
+			targets[t.Volume] = append(targets[t.Volume], jobTarget{job, t})
+		}
+	}
+
+	ctx, stop := interruptContext()
+	defer stop()
+	fmt.Fprintf(textOut, "Watching for %d targets to be attached...\n", len(volumes))
+	w := watch.New(diskutil.New(), watch.Interval(*pollInterval), watch.Cooldown(*cooldown), watch.Stdout(textOut))
+	w.Watch(ctx, volumes, func(volume string, stdout io.Writer) error {
+		var failed int
+		for _, jt := range targets[volume] {
+			prefix := fmt.Sprintf("[%s/%s] ", jt.job.Name, jt.target.Alias)
+			if err := cloneAttached(ctx, jt.job, jt.target, newPrefixWriter([]byte(prefix), stdout)); err != nil {
+				fmt.Fprintf(stdout, "%sError: %v\n", prefix, err)
+				failed++
+			}
+		}
+		if failed > 0 {
+			return fmt.Errorf("failed to clone to %d/%d jobs", failed, len(targets[volume]))
+		}
+		return nil
+	})
+}
+
+// diagnoseJob prints how the snapshots of each of job's targets compare with
+// source's. diagnoseJob exits with exitValidationFailure if any target can't be
+// cloned to.
+func diagnoseJob(job config.Job) {
+	selectSnapshot, err := cloner.ParseSnapshotSelector(job.Snapshot)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(exitValidationFailure)
+	}
+	c := newCloner(textOut, job, config.TargetOptions{}, selectSnapshot)
+	var failed int
+	for _, t := range job.Targets {
+		fmt.Fprintf(textOut, "Target %s (%s):\n", t.Alias, t.Volume)
+		histories, err := c.Diagnose(job.Source, t.Volume)
+		if err != nil {
+			fmt.Fprintf(textOut, "\tError: %v\n", err)
+			failed++
+			continue
+		}
+		th := histories[0]
+		fmt.Fprint(newPrefixWriter([]byte("\t"), textOut), th.History)
+		if th.History.Err() != nil {
+			failed++
+		}
+		if events != nil {
+			if err := events.Diagnosis(th); err != nil {
+				fmt.Fprintln(os.Stderr, "Error:", err)
+				os.Exit(exitFailure)
+			}
+		}
+	}
+	if failed > 0 {
+		fmt.Fprintf(textOut, "%d/%d targets can't be cloned to.\n", failed, len(job.Targets))
+		os.Exit(exitValidationFailure)
+	}
+}
+
+// launchdJob prints a launchd daemon definition that runs job on its
+// schedule, or with -install, installs it.
+func launchdJob(job config.Job) {
+	if job.Schedule == "" {
+		fmt.Fprintf(os.Stderr, "Error: job %q has no schedule\n", job.Name)
+		os.Exit(exitValidationFailure)
+	}
+	schedule, err := launchd.ParseSchedule(job.Schedule)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(exitValidationFailure)
+	}
+	program, err := os.Executable()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(exitFailure)
+	}
+	conf, err := filepath.Abs(*configPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(exitFailure)
+	}
+	args := []string{program, "-config", conf}
+	// launchd starts on-mount jobs whenever any volume is mounted, not just
+	// the job's targets, so watch for the targets rather than running the
+	// job, which would fail whenever they aren't attached. Once started,
+	// watch keeps running, and its cooldown keeps targets from being cloned
+	// to on every mount.
+	if schedule.KeepAlive || schedule.OnMount {
+		args = append(args, "watch", job.Name)
+	} else {
+		args = append(args, "-yes", "run", job.Name)
+	}
+	log := job.Log
+	if log == "" {
+		log = fmt.Sprintf("/var/log/offsite-apfs-backup.%s.log", job.Name)
+	}
+	j := launchd.NewJob("com.github.voidingwarranties.offsite-apfs-backup."+job.Name, args, schedule, log)
+
+	if !*install {
+		data, err := j.Marshal()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			os.Exit(exitFailure)
+		}
+		os.Stdout.Write(data)
+		return
+	}
+	path, err := launchd.Install(j, launchd.DaemonsDir)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error installing launchd daemon:", err)
+		os.Exit(exitFailure)
+	}
+	fmt.Printf("Installed %s. To load it, run:\n  sudo launchctl bootstrap system %s\n", path, path)
+}
+
+// jobTarget is a target of a job.
+type jobTarget struct {
+	job    config.Job
+	target config.Target
+}
+
+// cloneAttached clones job's source to t, which was just attached, without
+// confirmation. The clone is interrupted once ctx is done.
+func cloneAttached(ctx context.Context, job config.Job, t config.Target, stdout io.Writer) error {
+	selectSnapshot, err := cloner.ParseSnapshotSelector(job.Snapshot)
+	if err != nil {
+		return err
+	}
+	c := newCloner(stdout, job, t.TargetOptions, selectSnapshot)
+	o := cloner.NewOrchestrator(append(notifiers(job), cloner.OrchestratorStdout(stdout))...)
+	var plan cloner.ClonePlan
+	summary := o.RunContext(ctx, job.Source, []string{t.Volume}, func(target string, stdout io.Writer) (cloner.CloneResult, error) {
+		if err := c.PreValidateContext(ctx, job.Source, target); err != nil {
+			return cloner.CloneResult{}, err
+		}
+		if err := c.CloneableContext(ctx, job.Source, target); err != nil {
+			return cloner.CloneResult{}, err
+		}
+		if job.CreateSnapshot && !*dryrun {
+			snap, err := c.CreateSnapshotContext(ctx, job.Source)
+			if err != nil {
+				return cloner.CloneResult{}, err
+			}
+			c = newCloner(stdout, job, t.TargetOptions, cloner.SnapshotByUUID(snap.UUID))
+		}
+		var err error
+		plan, err = c.PlanContext(ctx, job.Source, target)
+		if err != nil {
+			return cloner.CloneResult{}, err
+		}
+		result, err := c.ExecuteTargetContext(ctx, plan, plan.Targets[0])
+		if err == nil && !*dryrun && (t.VerifyContents || t.VerifyHashes) {
+			err = compareContents(ctx, stdout, result, t.VerifyHashes)
+		}
+		return result, err
+	})
+	for _, w := range summary.Targets[0].Result.Warnings {
+		fmt.Fprintf(stdout, "Warning: %s\n", w)
+	}
+	if err := c.PostRun(job.Source, summary); err != nil {
+		fmt.Fprintf(stdout, "Warning: %v\n", err)
+	}
+	writeMetrics(stdout, job, plan, summary)
+	return summary.Targets[0].Err
+}
+
+// flagJob returns a job that clones source to targets, configured by the
+// command line flags.
+func flagJob(source string, targets []string) config.Job {
+	job := config.Job{
+		Source:          source,
+		Parallel:        *parallel,
+		CreateSnapshot:  *createSnapshot,
+		Snapshot:        *snapshot,
+		PreValidateHook: *hookPreValidate,
+		PostRunHook:     *hookPostRun,
+		NotifyWebhook:   *notifyWebhook,
+		NotifyCommand:   *notifyCommand,
+		NotifyMacOS:     *notifyMacOS,
+		NotifyEmail:     notifyEmail,
+		SMTPServer:      *smtpServer,
+		SMTPFrom:        *smtpFrom,
+		Metrics:         *metricsPath,
+	}
+	opts := config.TargetOptions{
+		Prune:           *prune,
+		CatchUp:         *catchUp,
+		KeepLast:        *keepLast,
+		KeepDaily:       *keepDaily,
+		KeepWeekly:      *keepWeekly,
+		KeepMonthly:     *keepMonthly,
+		MaxAge:          *maxAge,
+		Verify:          *verifyTargets,
+		VerifyContents:  *verifyContents,
+		VerifyHashes:    *verifyHashes,
+		PreRestoreHook:  *hookPreRestore,
+		PostRestoreHook: *hookPostRestore,
+		PostPruneHook:   *hookPostPrune,
+	}
+	for _, t := range targets {
+		job.Targets = append(job.Targets, config.Target{
+			Volume:        t,
+			TargetOptions: opts,
+		})
+	}
+	return job
+}
+
+// runJob plans and executes job. runJob exits if any step fails.
+func runJob(job config.Job) {
+	var targets []string
+	for _, t := range job.Targets {
+		targets = append(targets, t.Volume)
+	}
+	selectSnapshot, err := cloner.ParseSnapshotSelector(job.Snapshot)
+	if err != nil {
+		failRun(job, targets, exitValidationFailure, err)
+	}
+	if status, err := checkJournal(); err != nil {
+		failRun(job, targets, status, err)
+	}
+	if err := jobCloner(job).PreValidate(job.Source, targets...); err != nil {
+		failRun(job, targets, exitValidationFailure, err)
+	}
+	if job.CreateSnapshot {
+		if *dryrun {
+			fmt.Fprintln(textOut, "Dry run: not creating a snapshot of source. Using the latest snapshot instead.")
+		} else {
+			snap, err := jobCloner(job).CreateSnapshot(job.Source)
+			if err != nil {
+				failRun(job, targets, exitFailure, err)
+			}
+			selectSnapshot = cloner.SnapshotByUUID(snap.UUID)
+		}
+	}
+
+	plan, err := planJob(job, selectSnapshot)
+	if err != nil {
+		failRun(job, targets, exitValidationFailure, err)
+	}
+	if *printPlan {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(plan); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			os.Exit(exitFailure)
+		}
+		return
+	}
+	execute(plan, job)
+}
+
+// failRun reports that a run of job failed with err before any of targets
+// were cloned, as if each target failed with err, to job's notifiers and
+// metrics, and exits with status.
+func failRun(job config.Job, targets []string, status int, err error) {
+	fmt.Fprintln(os.Stderr, "Error:", err)
+	o := cloner.NewOrchestrator(append(notifiers(job), cloner.OrchestratorStdout(textOut))...)
+	summary := o.Fail(targets, err)
+	writeMetrics(os.Stderr, job, cloner.ClonePlan{}, summary)
+	os.Exit(status)
+}
+
+// planJob plans cloning job's source to each of its targets. Targets with the
+// same options are planned together, so that they are validated against each
+// other.
+func planJob(job config.Job, selectSnapshot cloner.SnapshotSelector) (cloner.ClonePlan, error) {
+	var groups [][]config.Target
+	for _, t := range job.Targets {
+		i := 0
+		for i < len(groups) && groups[i][0].TargetOptions != t.TargetOptions {
+			i++
+		}
+		if i == len(groups) {
+			groups = append(groups, nil)
+		}
+		groups[i] = append(groups[i], t)
+	}
+
+	var merged cloner.ClonePlan
+	for _, group := range groups {
+		var volumes []string
+		for _, t := range group {
+			volumes = append(volumes, t.Volume)
+		}
+		c := newCloner(textOut, job, group[0].TargetOptions, selectSnapshot)
+		plan, err := c.Plan(job.Source, volumes...)
+		if err != nil {
+			return cloner.ClonePlan{}, err
+		}
+		merged.Source = plan.Source
+		merged.SourceSnapshots = plan.SourceSnapshots
+		merged.Targets = append(merged.Targets, plan.Targets...)
+	}
+	// Order targets as in job.
+	targets := merged.Targets
+	merged.Targets = nil
+	for _, t := range job.Targets {
+		tp, _ := (cloner.ClonePlan{Targets: targets}).Target(t.Volume)
+		merged.Targets = append(merged.Targets, tp)
+	}
+	return merged, nil
+}
+
+// execute confirms plan with the user, unless -dryrun or -yes is set, and then
+// clones to each of plan's targets, configured by job's targets of the same
+// volume. execute exits if any target fails.
+func execute(plan cloner.ClonePlan, job config.Job) {
+	if !*dryrun && !*yes {
+		if err := confirm(plan); err != nil {
+			fmt.Fprintln(flag.CommandLine.Output(), "Error:", err)
+			os.Exit(exitAborted)
+		}
 	}
 
-	// Indent the stdout of cloner, diskutil, and asr with a single tab, to
-	// help separate different clones to different targets.
-	stdout := newPrefixWriter([]byte("\t"), os.Stdout)
+	var targets []string
+	for _, tp := range plan.Targets {
+		targets = append(targets, tp.Arg)
+	}
+	// The output of parallel clones is buffered, so progress bars would
+	// only be seen once complete.
+	progressBars = job.Parallel == 1 && isTerminal(textOut)
+	ctx, stop := interruptContext()
+	defer stop()
+	o := cloner.NewOrchestrator(append(notifiers(job), cloner.Concurrency(job.Parallel), cloner.OrchestratorStdout(textOut))...)
+	summary := o.RunContext(ctx, job.Source, targets, func(target string, stdout io.Writer) (cloner.CloneResult, error) {
+		tp, ok := plan.Target(target)
+		if !ok {
+			return cloner.CloneResult{}, fmt.Errorf("target %q is not in the plan", target)
+		}
+		opts := targetOptions(job, target)
+		// Indent the stdout of cloner, diskutil, and asr with a single tab,
+		// to help separate different clones to different targets.
+		stdout = newPrefixWriter([]byte("\t"), stdout)
+		c := newCloner(stdout, job, opts, cloner.LatestSnapshot())
+		result, err := c.ExecuteTargetContext(ctx, plan, tp)
+		if err != nil || *dryrun || !(opts.VerifyContents || opts.VerifyHashes) {
+			return result, err
+		}
+		return result, compareContents(ctx, stdout, result, opts.VerifyHashes)
+	})
+	if err := jobCloner(job).PostRun(job.Source, summary); err != nil {
+		fmt.Fprintln(os.Stderr, "Warning:", err)
+	}
+	writeMetrics(os.Stderr, job, plan, summary)
+	source := plan.Source.Name
+	for _, t := range summary.Targets {
+		for _, w := range t.Result.Warnings {
+			fmt.Fprintf(os.Stderr, "warning cloning %q to %q: %s\n", source, t.Target, w)
+		}
+	}
+	failed := summary.Failed()
+	var unverified, interrupted, unrecovered int
+	for _, t := range failed {
+		var verr *cloner.VerificationError
+		var ierr *cloner.InterruptedError
+		if errors.Is(t.Err, context.Canceled) {
+			interrupted++
+		}
+		if errors.As(t.Err, &ierr) {
+			fmt.Fprintf(os.Stderr, "interrupted cloning %q to %q during %s: %v\n", source, t.Target, ierr.Phase, ierr.Err)
+			switch ierr.Phase {
+			case cloner.PhaseDiscard, cloner.PhaseRestore, cloner.PhasePrune, cloner.PhaseRename:
+				unrecovered++
+			}
+			continue
+		}
+		if errors.Is(t.Err, context.Canceled) {
+			fmt.Fprintf(os.Stderr, "interrupted before cloning %q to %q\n", source, t.Target)
+			continue
+		}
+		if errors.As(t.Err, &verr) {
+			unverified++
+			fmt.Fprintf(os.Stderr, "cloned %q to %q, but %v\n", source, t.Target, t.Err)
+			continue
+		}
+		if t.Result.Skipped {
+			fmt.Fprintf(os.Stderr, "skipped cloning %q to %q: %v\n", source, t.Target, t.Err)
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "failed to clone %q to %q: %v\n", source, t.Target, t.Err)
+	}
+	if unrecovered > 0 && *journalDir != "" && !*dryrun {
+		fmt.Fprintln(os.Stderr, "Run again with -recover to complete the interrupted steps.")
+	}
+	if notCloned := len(failed) - unverified; notCloned > 0 {
+		fmt.Fprintf(os.Stderr, "failed to clone to %d/%d targets\n", notCloned, len(targets))
+		switch {
+		case interrupted > 0:
+			os.Exit(exitAborted)
+		case notCloned < len(targets):
+			os.Exit(exitPartialFailure)
+		}
+		os.Exit(exitFailure)
+	}
+	if unverified > 0 {
+		fmt.Fprintf(os.Stderr, "failed to verify %d/%d targets\n", unverified, len(targets))
+		os.Exit(exitVerificationFailure)
+	}
+}
+
+// compareContents compares the contents of the snapshot restored to target
+// with source's, and returns a *cloner.VerificationError if they differ. The
+// comparison stops once ctx is done.
+func compareContents(ctx context.Context, stdout io.Writer, result cloner.CloneResult, hash bool) error {
+	fmt.Fprintln(stdout, "Comparing contents of restored snapshot...")
+	report, err := verify.SnapshotsContext(ctx, verify.NewMounter(), result.Source, result.Target, result.To, verify.Hash(hash))
+	if err != nil {
+		return fmt.Errorf("error comparing contents: %v", err)
+	}
+	for _, d := range report.Differences {
+		fmt.Fprintf(stdout, "\t%s\n", d)
+	}
+	fmt.Fprintf(stdout, "Compared %d files, %d differences.\n", report.Compared, len(report.Differences))
+	if len(report.Differences) > 0 {
+		return &cloner.VerificationError{
+			Target:     result.Target,
+			Mismatches: []string{fmt.Sprintf("%d files differ from source in snapshot %s", len(report.Differences), result.To)},
+		}
+	}
+	return nil
+}
+
+// writeMetrics updates job's metrics textfile, if any, with the outcome of a
+// run of plan. Errors are written to w as warnings.
+func writeMetrics(w io.Writer, job config.Job, plan cloner.ClonePlan, summary cloner.Summary) {
+	if job.Metrics == "" || *dryrun {
+		return
+	}
+	run := metrics.Run{
+		Job:     job.Name,
+		Plan:    plan,
+		Summary: summary,
+		Time:    time.Now(),
+	}
+	if err := metrics.Update(job.Metrics, run); err != nil {
+		fmt.Fprintln(w, "Warning:", err)
+	}
+}
+
+// checkJournal reports steps of previous clones that did not complete. If
+// -recover is set, the steps are recovered. Otherwise, or if recovery fails,
+// checkJournal returns an error, and the status to exit with.
+func checkJournal() (int, error) {
+	if *journalDir == "" {
+		return 0, nil
+	}
+	c := jobCloner(config.Job{})
+	pending, err := cloner.NewFileJournal(*journalDir).Pending()
+	if err != nil {
+		return exitFailure, fmt.Errorf("error reading journal: %v", err)
+	}
+	if len(pending) == 0 {
+		return 0, nil
+	}
+	fmt.Fprintln(os.Stderr, "Found steps of previous clones that did not complete:")
+	for _, e := range pending {
+		fmt.Fprintf(os.Stderr, "  - %s\n", e)
+	}
+	if *dryrun {
+		fmt.Fprintln(os.Stderr, "Dry run: not recovering.")
+		return 0, nil
+	}
+	if !*recoverInterrupted {
+		fmt.Fprintln(os.Stderr, "Run again with -recover to re-run interrupted restores and rename targets back to their original names.")
+		return exitFailure, fmt.Errorf("%d steps of previous clones did not complete", len(pending))
+	}
+	ctx, stop := interruptContext()
+	defer stop()
+	for _, e := range pending {
+		fmt.Fprintf(textOut, "Recovering %q...\n", e.Target.Name)
+		if err := c.RecoverContext(ctx, e); err != nil {
+			if ctx.Err() != nil {
+				return exitAborted, err
+			}
+			return exitFailure, err
+		}
+	}
+	return 0, nil
+}
+
+// mustCheckJournal is like checkJournal, but exits if checkJournal returns an
+// error.
+func mustCheckJournal() {
+	if status, err := checkJournal(); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(status)
+	}
+}
+
+// readPlan reads a plan written by -plan from the file at path.
+func readPlan(path string) (cloner.ClonePlan, error) {
+	var plan cloner.ClonePlan
+	f, err := os.Open(path)
+	if err != nil {
+		return plan, fmt.Errorf("error reading plan: %v", err)
+	}
+	defer f.Close()
+	if err := json.NewDecoder(f).Decode(&plan); err != nil {
+		return plan, fmt.Errorf("error decoding plan %q: %v", path, err)
+	}
+	if len(plan.Targets) == 0 {
+		return plan, fmt.Errorf("invalid plan %q: no targets", path)
+	}
+	return plan, nil
+}
+
+// targetOptions returns the options of job's target with the given volume.
+func targetOptions(job config.Job, volume string) config.TargetOptions {
+	for _, t := range job.Targets {
+		if t.Volume == volume {
+			return t.TargetOptions
+		}
+	}
+	return config.TargetOptions{Verify: true}
+}
+
+// jobCloner returns a Cloner for the steps of job that are not specific to a
+// target.
+func jobCloner(job config.Job) cloner.Cloner {
+	return newCloner(textOut, job, config.TargetOptions{}, cloner.LatestSnapshot())
+}
+
+// newCloner returns a Cloner configured by job and a target's opts, whose
+// cloner, diskutil, and asr output is written to stdout.
+func newCloner(stdout io.Writer, job config.Job, opts config.TargetOptions, selectSnapshot cloner.SnapshotSelector) cloner.Cloner {
 	du := diskutil.New()
-	var r asr.ASR = asr.New(asr.Stdout(stdout))
+	asrOpts := []asr.Option{asr.Stdout(stdout)}
+	if progressBars {
+		asrOpts = append(asrOpts, asr.OnProgress(progressBar(stdout)))
+	}
+	var r asr.ASR = asr.New(asrOpts...)
 	if *dryrun {
 		du = diskutil.NewDryRun(du)
 		r = asr.NewDryRun(asr.Stdout(stdout))
 	}
-	c := cloner.New(
-		du, r,
-		cloner.Prune(*prune),
+	options := []cloner.Option{
+		cloner.Prune(opts.Prune),
 		cloner.InitializeTargets(*initialize),
+		cloner.CatchUp(opts.CatchUp),
+		cloner.DiscardDiverged(*discardDiverged),
+		cloner.FallbackRestores(*fallbackRestores),
+		cloner.ToSnapshot(selectSnapshot),
+		cloner.Retention(opts.RetentionPolicies()...),
+		cloner.SnapshotCreator(tmutil.New()),
+		cloner.Journaling(journal()),
+		cloner.Verify(opts.Verify && !*dryrun),
+		cloner.CommandTimeout(*commandTimeout),
+		cloner.RestoreTimeout(*restoreTimeout),
+		cloner.Retry(cloner.RetryPolicy{
+			Retries:    *retries,
+			Backoff:    *retryBackoff,
+			MaxBackoff: cloner.DefaultRetryPolicy.MaxBackoff,
+		}),
 		cloner.Stdout(stdout),
-	)
-	if err := c.Cloneable(source, targets...); err != nil {
-		fmt.Fprintln(os.Stderr, "Error:", err)
-		os.Exit(1)
+	}
+	if events != nil {
+		options = append(options, cloner.Events(events))
 	}
 	if !*dryrun {
-		if err := confirm(source, targets); err != nil {
-			fmt.Fprintln(flag.CommandLine.Output(), "Error:", err)
-			os.Exit(1)
+		options = append(options, hooks(job, opts)...)
+	}
+	return cloner.New(du, r, options...)
+}
+
+// interruptContext returns a context that is done once the process receives
+// SIGINT or SIGTERM. Running commands are then signaled to stop (see
+// asr.GracePeriod), and no more targets are started. Only the first signal is
+// caught, so that a second one exits immediately.
+func interruptContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case sig := <-sigs:
+			fmt.Fprintf(os.Stderr, "Received %v, stopping. Send it again to exit immediately.\n", sig)
+			cancel()
+		case <-ctx.Done():
+		}
+		signal.Stop(sigs)
+	}()
+	return ctx, cancel
+}
+
+// isTerminal returns true if w is a terminal.
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// progressBar returns an asr.ProgressFunc that draws a progress bar of each
+// phase of a restore on stdout, redrawing it in place as it progresses.
+func progressBar(stdout io.Writer) asr.ProgressFunc {
+	const width = 40
+	var last string
+	return func(_ diskutil.VolumeInfo, p asr.Progress) {
+		filled := int(p.Percent / 100 * width)
+		bar := fmt.Sprintf("%-8s [%s%s] %3.0f%%", p.Phase, strings.Repeat("=", filled), strings.Repeat(" ", width-filled), p.Percent)
+		if p.Bytes > 0 {
+			bar += fmt.Sprintf(" (%s of %s)", formatBytes(p.Bytes), formatBytes(p.TotalBytes))
+		}
+		if bar == last {
+			return
+		}
+		last = bar
+		fmt.Fprintf(stdout, "\r%s", bar)
+		if p.Percent == 100 {
+			fmt.Fprintln(stdout)
 		}
 	}
+}
+
+// formatBytes formats n bytes using decimal units, like Finder.
+func formatBytes(n int64) string {
+	const unit = 1000
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "kMGTPE"[exp])
+}
+
+// hooks returns Options that run the hooks set by job and a target's opts.
+func hooks(job config.Job, opts config.TargetOptions) []cloner.Option {
+	var options []cloner.Option
+	for point, command := range map[cloner.HookPoint]string{
+		cloner.HookPreValidate: job.PreValidateHook,
+		cloner.HookPreRestore:  opts.PreRestoreHook,
+		cloner.HookPostRestore: opts.PostRestoreHook,
+		cloner.HookPostPrune:   opts.PostPruneHook,
+		cloner.HookPostRun:     job.PostRunHook,
+	} {
+		if command != "" {
+			options = append(options, cloner.OnHook(point, cloner.CommandHook(command)))
+		}
+	}
+	return options
+}
 
-	errs := make(map[string]error) // Map of target volume to clone error.
-	for _, target := range targets {
-		fmt.Printf("Cloning %q to %q...\n", source, target)
-		if err := c.Clone(source, target); err != nil {
-			errs[target] = err
-			fmt.Fprintf(os.Stderr, "failed to clone %q to %q: %v\n", source, target, err)
+// notifiers returns OrchestratorOptions that send the notifications set by
+// job, and with -output=json, write the summary of each run. Dry runs only
+// write the summary.
+func notifiers(job config.Job) []cloner.OrchestratorOption {
+	var opts []cloner.OrchestratorOption
+	if events != nil {
+		opts = append(opts, cloner.Notify(events))
+	}
+	if *dryrun {
+		return opts
+	}
+	if job.NotifyWebhook != "" {
+		opts = append(opts, cloner.Notify(notify.Webhook(job.NotifyWebhook)))
+	}
+	if len(job.NotifyEmail) > 0 {
+		var auth smtp.Auth
+		if username := os.Getenv("OFFSITE_SMTP_USERNAME"); username != "" {
+			host, _, _ := net.SplitHostPort(job.SMTPServer)
+			auth = smtp.PlainAuth("", username, os.Getenv("OFFSITE_SMTP_PASSWORD"), host)
 		}
+		opts = append(opts, cloner.Notify(notify.Email(job.SMTPServer, job.SMTPFrom, job.NotifyEmail, auth)))
+	}
+	if job.NotifyCommand != "" {
+		opts = append(opts, cloner.Notify(notify.Command(job.NotifyCommand)))
 	}
-	if len(errs) > 0 {
-		fmt.Fprintf(os.Stderr, "failed to clone to %d/%d targets\n", len(errs), len(targets))
-		os.Exit(1)
+	if job.NotifyMacOS {
+		opts = append(opts, cloner.Notify(notify.MacOS()))
+	}
+	return opts
+}
+
+// journal returns the Journal set by the command line flags. Dry runs do not
+// modify targets, so are not journaled.
+func journal() cloner.Journal {
+	if *journalDir == "" || *dryrun {
+		return nil
 	}
+	return cloner.NewFileJournal(*journalDir)
 }
 
 func parseArguments() (source string, targets []string, err error) {
@@ -129,23 +1238,60 @@ func parseArguments() (source string, targets []string, err error) {
 	return source, targets, nil
 }
 
-func validateFlags(targets []string) error {
+func validateFlags() error {
 	if *initialize && *prune {
 		return errors.New("-initialize and -prune are incompatible")
 	}
+	if *keepLast < 0 || *keepDaily < 0 || *keepWeekly < 0 || *keepMonthly < 0 || *maxAge < 0 {
+		return errors.New("retention flags must not be negative")
+	}
+	if *createSnapshot && *snapshot != "latest" {
+		return errors.New("-create-snapshot and -snapshot are incompatible")
+	}
+	if *fallbackRestores < 0 {
+		return errors.New("-fallback-restores must not be negative")
+	}
+	if *parallel < 1 {
+		return errors.New("-parallel must be at least 1")
+	}
+	if *initialize && *catchUp {
+		return errors.New("-initialize and -catchup are incompatible")
+	}
+	if *initialize && *discardDiverged {
+		return errors.New("-initialize and -discard-diverged are incompatible")
+	}
+	if *printPlan && *fromPlan != "" {
+		return errors.New("-plan and -from-plan are incompatible")
+	}
+	if *fromPlan != "" && *createSnapshot {
+		return errors.New("-from-plan and -create-snapshot are incompatible")
+	}
+	if len(notifyEmail) > 0 && (*smtpServer == "" || *smtpFrom == "") {
+		return errors.New("-notify-email requires -smtp-server and -smtp-from")
+	}
 	return nil
 }
 
-func confirm(source string, targets []string) error {
-	if *initialize {
-		fmt.Printf("This will delete all data on the following volumes before restoring them to %s's most recent snapshot.\n", source)
+func confirm(plan cloner.ClonePlan) error {
+	var destructive, discard bool
+	for _, tp := range plan.Targets {
+		if tp.Type == cloner.RestoreDestructive {
+			destructive = true
+		}
+		if len(tp.Discard) > 0 {
+			discard = true
+		}
+	}
+	if destructive {
+		fmt.Fprintln(textOut, "This will delete all data on initialized targets before restoring them.")
 	} else {
-		fmt.Println("This will keep existing snapshots but delete any data written to the following volume's after their most recent snapshot.")
+		fmt.Fprintln(textOut, "This will keep existing snapshots but delete any data written to the following volume's after their most recent snapshot.")
 	}
-	for _, t := range targets {
-		fmt.Printf("  - %s\n", t)
+	if discard {
+		fmt.Fprintln(textOut, "Snapshots that only targets have, and the data in them, will be discarded.")
 	}
-	fmt.Print("This cannot be undone. Are you sure? y/N: ")
+	fmt.Fprint(textOut, plan)
+	fmt.Fprint(textOut, "This cannot be undone. Are you sure? y/N: ")
 	r := bufio.NewReader(os.Stdin)
 	response, err := r.ReadString('\n')
 	if err != nil {
@@ -157,7 +1303,7 @@ func confirm(source string, targets []string) error {
 	case "yes":
 		return nil
 	}
-	return errors.New("-initialize confirmation rejected")
+	return errors.New("confirmation rejected")
 }
 
 type prefixWriter struct {
@@ -177,7 +1323,7 @@ func newPrefixWriter(prefix []byte, w io.Writer) *prefixWriter {
 }
 
 func (w *prefixWriter) Write(p []byte) (n int, err error) {
-	lines := bytes.SplitAfter(p, []byte("\n"))
+	lines := splitLines(p)
 	if len(lines[len(lines)-1]) == 0 {
 		// If p ends in a newline, remove the last element so we don't write a tab
 		// after the last newline.
@@ -206,7 +1352,22 @@ func (w *prefixWriter) Write(p []byte) (n int, err error) {
 		}
 	}
 	// Only prefix the next call to Write with prefix if p ends in a
-	// newline.
-	w.prefixNextWrite = p[len(p)-1] == '\n'
+	// newline or carriage return.
+	w.prefixNextWrite = p[len(p)-1] == '\n' || p[len(p)-1] == '\r'
 	return len(p), nil
 }
+
+// splitLines splits p after each newline, and after each carriage return
+// that isn't part of a CRLF, so that lines redrawn in place, e.g. progress
+// bars, are prefixed as well.
+func splitLines(p []byte) [][]byte {
+	var lines [][]byte
+	start := 0
+	for i, b := range p {
+		if b == '\n' || b == '\r' && (i+1 == len(p) || p[i+1] != '\n') {
+			lines = append(lines, p[start:i+1])
+			start = i + 1
+		}
+	}
+	return append(lines, p[start:])
+}
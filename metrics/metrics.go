@@ -0,0 +1,228 @@
+// Package metrics implements writing metrics of runs in the Prometheus text
+// exposition format, for node_exporter's textfile collector.
+//
+// Each run updates the metrics of the targets it cloned to, and keeps the
+// metrics of other targets already in the file. This way, a single file may
+// be updated by runs to different targets, e.g. by the watch command, and
+// counters and the last success of a target survive runs that fail.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/cloner"
+)
+
+const prefix = "offsite_apfs_backup_"
+
+// Metrics written by Update.
+const (
+	LastRunTimestamp      = prefix + "last_run_timestamp_seconds"
+	RunsTotal             = prefix + "runs_total"
+	SourceSnapshots       = prefix + "source_snapshots"
+	TargetSnapshots       = prefix + "target_snapshots"
+	LastRunSuccess        = prefix + "last_run_success"
+	LastSuccessTimestamp  = prefix + "last_success_timestamp_seconds"
+	LastSnapshotTimestamp = prefix + "last_cloned_snapshot_timestamp_seconds"
+	LastSnapshotAge       = prefix + "last_cloned_snapshot_age_seconds"
+	RestoreDuration       = prefix + "restore_duration_seconds"
+	CloneDuration         = prefix + "clone_duration_seconds"
+	FailuresTotal         = prefix + "failures_total"
+)
+
+var help = map[string]struct{ typ, text string }{
+	LastRunTimestamp:      {"gauge", "Time the job last ran."},
+	RunsTotal:             {"counter", "Number of times the job ran."},
+	SourceSnapshots:       {"gauge", "Number of snapshots on the source when the job last ran."},
+	TargetSnapshots:       {"gauge", "Number of snapshots on the target after it was last cloned to."},
+	LastRunSuccess:        {"gauge", "Whether the last clone to the target succeeded (1) or failed (0)."},
+	LastSuccessTimestamp:  {"gauge", "Time of the last successful clone to the target."},
+	LastSnapshotTimestamp: {"gauge", "Creation time of the snapshot last successfully cloned to the target."},
+	LastSnapshotAge:       {"gauge", "Age of the snapshot last successfully cloned to the target, as of the last run."},
+	RestoreDuration:       {"gauge", "Duration of the restore phase of the last clone to the target."},
+	CloneDuration:         {"gauge", "Duration of all phases of the last clone to the target."},
+	FailuresTotal:         {"counter", "Number of failed clones to the target."},
+}
+
+// Run is the outcome of a run.
+type Run struct {
+	// Job is the name of the job that was run. Empty if run from the command
+	// line.
+	Job string
+	// Plan is the plan that was executed. Targets whose planning failed are
+	// not in Plan, and Plan is empty if the run failed before it was
+	// planned.
+	Plan    cloner.ClonePlan
+	Summary cloner.Summary
+	// Time is when the run finished.
+	Time time.Time
+}
+
+// mu serializes updates, so that concurrent runs don't lose each other's
+// updates.
+var mu sync.Mutex
+
+// Update updates the metrics in the textfile at path with the outcome of run.
+// The file is replaced atomically, so that it is never read partially written.
+func Update(path string, run Run) error {
+	mu.Lock()
+	defer mu.Unlock()
+	samples, err := read(path)
+	if err != nil {
+		return fmt.Errorf("error reading metrics: %v", err)
+	}
+	update(samples, run)
+	if err := write(path, samples); err != nil {
+		return fmt.Errorf("error writing metrics: %v", err)
+	}
+	return nil
+}
+
+// series identifies a time series by its metric name and labels.
+type series struct {
+	name   string
+	labels string
+}
+
+func (s series) String() string {
+	if s.labels == "" {
+		return s.name
+	}
+	return s.name + "{" + s.labels + "}"
+}
+
+// labelEscaper escapes label values as required by the text format.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func newSeries(name string, labels ...string) series {
+	var pairs []string
+	for i := 0; i+1 < len(labels); i += 2 {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, labels[i], labelEscaper.Replace(labels[i+1])))
+	}
+	return series{name, strings.Join(pairs, ",")}
+}
+
+func update(samples map[series]float64, run Run) {
+	now := float64(run.Time.Unix())
+	samples[newSeries(LastRunTimestamp, "job", run.Job)] = now
+	samples[newSeries(RunsTotal, "job", run.Job)]++
+	if run.Plan.Source.UUID != "" {
+		samples[newSeries(SourceSnapshots, "job", run.Job, "source", run.Plan.Source.UUID)] = float64(len(run.Plan.SourceSnapshots))
+	}
+
+	for _, t := range run.Summary.Targets {
+		labels := []string{"job", run.Job, "target", t.Target}
+		tp, planned := run.Plan.Target(t.Target)
+		if t.Err != nil {
+			samples[newSeries(LastRunSuccess, labels...)] = 0
+			samples[newSeries(FailuresTotal, labels...)]++
+		} else {
+			samples[newSeries(LastRunSuccess, labels...)] = 1
+			samples[newSeries(LastSuccessTimestamp, labels...)] = now
+			// Make sure the counter exists, so that increases from
+			// zero are detected.
+			samples[newSeries(FailuresTotal, labels...)] += 0
+			if created := t.Result.To.Created; !created.IsZero() {
+				samples[newSeries(LastSnapshotTimestamp, labels...)] = float64(created.Unix())
+			}
+			if planned {
+				samples[newSeries(TargetSnapshots, labels...)] = float64(len(tp.Snapshots()))
+			}
+		}
+		if created, ok := samples[newSeries(LastSnapshotTimestamp, labels...)]; ok {
+			samples[newSeries(LastSnapshotAge, labels...)] = now - created
+		}
+		if d, ok := t.Result.PhaseDuration(cloner.PhaseRestore); ok {
+			samples[newSeries(RestoreDuration, labels...)] = d.Seconds()
+		}
+		if len(t.Result.Timings) > 0 {
+			samples[newSeries(CloneDuration, labels...)] = t.Result.Duration().Seconds()
+		}
+	}
+}
+
+// read returns the samples in the textfile at path, which may not exist.
+func read(path string) (map[series]float64, error) {
+	samples := make(map[series]float64)
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return samples, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.LastIndex(line, " ")
+		if i < 0 {
+			continue
+		}
+		value, err := strconv.ParseFloat(line[i+1:], 64)
+		if err != nil {
+			continue
+		}
+		s := series{name: line[:i]}
+		if j := strings.Index(s.name, "{"); j >= 0 && strings.HasSuffix(s.name, "}") {
+			s.labels = s.name[j+1 : len(s.name)-1]
+			s.name = s.name[:j]
+		}
+		samples[s] = value
+	}
+	return samples, scanner.Err()
+}
+
+// write atomically replaces the textfile at path with samples.
+func write(path string, samples map[series]float64) error {
+	var all []series
+	for s := range samples {
+		all = append(all, s)
+	}
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].name != all[j].name {
+			return all[i].name < all[j].name
+		}
+		return all[i].labels < all[j].labels
+	})
+
+	b := new(strings.Builder)
+	for i, s := range all {
+		if i == 0 || all[i-1].name != s.name {
+			if h, ok := help[s.name]; ok {
+				fmt.Fprintf(b, "# HELP %s %s\n", s.name, h.text)
+				fmt.Fprintf(b, "# TYPE %s %s\n", s.name, h.typ)
+			}
+		}
+		fmt.Fprintf(b, "%s %s\n", s, strconv.FormatFloat(samples[s], 'f', -1, 64))
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString(b.String()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	// Readable by node_exporter, which typically doesn't run as root.
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,153 @@
+package metrics
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/cloner"
+	"github.com/voidingwarranties/offsite-apfs-backup/diskutil"
+)
+
+func TestUpdate(t *testing.T) {
+	start := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	snap1 := diskutil.Snapshot{Name: "snap-1", UUID: "snap-1-uuid", Created: start.Add(-2 * time.Hour)}
+	snap2 := diskutil.Snapshot{Name: "snap-2", UUID: "snap-2-uuid", Created: start.Add(-time.Hour)}
+	plan := cloner.ClonePlan{
+		Source:          diskutil.VolumeInfo{UUID: "source-uuid"},
+		SourceSnapshots: []diskutil.Snapshot{snap2, snap1},
+		Targets: []cloner.TargetPlan{
+			{
+				Arg:             "a-uuid",
+				TargetSnapshots: []diskutil.Snapshot{snap1},
+				From:            &snap1,
+				To:              snap2,
+			},
+			{
+				Arg:             "b-uuid",
+				TargetSnapshots: []diskutil.Snapshot{snap1},
+				From:            &snap1,
+				To:              snap2,
+			},
+		},
+	}
+	success := cloner.CloneResult{
+		To: snap2,
+		Timings: []cloner.PhaseTiming{
+			{Phase: cloner.PhaseValidate, Duration: time.Second},
+			{Phase: cloner.PhaseRestore, Duration: time.Minute},
+		},
+	}
+	path := filepath.Join(t.TempDir(), "offsite.prom")
+
+	// a succeeds, b fails.
+	err := Update(path, Run{
+		Job:  "offsite",
+		Plan: plan,
+		Summary: cloner.Summary{
+			Targets: []cloner.TargetSummary{
+				{Target: "a-uuid", Result: success},
+				{Target: "b-uuid", Err: errors.New("failed")},
+			},
+		},
+		Time: start,
+	})
+	if err != nil {
+		t.Fatalf("Update returned unexpected error: %v", err)
+	}
+	// Later, only a is cloned to, and fails.
+	err = Update(path, Run{
+		Job: "offsite",
+		Summary: cloner.Summary{
+			Targets: []cloner.TargetSummary{
+				{Target: "a-uuid", Err: errors.New("failed")},
+			},
+		},
+		Time: start.Add(time.Hour),
+	})
+	if err != nil {
+		t.Fatalf("Update returned unexpected error: %v", err)
+	}
+
+	want := `# HELP offsite_apfs_backup_clone_duration_seconds Duration of all phases of the last clone to the target.
+# TYPE offsite_apfs_backup_clone_duration_seconds gauge
+offsite_apfs_backup_clone_duration_seconds{job="offsite",target="a-uuid"} 61
+# HELP offsite_apfs_backup_failures_total Number of failed clones to the target.
+# TYPE offsite_apfs_backup_failures_total counter
+offsite_apfs_backup_failures_total{job="offsite",target="a-uuid"} 1
+offsite_apfs_backup_failures_total{job="offsite",target="b-uuid"} 1
+# HELP offsite_apfs_backup_last_cloned_snapshot_age_seconds Age of the snapshot last successfully cloned to the target, as of the last run.
+# TYPE offsite_apfs_backup_last_cloned_snapshot_age_seconds gauge
+offsite_apfs_backup_last_cloned_snapshot_age_seconds{job="offsite",target="a-uuid"} 7200
+# HELP offsite_apfs_backup_last_cloned_snapshot_timestamp_seconds Creation time of the snapshot last successfully cloned to the target.
+# TYPE offsite_apfs_backup_last_cloned_snapshot_timestamp_seconds gauge
+offsite_apfs_backup_last_cloned_snapshot_timestamp_seconds{job="offsite",target="a-uuid"} 1614596400
+# HELP offsite_apfs_backup_last_run_success Whether the last clone to the target succeeded (1) or failed (0).
+# TYPE offsite_apfs_backup_last_run_success gauge
+offsite_apfs_backup_last_run_success{job="offsite",target="a-uuid"} 0
+offsite_apfs_backup_last_run_success{job="offsite",target="b-uuid"} 0
+# HELP offsite_apfs_backup_last_run_timestamp_seconds Time the job last ran.
+# TYPE offsite_apfs_backup_last_run_timestamp_seconds gauge
+offsite_apfs_backup_last_run_timestamp_seconds{job="offsite"} 1614603600
+# HELP offsite_apfs_backup_last_success_timestamp_seconds Time of the last successful clone to the target.
+# TYPE offsite_apfs_backup_last_success_timestamp_seconds gauge
+offsite_apfs_backup_last_success_timestamp_seconds{job="offsite",target="a-uuid"} 1614600000
+# HELP offsite_apfs_backup_restore_duration_seconds Duration of the restore phase of the last clone to the target.
+# TYPE offsite_apfs_backup_restore_duration_seconds gauge
+offsite_apfs_backup_restore_duration_seconds{job="offsite",target="a-uuid"} 60
+# HELP offsite_apfs_backup_runs_total Number of times the job ran.
+# TYPE offsite_apfs_backup_runs_total counter
+offsite_apfs_backup_runs_total{job="offsite"} 2
+# HELP offsite_apfs_backup_source_snapshots Number of snapshots on the source when the job last ran.
+# TYPE offsite_apfs_backup_source_snapshots gauge
+offsite_apfs_backup_source_snapshots{job="offsite",source="source-uuid"} 2
+# HELP offsite_apfs_backup_target_snapshots Number of snapshots on the target after it was last cloned to.
+# TYPE offsite_apfs_backup_target_snapshots gauge
+offsite_apfs_backup_target_snapshots{job="offsite",target="a-uuid"} 2
+`
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(want, string(got)); diff != "" {
+		t.Errorf("Update wrote unexpected metrics. -want +got:\n%s", diff)
+	}
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("Update left %d files in the metrics directory, want 1", len(entries))
+	}
+}
+
+func TestUpdate_EscapesLabels(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "offsite.prom")
+	run := Run{
+		Job: `"quoted" \ job`,
+		Summary: cloner.Summary{
+			Targets: []cloner.TargetSummary{{Target: "/Volumes/a"}},
+		},
+		Time: time.Unix(100, 0),
+	}
+	for i := 0; i < 2; i++ {
+		if err := Update(path, run); err != nil {
+			t.Fatalf("Update returned unexpected error: %v", err)
+		}
+	}
+	samples, err := read(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newSeries(RunsTotal, "job", run.Job)
+	if want := `job="\"quoted\" \\ job"`; s.labels != want {
+		t.Errorf("labels = %s, want: %s", s.labels, want)
+	}
+	if got := samples[s]; got != 2 {
+		t.Errorf("%s = %v, want: 2", s, got)
+	}
+}
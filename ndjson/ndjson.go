@@ -0,0 +1,102 @@
+// Package ndjson implements writing the progress and outcome of runs as
+// newline delimited JSON, one object per line, for other programs to consume.
+//
+// Every line has a "schema_version" field, set to SchemaVersion, and a "type"
+// field: either one of the cloner.EventTypes, with the fields of
+// cloner.Event, or "summary", with the fields of cloner.RunReport. The
+// summary is the last line written for a run, including a run that fails
+// before any target is cloned, in which every target is reported as failed
+// (see cloner.Orchestrator.Fail). The diagnose command instead
+// writes a "diagnosis" line for each target, with the fields of
+// cloner.TargetHistory.
+package ndjson
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/cloner"
+)
+
+// SchemaVersion is the version of the format of each line. Fields and event
+// types may be added without changing it; it's incremented only if fields
+// are removed, renamed, or change meaning.
+const SchemaVersion = 1
+
+// TypeSummary is the type of the line written by Notify.
+const TypeSummary = "summary"
+
+// TypeDiagnosis is the type of the lines written by Diagnosis.
+const TypeDiagnosis = "diagnosis"
+
+// Writer writes cloner.Events and cloner.RunReports as lines of JSON. It
+// implements cloner.EventSink and cloner.Notifier. A Writer is safe for
+// concurrent use.
+type Writer struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+	err error
+}
+
+// New returns a Writer that writes to w.
+func New(w io.Writer) *Writer {
+	return &Writer{enc: json.NewEncoder(w)}
+}
+
+type eventLine struct {
+	SchemaVersion int `json:"schema_version"`
+	cloner.Event
+}
+
+type summaryLine struct {
+	SchemaVersion int    `json:"schema_version"`
+	Type          string `json:"type"`
+	cloner.RunReport
+}
+
+type diagnosisLine struct {
+	SchemaVersion int    `json:"schema_version"`
+	Type          string `json:"type"`
+	cloner.TargetHistory
+	// Error is the error cloning to the target would fail with, if any.
+	Error string `json:"error,omitempty"`
+}
+
+// Event writes e. Errors are returned by Err.
+func (w *Writer) Event(e cloner.Event) {
+	w.write(eventLine{SchemaVersion, e})
+}
+
+// Notify writes report as a summary.
+func (w *Writer) Notify(report cloner.RunReport) error {
+	return w.write(summaryLine{SchemaVersion, TypeSummary, report})
+}
+
+// Diagnosis writes th as a diagnosis.
+func (w *Writer) Diagnosis(th cloner.TargetHistory) error {
+	line := diagnosisLine{SchemaVersion: SchemaVersion, Type: TypeDiagnosis, TargetHistory: th}
+	if err := th.History.Err(); err != nil {
+		line.Error = err.Error()
+	}
+	return w.write(line)
+}
+
+// Err returns the first error writing a line, if any.
+func (w *Writer) Err() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.err
+}
+
+func (w *Writer) write(line interface{}) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	// json.Encoder writes each value in a single Write, followed by a
+	// newline.
+	err := w.enc.Encode(line)
+	if err != nil && w.err == nil {
+		w.err = err
+	}
+	return err
+}
@@ -0,0 +1,154 @@
+package ndjson
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/cloner"
+	"github.com/voidingwarranties/offsite-apfs-backup/diskutil"
+)
+
+func TestWriter(t *testing.T) {
+	start := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	target := diskutil.VolumeInfo{Name: "target", UUID: "target-uuid"}
+	snap := diskutil.Snapshot{Name: "snap", UUID: "snap-uuid"}
+	b := new(bytes.Buffer)
+	w := New(b)
+	w.Event(cloner.Event{
+		Type:   cloner.EventRestoreStart,
+		Time:   start,
+		Target: &target,
+		To:     &snap,
+	})
+	w.Event(cloner.Event{
+		Type:            cloner.EventRestoreEnd,
+		Time:            start.Add(time.Minute),
+		Target:          &target,
+		To:              &snap,
+		Outcome:         cloner.OutcomeFailure,
+		Error:           "failed",
+		DurationSeconds: 60,
+	})
+	err := w.Notify(cloner.RunReport{
+		Started:  start,
+		Finished: start.Add(time.Minute),
+		Outcome:  cloner.OutcomeFailure,
+		Failed:   1,
+		Targets: []cloner.TargetReport{
+			{Target: "/Volumes/target", Outcome: cloner.OutcomeFailure, Error: "failed", DurationSeconds: 60},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Notify returned unexpected error: %v", err)
+	}
+	if err := w.Err(); err != nil {
+		t.Fatalf("Err() = %v, want: nil", err)
+	}
+
+	want := `{"schema_version":1,"type":"restore_start","time":"2021-03-01T12:00:00Z","target":{"VolumeUUID":"target-uuid","VolumeName":"target","MountPoint":"","DeviceNode":"","WritableVolume":false,"FilesystemType":"","FilesystemName":""},"to":{"SnapshotName":"snap","SnapshotUUID":"snap-uuid"}}
+{"schema_version":1,"type":"restore_end","time":"2021-03-01T12:01:00Z","target":{"VolumeUUID":"target-uuid","VolumeName":"target","MountPoint":"","DeviceNode":"","WritableVolume":false,"FilesystemType":"","FilesystemName":""},"to":{"SnapshotName":"snap","SnapshotUUID":"snap-uuid"},"outcome":"failure","error":"failed","duration_seconds":60}
+{"schema_version":1,"type":"summary","started":"2021-03-01T12:00:00Z","finished":"2021-03-01T12:01:00Z","outcome":"failure","succeeded":0,"failed":1,"targets":[{"target":"/Volumes/target","outcome":"failure","error":"failed","duration_seconds":60}]}
+`
+	if diff := cmp.Diff(want, b.String()); diff != "" {
+		t.Errorf("Writer wrote unexpected lines. -want +got:\n%s", diff)
+	}
+}
+
+func TestWriter_FailedRun(t *testing.T) {
+	b := new(bytes.Buffer)
+	o := cloner.NewOrchestrator(cloner.OrchestratorStdout(io.Discard), cloner.Notify(New(b)))
+	o.Fail([]string{"/Volumes/target"}, errors.New("no snapshots in common"))
+
+	var got struct {
+		SchemaVersion int    `json:"schema_version"`
+		Type          string `json:"type"`
+		Outcome       string `json:"outcome"`
+		Targets       []struct {
+			Target string `json:"target"`
+			Error  string `json:"error"`
+		} `json:"targets"`
+	}
+	if err := json.Unmarshal(b.Bytes(), &got); err != nil {
+		t.Fatalf("Writer wrote invalid JSON %q: %v", b, err)
+	}
+	if got.SchemaVersion != SchemaVersion || got.Type != TypeSummary || got.Outcome != cloner.OutcomeFailure {
+		t.Errorf("Writer wrote %s, want: a failed summary", b)
+	}
+	if len(got.Targets) != 1 || got.Targets[0].Error != "no snapshots in common" {
+		t.Errorf("Writer wrote targets %+v, want: /Volumes/target failed with the run's error", got.Targets)
+	}
+}
+
+func TestWriter_Concurrent(t *testing.T) {
+	b := new(bytes.Buffer)
+	w := New(b)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.Event(cloner.Event{Type: cloner.EventValidate, Outcome: cloner.OutcomeSuccess})
+		}()
+	}
+	wg.Wait()
+	lines := strings.Split(strings.TrimSuffix(b.String(), "\n"), "\n")
+	if len(lines) != 10 {
+		t.Fatalf("Writer wrote %d lines, want: 10", len(lines))
+	}
+	for _, line := range lines {
+		var got map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &got); err != nil {
+			t.Errorf("Writer wrote invalid JSON line %q: %v", line, err)
+		}
+	}
+}
+
+type errWriter struct{}
+
+func (errWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("write failed")
+}
+
+func TestWriter_Err(t *testing.T) {
+	w := New(errWriter{})
+	w.Event(cloner.Event{Type: cloner.EventValidate})
+	if err := w.Err(); err == nil {
+		t.Error("Err() = nil, want: error")
+	}
+	if err := w.Notify(cloner.RunReport{}); err == nil {
+		t.Error("Notify returned nil, want: error")
+	}
+}
+
+func TestWriter_Diagnosis(t *testing.T) {
+	target := diskutil.VolumeInfo{Name: "target", UUID: "target-uuid"}
+	snap := diskutil.Snapshot{Name: "snap", UUID: "snap-uuid"}
+	b := new(bytes.Buffer)
+	w := New(b)
+	err := w.Diagnosis(cloner.TargetHistory{
+		Arg:    "/Volumes/target",
+		Target: target,
+		History: cloner.History{
+			Relation: cloner.RelationInSync,
+			Selected: snap,
+			Common:   &snap,
+		},
+	})
+	if err != nil {
+		t.Fatalf("Diagnosis returned unexpected error: %v", err)
+	}
+
+	want := `{"schema_version":1,"type":"diagnosis","arg":"/Volumes/target","target":{"VolumeUUID":"target-uuid","VolumeName":"target","MountPoint":"","DeviceNode":"","WritableVolume":false,"FilesystemType":"","FilesystemName":""},"history":{"relation":"in_sync","selected":{"SnapshotName":"snap","SnapshotUUID":"snap-uuid"},"common":{"SnapshotName":"snap","SnapshotUUID":"snap-uuid"}},"error":"target is up to date: target's latest snapshot is already the selected snapshot snap (snap-uuid)"}
+`
+	if diff := cmp.Diff(want, b.String()); diff != "" {
+		t.Errorf("Writer wrote unexpected line. -want +got:\n%s", diff)
+	}
+}
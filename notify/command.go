@@ -0,0 +1,131 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/cloner"
+)
+
+// runner runs local commands.
+type runner struct {
+	execCommand func(context.Context, string, ...string) *exec.Cmd
+}
+
+type option func(*runner)
+
+func withExecCommand(f func(context.Context, string, ...string) *exec.Cmd) option {
+	return func(r *runner) {
+		r.execCommand = f
+	}
+}
+
+func newRunner(opts ...option) runner {
+	r := runner{
+		execCommand: exec.CommandContext,
+	}
+	for _, opt := range opts {
+		opt(&r)
+	}
+	return r
+}
+
+// run runs name with args, killing it if ctx is done first.
+func (r runner) run(ctx context.Context, stdin []byte, env []string, name string, args ...string) error {
+	cmd := r.execCommand(ctx, name, args...)
+	if cmd.Env == nil {
+		cmd.Env = os.Environ()
+	}
+	cmd.Env = append(cmd.Env, env...)
+	cmd.Stdin = bytes.NewReader(stdin)
+	stderr := new(bytes.Buffer)
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("`%s` failed (%w) with stderr: %s", cmd, err, stderr)
+	}
+	return nil
+}
+
+// Command returns a Notifier that runs command using `sh -c`. The command
+// receives the cloner.RunReport as JSON on stdin, and its outcome, subject
+// (see Text), and number of succeeded and failed targets as the
+// OFFSITE_OUTCOME, OFFSITE_SUBJECT, OFFSITE_SUCCEEDED, and OFFSITE_FAILED
+// environment variables. The Notifier is a cloner.ContextNotifier, which
+// kills command if its context is done first.
+func Command(command string, opts ...option) cloner.Notifier {
+	return commandNotifier{
+		command: command,
+		runner:  newRunner(opts...),
+	}
+}
+
+type commandNotifier struct {
+	command string
+	runner  runner
+}
+
+func (n commandNotifier) Notify(report cloner.RunReport) error {
+	return n.NotifyContext(context.Background(), report)
+}
+
+func (n commandNotifier) NotifyContext(ctx context.Context, report cloner.RunReport) error {
+	stdin, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	subject, _ := Text(report)
+	env := []string{
+		"OFFSITE_OUTCOME=" + report.Outcome,
+		"OFFSITE_SUBJECT=" + subject,
+		fmt.Sprintf("OFFSITE_SUCCEEDED=%d", report.Succeeded),
+		fmt.Sprintf("OFFSITE_FAILED=%d", report.Failed),
+	}
+	return n.runner.run(ctx, stdin, env, "sh", "-c", n.command)
+}
+
+// MacOS returns a Notifier that shows a macOS notification using osascript.
+// Like Command's, the Notifier is a cloner.ContextNotifier.
+func MacOS(opts ...option) cloner.Notifier {
+	return macOSNotifier{
+		runner: newRunner(opts...),
+	}
+}
+
+type macOSNotifier struct {
+	runner runner
+}
+
+func (n macOSNotifier) Notify(report cloner.RunReport) error {
+	return n.NotifyContext(context.Background(), report)
+}
+
+func (n macOSNotifier) NotifyContext(ctx context.Context, report cloner.RunReport) error {
+	var failed []string
+	for _, t := range report.Targets {
+		if t.Outcome != cloner.OutcomeSuccess {
+			name := t.Name
+			if name == "" {
+				name = t.Target
+			}
+			failed = append(failed, name)
+		}
+	}
+	message := report.String() + "."
+	if len(failed) > 0 {
+		message = fmt.Sprintf("%s: %s.", report, strings.Join(failed, ", "))
+	}
+	script := fmt.Sprintf("display notification %s with title %s", appleScriptString(message), appleScriptString("offsite-apfs-backup"))
+	return n.runner.run(ctx, nil, nil, "osascript", "-e", script)
+}
+
+// appleScriptString returns s as an AppleScript string literal.
+func appleScriptString(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	return `"` + s + `"`
+}
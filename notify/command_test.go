@@ -0,0 +1,114 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/cloner"
+	"github.com/voidingwarranties/offsite-apfs-backup/testutils/fakecmd"
+)
+
+func TestHelperProcess(t *testing.T) {
+	fakecmd.HelperProcess(t)
+}
+
+func TestCommand(t *testing.T) {
+	stdin, err := json.Marshal(report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		opts    []fakecmd.Option
+		wantErr bool
+	}{
+		{
+			name: "passes report as JSON on stdin",
+			opts: []fakecmd.Option{
+				fakecmd.WantArg("sh", "-c"),
+				fakecmd.WantArg("sh", "notify-send backup"),
+				fakecmd.WantStdin("sh", string(stdin)),
+			},
+		},
+		{
+			name: "command fails",
+			opts: []fakecmd.Option{
+				fakecmd.WantStdin("sh", string(stdin)),
+				fakecmd.Stderr("sh", "not found"),
+				fakecmd.ExitFail("sh"),
+			},
+			wantErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			execCmd := fakecmd.FakeCommandContext(t, test.opts...)
+			err := Command("notify-send backup", withExecCommand(execCmd)).Notify(report)
+			if err := fakecmd.AsHelperProcessErr(err); err != nil {
+				t.Fatal(err)
+			}
+			if gotErr := err != nil; gotErr != test.wantErr {
+				t.Errorf("Notify returned error: %v, want error: %t", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestCommand_Canceled(t *testing.T) {
+	execCmd := fakecmd.FakeCommandContext(t, fakecmd.Block("sh"))
+	n := Command("notify-send backup", withExecCommand(execCmd)).(cloner.ContextNotifier)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := n.NotifyContext(ctx, report); err == nil {
+		t.Error("NotifyContext returned error: nil, want: non-nil")
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("NotifyContext returned after %v, want: soon after ctx was done", elapsed)
+	}
+}
+
+func TestMacOS(t *testing.T) {
+	tests := []struct {
+		name   string
+		report cloner.RunReport
+		want   string
+	}{
+		{
+			name:   "failure",
+			report: report,
+			want:   `display notification "Failed to clone to 1/2 targets: /Volumes/b." with title "offsite-apfs-backup"`,
+		},
+		{
+			name: "success with quotes",
+			report: cloner.NewRunReport(cloner.Summary{
+				Targets: []cloner.TargetSummary{{Target: `"quoted"`}},
+			}, report.Started, report.Finished),
+			want: `display notification "Cloned to 1/1 targets." with title "offsite-apfs-backup"`,
+		},
+		{
+			name: "escapes quotes",
+			report: cloner.NewRunReport(cloner.Summary{
+				Targets: []cloner.TargetSummary{{Target: `"quoted" \ target`, Err: errFailed}},
+			}, report.Started, report.Finished),
+			want: `display notification "Failed to clone to 1/1 targets: \"quoted\" \\ target." with title "offsite-apfs-backup"`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			execCmd := fakecmd.FakeCommandContext(t,
+				fakecmd.WantArg("osascript", "-e"),
+				fakecmd.WantArg("osascript", test.want),
+			)
+			err := MacOS(withExecCommand(execCmd)).Notify(test.report)
+			if err := fakecmd.AsHelperProcessErr(err); err != nil {
+				t.Fatal(err)
+			}
+			if err != nil {
+				t.Errorf("Notify returned unexpected error: %v", err)
+			}
+		})
+	}
+}
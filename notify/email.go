@@ -0,0 +1,48 @@
+package notify
+
+import (
+	"bytes"
+	"fmt"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/cloner"
+)
+
+// Email returns a Notifier that emails a plain text description of the
+// cloner.RunReport (see Text) from from to each of to, using the SMTP server
+// at addr (host:port). auth may be nil if the server does not require
+// authentication.
+func Email(addr, from string, to []string, auth smtp.Auth) cloner.Notifier {
+	return email{
+		addr: addr,
+		from: from,
+		to:   to,
+		auth: auth,
+	}
+}
+
+type email struct {
+	addr string
+	from string
+	to   []string
+	auth smtp.Auth
+}
+
+func (e email) Notify(report cloner.RunReport) error {
+	subject, body := Text(report)
+	msg := new(bytes.Buffer)
+	fmt.Fprintf(msg, "From: %s\r\n", e.from)
+	fmt.Fprintf(msg, "To: %s\r\n", strings.Join(e.to, ", "))
+	fmt.Fprintf(msg, "Subject: %s\r\n", subject)
+	fmt.Fprintf(msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	msg.WriteString("\r\n")
+	msg.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+	if err := smtp.SendMail(e.addr, e.auth, e.from, e.to, msg.Bytes()); err != nil {
+		return fmt.Errorf("error sending email via %s: %w", e.addr, err)
+	}
+	return nil
+}
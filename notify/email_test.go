@@ -0,0 +1,126 @@
+package notify
+
+import (
+	"bufio"
+	"net"
+	"net/textproto"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// smtpServer is a minimal SMTP server that accepts a single message.
+type smtpServer struct {
+	listener net.Listener
+	done     chan struct{}
+
+	from string
+	to   []string
+	data string
+}
+
+func newSMTPServer(t *testing.T, reject bool) *smtpServer {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &smtpServer{
+		listener: l,
+		done:     make(chan struct{}),
+	}
+	go s.serve(t, reject)
+	return s
+}
+
+func (s *smtpServer) serve(t *testing.T, reject bool) {
+	defer close(s.done)
+	conn, err := s.listener.Accept()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer conn.Close()
+	c := textproto.NewConn(conn)
+	c.PrintfLine("220 localhost ready")
+	for {
+		line, err := c.ReadLine()
+		if err != nil {
+			return
+		}
+		verb := strings.ToUpper(strings.Fields(line)[0])
+		switch verb {
+		case "EHLO", "HELO":
+			c.PrintfLine("250 localhost")
+		case "MAIL":
+			s.from = strings.TrimSuffix(strings.TrimPrefix(line, "MAIL FROM:<"), ">")
+			c.PrintfLine("250 OK")
+		case "RCPT":
+			if reject {
+				c.PrintfLine("550 no such user")
+				continue
+			}
+			s.to = append(s.to, strings.TrimSuffix(strings.TrimPrefix(line, "RCPT TO:<"), ">"))
+			c.PrintfLine("250 OK")
+		case "DATA":
+			c.PrintfLine("354 go ahead")
+			data, err := c.ReadDotBytes()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			s.data = string(data)
+			c.PrintfLine("250 OK")
+		case "QUIT":
+			c.PrintfLine("221 bye")
+			return
+		default:
+			c.PrintfLine("502 not implemented")
+		}
+	}
+}
+
+func TestEmail(t *testing.T) {
+	server := newSMTPServer(t, false)
+	defer server.listener.Close()
+
+	n := Email(server.listener.Addr().String(), "backup@example.com", []string{"a@example.com", "b@example.com"}, nil)
+	if err := n.Notify(report); err != nil {
+		t.Fatalf("Notify returned unexpected error: %v", err)
+	}
+	<-server.done
+
+	if server.from != "backup@example.com" {
+		t.Errorf("email sent from %q, want: %q", server.from, "backup@example.com")
+	}
+	if diff := cmp.Diff([]string{"a@example.com", "b@example.com"}, server.to); diff != "" {
+		t.Errorf("email sent to unexpected recipients. -want +got:\n%s", diff)
+	}
+	r := textproto.NewReader(bufio.NewReader(strings.NewReader(server.data)))
+	header, err := r.ReadMIMEHeader()
+	if err != nil {
+		t.Fatalf("email has invalid header: %v", err)
+	}
+	subject, body := Text(report)
+	if got := header.Get("Subject"); got != subject {
+		t.Errorf("email has subject %q, want: %q", got, subject)
+	}
+	if got := header.Get("To"); got != "a@example.com, b@example.com" {
+		t.Errorf("email has To %q, want: %q", got, "a@example.com, b@example.com")
+	}
+	gotBody := server.data[strings.Index(server.data, "\n\n")+2:]
+	if diff := cmp.Diff(body, gotBody); diff != "" {
+		t.Errorf("email has unexpected body. -want +got:\n%s", diff)
+	}
+}
+
+func TestEmail_Error(t *testing.T) {
+	server := newSMTPServer(t, true)
+	defer server.listener.Close()
+
+	n := Email(server.listener.Addr().String(), "backup@example.com", []string{"a@example.com"}, nil)
+	if err := n.Notify(report); err == nil || !strings.Contains(err.Error(), "no such user") {
+		t.Errorf("Notify returned error %v, want error containing %q", err, "no such user")
+	}
+	<-server.done
+}
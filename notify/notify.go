@@ -0,0 +1,45 @@
+// Package notify implements cloner.Notifiers that tell someone the outcome of
+// a run: by posting it to a webhook, emailing it, running a command, or
+// showing a macOS notification.
+package notify
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/cloner"
+)
+
+// Text returns a plain text subject and body describing report.
+func Text(report cloner.RunReport) (subject, body string) {
+	b := new(strings.Builder)
+	fmt.Fprintf(b, "%s.\n\n", report)
+	for _, t := range report.Targets {
+		name := t.Target
+		if t.Name != "" {
+			name = fmt.Sprintf("%s (%s)", t.Name, t.Target)
+		}
+		fmt.Fprintf(b, "%s: %s\n", name, t.Outcome)
+		if t.Error != "" {
+			fmt.Fprintf(b, "\tError: %s\n", t.Error)
+		}
+		if t.To != nil {
+			fmt.Fprintf(b, "\tRestored to: %s\n", t.To)
+		}
+		if t.From != nil {
+			fmt.Fprintf(b, "\tRestored from: %s\n", t.From)
+		}
+		for _, s := range t.Discarded {
+			fmt.Fprintf(b, "\tDiscarded: %s\n", s)
+		}
+		for _, s := range t.Pruned {
+			fmt.Fprintf(b, "\tPruned: %s\n", s)
+		}
+		for _, w := range t.Warnings {
+			fmt.Fprintf(b, "\tWarning: %s\n", w)
+		}
+		fmt.Fprintf(b, "\tDuration: %.0fs\n", t.DurationSeconds)
+	}
+	fmt.Fprintf(b, "\nStarted %s, finished %s.\n", report.Started.Format("2006-01-02 15:04:05"), report.Finished.Format("2006-01-02 15:04:05"))
+	return "offsite-apfs-backup: " + report.String(), b.String()
+}
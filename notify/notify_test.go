@@ -0,0 +1,64 @@
+package notify
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/cloner"
+	"github.com/voidingwarranties/offsite-apfs-backup/diskutil"
+)
+
+var (
+	errFailed = errors.New("failed")
+
+	snap1 = diskutil.Snapshot{Name: "snap-1", UUID: "snap-1-uuid"}
+	snap2 = diskutil.Snapshot{Name: "snap-2", UUID: "snap-2-uuid"}
+
+	report = cloner.NewRunReport(cloner.Summary{
+		Targets: []cloner.TargetSummary{
+			{
+				Target: "/Volumes/a",
+				Result: cloner.CloneResult{
+					Source:   diskutil.VolumeInfo{Name: "Data"},
+					Target:   diskutil.VolumeInfo{Name: "A"},
+					From:     snap1,
+					To:       snap2,
+					Pruned:   []diskutil.Snapshot{snap1},
+					Warnings: []string{"rename failed"},
+					Timings:  []cloner.PhaseTiming{{Phase: cloner.PhaseRestore, Duration: 90 * time.Second}},
+				},
+			},
+			{
+				Target: "/Volumes/b",
+				Err:    errors.New("disk full"),
+			},
+		},
+	}, time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC), time.Date(2021, 3, 1, 12, 5, 0, 0, time.UTC))
+)
+
+func TestText(t *testing.T) {
+	subject, body := Text(report)
+	if want := "offsite-apfs-backup: Failed to clone to 1/2 targets"; subject != want {
+		t.Errorf("Text returned subject %q, want: %q", subject, want)
+	}
+	want := `Failed to clone to 1/2 targets.
+
+A (/Volumes/a): success
+	Restored to: snap-2 (snap-2-uuid)
+	Restored from: snap-1 (snap-1-uuid)
+	Pruned: snap-1 (snap-1-uuid)
+	Warning: rename failed
+	Duration: 90s
+/Volumes/b: failure
+	Error: disk full
+	Duration: 0s
+
+Started 2021-03-01 12:00:00, finished 2021-03-01 12:05:00.
+`
+	if diff := cmp.Diff(want, body); diff != "" {
+		t.Errorf("Text returned unexpected body. -want +got:\n%s", diff)
+	}
+}
@@ -0,0 +1,43 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/cloner"
+)
+
+// Webhook returns a Notifier that POSTs the cloner.RunReport as JSON to url.
+// The notification fails if the response status is not 2xx.
+func Webhook(url string) cloner.Notifier {
+	return webhook{
+		url:    url,
+		client: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+type webhook struct {
+	url    string
+	client *http.Client
+}
+
+func (w webhook) Notify(report cloner.RunReport) error {
+	body, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	resp, err := w.client.Post(w.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("error posting to webhook: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("webhook %s returned %s: %s", w.url, resp.Status, msg)
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package notify
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/cloner"
+)
+
+func TestWebhook(t *testing.T) {
+	var (
+		gotMethod, gotContentType string
+		got                       cloner.RunReport
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotMethod = r.Method
+		gotContentType = r.Header.Get("Content-Type")
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("webhook received invalid JSON: %v", err)
+		}
+	}))
+	defer server.Close()
+
+	if err := Webhook(server.URL).Notify(report); err != nil {
+		t.Fatalf("Notify returned unexpected error: %v", err)
+	}
+	if gotMethod != http.MethodPost {
+		t.Errorf("webhook received method %s, want: %s", gotMethod, http.MethodPost)
+	}
+	if gotContentType != "application/json" {
+		t.Errorf("webhook received Content-Type %q, want: %q", gotContentType, "application/json")
+	}
+	if diff := cmp.Diff(report, got); diff != "" {
+		t.Errorf("webhook received unexpected report. -want +got:\n%s", diff)
+	}
+}
+
+func TestWebhook_Error(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		http.Error(w, "no such hook", http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	err := Webhook(server.URL).Notify(report)
+	if err == nil || !strings.Contains(err.Error(), "no such hook") {
+		t.Errorf("Notify returned error %v, want error containing the response body", err)
+	}
+	server.Close()
+	if err := Webhook(server.URL).Notify(report); err == nil {
+		t.Error("Notify to a closed server returned no error")
+	}
+}
@@ -0,0 +1,203 @@
+package plutil
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const xmlHeader = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+`
+
+// Marshal returns the XML plist encoding of v, in the same format as
+// `plutil -convert xml1`.
+//
+// Marshal is the inverse of PLUtil.Unmarshal: struct fields are encoded as
+// dict keys named after the field, or its `json:"name"` tag, and the
+// "omitempty" and "-" tag options are respected. Maps must have string keys,
+// which are sorted. Strings, bools, integers, floats, time.Time, []byte,
+// slices, arrays, maps, and structs are supported. Nil pointers and interfaces
+// are omitted from dicts and arrays.
+func Marshal(v interface{}) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	buf.WriteString(xmlHeader)
+	if err := encode(buf, reflect.ValueOf(v), 0); err != nil {
+		return nil, err
+	}
+	buf.WriteString("</plist>\n")
+	return buf.Bytes(), nil
+}
+
+var (
+	timeType  = reflect.TypeOf(time.Time{})
+	bytesType = reflect.TypeOf([]byte(nil))
+)
+
+// encode writes v as a plist element, indented by depth tabs.
+func encode(buf *bytes.Buffer, v reflect.Value, depth int) error {
+	indent := strings.Repeat("\t", depth)
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return fmt.Errorf("cannot encode nil %s", v.Type())
+		}
+		v = v.Elem()
+	}
+	switch {
+	case !v.IsValid():
+		return fmt.Errorf("cannot encode nil")
+	case v.Type() == timeType:
+		fmt.Fprintf(buf, "%s<date>%s</date>\n", indent, v.Interface().(time.Time).UTC().Format(time.RFC3339))
+		return nil
+	case v.Type() == bytesType:
+		fmt.Fprintf(buf, "%s<data>%s</data>\n", indent, base64.StdEncoding.EncodeToString(v.Bytes()))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		buf.WriteString(indent + "<string>")
+		if err := xml.EscapeText(buf, []byte(v.String())); err != nil {
+			return err
+		}
+		buf.WriteString("</string>\n")
+	case reflect.Bool:
+		fmt.Fprintf(buf, "%s<%t/>\n", indent, v.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		fmt.Fprintf(buf, "%s<integer>%d</integer>\n", indent, v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		fmt.Fprintf(buf, "%s<integer>%d</integer>\n", indent, v.Uint())
+	case reflect.Float32, reflect.Float64:
+		fmt.Fprintf(buf, "%s<real>%s</real>\n", indent, strconv.FormatFloat(v.Float(), 'g', -1, 64))
+	case reflect.Slice, reflect.Array:
+		if v.Len() == 0 {
+			buf.WriteString(indent + "<array/>\n")
+			return nil
+		}
+		buf.WriteString(indent + "<array>\n")
+		for i := 0; i < v.Len(); i++ {
+			if isNil(v.Index(i)) {
+				continue
+			}
+			if err := encode(buf, v.Index(i), depth+1); err != nil {
+				return err
+			}
+		}
+		buf.WriteString(indent + "</array>\n")
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return fmt.Errorf("cannot encode %s: keys must be strings", v.Type())
+		}
+		var entries []dictEntry
+		for _, k := range v.MapKeys() {
+			entries = append(entries, dictEntry{k.String(), v.MapIndex(k)})
+		}
+		sort.Slice(entries, func(i, j int) bool { return entries[i].key < entries[j].key })
+		return encodeDict(buf, entries, depth)
+	case reflect.Struct:
+		return encodeDict(buf, structEntries(v), depth)
+	default:
+		return fmt.Errorf("cannot encode %s", v.Type())
+	}
+	return nil
+}
+
+type dictEntry struct {
+	key   string
+	value reflect.Value
+}
+
+func encodeDict(buf *bytes.Buffer, entries []dictEntry, depth int) error {
+	indent := strings.Repeat("\t", depth)
+	var kept []dictEntry
+	for _, e := range entries {
+		if !isNil(e.value) {
+			kept = append(kept, e)
+		}
+	}
+	if len(kept) == 0 {
+		buf.WriteString(indent + "<dict/>\n")
+		return nil
+	}
+	buf.WriteString(indent + "<dict>\n")
+	for _, e := range kept {
+		buf.WriteString(indent + "\t<key>")
+		if err := xml.EscapeText(buf, []byte(e.key)); err != nil {
+			return err
+		}
+		buf.WriteString("</key>\n")
+		if err := encode(buf, e.value, depth+1); err != nil {
+			return fmt.Errorf("%s: %w", e.key, err)
+		}
+	}
+	buf.WriteString(indent + "</dict>\n")
+	return nil
+}
+
+// structEntries returns the dict entries of the exported fields of struct v,
+// named as encoding/json would name them.
+func structEntries(v reflect.Value) []dictEntry {
+	var entries []dictEntry
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		name := field.Name
+		var omitEmpty bool
+		if tag, ok := field.Tag.Lookup("json"); ok {
+			if tag == "-" {
+				continue
+			}
+			opts := strings.Split(tag, ",")
+			if opts[0] != "" {
+				name = opts[0]
+			}
+			for _, opt := range opts[1:] {
+				omitEmpty = omitEmpty || opt == "omitempty"
+			}
+		}
+		value := v.Field(i)
+		if omitEmpty && isEmpty(value) {
+			continue
+		}
+		entries = append(entries, dictEntry{name, value})
+	}
+	return entries
+}
+
+func isNil(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		return v.IsNil()
+	}
+	return false
+}
+
+// isEmpty returns whether v is empty, as defined by encoding/json's
+// "omitempty" option.
+func isEmpty(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Ptr, reflect.Interface:
+		return v.IsNil()
+	}
+	return false
+}
@@ -0,0 +1,131 @@
+package plutil
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+type marshalStruct struct {
+	Name     string            `json:"name"`
+	Count    int               `json:"count,omitempty"`
+	Enabled  bool              `json:"enabled"`
+	Ratio    float64           `json:"ratio,omitempty"`
+	Tags     []string          `json:"tags,omitempty"`
+	Env      map[string]string `json:"env,omitempty"`
+	Created  time.Time         `json:"created"`
+	Data     []byte            `json:"data,omitempty"`
+	Child    *marshalStruct    `json:"child,omitempty"`
+	Ignored  string            `json:"-"`
+	Untagged uint
+	private  string
+}
+
+func TestMarshal(t *testing.T) {
+	v := marshalStruct{
+		Name:    "a <b> & c",
+		Count:   3,
+		Enabled: true,
+		Ratio:   0.5,
+		Tags:    []string{"x", "y"},
+		Env:     map[string]string{"Z": "1", "A": "2"},
+		Created: time.Date(2021, 3, 1, 20, 35, 9, 0, time.UTC),
+		Data:    []byte("hi"),
+		Child: &marshalStruct{
+			Name: "child",
+		},
+		Ignored:  "ignored",
+		Untagged: 7,
+		private:  "private",
+	}
+	want := `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>name</key>
+	<string>a &lt;b&gt; &amp; c</string>
+	<key>count</key>
+	<integer>3</integer>
+	<key>enabled</key>
+	<true/>
+	<key>ratio</key>
+	<real>0.5</real>
+	<key>tags</key>
+	<array>
+		<string>x</string>
+		<string>y</string>
+	</array>
+	<key>env</key>
+	<dict>
+		<key>A</key>
+		<string>2</string>
+		<key>Z</key>
+		<string>1</string>
+	</dict>
+	<key>created</key>
+	<date>2021-03-01T20:35:09Z</date>
+	<key>data</key>
+	<data>aGk=</data>
+	<key>child</key>
+	<dict>
+		<key>name</key>
+		<string>child</string>
+		<key>enabled</key>
+		<false/>
+		<key>created</key>
+		<date>0001-01-01T00:00:00Z</date>
+		<key>Untagged</key>
+		<integer>0</integer>
+	</dict>
+	<key>Untagged</key>
+	<integer>7</integer>
+</dict>
+</plist>
+`
+	got, err := Marshal(v)
+	if err != nil {
+		t.Fatalf("Marshal returned unexpected error: %v", err)
+	}
+	if diff := cmp.Diff(want, string(got)); diff != "" {
+		t.Errorf("Marshal returned unexpected plist. -want +got:\n%s", diff)
+	}
+}
+
+func TestMarshal_Empty(t *testing.T) {
+	got, err := Marshal(map[string][]int{"empty": nil})
+	if err != nil {
+		t.Fatalf("Marshal returned unexpected error: %v", err)
+	}
+	want := xmlHeader + "<dict>\n\t<key>empty</key>\n\t<array/>\n</dict>\n</plist>\n"
+	if diff := cmp.Diff(want, string(got)); diff != "" {
+		t.Errorf("Marshal returned unexpected plist. -want +got:\n%s", diff)
+	}
+}
+
+func TestMarshal_Errors(t *testing.T) {
+	tests := []struct {
+		name string
+		v    interface{}
+	}{
+		{
+			name: "nil",
+			v:    nil,
+		},
+		{
+			name: "non-string map keys",
+			v:    map[int]string{1: "a"},
+		},
+		{
+			name: "unsupported type",
+			v:    struct{ C chan int }{},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := Marshal(test.v); err == nil {
+				t.Errorf("Marshal(%#v) returned no error, want error", test.v)
+			}
+		})
+	}
+}
@@ -1,4 +1,5 @@
-// Package plutil implements plist unmarshalling using MacOS's plutil.
+// Package plutil implements plist marshalling, and unmarshalling using MacOS's
+// plutil.
 //
 //	data := `<?xml version="1.0" encoding="UTF-8"?>
 //	<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
@@ -0,0 +1,44 @@
+// +build darwin
+
+package plutil_test
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/plutil"
+)
+
+func TestMarshal_RoundTrip(t *testing.T) {
+	type child struct {
+		Hour   *int `json:",omitempty"`
+		Minute *int `json:",omitempty"`
+	}
+	type job struct {
+		Label            string
+		ProgramArguments []string
+		RunAtLoad        bool    `json:",omitempty"`
+		StartInterval    int     `json:",omitempty"`
+		Intervals        []child `json:",omitempty"`
+	}
+	zero, three := 0, 3
+	want := job{
+		Label:            "com.example.test",
+		ProgramArguments: []string{"/usr/local/bin/test", "-flag", "a & b"},
+		RunAtLoad:        true,
+		StartInterval:    3600,
+		Intervals:        []child{{Hour: &three, Minute: &zero}},
+	}
+	data, err := plutil.Marshal(want)
+	if err != nil {
+		t.Fatalf("Marshal returned unexpected error: %v", err)
+	}
+	var got job
+	if err := plutil.New().Unmarshal(data, &got); err != nil {
+		t.Fatalf("Unmarshal returned unexpected error: %v", err)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Unmarshal(Marshal(v)) != v. -want +got:\n%s", diff)
+	}
+}
@@ -17,6 +17,9 @@ import (
 	"testing"
 	"time"
 
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+
 	"github.com/voidingwarranties/offsite-apfs-backup/diskutil"
 	"github.com/voidingwarranties/offsite-apfs-backup/plutil"
 )
@@ -159,7 +162,14 @@ func (img DiskImage) UUID(t *testing.T) string {
 	return info.UUID
 }
 
-// Snapshots returns the APFS snapshots of the disk image.
+// IgnoreSnapshotState is a cmp.Option that ignores the fields of
+// diskutil.Snapshot that depend on the state of the APFS container the
+// snapshot is in, rather than on the snapshot itself, and so aren't set by
+// DiskImage.Snapshots: XID, Purgeable, and LimitingContainerShrink.
+var IgnoreSnapshotState cmp.Option = cmpopts.IgnoreFields(diskutil.Snapshot{}, "XID", "Purgeable", "LimitingContainerShrink")
+
+// Snapshots returns the APFS snapshots of the disk image. Compare them with
+// the snapshots listed by diskutil using IgnoreSnapshotState.
 func (img DiskImage) Snapshots(t *testing.T) []diskutil.Snapshot {
 	snaps, exists := snapshots[img]
 	if !exists {
@@ -50,12 +50,14 @@
 package fakecmd
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"os"
 	"os/exec"
 	"testing"
+	"time"
 )
 
 // config defines the behaviors of commands faked by FakeCommand. All keys in
@@ -66,6 +68,7 @@ type config struct {
 	exitFails  map[string]bool
 	wantStdins map[string]string
 	wantArgs   map[string]map[string]bool // Map value is set of args.
+	blocks     map[string]bool
 }
 
 // Option configures the behavior of a command faked by FakeCommand.
@@ -92,6 +95,14 @@ func ExitFail(name string) Option {
 	}
 }
 
+// Block causes `name` to never exit on its own, e.g. to test cancellation.
+// It must be killed or interrupted.
+func Block(name string) Option {
+	return func(conf *config) {
+		conf.blocks[name] = true
+	}
+}
+
 // WantStdin sets the expected value of `name`'s stdin. If a different value is
 // received, the helper process exits in such a way that AsHelperProcessErr
 // returns non-nil.
@@ -119,19 +130,30 @@ func WantArg(name string, arg string) Option {
 // exec.Command in tests. Inspired by the stdlib's exec_test. Modified to allow
 // specifying different stdouts, stderrs, stdins, and exit codes per command.
 func FakeCommand(t *testing.T, opts ...Option) func(string, ...string) *exec.Cmd {
+	fake := FakeCommandContext(t, opts...)
+	return func(name string, args ...string) *exec.Cmd {
+		return fake(context.Background(), name, args...)
+	}
+}
+
+// FakeCommandContext is like FakeCommand, but returns a function suitable for
+// replacing a call to exec.CommandContext. The fake command is killed when
+// the context is done.
+func FakeCommandContext(t *testing.T, opts ...Option) func(context.Context, string, ...string) *exec.Cmd {
 	conf := config{
 		stdouts:    make(map[string]string),
 		stderrs:    make(map[string]string),
 		exitFails:  make(map[string]bool),
 		wantStdins: make(map[string]string),
 		wantArgs:   make(map[string]map[string]bool),
+		blocks:     make(map[string]bool),
 	}
 	for _, opt := range opts {
 		opt(&conf)
 	}
-	return func(name string, args ...string) *exec.Cmd {
+	return func(ctx context.Context, name string, args ...string) *exec.Cmd {
 		validateArgs(t, name, conf.wantArgs[name], args)
-		cmd := exec.Command(os.Args[0], "-test.run=TestHelperProcess")
+		cmd := exec.CommandContext(ctx, os.Args[0], "-test.run=TestHelperProcess")
 		cmd.Env = append(os.Environ(),
 			"GO_WANT_HELPER_PROCESS=1",
 			fmt.Sprintf("GO_HELPER_PROCESS_STDOUT=%s", conf.stdouts[name]),
@@ -143,6 +165,9 @@ func FakeCommand(t *testing.T, opts ...Option) func(string, ...string) *exec.Cmd
 		if wantStdin, exists := conf.wantStdins[name]; exists {
 			cmd.Env = append(cmd.Env, fmt.Sprintf("GO_HELPER_PROCESS_WANT_STDIN=%s", wantStdin))
 		}
+		if conf.blocks[name] {
+			cmd.Env = append(cmd.Env, "GO_HELPER_PROCESS_BLOCK=1")
+		}
 		return cmd
 	}
 }
@@ -198,6 +223,10 @@ func HelperProcess(t *testing.T) {
 	//     stdin is incorrect, the test case's fake stderr will be included
 	//     in the error message.
 	fmt.Fprint(os.Stdout, os.Getenv("GO_HELPER_PROCESS_STDOUT"))
+	if _, exists := os.LookupEnv("GO_HELPER_PROCESS_BLOCK"); exists {
+		// Until killed, or interrupted by a signal.
+		time.Sleep(time.Hour)
+	}
 	gotStdin, err := io.ReadAll(os.Stdin)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error reading from STDIN: %v", err)
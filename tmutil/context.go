@@ -0,0 +1,28 @@
+package tmutil
+
+import "context"
+
+// WithContext returns tm as a ContextTMUtil. If tm doesn't implement
+// ContextTMUtil, LocalSnapshotContext of the returned TMUtil returns ctx's
+// error if ctx is already done, and otherwise calls tm.LocalSnapshot, which
+// runs to completion. WithContext returns nil if tm is nil.
+func WithContext(tm TMUtil) ContextTMUtil {
+	if tm == nil {
+		return nil
+	}
+	if ctm, ok := tm.(ContextTMUtil); ok {
+		return ctm
+	}
+	return contextTMUtil{tm}
+}
+
+type contextTMUtil struct {
+	TMUtil
+}
+
+func (tm contextTMUtil) LocalSnapshotContext(ctx context.Context) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	return tm.LocalSnapshot()
+}
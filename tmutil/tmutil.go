@@ -0,0 +1,76 @@
+// Package tmutil implements creating local APFS snapshots using MacOS's Time
+// Machine utility.
+package tmutil
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"regexp"
+)
+
+// TMUtil creates local APFS snapshots.
+type TMUtil interface {
+	LocalSnapshot() (string, error)
+}
+
+// ContextTMUtil is a TMUtil with variants of its methods that stop early if
+// ctx is done: tmutil is killed and the method returns an error. The TMUtil
+// returned by New implements ContextTMUtil. See WithContext.
+type ContextTMUtil interface {
+	TMUtil
+	LocalSnapshotContext(ctx context.Context) (string, error)
+}
+
+type tmUtil struct {
+	execCommand func(context.Context, string, ...string) *exec.Cmd
+}
+
+type option func(*tmUtil)
+
+func withExecCommand(f func(context.Context, string, ...string) *exec.Cmd) option {
+	return func(tm *tmUtil) {
+		tm.execCommand = f
+	}
+}
+
+// New returns a new TMUtil.
+func New(opts ...option) TMUtil {
+	tm := tmUtil{
+		execCommand: exec.CommandContext,
+	}
+	for _, opt := range opts {
+		opt(&tm)
+	}
+	return tm
+}
+
+// LocalSnapshot creates a local Time Machine snapshot of every APFS volume
+// included in Time Machine backups, and returns the snapshots' date, of the
+// form yyyy-mm-dd-hhmmss. The date is embedded in the name of each snapshot,
+// e.g. com.apple.TimeMachine.2021-03-01-203509.local.
+//
+// Volumes excluded from Time Machine backups are not snapshotted.
+func (tm tmUtil) LocalSnapshot() (string, error) {
+	return tm.LocalSnapshotContext(context.Background())
+}
+
+// LocalSnapshotContext is like LocalSnapshot, but kills tmutil if ctx is done
+// first.
+func (tm tmUtil) LocalSnapshotContext(ctx context.Context) (string, error) {
+	cmd := tm.execCommand(ctx, "tmutil", "localsnapshot")
+	stderr := new(bytes.Buffer)
+	cmd.Stderr = stderr
+	stdout, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("`%s` failed (%w) with stderr: %s", cmd, err, stderr)
+	}
+	// e.g. "Created local snapshot with date: 2021-03-01-203509"
+	dateRegex := regexp.MustCompile(`\d{4}-\d{2}-\d{2}-\d{6}`)
+	date := dateRegex.Find(stdout)
+	if date == nil {
+		return "", fmt.Errorf("`%s` output (%q) does not contain a snapshot date of the form yyyy-mm-dd-hhmmss", cmd, stdout)
+	}
+	return string(date), nil
+}
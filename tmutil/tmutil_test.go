@@ -0,0 +1,59 @@
+package tmutil
+
+import (
+	"errors"
+	"os/exec"
+	"testing"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/testutils/fakecmd"
+)
+
+func TestHelperProcess(t *testing.T) {
+	fakecmd.HelperProcess(t)
+}
+
+func TestLocalSnapshot(t *testing.T) {
+	tm := New(withExecCommand(fakecmd.FakeCommandContext(t,
+		fakecmd.Stdout("tmutil", "Created local snapshot with date: 2021-03-01-203509\n"),
+		fakecmd.WantArg("tmutil", "localsnapshot"),
+	)))
+	got, err := tm.LocalSnapshot()
+	if err := fakecmd.AsHelperProcessErr(err); err != nil {
+		t.Fatal(err)
+	}
+	if err != nil {
+		t.Fatalf("LocalSnapshot returned unexpected error: %v, want: nil", err)
+	}
+	if got != "2021-03-01-203509" {
+		t.Errorf("LocalSnapshot returned unexpected date: %q, want: %q", got, "2021-03-01-203509")
+	}
+}
+
+func TestLocalSnapshot_Errors(t *testing.T) {
+	t.Run("tmutil exec errors", func(t *testing.T) {
+		tm := New(withExecCommand(fakecmd.FakeCommandContext(t,
+			fakecmd.Stderr("tmutil", "example stderr"),
+			fakecmd.ExitFail("tmutil"),
+		)))
+		_, err := tm.LocalSnapshot()
+		if err := fakecmd.AsHelperProcessErr(err); err != nil {
+			t.Fatal(err)
+		}
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) {
+			t.Errorf("LocalSnapshot returned unexpected error: %v, want type: *exec.ExitError", err)
+		}
+	})
+	t.Run("no date in output", func(t *testing.T) {
+		tm := New(withExecCommand(fakecmd.FakeCommandContext(t,
+			fakecmd.Stdout("tmutil", "unexpected output"),
+		)))
+		_, err := tm.LocalSnapshot()
+		if err := fakecmd.AsHelperProcessErr(err); err != nil {
+			t.Fatal(err)
+		}
+		if err == nil {
+			t.Error("LocalSnapshot returned error: nil, want: non-nil")
+		}
+	})
+}
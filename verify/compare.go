@@ -0,0 +1,277 @@
+// Package verify implements verifying that a target's copy of a snapshot has
+// the same contents as source's snapshot, by mounting both snapshots and
+// comparing their file trees.
+package verify
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// DifferenceKind describes how a file differs between two trees.
+type DifferenceKind string
+
+// Kinds of differences.
+const (
+	// Missing files exist in source, but not target.
+	Missing DifferenceKind = "missing"
+	// Extra files exist in target, but not source.
+	Extra DifferenceKind = "extra"
+	// TypeChanged files are of different types, e.g. a file in source and a
+	// directory in target.
+	TypeChanged DifferenceKind = "type"
+	// ModeChanged files have different permissions.
+	ModeChanged DifferenceKind = "mode"
+	// SizeChanged files have different sizes.
+	SizeChanged DifferenceKind = "size"
+	// ModTimeChanged files have different modification times.
+	ModTimeChanged DifferenceKind = "mtime"
+	// LinkChanged symlinks point to different paths.
+	LinkChanged DifferenceKind = "link"
+	// ContentChanged files have the same size but different contents. Only
+	// reported if hashing is enabled (see Hash).
+	ContentChanged DifferenceKind = "content"
+)
+
+// Difference describes a single file that differs between two trees.
+type Difference struct {
+	// Path is relative to the root of the trees.
+	Path string
+	Kind DifferenceKind
+	// Source and Target describe the differing property in each tree,
+	// e.g. file sizes. Empty for Missing and Extra files.
+	Source, Target string
+}
+
+func (d Difference) String() string {
+	switch d.Kind {
+	case Missing:
+		return fmt.Sprintf("%s: missing from target", d.Path)
+	case Extra:
+		return fmt.Sprintf("%s: only in target", d.Path)
+	}
+	return fmt.Sprintf("%s: %s differs: source %s, target %s", d.Path, d.Kind, d.Source, d.Target)
+}
+
+// Report is the result of comparing two trees.
+type Report struct {
+	// Compared is the number of files present in both trees that were
+	// compared, including directories.
+	Compared int
+	// Differences are ordered by path.
+	Differences []Difference
+}
+
+// Option configures Compare.
+type Option func(*config)
+
+type config struct {
+	hash bool
+}
+
+// Hash returns an Option that, if hash is true, compares the SHA-256 hash of
+// the contents of regular files that have the same size. By default, only
+// metadata is compared.
+func Hash(hash bool) Option {
+	return func(conf *config) {
+		conf.hash = hash
+	}
+}
+
+// Compare walks the file trees rooted at source and target, and reports each
+// file that is missing from, or only in, target, or whose type, permissions,
+// size, modification time, or symlink destination differ. Symlinks are not
+// followed, and their modification times are not compared.
+func Compare(source, target string, opts ...Option) (Report, error) {
+	return CompareContext(context.Background(), source, target, opts...)
+}
+
+// CompareContext is like Compare, but stops walking the trees and returns
+// ctx's error once ctx is done.
+func CompareContext(ctx context.Context, source, target string, opts ...Option) (Report, error) {
+	conf := config{}
+	for _, opt := range opts {
+		opt(&conf)
+	}
+	c := comparer{
+		ctx:    ctx,
+		source: source,
+		target: target,
+		conf:   conf,
+	}
+	if err := c.compareDir("."); err != nil {
+		return Report{}, err
+	}
+	return c.report, nil
+}
+
+type comparer struct {
+	ctx            context.Context
+	source, target string
+	conf           config
+	report         Report
+}
+
+func (c *comparer) add(path string, kind DifferenceKind, source, target string) {
+	c.report.Differences = append(c.report.Differences, Difference{
+		Path:   path,
+		Kind:   kind,
+		Source: source,
+		Target: target,
+	})
+}
+
+// compareDir compares the contents of the directory at rel, relative to the
+// roots of both trees.
+func (c *comparer) compareDir(rel string) error {
+	sourceNames, err := readDirNames(filepath.Join(c.source, rel))
+	if err != nil {
+		return err
+	}
+	targetNames, err := readDirNames(filepath.Join(c.target, rel))
+	if err != nil {
+		return err
+	}
+	inTarget := make(map[string]bool)
+	for _, name := range targetNames {
+		inTarget[name] = true
+	}
+	inSource := make(map[string]bool)
+	for _, name := range sourceNames {
+		inSource[name] = true
+	}
+
+	names := append([]string(nil), sourceNames...)
+	for _, name := range targetNames {
+		if !inSource[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		path := filepath.Join(rel, name)
+		switch {
+		case !inTarget[name]:
+			c.add(path, Missing, "", "")
+		case !inSource[name]:
+			c.add(path, Extra, "", "")
+		default:
+			if err := c.compareFile(path); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// compareFile compares the file at rel, present in both trees, and recurses
+// into it if it's a directory.
+func (c *comparer) compareFile(rel string) error {
+	if err := c.ctx.Err(); err != nil {
+		return err
+	}
+	sourcePath := filepath.Join(c.source, rel)
+	targetPath := filepath.Join(c.target, rel)
+	s, err := os.Lstat(sourcePath)
+	if err != nil {
+		return err
+	}
+	t, err := os.Lstat(targetPath)
+	if err != nil {
+		return err
+	}
+	c.report.Compared++
+
+	if s.Mode().Type() != t.Mode().Type() {
+		c.add(rel, TypeChanged, fileType(s), fileType(t))
+		return nil
+	}
+	if s.Mode().Perm() != t.Mode().Perm() {
+		c.add(rel, ModeChanged, s.Mode().Perm().String(), t.Mode().Perm().String())
+	}
+	// The modification times of symlinks are not compared, as they are
+	// not preserved by most tools that copy files.
+	if s.Mode()&os.ModeSymlink == 0 && !s.ModTime().Equal(t.ModTime()) {
+		c.add(rel, ModTimeChanged, s.ModTime().String(), t.ModTime().String())
+	}
+
+	switch {
+	case s.IsDir():
+		return c.compareDir(rel)
+	case s.Mode()&os.ModeSymlink != 0:
+		sourceLink, err := os.Readlink(sourcePath)
+		if err != nil {
+			return err
+		}
+		targetLink, err := os.Readlink(targetPath)
+		if err != nil {
+			return err
+		}
+		if sourceLink != targetLink {
+			c.add(rel, LinkChanged, sourceLink, targetLink)
+		}
+	case s.Mode().IsRegular():
+		if s.Size() != t.Size() {
+			c.add(rel, SizeChanged, fmt.Sprint(s.Size()), fmt.Sprint(t.Size()))
+			return nil
+		}
+		if !c.conf.hash {
+			return nil
+		}
+		sourceHash, err := hashFile(sourcePath)
+		if err != nil {
+			return err
+		}
+		targetHash, err := hashFile(targetPath)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(sourceHash, targetHash) {
+			c.add(rel, ContentChanged, fmt.Sprintf("sha256:%x", sourceHash), fmt.Sprintf("sha256:%x", targetHash))
+		}
+	}
+	return nil
+}
+
+func readDirNames(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	return names, nil
+}
+
+func fileType(info os.FileInfo) string {
+	switch {
+	case info.IsDir():
+		return "directory"
+	case info.Mode()&os.ModeSymlink != 0:
+		return "symlink"
+	case info.Mode().IsRegular():
+		return "file"
+	}
+	return info.Mode().Type().String()
+}
+
+func hashFile(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
@@ -0,0 +1,219 @@
+package verify
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+// file describes a file to create in a test tree.
+type file struct {
+	path string
+	// One of contents, link, or dir is set.
+	contents string
+	link     string
+	dir      bool
+	mode     os.FileMode
+}
+
+var modTime = time.Date(2021, 3, 1, 20, 35, 9, 0, time.UTC)
+
+// makeTree creates files under a new temporary directory, and returns the
+// directory. All files have the same modification time.
+func makeTree(t *testing.T, files ...file) string {
+	t.Helper()
+	root := t.TempDir()
+	for _, f := range files {
+		path := filepath.Join(root, f.path)
+		mode := f.mode
+		var err error
+		switch {
+		case f.dir:
+			if mode == 0 {
+				mode = 0755
+			}
+			err = os.Mkdir(path, mode)
+		case f.link != "":
+			err = os.Symlink(f.link, path)
+		default:
+			if mode == 0 {
+				mode = 0644
+			}
+			err = os.WriteFile(path, []byte(f.contents), mode)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Set times after creating all files, as creating a file changes its
+	// directory's modification time.
+	for i := len(files) - 1; i >= 0; i-- {
+		if files[i].link != "" {
+			continue
+		}
+		path := filepath.Join(root, files[i].path)
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Chtimes(root, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+	return root
+}
+
+func TestCompare(t *testing.T) {
+	base := []file{
+		{path: "dir", dir: true},
+		{path: "dir/a", contents: "aaa"},
+		{path: "b", contents: "bbb"},
+		{path: "link", link: "dir/a"},
+	}
+	with := func(files ...file) []file {
+		return append(append([]file(nil), base...), files...)
+	}
+	replace := func(path string, f file) []file {
+		var files []file
+		for _, bf := range base {
+			if bf.path == path {
+				bf = f
+			}
+			files = append(files, bf)
+		}
+		return files
+	}
+
+	tests := []struct {
+		name         string
+		source       []file
+		target       []file
+		opts         []Option
+		want         []Difference
+		wantCompared int
+	}{
+		{
+			name:         "identical",
+			source:       base,
+			target:       base,
+			wantCompared: 4,
+		},
+		{
+			name:         "missing",
+			source:       with(file{path: "dir/missing", contents: "m"}),
+			target:       base,
+			want:         []Difference{{Path: "dir/missing", Kind: Missing}},
+			wantCompared: 4,
+		},
+		{
+			name:   "extra directory is not walked",
+			source: base,
+			target: with(
+				file{path: "extra", dir: true},
+				file{path: "extra/x", contents: "x"},
+			),
+			want:         []Difference{{Path: "extra", Kind: Extra}},
+			wantCompared: 4,
+		},
+		{
+			name:         "type",
+			source:       base,
+			target:       replace("b", file{path: "b", dir: true}),
+			want:         []Difference{{Path: "b", Kind: TypeChanged, Source: "file", Target: "directory"}},
+			wantCompared: 4,
+		},
+		{
+			name:         "mode",
+			source:       base,
+			target:       replace("b", file{path: "b", contents: "bbb", mode: 0600}),
+			want:         []Difference{{Path: "b", Kind: ModeChanged, Source: "-rw-r--r--", Target: "-rw-------"}},
+			wantCompared: 4,
+		},
+		{
+			name:         "size",
+			source:       base,
+			target:       replace("b", file{path: "b", contents: "bbbb"}),
+			want:         []Difference{{Path: "b", Kind: SizeChanged, Source: "3", Target: "4"}},
+			wantCompared: 4,
+		},
+		{
+			name:         "link",
+			source:       base,
+			target:       replace("link", file{path: "link", link: "b"}),
+			want:         []Difference{{Path: "link", Kind: LinkChanged, Source: "dir/a", Target: "b"}},
+			wantCompared: 4,
+		},
+		{
+			name:         "content not compared by default",
+			source:       base,
+			target:       replace("b", file{path: "b", contents: "BBB"}),
+			wantCompared: 4,
+		},
+		{
+			name:   "content",
+			source: base,
+			target: replace("b", file{path: "b", contents: "BBB"}),
+			opts:   []Option{Hash(true)},
+			want: []Difference{{
+				Path:   "b",
+				Kind:   ContentChanged,
+				Source: "sha256:3e744b9dc39389baf0c5a0660589b8402f3dbb49b89b3e75f2c9355852a3c677",
+				Target: "sha256:dcdb704109a454784b81229d2b05f368692e758bfa33cb61d04c1b93791b0273",
+			}},
+			wantCompared: 4,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			source := makeTree(t, test.source...)
+			target := makeTree(t, test.target...)
+			got, err := Compare(source, target, test.opts...)
+			if err != nil {
+				t.Fatalf("Compare(...) returned unexpected error: %v, want: nil", err)
+			}
+			if diff := cmp.Diff(test.want, got.Differences, cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("Compare(...) returned unexpected differences. -want +got:\n%s", diff)
+			}
+			if got.Compared != test.wantCompared {
+				t.Errorf("Compare(...) compared %d files, want: %d", got.Compared, test.wantCompared)
+			}
+		})
+	}
+}
+
+func TestCompare_ModTime(t *testing.T) {
+	source := makeTree(t, file{path: "a", contents: "a"})
+	target := makeTree(t, file{path: "a", contents: "a"})
+	later := modTime.Add(time.Hour)
+	if err := os.Chtimes(filepath.Join(target, "a"), later, later); err != nil {
+		t.Fatal(err)
+	}
+	got, err := Compare(source, target)
+	if err != nil {
+		t.Fatalf("Compare(...) returned unexpected error: %v, want: nil", err)
+	}
+	want := []Difference{{
+		Path:   "a",
+		Kind:   ModTimeChanged,
+		Source: modTime.Local().String(),
+		Target: later.Local().String(),
+	}}
+	if diff := cmp.Diff(want, got.Differences); diff != "" {
+		t.Errorf("Compare(...) returned unexpected differences. -want +got:\n%s", diff)
+	}
+}
+
+func TestCompareContext_Canceled(t *testing.T) {
+	source := makeTree(t, file{path: "a", contents: "a"})
+	target := makeTree(t, file{path: "a", contents: "a"})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := CompareContext(ctx, source, target); !errors.Is(err, context.Canceled) {
+		t.Errorf("CompareContext(...) returned unexpected error: %v, want: %v", err, context.Canceled)
+	}
+}
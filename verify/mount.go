@@ -0,0 +1,135 @@
+package verify
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/diskutil"
+)
+
+// Mounter mounts APFS snapshots read-only.
+type Mounter interface {
+	// Mount snap of volume at dir, which must be an existing directory.
+	Mount(volume diskutil.VolumeInfo, snap diskutil.Snapshot, dir string) error
+	// Unmount the snapshot mounted at dir.
+	Unmount(dir string) error
+}
+
+// ContextMounter is a Mounter that stops mounting early if ctx is done: the
+// mount is killed and MountContext returns an error. The Mounter returned by
+// NewMounter implements ContextMounter.
+type ContextMounter interface {
+	Mounter
+	MountContext(ctx context.Context, volume diskutil.VolumeInfo, snap diskutil.Snapshot, dir string) error
+}
+
+type mounter struct {
+	execCommand func(context.Context, string, ...string) *exec.Cmd
+}
+
+type mounterOption func(*mounter)
+
+func withExecCommand(f func(context.Context, string, ...string) *exec.Cmd) mounterOption {
+	return func(m *mounter) {
+		m.execCommand = f
+	}
+}
+
+// NewMounter returns a new Mounter that uses MacOS's mount_apfs and umount.
+func NewMounter(opts ...mounterOption) Mounter {
+	m := mounter{
+		execCommand: exec.CommandContext,
+	}
+	for _, opt := range opts {
+		opt(&m)
+	}
+	return m
+}
+
+func (m mounter) Mount(volume diskutil.VolumeInfo, snap diskutil.Snapshot, dir string) error {
+	return m.MountContext(context.Background(), volume, snap, dir)
+}
+
+func (m mounter) MountContext(ctx context.Context, volume diskutil.VolumeInfo, snap diskutil.Snapshot, dir string) error {
+	cmd := m.execCommand(ctx, "mount_apfs", "-o", "rdonly", "-s", snap.Name, volume.Device, dir)
+	return run(cmd)
+}
+
+func (m mounter) Unmount(dir string) error {
+	cmd := m.execCommand(context.Background(), "umount", dir)
+	return run(cmd)
+}
+
+func run(cmd *exec.Cmd) error {
+	stderr := new(bytes.Buffer)
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("`%s` failed (%w) with stderr: %s", cmd, err, stderr)
+	}
+	return nil
+}
+
+// Snapshots mounts snap of both source and target read-only, compares their
+// file trees (see Compare), and unmounts them.
+func Snapshots(m Mounter, source, target diskutil.VolumeInfo, snap diskutil.Snapshot, opts ...Option) (Report, error) {
+	return SnapshotsContext(context.Background(), m, source, target, snap, opts...)
+}
+
+// SnapshotsContext is like Snapshots, but stops mounting and comparing once
+// ctx is done. Mounted snapshots are unmounted even if ctx is done.
+func SnapshotsContext(ctx context.Context, m Mounter, source, target diskutil.VolumeInfo, snap diskutil.Snapshot, opts ...Option) (report Report, err error) {
+	sourceDir, unmountSource, err := mountTemp(ctx, m, source, snap)
+	if err != nil {
+		return Report{}, fmt.Errorf("error mounting source snapshot %s: %v", snap, err)
+	}
+	defer func() {
+		if uerr := unmountSource(); uerr != nil && err == nil {
+			err = fmt.Errorf("error unmounting source snapshot: %v", uerr)
+		}
+	}()
+	targetDir, unmountTarget, err := mountTemp(ctx, m, target, snap)
+	if err != nil {
+		return Report{}, fmt.Errorf("error mounting target snapshot %s: %v", snap, err)
+	}
+	defer func() {
+		if uerr := unmountTarget(); uerr != nil && err == nil {
+			err = fmt.Errorf("error unmounting target snapshot: %v", uerr)
+		}
+	}()
+	return CompareContext(ctx, sourceDir, targetDir, opts...)
+}
+
+// mountTemp mounts snap of volume at a new temporary directory, and returns
+// the directory and a function that unmounts and removes it.
+func mountTemp(ctx context.Context, m Mounter, volume diskutil.VolumeInfo, snap diskutil.Snapshot) (string, func() error, error) {
+	dir, err := os.MkdirTemp("", "offsite-apfs-backup-verify-")
+	if err != nil {
+		return "", nil, err
+	}
+	if err := mount(ctx, m, volume, snap, dir); err != nil {
+		os.Remove(dir)
+		return "", nil, err
+	}
+	unmount := func() error {
+		if err := m.Unmount(dir); err != nil {
+			return err
+		}
+		return os.Remove(dir)
+	}
+	return dir, unmount, nil
+}
+
+// mount mounts snap of volume at dir, with MountContext if m is a
+// ContextMounter.
+func mount(ctx context.Context, m Mounter, volume diskutil.VolumeInfo, snap diskutil.Snapshot, dir string) error {
+	if cm, ok := m.(ContextMounter); ok {
+		return cm.MountContext(ctx, volume, snap, dir)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return m.Mount(volume, snap, dir)
+}
@@ -0,0 +1,132 @@
+package verify
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/diskutil"
+	"github.com/voidingwarranties/offsite-apfs-backup/testutils/fakecmd"
+)
+
+func TestHelperProcess(t *testing.T) {
+	fakecmd.HelperProcess(t)
+}
+
+func TestMount(t *testing.T) {
+	m := NewMounter(withExecCommand(fakecmd.FakeCommandContext(t,
+		fakecmd.WantArg("mount_apfs", "rdonly"),
+		fakecmd.WantArg("mount_apfs", "-s"),
+		fakecmd.WantArg("mount_apfs", "snap-name"),
+		fakecmd.WantArg("mount_apfs", "/dev/disk-foo"),
+		fakecmd.WantArg("mount_apfs", "/mount/dir"),
+	)))
+	volume := diskutil.VolumeInfo{Device: "/dev/disk-foo"}
+	snap := diskutil.Snapshot{Name: "snap-name"}
+	err := m.Mount(volume, snap, "/mount/dir")
+	if err := fakecmd.AsHelperProcessErr(err); err != nil {
+		t.Fatal(err)
+	}
+	if err != nil {
+		t.Errorf("Mount returned unexpected error: %v, want: nil", err)
+	}
+}
+
+func TestMount_Error(t *testing.T) {
+	m := NewMounter(withExecCommand(fakecmd.FakeCommandContext(t,
+		fakecmd.Stderr("mount_apfs", "example stderr"),
+		fakecmd.ExitFail("mount_apfs"),
+	)))
+	err := m.Mount(diskutil.VolumeInfo{}, diskutil.Snapshot{}, "/mount/dir")
+	if err := fakecmd.AsHelperProcessErr(err); err != nil {
+		t.Fatal(err)
+	}
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		t.Errorf("Mount returned unexpected error: %v, want type: *exec.ExitError", err)
+	}
+}
+
+func TestUnmount(t *testing.T) {
+	m := NewMounter(withExecCommand(fakecmd.FakeCommandContext(t,
+		fakecmd.WantArg("umount", "/mount/dir"),
+	)))
+	err := m.Unmount("/mount/dir")
+	if err := fakecmd.AsHelperProcessErr(err); err != nil {
+		t.Fatal(err)
+	}
+	if err != nil {
+		t.Errorf("Unmount returned unexpected error: %v, want: nil", err)
+	}
+}
+
+// fakeMounter "mounts" a snapshot by writing a file to the mount directory
+// whose contents are the volume's name.
+type fakeMounter struct {
+	mounted map[string]bool
+}
+
+func (m *fakeMounter) Mount(volume diskutil.VolumeInfo, snap diskutil.Snapshot, dir string) error {
+	path := filepath.Join(dir, snap.Name)
+	if err := os.WriteFile(path, []byte(volume.Name), 0644); err != nil {
+		return err
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		return err
+	}
+	if err := os.Chtimes(dir, modTime, modTime); err != nil {
+		return err
+	}
+	m.mounted[dir] = true
+	return nil
+}
+
+func (m *fakeMounter) Unmount(dir string) error {
+	if !m.mounted[dir] {
+		return errors.New("not mounted")
+	}
+	delete(m.mounted, dir)
+	// Remove the file added by Mount, as unmounting would.
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if err := os.Remove(filepath.Join(dir, e.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestSnapshots(t *testing.T) {
+	m := &fakeMounter{mounted: make(map[string]bool)}
+	source := diskutil.VolumeInfo{Name: "source"}
+	target := diskutil.VolumeInfo{Name: "target"}
+	snap := diskutil.Snapshot{Name: "snap"}
+	got, err := Snapshots(m, source, target, snap, Hash(true))
+	if err != nil {
+		t.Fatalf("Snapshots(...) returned unexpected error: %v, want: nil", err)
+	}
+	want := Report{
+		Compared: 1,
+		Differences: []Difference{{
+			Path:   "snap",
+			Kind:   ContentChanged,
+			Source: fmt.Sprintf("sha256:%x", sha256.Sum256([]byte("source"))),
+			Target: fmt.Sprintf("sha256:%x", sha256.Sum256([]byte("target"))),
+		}},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Snapshots(...) returned unexpected report. -want +got:\n%s", diff)
+	}
+	if len(m.mounted) != 0 {
+		t.Errorf("Snapshots(...) left snapshots mounted at: %v", m.mounted)
+	}
+}
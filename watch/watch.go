@@ -0,0 +1,198 @@
+// Package watch implements watching for volumes to be attached, e.g. offsite
+// drives being brought on-site, and running an action, such as cloning to
+// them, when they are.
+package watch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/diskutil"
+)
+
+// Action is run when a watched volume is attached. Its output should be
+// written to stdout.
+type Action func(volume string, stdout io.Writer) error
+
+// Option configures Watcher.
+type Option func(*Watcher)
+
+// Interval returns an Option that sets how often volumes are polled. Defaults
+// to 30 seconds.
+func Interval(d time.Duration) Option {
+	return func(w *Watcher) {
+		w.interval = d
+	}
+}
+
+// Cooldown returns an Option that sets the minimum time between the end of an
+// action for a volume and the start of its next action. A volume attached
+// during its cooldown is not acted on until it is detached and attached
+// again. Defaults to 1 hour.
+func Cooldown(d time.Duration) Option {
+	return func(w *Watcher) {
+		w.cooldown = d
+	}
+}
+
+// Stdout returns an Option that sets the stdout to the given io.Writer.
+func Stdout(stdout io.Writer) Option {
+	return func(w *Watcher) {
+		w.stdout = stdout
+	}
+}
+
+func withNow(now func() time.Time) Option {
+	return func(w *Watcher) {
+		w.now = now
+	}
+}
+
+// Watcher polls for volumes using `diskutil info`, and runs an action each
+// time a watched volume is attached. Actions run in the background, so that a
+// long running action does not delay acting on other volumes, but at most one
+// action runs for each volume at a time.
+type Watcher struct {
+	diskutil diskutil.ContextDiskUtil
+	interval time.Duration
+	cooldown time.Duration
+	stdout   io.Writer
+	now      func() time.Time
+
+	mu      sync.Mutex
+	volumes map[string]*volumeState
+	running sync.WaitGroup
+}
+
+type volumeState struct {
+	// attached is whether the volume was attached when last polled.
+	attached bool
+	// running is whether an action for the volume is running.
+	running bool
+	// finished is when the volume's last action finished.
+	finished time.Time
+}
+
+// New returns a new Watcher.
+func New(du diskutil.DiskUtil, opts ...Option) *Watcher {
+	w := &Watcher{
+		diskutil: diskutil.WithContext(du),
+		interval: 30 * time.Second,
+		cooldown: time.Hour,
+		stdout:   os.Stdout,
+		now:      time.Now,
+		volumes:  make(map[string]*volumeState),
+	}
+	for _, opt := range opts {
+		opt(w)
+	}
+	return w
+}
+
+// Watch polls volumes until ctx is done, and then waits for running actions
+// to finish. Volumes may be mount points, /dev/ paths, or volume UUIDs, but
+// only UUIDs identify a volume regardless of where or whether it's mounted.
+// Volumes that are attached when Watch starts are acted on as if they were
+// just attached.
+func (w *Watcher) Watch(ctx context.Context, volumes []string, action Action) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		w.PollContext(ctx, volumes, action)
+		select {
+		case <-ctx.Done():
+			w.Wait()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Poll checks whether each of volumes is attached once, and starts action for
+// each volume that was attached since the last poll, unless an action for the
+// volume is already running or the volume is in its cooldown. A volume is
+// only considered detached if diskutil reports diskutil.ErrVolumeNotFound;
+// other errors are written to stdout, and the volume keeps its state from the
+// last poll.
+func (w *Watcher) Poll(volumes []string, action Action) {
+	w.PollContext(context.Background(), volumes, action)
+}
+
+// PollContext is like Poll, but stops if ctx is done first. Volumes that
+// weren't checked before ctx is done keep their state from the last poll.
+func (w *Watcher) PollContext(ctx context.Context, volumes []string, action Action) {
+	for _, volume := range volumes {
+		_, err := w.diskutil.InfoContext(ctx, volume)
+		if ctx.Err() != nil {
+			return
+		}
+		// Other errors, e.g. a busy diskutil, don't mean that volume was
+		// detached, so keep its state rather than acting on it again once
+		// the error clears.
+		if err != nil && !errors.Is(err, diskutil.ErrVolumeNotFound) {
+			fmt.Fprintf(w.stdout, "Error checking whether %s is attached: %v\n", volume, err)
+			continue
+		}
+		attached := err == nil
+
+		w.mu.Lock()
+		state, ok := w.volumes[volume]
+		if !ok {
+			state = &volumeState{}
+			w.volumes[volume] = state
+		}
+		appeared := attached && !state.attached
+		state.attached = attached
+		start := appeared && w.startable(volume, state)
+		if start {
+			state.running = true
+		}
+		w.mu.Unlock()
+
+		if start {
+			w.running.Add(1)
+			go w.run(volume, state, action)
+		}
+	}
+}
+
+// startable returns whether an action may start for volume, which was just
+// attached. w.mu must be held.
+func (w *Watcher) startable(volume string, state *volumeState) bool {
+	if state.running {
+		fmt.Fprintf(w.stdout, "%s attached, but is already being acted on.\n", volume)
+		return false
+	}
+	if !state.finished.IsZero() {
+		if remaining := state.finished.Add(w.cooldown).Sub(w.now()); remaining > 0 {
+			fmt.Fprintf(w.stdout, "%s attached, but was acted on recently. Detach and attach it again after %s.\n", volume, remaining.Round(time.Second))
+			return false
+		}
+	}
+	return true
+}
+
+func (w *Watcher) run(volume string, state *volumeState, action Action) {
+	defer w.running.Done()
+	fmt.Fprintf(w.stdout, "%s attached.\n", volume)
+	err := action(volume, w.stdout)
+	if err != nil {
+		fmt.Fprintf(w.stdout, "Error acting on %s: %v\n", volume, err)
+	} else {
+		fmt.Fprintf(w.stdout, "Finished acting on %s.\n", volume)
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	state.running = false
+	state.finished = w.now()
+}
+
+// Wait waits for running actions to finish.
+func (w *Watcher) Wait() {
+	w.running.Wait()
+}
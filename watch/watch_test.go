@@ -0,0 +1,243 @@
+package watch
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/diskutil"
+)
+
+// fakeDiskUtil reports the volumes in attached as attached, and fails to get
+// the info of volumes in failing.
+type fakeDiskUtil struct {
+	diskutil.DiskUtil
+
+	mu       sync.Mutex
+	attached map[string]bool
+	failing  map[string]bool
+}
+
+func (du *fakeDiskUtil) Info(volume string) (diskutil.VolumeInfo, error) {
+	du.mu.Lock()
+	defer du.mu.Unlock()
+	if du.failing[volume] {
+		return diskutil.VolumeInfo{}, errors.New("Resource busy")
+	}
+	if !du.attached[volume] {
+		return diskutil.VolumeInfo{}, fmt.Errorf("not attached: %w", diskutil.ErrVolumeNotFound)
+	}
+	return diskutil.VolumeInfo{UUID: volume}, nil
+}
+
+func (du *fakeDiskUtil) set(volume string, attached bool) {
+	du.mu.Lock()
+	defer du.mu.Unlock()
+	du.attached[volume] = attached
+}
+
+// recorder is an Action that records the volumes it's run for.
+type recorder struct {
+	mu      sync.Mutex
+	volumes []string
+	err     error
+	// block, if not nil, is received from before the action returns.
+	block chan struct{}
+}
+
+func (r *recorder) action(volume string, stdout io.Writer) error {
+	r.mu.Lock()
+	r.volumes = append(r.volumes, volume)
+	block := r.block
+	r.mu.Unlock()
+	if block != nil {
+		<-block
+	}
+	return r.err
+}
+
+func (r *recorder) got() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.volumes...)
+}
+
+func TestPoll(t *testing.T) {
+	du := &fakeDiskUtil{attached: map[string]bool{"a": true}}
+	now := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	w := New(du, Cooldown(time.Hour), Stdout(io.Discard), withNow(func() time.Time { return now }))
+	r := &recorder{err: errors.New("failed")}
+	volumes := []string{"a", "b"}
+
+	steps := []struct {
+		name     string
+		attach   map[string]bool
+		advance  time.Duration
+		wantRuns []string
+	}{
+		{
+			name:     "attached at start",
+			wantRuns: []string{"a"},
+		},
+		{
+			name:     "still attached",
+			wantRuns: []string{"a"},
+		},
+		{
+			name:     "attached",
+			attach:   map[string]bool{"b": true},
+			wantRuns: []string{"a", "b"},
+		},
+		{
+			name:     "detached",
+			attach:   map[string]bool{"a": false},
+			wantRuns: []string{"a", "b"},
+		},
+		{
+			name:     "attached during cooldown",
+			attach:   map[string]bool{"a": true},
+			advance:  30 * time.Minute,
+			wantRuns: []string{"a", "b"},
+		},
+		{
+			name:     "detached after cooldown",
+			attach:   map[string]bool{"a": false},
+			advance:  time.Hour,
+			wantRuns: []string{"a", "b"},
+		},
+		{
+			name:     "attached after cooldown",
+			attach:   map[string]bool{"a": true},
+			wantRuns: []string{"a", "b", "a"},
+		},
+	}
+	for _, step := range steps {
+		for volume, attached := range step.attach {
+			du.set(volume, attached)
+		}
+		now = now.Add(step.advance)
+		w.Poll(volumes, r.action)
+		w.Wait()
+		if diff := cmp.Diff(step.wantRuns, r.got()); diff != "" {
+			t.Errorf("%s: unexpected runs (-want +got):\n%s", step.name, diff)
+		}
+	}
+}
+
+func TestPoll_InfoErrors(t *testing.T) {
+	du := &fakeDiskUtil{
+		attached: map[string]bool{"a": true},
+		failing:  map[string]bool{},
+	}
+	stdout := new(bytes.Buffer)
+	w := New(du, Cooldown(0), Stdout(stdout))
+	r := &recorder{}
+	volumes := []string{"a"}
+
+	w.Poll(volumes, r.action)
+	w.Wait()
+	// A failure to get a's info doesn't mean it was detached, so it's not
+	// acted on again once diskutil succeeds.
+	du.failing["a"] = true
+	w.Poll(volumes, r.action)
+	du.failing["a"] = false
+	w.Poll(volumes, r.action)
+	w.Wait()
+	if diff := cmp.Diff([]string{"a"}, r.got()); diff != "" {
+		t.Errorf("unexpected runs (-want +got):\n%s", diff)
+	}
+	if !strings.Contains(stdout.String(), "Error checking whether a is attached: Resource busy") {
+		t.Errorf("Poll did not log diskutil error, got stdout:\n%s", stdout)
+	}
+}
+
+func TestPoll_SingleFlight(t *testing.T) {
+	du := &fakeDiskUtil{attached: map[string]bool{"a": true}}
+	w := New(du, Cooldown(0), Stdout(io.Discard))
+	r := &recorder{block: make(chan struct{})}
+	volumes := []string{"a"}
+
+	w.Poll(volumes, r.action)
+	// Detach and attach a while its action is running.
+	du.set("a", false)
+	w.Poll(volumes, r.action)
+	du.set("a", true)
+	w.Poll(volumes, r.action)
+	close(r.block)
+	w.Wait()
+	if diff := cmp.Diff([]string{"a"}, r.got()); diff != "" {
+		t.Errorf("unexpected runs (-want +got):\n%s", diff)
+	}
+
+	// Once finished, it's acted on when attached again.
+	du.set("a", false)
+	w.Poll(volumes, r.action)
+	du.set("a", true)
+	w.Poll(volumes, r.action)
+	w.Wait()
+	if diff := cmp.Diff([]string{"a", "a"}, r.got()); diff != "" {
+		t.Errorf("unexpected runs (-want +got):\n%s", diff)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	du := &fakeDiskUtil{attached: map[string]bool{"a": true}}
+	w := New(du, Interval(time.Millisecond), Stdout(io.Discard))
+	r := &recorder{}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		w.Watch(ctx, []string{"a"}, r.action)
+		close(done)
+	}()
+	for len(r.got()) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("Watch did not return after its context was cancelled")
+	}
+	if diff := cmp.Diff([]string{"a"}, r.got()); diff != "" {
+		t.Errorf("unexpected runs (-want +got):\n%s", diff)
+	}
+}
+
+// hangingDiskUtil blocks in InfoContext until ctx is done.
+type hangingDiskUtil struct {
+	diskutil.ContextDiskUtil
+}
+
+func (hangingDiskUtil) InfoContext(ctx context.Context, volume string) (diskutil.VolumeInfo, error) {
+	<-ctx.Done()
+	return diskutil.VolumeInfo{}, ctx.Err()
+}
+
+func TestWatch_HungDiskUtil(t *testing.T) {
+	w := New(hangingDiskUtil{}, Interval(time.Millisecond), Stdout(io.Discard))
+	r := &recorder{}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		w.Watch(ctx, []string{"a"}, r.action)
+		close(done)
+	}()
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("Watch did not return after its context was cancelled while diskutil was hung")
+	}
+	if got := r.got(); len(got) != 0 {
+		t.Errorf("unexpected runs: %v", got)
+	}
+}